@@ -0,0 +1,151 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// ParsedFile is a Go source file parsed once per change and shared by all the
+// AST based checks. It must not be modified.
+type ParsedFile struct {
+	// Path is the path relative to the repository root.
+	Path string
+	Fset *token.FileSet
+	// File is parsed with comments.
+	File *ast.File
+}
+
+// Finding is an issue found by an AST based check.
+type Finding struct {
+	Pos     token.Position
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", f.Pos.Filename, f.Pos.Line, f.Pos.Column, f.Message)
+}
+
+// FileVisitor is implemented by AST based checks to inspect each file.
+type FileVisitor interface {
+	VisitFile(f *ParsedFile) []Finding
+}
+
+// FuncVisitor is implemented by AST based checks to inspect each top level
+// function and method.
+type FuncVisitor interface {
+	VisitFunc(f *ParsedFile, fn *ast.FuncDecl) []Finding
+}
+
+// ImportVisitor is implemented by AST based checks to inspect each import.
+type ImportVisitor interface {
+	VisitImport(f *ParsedFile, imp *ast.ImportSpec) []Finding
+}
+
+// RunAST calls the visitors implemented by v, one or multiple of
+// FileVisitor, FuncVisitor and ImportVisitor, on each modified Go file that is
+// not ignored.
+//
+// The files are parsed once per change, so multiple checks using RunAST
+// concurrently share the parsing cost. The findings are returned sorted as an
+// error prefixed with name.
+func RunAST(change scm.Change, name string, v interface{}) error {
+	fileV, _ := v.(FileVisitor)
+	funcV, _ := v.(FuncVisitor)
+	importV, _ := v.(ImportVisitor)
+	var results []string
+	for _, p := range change.Changed().GoFiles() {
+		if change.IsIgnored(p) {
+			continue
+		}
+		f, err := ParseFile(change, p)
+		if err != nil {
+			return fmt.Errorf("%s failed to parse %s: %s", name, p, err)
+		}
+		if f == nil {
+			// Deleted.
+			continue
+		}
+		var findings []Finding
+		if fileV != nil {
+			findings = append(findings, fileV.VisitFile(f)...)
+		}
+		if importV != nil {
+			for _, imp := range f.File.Imports {
+				findings = append(findings, importV.VisitImport(f, imp)...)
+			}
+		}
+		if funcV != nil {
+			for _, d := range f.File.Decls {
+				if fn, ok := d.(*ast.FuncDecl); ok {
+					findings = append(findings, funcV.VisitFunc(f, fn)...)
+				}
+			}
+		}
+		for _, finding := range findings {
+			results = append(results, finding.String())
+		}
+	}
+	if len(results) != 0 {
+		sort.Strings(results)
+		return fmt.Errorf("%s failed:\n%s", name, strings.Join(results, "\n"))
+	}
+	return nil
+}
+
+// ParseFile returns the file p of change parsed with comments. The result is
+// cached for the most recent change. Returns nil and no error if the file
+// doesn't exist.
+func ParseFile(change scm.Change, p string) (*ParsedFile, error) {
+	astCache.Lock()
+	if astCache.change != change {
+		astCache.change = change
+		astCache.files = map[string]*parseResult{}
+	}
+	r := astCache.files[p]
+	if r == nil {
+		r = &parseResult{}
+		astCache.files[p] = r
+	}
+	astCache.Unlock()
+
+	internal.Latency("checks.ParseFile")
+	r.once.Do(func() {
+		content := change.Content(p)
+		if content == nil {
+			return
+		}
+		fset := token.NewFileSet()
+		var f *ast.File
+		if f, r.err = parser.ParseFile(fset, p, content, parser.ParseComments); r.err == nil {
+			r.file = &ParsedFile{Path: p, Fset: fset, File: f}
+		}
+	})
+	return r.file, r.err
+}
+
+// Private stuff.
+
+// astCache holds the files parsed for the most recent change.
+var astCache struct {
+	sync.Mutex
+	change scm.Change
+	files  map[string]*parseResult
+}
+
+type parseResult struct {
+	once sync.Once
+	file *ParsedFile
+	err  error
+}
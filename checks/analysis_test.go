@@ -0,0 +1,60 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"errors"
+	"go/ast"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestRunAST(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	files := map[string]string{
+		"foo.go":     "package foo\n\nimport \"fmt\"\n\nfunc Foo() { fmt.Println() }\n",
+		"bar/bar.go": "package bar\n\nfunc bar() {}\n",
+	}
+	change := setup(t, td, files)
+
+	f1, err := ParseFile(change, "foo.go")
+	ut.AssertEqual(t, nil, err)
+	f2, err := ParseFile(change, "foo.go")
+	ut.AssertEqual(t, nil, err)
+	// The file is only parsed once.
+	ut.AssertEqual(t, true, f1 == f2)
+
+	expected := errors.New("visitor failed:\nbar/bar.go:1:1: file bar\nbar/bar.go:3:1: func bar\nfoo.go:1:1: file foo\nfoo.go:3:8: import \"fmt\"\nfoo.go:5:1: func Foo")
+	ut.AssertEqual(t, expected, RunAST(change, "visitor", &testVisitor{}))
+	ut.AssertEqual(t, nil, RunAST(change, "visitor", struct{}{}))
+}
+
+// Private stuff.
+
+type testVisitor struct{}
+
+func (t *testVisitor) VisitFile(f *ParsedFile) []Finding {
+	return []Finding{{f.Fset.Position(f.File.Pos()), "file " + f.File.Name.Name}}
+}
+
+func (t *testVisitor) VisitFunc(f *ParsedFile, fn *ast.FuncDecl) []Finding {
+	return []Finding{{f.Fset.Position(fn.Pos()), "func " + fn.Name.Name}}
+}
+
+func (t *testVisitor) VisitImport(f *ParsedFile, imp *ast.ImportSpec) []Finding {
+	p, _ := strconv.Unquote(imp.Path.Value)
+	return []Finding{{f.Fset.Position(imp.Pos()), "import " + strconv.Quote(p)}}
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// multicheckerPkg is the package used to drive the analyzers.
+const multicheckerPkg = "golang.org/x/tools/go/analysis/multichecker"
+
+// Analyzers runs golang.org/x/tools/go/analysis analyzers on the modified
+// packages.
+//
+// A small driver importing the analyzers is compiled on the fly, so any
+// analyzer can be used without installing a separate binary. The analyzer
+// packages must be in GOPATH, or be required by go.mod when the repository
+// uses go modules.
+type Analyzers struct {
+	// Packages is the list of import paths of the packages exporting the
+	// analyzer to run as the variable Analyzer, e.g.
+	// "golang.org/x/tools/go/analysis/passes/nilness".
+	Packages []string `yaml:"packages"`
+	// Flags are passed to the driver, e.g. "-printf.funcs=Wrapf".
+	Flags []string `yaml:"flags"`
+}
+
+// GetDescription implements Check.
+func (a *Analyzers) GetDescription() string {
+	return "runs golang.org/x/tools/go/analysis analyzers on the modified packages"
+}
+
+// GetName implements Check.
+func (a *Analyzers) GetName() string {
+	return "analyzers"
+}
+
+// GetPrerequisites implements Check.
+func (a *Analyzers) GetPrerequisites() []CheckPrerequisite {
+	if len(a.Packages) == 0 {
+		return nil
+	}
+	out := []CheckPrerequisite{{[]string{"go", "list", multicheckerPkg}, 0, multicheckerPkg}}
+	for _, p := range a.Packages {
+		out = append(out, CheckPrerequisite{[]string{"go", "list", p}, 0, p})
+	}
+	return out
+}
+
+// Scope implements Scoper.
+func (a *Analyzers) Scope() Scope {
+	return ScopePackages
+}
+
+// Run implements Check.
+func (a *Analyzers) Run(ctx context.Context, change scm.Change, options *Options) (err error) {
+	pkgs := change.Changed().Packages()
+	if len(a.Packages) == 0 || len(pkgs) == 0 {
+		return nil
+	}
+	options.Count(CounterPackages, len(pkgs))
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := internal.RemoveAll(tmpDir); err == nil {
+			err = err2
+		}
+	}()
+	src, err := analyzersDriver(a.Packages)
+	if err != nil {
+		return err
+	}
+	mainGo := filepath.Join(tmpDir, "main.go")
+	if err = ioutil.WriteFile(mainGo, src, 0600); err != nil {
+		return err
+	}
+	driver := filepath.Join(tmpDir, "analyzers")
+	if runtime.GOOS == "windows" {
+		driver += ".exe"
+	}
+	// The driver is built from the repository so the analyzers are resolved
+	// with its GOPATH or go.mod.
+	out, exitCode, _, err := options.Capture(ctx, change.Repo(), "go", "build", "-o", driver, mainGo)
+	if err != nil || exitCode != 0 {
+		return fmt.Errorf("analyzers failed to build the driver: %v\n%s", err, out)
+	}
+	args := append(append([]string{driver, "-json"}, a.Flags...), pkgs...)
+	out, exitCode, _, err = options.Capture(ctx, change.Repo(), args...)
+	if err != nil {
+		return err
+	}
+	findings, err := parseAnalyzersOutput(change.Repo().Root(), out)
+	if err != nil {
+		return fmt.Errorf("analyzers failed with code %d: %s\n%s", exitCode, err, out)
+	}
+	files := map[string]bool{}
+	for _, f := range change.Changed().GoFiles() {
+		files[f] = !change.IsIgnored(f)
+	}
+	// A diagnostic is printed once per package variant, e.g. with and without
+	// its tests.
+	seen := map[string]bool{}
+	var results []string
+	for _, f := range findings {
+		line := f.Message
+		if f.Pos.Filename != "" {
+			if !files[f.Pos.Filename] {
+				continue
+			}
+			line = f.String()
+		}
+		if !seen[line] {
+			seen[line] = true
+			results = append(results, line)
+		}
+	}
+	if len(results) != 0 {
+		sort.Strings(results)
+		return fmt.Errorf("analyzers failed:\n%s", strings.Join(results, "\n"))
+	}
+	return nil
+}
+
+// Private stuff.
+
+var analyzersDriverTmpl = template.Must(template.New("").Parse(`// Code generated by pre-commit-go. DO NOT EDIT.
+
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/multichecker"
+{{range $i, $p := .}}
+	a{{$i}} {{printf "%q" $p}}{{end}}
+)
+
+func main() {
+	multichecker.Main({{range $i, $p := .}}
+		a{{$i}}.Analyzer,{{end}}
+	)
+}
+`))
+
+// analyzersDriver returns the source of a program running the analyzers
+// exported by pkgs.
+func analyzersDriver(pkgs []string) ([]byte, error) {
+	var b bytes.Buffer
+	if err := analyzersDriverTmpl.Execute(&b, pkgs); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// analyzerDiagnostic is a diagnostic as printed by the driver with -json.
+type analyzerDiagnostic struct {
+	Posn    string `json:"posn"`
+	Message string `json:"message"`
+}
+
+// parseAnalyzersOutput parses the output of the driver run with -json.
+//
+// Positions are made relative to root. A package that failed to be analyzed
+// is returned as a finding without position.
+func parseAnalyzersOutput(root, out string) ([]Finding, error) {
+	// Warnings printed on stderr may precede the JSON document, which starts
+	// with a line containing only "{".
+	start := strings.Index("\n"+out, "\n{\n")
+	if start == -1 {
+		if strings.TrimSpace(out) == "" {
+			return nil, nil
+		}
+		return nil, errors.New("no result found")
+	}
+	var tree map[string]map[string]json.RawMessage
+	if err := json.NewDecoder(strings.NewReader(out[start:])).Decode(&tree); err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, pkg := range sortedKeys(tree) {
+		analyzers := tree[pkg]
+		names := make([]string, 0, len(analyzers))
+		for name := range analyzers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			raw := analyzers[name]
+			var diags []analyzerDiagnostic
+			if err := json.Unmarshal(raw, &diags); err != nil {
+				var e struct {
+					Error string `json:"error"`
+				}
+				if err = json.Unmarshal(raw, &e); err != nil {
+					return nil, err
+				}
+				findings = append(findings, Finding{Message: fmt.Sprintf("%s: %s: %s", pkg, name, e.Error)})
+				continue
+			}
+			for _, d := range diags {
+				f := Finding{Message: name + ": " + d.Message}
+				f.Pos.Filename, f.Pos.Line, f.Pos.Column = splitPosn(d.Posn)
+				if rel, err := filepath.Rel(root, f.Pos.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+					f.Pos.Filename = rel
+				}
+				findings = append(findings, f)
+			}
+		}
+	}
+	return findings, nil
+}
+
+// sortedKeys returns the packages of the driver output sorted.
+func sortedKeys(tree map[string]map[string]json.RawMessage) []string {
+	out := make([]string, 0, len(tree))
+	for k := range tree {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// splitPosn splits a "file:line:col" position. The file name may contain ':'.
+func splitPosn(posn string) (string, int, int) {
+	items := rsplitn(posn, ":", 3)
+	if len(items) != 3 {
+		return posn, 0, 0
+	}
+	line, err1 := strconv.Atoi(items[1])
+	col, err2 := strconv.Atoi(items[2])
+	if err1 != nil || err2 != nil {
+		return posn, 0, 0
+	}
+	return items[0], line, col
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestAnalyzersDriver(t *testing.T) {
+	t.Parallel()
+	src, err := analyzersDriver([]string{"example.com/a", "example.com/b"})
+	ut.AssertEqual(t, nil, err)
+	expected := `// Code generated by pre-commit-go. DO NOT EDIT.
+
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/multichecker"
+
+	a0 "example.com/a"
+	a1 "example.com/b"
+)
+
+func main() {
+	multichecker.Main(
+		a0.Analyzer,
+		a1.Analyzer,
+	)
+}
+`
+	ut.AssertEqual(t, expected, string(src))
+	ut.AssertEqual(t, []CheckPrerequisite(nil), (&Analyzers{}).GetPrerequisites())
+	ut.AssertEqual(t, 3, len((&Analyzers{Packages: []string{"example.com/a", "example.com/b"}}).GetPrerequisites()))
+}
+
+func TestParseAnalyzersOutput(t *testing.T) {
+	t.Parallel()
+	root := filepath.FromSlash("/src/foo")
+	out := `warning: something
+{
+	"example.com/foo": {
+		"nilness": [
+			{
+				"posn": ` + strconv.Quote(filepath.Join(root, "foo.go")+":3:2") + `,
+				"message": "nil dereference"
+			}
+		]
+	},
+	"example.com/foo/bar": {
+		"nilness": {
+			"error": "type error"
+		}
+	}
+}
+`
+	findings, err := parseAnalyzersOutput(root, out)
+	ut.AssertEqual(t, nil, err)
+	expected := []Finding{
+		{token.Position{Filename: "foo.go", Line: 3, Column: 2}, "nilness: nil dereference"},
+		{Message: "example.com/foo/bar: nilness: type error"},
+	}
+	ut.AssertEqual(t, expected, findings)
+
+	findings, err = parseAnalyzersOutput(root, "")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []Finding(nil), findings)
+	_, err = parseAnalyzersOutput(root, "can't load packages\n")
+	ut.AssertEqual(t, true, err != nil)
+}
+
+func TestSplitPosn(t *testing.T) {
+	t.Parallel()
+	f, line, col := splitPosn("c:/foo.go:3:4")
+	ut.AssertEqual(t, "c:/foo.go", f)
+	ut.AssertEqual(t, 3, line)
+	ut.AssertEqual(t, 4, col)
+	f, line, col = splitPosn("foo.go")
+	ut.AssertEqual(t, "foo.go", f)
+	ut.AssertEqual(t, 0, line)
+	ut.AssertEqual(t, 0, col)
+}
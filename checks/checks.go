@@ -5,16 +5,24 @@
 // Package checks implements pre-made checks for pcg.
 //
 // This package defines the `pre-commit-go.yml` configuration file format and
-// implements all the checks.
+// implements all the checks. A program embedding this package can add its own
+// checks to the configuration file format with Register and run the checks
+// with Runner.
 package checks
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -41,7 +49,29 @@ type CheckPrerequisite struct {
 
 // IsPresent returns true if the prerequisite is present on the system.
 func (c *CheckPrerequisite) IsPresent() bool {
-	_, exitCode, _ := internal.Capture(cwd, nil, c.HelpCommand...)
+	_, exitCode, _ := internal.Capture(context.Background(), cwd, nil, c.HelpCommand...)
+	return exitCode == c.ExpectedExitCode
+}
+
+// IsPresentIn returns true if the prerequisite is present in the directory
+// bin, e.g. ToolsBin, ignoring the rest of PATH. The prerequisites verified by
+// running "go", like a package to build, are looked up like IsPresent.
+func (c *CheckPrerequisite) IsPresentIn(bin string) bool {
+	if len(c.HelpCommand) == 0 {
+		return false
+	}
+	if c.HelpCommand[0] == "go" {
+		return c.IsPresent()
+	}
+	name := c.HelpCommand[0]
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	p := filepath.Join(bin, name)
+	if _, err := os.Stat(p); err != nil {
+		return false
+	}
+	_, exitCode, _ := internal.Capture(context.Background(), cwd, nil, append([]string{p}, c.HelpCommand[1:]...)...)
 	return exitCode == c.ExpectedExitCode
 }
 
@@ -54,8 +84,17 @@ type Check interface {
 	// GetPrerequisites lists all the go packages to be installed before running
 	// this check.
 	GetPrerequisites() []CheckPrerequisite
-	// Run executes the check.
-	Run(change scm.Change, options *Options) error
+	// Run executes the check. It can return a Warning to report a non fatal
+	// issue.
+	Run(ctx context.Context, change scm.Change, options *Options) error
+}
+
+// Warning is returned by Check.Run to report an issue that shouldn't fail the
+// check.
+type Warning string
+
+func (w Warning) Error() string {
+	return string(w)
 }
 
 // Native checks.
@@ -64,10 +103,16 @@ type Check interface {
 type Build struct {
 	BuildAll  bool     `yaml:"build_all"`
 	ExtraArgs []string `yaml:"extra_args"`
+	// BinarySize builds the modified main packages and publishes the size in
+	// bytes of each executable as the metric "binary_size.<dir>".
+	BinarySize bool `yaml:"binary_size,omitempty"`
 }
 
 // GetDescription implements Check.
 func (b *Build) GetDescription() string {
+	if b.BinarySize {
+		return "measures the size of the executables of the modified main packages"
+	}
 	return "(obsolete)"
 }
 
@@ -81,53 +126,55 @@ func (b *Build) GetPrerequisites() []CheckPrerequisite {
 	return nil
 }
 
-// Run implements Check.
-func (b *Build) Run(change scm.Change, options *Options) error {
-	// With Go 1.4, 'go test' on a package without test now builds
-	// the package. So running this check is not unnecessary.
-	// https://golang.org/doc/go1.4#gocmd
-	return nil
-}
-
-// Copyright looks for copyright headers in all files.
-type Copyright struct {
-	Header string
-}
-
-// GetDescription implements Check.
-func (c *Copyright) GetDescription() string {
-	return "enforces all .go sources have copyright"
-}
-
-// GetName implements Check.
-func (c *Copyright) GetName() string {
-	return "copyright"
+// Scope implements Scoper.
+func (b *Build) Scope() Scope {
+	return ScopeIndirect
 }
 
-// GetPrerequisites implements Check.
-func (c *Copyright) GetPrerequisites() []CheckPrerequisite {
-	return nil
+// Weight implements Weighter.
+func (b *Build) Weight() Weight {
+	return WeightExclusive
 }
 
 // Run implements Check.
-func (c *Copyright) Run(change scm.Change, options *Options) error {
-	var badFiles []string
-	prefix := []byte(c.Header)
-	// This this serially since it's I/O bound and will compete with process
-	// startup of other checks.
-	for _, f := range change.Changed().GoFiles() {
-		if !change.IsIgnored(f) {
-			if content := change.Content(f); content != nil {
-				if !bytes.HasPrefix(content, prefix) {
-					badFiles = append(badFiles, f)
-				}
-			} else {
-				badFiles = append(badFiles, f)
-			}
-		}
+func (b *Build) Run(ctx context.Context, change scm.Change, options *Options) (err error) {
+	// With Go 1.4, 'go test' on a package without test now builds
+	// the package. So running this check is not unnecessary.
+	// https://golang.org/doc/go1.4#gocmd
+	if !b.BinarySize {
+		return nil
 	}
-	if len(badFiles) != 0 {
-		return fmt.Errorf("files have invalid copyright header:\n  %s", strings.Join(badFiles, "\n  "))
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := internal.RemoveAll(tmpDir); err == nil {
+			err = err2
+		}
+	}()
+	pkgs := change.Indirect().Packages()
+	options.Count(CounterPackages, len(pkgs))
+	for i, pkg := range pkgs {
+		out, exitCode, _, err := options.Capture(ctx, change.Repo(), "go", "list", "-f", "{{.Name}}", pkg)
+		if err != nil || exitCode != 0 {
+			return fmt.Errorf("go list %s failed: %v\n%s", pkg, err, out)
+		}
+		if strings.TrimSpace(out) != "main" {
+			continue
+		}
+		exe := filepath.Join(tmpDir, strconv.Itoa(i))
+		args := append(append([]string{"go", "build", "-o", exe}, b.ExtraArgs...), pkg)
+		out, exitCode, _, err = options.Capture(ctx, change.Repo(), args...)
+		options.SaveLog("build", pkg, out)
+		if err != nil || exitCode != 0 {
+			return fmt.Errorf("go build %s failed: %v\n%s", pkg, err, out)
+		}
+		fi, err := os.Stat(exe)
+		if err != nil {
+			return err
+		}
+		options.PublishMetric("binary_size."+pkgToDir(pkg), float64(fi.Size()))
 	}
 	return nil
 }
@@ -151,14 +198,19 @@ func (g *Gofmt) GetPrerequisites() []CheckPrerequisite {
 	return nil
 }
 
+// Scope implements Scoper.
+func (g *Gofmt) Scope() Scope {
+	return ScopeRepository
+}
+
 // Run implements Check.
-func (g *Gofmt) Run(change scm.Change, options *Options) error {
+func (g *Gofmt) Run(ctx context.Context, change scm.Change, options *Options) error {
 	// gofmt doesn't return non-zero even if some files need to be updated.
 	// gofmt accepts files, not packages but using . makes it recursive.
 	//
 	// TODO(maruel): Do it in process. It'll be much faster as the content of the
 	// modified files is already in memory.
-	out, _, _, err := options.Capture(change.Repo(), "gofmt", "-l", "-s", ".")
+	out, _, _, err := options.Capture(ctx, change.Repo(), "gofmt", "-l", "-s", ".")
 	// Split the files to ignore as needed.
 	files := []string{}
 	for _, line := range strings.Split(string(out), "\n") {
@@ -175,11 +227,128 @@ func (g *Gofmt) Run(change scm.Change, options *Options) error {
 	return nil
 }
 
+// Gomodtidy verifies that go.mod and go.sum are tidy.
+//
+// It is skipped when the repository has no go.mod at its root.
+type Gomodtidy struct {
+}
+
+// GetDescription implements Check.
+func (g *Gomodtidy) GetDescription() string {
+	return "enforces go.mod and go.sum are kept tidy per 'go mod tidy'"
+}
+
+// GetName implements Check.
+func (g *Gomodtidy) GetName() string {
+	return "gomodtidy"
+}
+
+// GetPrerequisites implements Check.
+func (g *Gomodtidy) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (g *Gomodtidy) Scope() Scope {
+	return ScopeRepository
+}
+
+// Run implements Check.
+func (g *Gomodtidy) Run(ctx context.Context, change scm.Change, options *Options) error {
+	root := change.Repo().Root()
+	if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+		return nil
+	}
+	// -diff was added in Go 1.23. It doesn't modify any file and returns
+	// non-zero when the files are not tidy.
+	out, exitCode, _, err := options.Capture(ctx, change.Repo(), "go", "mod", "tidy", "-diff")
+	if err != nil {
+		return fmt.Errorf("go mod tidy -diff failed: %s", err)
+	}
+	if exitCode == 0 {
+		return nil
+	}
+	if strings.Contains(out, "flag provided but not defined") {
+		return g.runCopy(ctx, change, options)
+	}
+	// Exit code 1 is also used for errors, e.g. a module that can't be
+	// fetched, in which case there's no diff.
+	if exitCode == 1 && isTidyDiff(out) {
+		return fmt.Errorf("go.mod or go.sum is not tidy, please run: go mod tidy\n%s", out)
+	}
+	return fmt.Errorf("go mod tidy -diff failed with exit code %d:\n%s", exitCode, out)
+}
+
+// runCopy runs 'go mod tidy' in a temporary copy of the repository, for
+// toolchains that do not support -diff.
+func (g *Gomodtidy) runCopy(ctx context.Context, change scm.Change, options *Options) (err error) {
+	root := change.Repo().Root()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := internal.RemoveAll(tmpDir); err == nil {
+			err = err2
+		}
+	}()
+	if err = copyTree(root, tmpDir); err != nil {
+		return err
+	}
+	options.LeaseRunToken()
+	out, exitCode, err := internal.Capture(ctx, tmpDir, internal.GoEnv(tmpDir, change.Repo().GOPATH()), "go", "mod", "tidy")
+	options.ReturnRunToken()
+	if err != nil || exitCode != 0 {
+		return fmt.Errorf("go mod tidy failed: %v\n%s", err, out)
+	}
+	var bad []string
+	for _, name := range []string{"go.mod", "go.sum"} {
+		before, _ := ioutil.ReadFile(filepath.Join(root, name))
+		after, _ := ioutil.ReadFile(filepath.Join(tmpDir, name))
+		if !bytes.Equal(before, after) {
+			bad = append(bad, name)
+		}
+	}
+	if len(bad) != 0 {
+		return fmt.Errorf("%s not tidy, please run: go mod tidy", strings.Join(bad, " and "))
+	}
+	return nil
+}
+
 // Test runs all tests via go test.
 type Test struct {
 	ExtraArgs []string `yaml:"extra_args"`
+	// Tags is a list of build tag sets to run the tests with, one run per
+	// entry. Each entry is passed as -tags, e.g. "integration,linux". "" means
+	// no tag. If empty, the tests are run once without tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// Platforms is a list of "GOOS/GOARCH" to test, e.g. "windows/amd64". The
+	// tests are only compiled for foreign platforms, ExtraArgs is not used for
+	// these. If empty, only the host platform is tested.
+	Platforms []string `yaml:"platforms,omitempty"`
+	// AffectedOnly only runs the tests that reference a declaration of the
+	// modified files in directly modified packages, via a computed -run
+	// regexp. Packages only indirectly affected run all their tests. It is
+	// meant for fast modes like pre-commit.
+	AffectedOnly bool `yaml:"affected_only,omitempty"`
+	// Retries is the number of times a failing package is tested again. A
+	// package that fails then passes is flaky.
+	Retries int `yaml:"retries,omitempty"`
+	// ReportFlaky is either "warn" or "fail". With "warn", the default, flaky
+	// packages are reported as a warning. With "fail", they fail the check.
+	// In both cases, the flaky tests are listed in flaky_tests.txt in the
+	// artifacts directory.
+	ReportFlaky string `yaml:"report_flaky,omitempty"`
+	// Cache skips the packages that passed before with the same arguments and
+	// the same content of all the packages they depend on. The results are
+	// cached in the scm directory, e.g. .git/pcg-test-cache.
+	Cache bool `yaml:"cache,omitempty"`
 }
 
+// FlakyTestsFile is the file listing the flaky tests in the artifacts
+// directory.
+const FlakyTestsFile = "flaky_tests.txt"
+
 // GetDescription implements Check.
 func (t *Test) GetDescription() string {
 	return "runs all tests, potentially with options (race detector, different tags, etc)"
@@ -195,41 +364,195 @@ func (t *Test) GetPrerequisites() []CheckPrerequisite {
 	return nil
 }
 
+// Scope implements Scoper.
+func (t *Test) Scope() Scope {
+	return ScopeIndirect
+}
+
+// Weight implements Weighter.
+func (t *Test) Weight() Weight {
+	return WeightPerPackage
+}
+
 // Run implements Check.
-func (t *Test) Run(change scm.Change, options *Options) error {
+func (t *Test) Run(ctx context.Context, change scm.Change, options *Options) error {
+	tags := t.Tags
+	if len(tags) == 0 {
+		tags = []string{""}
+	}
+	platforms := t.Platforms
+	if len(platforms) == 0 {
+		// Host platform.
+		platforms = []string{""}
+	}
+	for _, p := range platforms {
+		if p != "" && !rePlatform.MatchString(p) {
+			return fmt.Errorf("test has invalid platform %q; expected GOOS/GOARCH", p)
+		}
+	}
+	if t.ReportFlaky != "" && t.ReportFlaky != "warn" && t.ReportFlaky != "fail" {
+		return fmt.Errorf("test has invalid report_flaky %q; expected \"warn\" or \"fail\"", t.ReportFlaky)
+	}
 	// go test accepts packages, not files.
 	var wg sync.WaitGroup
 	// With go 1.4, 'go test' now correctly build all packages even if they have
 	// no test. https://golang.org/doc/go1.4#gocmd
-	testPkgs := change.Indirect().Packages()
-	errs := make(chan error, len(testPkgs))
+	testPkgs := options.Shard.Filter(change.Indirect().Packages())
+	options.Count(CounterPackages, len(testPkgs))
+	var filters map[string]string
+	if t.AffectedOnly {
+		filters = affectedTests(change)
+	}
+	var cache *testCache
+	if t.Cache || options.Resume {
+		var err error
+		if cache, err = newTestCache(ctx, change.Repo(), options); err != nil {
+			log.Printf("test cache disabled: %s", err)
+		}
+	}
+	errs := make(chan error, len(testPkgs)*len(tags)*len(platforms))
+	flakes := make(chan string, len(testPkgs)*len(tags)*len(platforms))
+	var mu sync.Mutex
+	durations := map[string]time.Duration{}
 	for _, tp := range testPkgs {
-		wg.Add(1)
-		go func(testPkg string) {
-			defer wg.Done()
-			args := append(
-				[]string{
-					"go", "test",
-					"-timeout", fmt.Sprintf("%ds", options.MaxDuration),
-				},
-				t.ExtraArgs...)
-			args = append(args, testPkg)
-			out, exitCode, duration, _ := options.Capture(change.Repo(), args...)
-			if duration > time.Second {
-				log.Printf("%s was slow: %s", args, round(duration, time.Millisecond))
-			}
-			if exitCode != 0 {
-				errs <- fmt.Errorf("%s failed:\n%s", strings.Join(args, " "), processStackTrace(out))
+		for _, tag := range tags {
+			for _, platform := range platforms {
+				wg.Add(1)
+				go func(testPkg, tag, platform string) {
+					defer wg.Done()
+					env, args := t.args(testPkg, tag, platform, filters[testPkg], options.MaxDuration)
+					cmd := strings.Join(append(env, args...), " ")
+					key := ""
+					if cache != nil {
+						var err error
+						if key, err = cache.key(ctx, change.Repo(), options, env, args); err != nil {
+							log.Printf("%s not cached: %s", cmd, err)
+						} else if cache.has(key) {
+							log.Printf("%s cached", cmd)
+							options.Count(CounterCacheHits, 1)
+							return
+						}
+					}
+					logName := testPkg
+					if tag != "" {
+						logName += " tags=" + tag
+					}
+					if platform != "" {
+						logName += " " + platform
+					}
+					var failed []string
+					for i := 0; i <= t.Retries; i++ {
+						out, exitCode, duration, _ := options.CaptureEnv(ctx, change.Repo(), env, args...)
+						options.SaveLog("test", logName, out)
+						if duration > time.Second {
+							log.Printf("%s was slow: %s", args, round(duration, time.Millisecond))
+						}
+						mu.Lock()
+						durations[testPkg] += duration
+						mu.Unlock()
+						if exitCode == 0 {
+							if key != "" {
+								if err := cache.add(key); err != nil {
+									log.Printf("failed to cache %s: %s", cmd, err)
+								}
+							}
+							if i != 0 {
+								flakes <- fmt.Sprintf("%s: %s", cmd, strings.Join(failed, ", "))
+							}
+							options.RecordResult("test:"+testPkg, true)
+							return
+						}
+						failed = failedTests(out)
+						if i == t.Retries {
+							options.RecordResult("test:"+testPkg, false)
+							errs <- fmt.Errorf("%s failed:\n%s", cmd, processStackTrace(out))
+						}
+					}
+				}(tp, tag, platform)
 			}
-		}(tp)
+		}
 	}
 	wg.Wait()
+	for testPkg, d := range durations {
+		options.PublishMetric("test.duration."+pkgToDir(testPkg), d.Seconds())
+	}
+	if len(testPkgs) != 0 {
+		options.PublishMetric("test.count", float64(countTests(change, testPkgs)))
+	}
+	var flaky []string
+	for loop := true; loop; {
+		select {
+		case f := <-flakes:
+			flaky = append(flaky, f)
+		default:
+			loop = false
+		}
+	}
 	select {
 	case err := <-errs:
 		return err
 	default:
 	}
-	return nil
+	if len(flaky) == 0 {
+		return nil
+	}
+	sort.Strings(flaky)
+	summary := strings.Join(flaky, "\n")
+	if options.ArtifactsDir != "" {
+		if err := ioutil.WriteFile(filepath.Join(options.ArtifactsDir, FlakyTestsFile), []byte(summary+"\n"), 0644); err != nil {
+			log.Printf("failed to write %s: %s", FlakyTestsFile, err)
+		}
+	}
+	if t.ReportFlaky == "fail" {
+		return fmt.Errorf("flaky tests:\n%s", summary)
+	}
+	return Warning("flaky tests:\n" + summary)
+}
+
+// args returns the environment and the command to test testPkg with the tags
+// on platform. An empty platform means the host platform. run, if set, is the
+// -run regexp.
+func (t *Test) args(testPkg, tags, platform, run string, maxDuration int) ([]string, []string) {
+	var env []string
+	crossCompile := false
+	if platform != "" {
+		parts := strings.SplitN(platform, "/", 2)
+		env = []string{"GOOS=" + parts[0], "GOARCH=" + parts[1]}
+		crossCompile = parts[0] != runtime.GOOS || parts[1] != runtime.GOARCH
+	}
+	var args []string
+	if crossCompile {
+		// The tests can't be run, only compile them. The race detector and
+		// test flags are not applicable.
+		args = []string{"go", "test", "-c", "-o", os.DevNull}
+	} else {
+		args = append(
+			[]string{
+				"go", "test",
+				"-timeout", fmt.Sprintf("%ds", maxDuration),
+			},
+			t.ExtraArgs...)
+		if run != "" {
+			args = append(args, "-run", run)
+		}
+	}
+	if tags != "" {
+		args = append(args, "-tags", tags)
+	}
+	return env, append(args, testPkg)
+}
+
+// failedTests returns the name of the failed tests in a go test output.
+func failedTests(out string) []string {
+	var names []string
+	for _, m := range reFailedTest.FindAllStringSubmatch(out, -1) {
+		names = append(names, m[1])
+	}
+	if len(names) == 0 {
+		// The package failed without any failed test, e.g. a panic in init.
+		names = append(names, "<package>")
+	}
+	return names
 }
 
 // Errcheck runs errcheck on packages.
@@ -254,11 +577,18 @@ func (e *Errcheck) GetPrerequisites() []CheckPrerequisite {
 	}
 }
 
+// Scope implements Scoper.
+func (e *Errcheck) Scope() Scope {
+	return ScopePackages
+}
+
 // Run implements Check.
-func (e *Errcheck) Run(change scm.Change, options *Options) error {
+func (e *Errcheck) Run(ctx context.Context, change scm.Change, options *Options) error {
 	// errcheck accepts packages, not files.
 	args := []string{"errcheck", "-ignore", e.Ignores}
-	out, _, _, err := options.Capture(change.Repo(), append(args, change.Changed().Packages()...)...)
+	pkgs := change.Changed().Packages()
+	options.Count(CounterPackages, len(pkgs))
+	out, _, _, err := options.Capture(ctx, change.Repo(), append(args, pkgs...)...)
 	if len(out) != 0 {
 		// TODO(maruel): Process output so paths are relative from
 		// change.Repo().Root().
@@ -293,11 +623,16 @@ func (g *Goimports) GetPrerequisites() []CheckPrerequisite {
 	}
 }
 
+// Scope implements Scoper.
+func (g *Goimports) Scope() Scope {
+	return ScopeGoFiles
+}
+
 // Run implements Check.
-func (g *Goimports) Run(change scm.Change, options *Options) error {
+func (g *Goimports) Run(ctx context.Context, change scm.Change, options *Options) error {
 	// goimports accepts files, not packages.
 	// goimports doesn't return non-zero even if some files need to be updated.
-	out, _, _, err := options.Capture(change.Repo(), append([]string{"goimports", "-l"}, change.Changed().GoFiles()...)...)
+	out, _, _, err := options.Capture(ctx, change.Repo(), append([]string{"goimports", "-l"}, change.Changed().GoFiles()...)...)
 	if len(out) != 0 {
 		return fmt.Errorf("these files are improperly formatted, please run: goimports -w <files>\n%s", out)
 	}
@@ -329,13 +664,24 @@ func (g *Golint) GetPrerequisites() []CheckPrerequisite {
 	}
 }
 
+// Scope implements Scoper.
+func (g *Golint) Scope() Scope {
+	return ScopePackages
+}
+
+// Weight implements Weighter.
+func (g *Golint) Weight() Weight {
+	return WeightPerPackage
+}
+
 // Run implements Check.
-func (g *Golint) Run(change scm.Change, options *Options) error {
+func (g *Golint) Run(ctx context.Context, change scm.Change, options *Options) error {
 	// - accepts packages, not files.
 	// - doesn't return non-zero ever.
 	// - doesn't like multiple packages per call.
 	// - "." is not recursive.
 	pkgs := change.Changed().Packages()
+	options.Count(CounterPackages, len(pkgs))
 	resultsC := make(chan []string, len(pkgs))
 	files := map[string]bool{}
 	for _, f := range change.Changed().GoFiles() {
@@ -344,7 +690,7 @@ func (g *Golint) Run(change scm.Change, options *Options) error {
 	for _, pkg := range pkgs {
 		go func(p string) {
 			r := []string{}
-			out, _, _, _ := options.Capture(change.Repo(), "golint", p)
+			out, _, _, _ := options.Capture(ctx, change.Repo(), "golint", p)
 			for _, line := range strings.Split(string(out), "\n") {
 				if len(line) == 0 {
 					continue
@@ -402,14 +748,19 @@ func (g *Govet) GetPrerequisites() []CheckPrerequisite {
 	}
 }
 
+// Scope implements Scoper.
+func (g *Govet) Scope() Scope {
+	return ScopeGoFiles
+}
+
 // Run implements Check.
-func (g *Govet) Run(change scm.Change, options *Options) error {
+func (g *Govet) Run(ctx context.Context, change scm.Change, options *Options) error {
 	// - accepts packages, not files.
 	// - returns non-zero on report.
 	// - accepts multiple packages per call.
 	// - "." is recursive.
 	// Ignore the return code since we ignore many errors.
-	out, _, _, _ := options.Capture(change.Repo(), "go", "tool", "vet", "-all", ".")
+	out, _, _, _ := options.Capture(ctx, change.Repo(), "go", "tool", "vet", "-all", ".")
 	result := []string{}
 	files := map[string]bool{}
 	for _, f := range change.Changed().GoFiles() {
@@ -441,11 +792,131 @@ func (g *Govet) Run(change scm.Change, options *Options) error {
 	return nil
 }
 
+// Ineffassign runs ineffassign on the modified files.
+type Ineffassign struct {
+}
+
+// GetDescription implements Check.
+func (i *Ineffassign) GetDescription() string {
+	return "enforces there is no ineffectual assignment using tool 'ineffassign'"
+}
+
+// GetName implements Check.
+func (i *Ineffassign) GetName() string {
+	return "ineffassign"
+}
+
+// GetPrerequisites implements Check.
+func (i *Ineffassign) GetPrerequisites() []CheckPrerequisite {
+	return []CheckPrerequisite{
+		{[]string{"ineffassign", "-h"}, 2, "github.com/gordonklaus/ineffassign"},
+	}
+}
+
+// Scope implements Scoper.
+func (i *Ineffassign) Scope() Scope {
+	return ScopeGoFiles
+}
+
+// Run implements Check.
+func (i *Ineffassign) Run(ctx context.Context, change scm.Change, options *Options) error {
+	// - accepts files, not packages.
+	// - returns non-zero on report.
+	var files []string
+	for _, f := range change.Changed().GoFiles() {
+		if !change.IsIgnored(f) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	out, exitCode, _, err := options.Capture(ctx, change.Repo(), append([]string{"ineffassign"}, files...)...)
+	if err != nil {
+		return fmt.Errorf("ineffassign failed: %s", err)
+	}
+	if exitCode != 0 || len(out) != 0 {
+		return fmt.Errorf("ineffassign failed:\n%s", out)
+	}
+	return nil
+}
+
+// Misspell runs misspell on comments and documentation.
+type Misspell struct {
+	// Locale is either "", "US" or "UK". "" accepts both.
+	Locale string
+	// Ignores is the list of words to not correct.
+	Ignores []string
+	// IncludeMarkdown includes the .md files in addition to .go files.
+	IncludeMarkdown bool `yaml:"include_markdown"`
+}
+
+// GetDescription implements Check.
+func (m *Misspell) GetDescription() string {
+	return "enforces comments and documentation have no commonly misspelled English words"
+}
+
+// GetName implements Check.
+func (m *Misspell) GetName() string {
+	return "misspell"
+}
+
+// GetPrerequisites implements Check.
+func (m *Misspell) GetPrerequisites() []CheckPrerequisite {
+	return []CheckPrerequisite{
+		{[]string{"misspell", "-h"}, 2, "github.com/client9/misspell/cmd/misspell"},
+	}
+}
+
+// Scope implements Scoper.
+func (m *Misspell) Scope() Scope {
+	return ScopeFiles
+}
+
+// considers implements fileFilter.
+func (m *Misspell) considers(p string) bool {
+	return strings.HasSuffix(p, ".go") || (m.IncludeMarkdown && strings.HasSuffix(p, ".md"))
+}
+
+// Run implements Check.
+func (m *Misspell) Run(ctx context.Context, change scm.Change, options *Options) error {
+	// - accepts files, not packages.
+	// - doesn't return non-zero unless -error is specified.
+	var files []string
+	for _, f := range change.Changed().Files() {
+		if change.IsIgnored(f) {
+			continue
+		}
+		if m.considers(f) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	args := []string{"misspell"}
+	if m.Locale != "" {
+		args = append(args, "-locale", m.Locale)
+	}
+	if len(m.Ignores) != 0 {
+		args = append(args, "-i", strings.Join(m.Ignores, ","))
+	}
+	out, _, _, err := options.Capture(ctx, change.Repo(), append(args, files...)...)
+	if len(out) != 0 {
+		return fmt.Errorf("%s failed:\n%s", strings.Join(args, " "), out)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %s", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
 // Extensibility.
 
 // Custom represents a user configured check running an external program.
 //
-// It can be used multiple times to run multiple external checks.
+// It can be used multiple times to run multiple external checks. The program
+// can publish metrics by printing lines "pcg-metric: <name>=<value>".
 type Custom struct {
 	// DisplayName is check's display name, required.
 	DisplayName string `yaml:"display_name"`
@@ -456,6 +927,13 @@ type Custom struct {
 	// CheckExitCode specifies if the check is declared to fail when exit code is
 	// non-zero.
 	CheckExitCode bool `yaml:"check_exit_code"`
+	// Protocol is how the command reports its result. When empty, the output
+	// is only used on failure, per CheckExitCode. With "json", the change is
+	// written as an ExternalRequest on stdin and the command prints an
+	// ExternalResponse on stdout, so its findings are reported per file.
+	Protocol string `yaml:"protocol,omitempty"`
+	// Config is passed as is to the command in the "json" protocol request.
+	Config map[string]interface{} `yaml:"config,omitempty"`
 	// Prerequisites are check's prerequisite packages to install first before
 	// running the check, optional.
 	Prerequisites []CheckPrerequisite `yaml:"prerequisites"`
@@ -479,11 +957,30 @@ func (c *Custom) GetPrerequisites() []CheckPrerequisite {
 	return c.Prerequisites
 }
 
+// Scope implements Scoper.
+func (c *Custom) Scope() Scope {
+	// Only the json protocol is told about the change.
+	if c.Protocol == "json" {
+		return ScopeFiles
+	}
+	return ScopeRepository
+}
+
 // Run implements Check.
-func (c *Custom) Run(change scm.Change, options *Options) error {
-	// TODO(maruel): Make what is passed to the command configurable, e.g. one of:
-	// (Changed, Indirect, All) x (GoFiles, Packages, TestPackages)
-	out, exitCode, _, err := options.Capture(change.Repo(), c.Command...)
+func (c *Custom) Run(ctx context.Context, change scm.Change, options *Options) error {
+	switch c.Protocol {
+	case "":
+	case "json":
+		return c.runJSON(ctx, change, options)
+	default:
+		return fmt.Errorf("invalid protocol %q; expected \"json\" or empty", c.Protocol)
+	}
+	out, exitCode, _, err := options.Capture(ctx, change.Repo(), c.Command...)
+	for _, m := range reMetric.FindAllStringSubmatch(out, -1) {
+		if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+			options.PublishMetric(m[1], v)
+		}
+	}
 	if exitCode != 0 && c.CheckExitCode {
 		return fmt.Errorf("\"%s\" failed with code %d:\n%s", strings.Join(c.Command, " "), exitCode, out)
 	}
@@ -492,22 +989,136 @@ func (c *Custom) Run(change scm.Change, options *Options) error {
 
 // Rest.
 
-// KnownChecks is the map of all known checks per check name.
-var KnownChecks = map[string]func() Check{
-	(&Build{}).GetName():     func() Check { return &Build{} },
-	(&Copyright{}).GetName(): func() Check { return &Copyright{} },
-	(&Coverage{}).GetName():  func() Check { return &Coverage{} },
-	(&Custom{}).GetName():    func() Check { return &Custom{} },
-	(&Errcheck{}).GetName():  func() Check { return &Errcheck{} },
-	(&Gofmt{}).GetName():     func() Check { return &Gofmt{} },
-	(&Goimports{}).GetName(): func() Check { return &Goimports{} },
-	(&Golint{}).GetName():    func() Check { return &Golint{} },
-	(&Govet{}).GetName():     func() Check { return &Govet{} },
-	(&Test{}).GetName():      func() Check { return &Test{} },
+// rePlatform matches a "GOOS/GOARCH" value.
+var rePlatform = regexp.MustCompile("^[a-z0-9]+/[a-z0-9]+$")
+
+// reMetric matches a "pcg-metric: <name>=<value>" line printed by a custom
+// check to publish a metric.
+var reMetric = regexp.MustCompile(`(?m)^pcg-metric: ([^\s=]+)=(\S+)\s*$`)
+
+// reFailedTest matches a failed top level test in go test output.
+var reFailedTest = regexp.MustCompile("(?m)^--- FAIL: ([^ /]+) ")
+
+// KnownChecks is the map of all known checks per check name, including the
+// ones added with Register.
+//
+// Deprecated: use Register to add a check, and RegisteredChecks and NewCheck
+// to list and create them. Modifying the map directly is not safe.
+var KnownChecks = builtinChecks()
+
+// Register makes a check available in the configuration file under name, so a
+// program embedding this package can add its own Check implementations.
+// factory must return a new instance with its default values; the
+// configuration is decoded into it as YAML.
+//
+// It is meant to be called from an init() function. It panics if name is
+// already registered, or if it is not the name returned by the check.
+func Register(name string, factory func() Check) {
+	if factory == nil {
+		panic("checks: Register factory is nil")
+	}
+	if n := factory().GetName(); n != name {
+		panic(fmt.Sprintf("checks: Register called with %q for check %q", name, n))
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := KnownChecks[name]; ok {
+		panic(fmt.Sprintf("checks: Register called twice for check %q", name))
+	}
+	KnownChecks[name] = factory
+}
+
+// RegisteredChecks returns the sorted names of the checks that can be used in
+// the configuration file.
+func RegisteredChecks() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	out := make([]string, 0, len(KnownChecks))
+	for name := range KnownChecks {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NewCheck returns a new instance of the check name with its default values.
+func NewCheck(name string) (Check, error) {
+	registry.RLock()
+	factory, ok := KnownChecks[name]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown check \"%s\"", name)
+	}
+	return factory(), nil
 }
 
 // Private stuff.
 
+// registry protects KnownChecks.
+var registry sync.RWMutex
+
+// builtinChecks returns the checks implemented in this package.
+func builtinChecks() map[string]func() Check {
+	builtins := []func() Check{
+		func() Check { return &Analyzers{} },
+		func() Check { return &Build{} },
+		func() Check { return &Copyright{} },
+		func() Check { return &Coverage{} },
+		func() Check { return &Custom{} },
+		func() Check { return &Errcheck{} },
+		func() Check { return &ForbiddenImports{} },
+		func() Check { return &Gocyclo{} },
+		func() Check { return &Gofmt{} },
+		func() Check { return &Goimports{} },
+		func() Check { return &Golint{} },
+		func() Check { return &Gomodtidy{} },
+		func() Check { return &Govet{} },
+		func() Check { return &Ineffassign{} },
+		func() Check { return &Misspell{} },
+		func() Check { return &Secrets{} },
+		func() Check { return &StaleBranches{} },
+		func() Check { return &Test{} },
+	}
+	out := make(map[string]func() Check, len(builtins))
+	for _, f := range builtins {
+		out[f().GetName()] = f
+	}
+	return out
+}
+
+// isTidyDiff returns true if out, the output of 'go mod tidy -diff', contains
+// the diff of go.mod or go.sum.
+func isTidyDiff(out string) bool {
+	return strings.HasPrefix(out, "diff current/") || strings.Contains(out, "\ndiff current/")
+}
+
+// copyTree copies the directory src to dst, skipping the .git directory.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), content, 0600)
+	})
+}
+
 // cwd provides a valid path to CheckPrerequisite.IsPresent().
 var cwd string
 
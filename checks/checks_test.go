@@ -5,18 +5,32 @@
 package checks
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/maruel/pre-commit-go/internal"
 	"github.com/maruel/pre-commit-go/scm"
 	"github.com/maruel/ut"
+	"gopkg.in/yaml.v2"
 )
 
+func TestMain(m *testing.M) {
+	// The repositories created by setup() are GOPATH workspaces; make sure the
+	// go toolchain doesn't run in module mode in them.
+	if err := os.Setenv("GO111MODULE", "off"); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 func TestCheckPrerequisite(t *testing.T) {
 	// Runs all checks, they should all pass.
 	t.Parallel()
@@ -38,8 +52,8 @@ func TestChecksSuccess(t *testing.T) {
 		}
 	}()
 	change := setup(t, td, goodFiles)
-	for _, name := range getKnownChecks() {
-		c := KnownChecks[name]()
+	for _, name := range RegisteredChecks() {
+		c := mustNewCheck(t, name)
 		switch name {
 		case "custom":
 			c = &Custom{
@@ -63,8 +77,11 @@ func TestChecksSuccess(t *testing.T) {
 			cov.Global.MaxCoverage = 100
 			cov.PerDirDefault.MinCoverage = 100
 			cov.PerDirDefault.MaxCoverage = 100
+		case "stalebranches":
+			// There's no remote branch to compare.
+			c.(*StaleBranches).DefaultBranch = "master"
 		}
-		if err := c.Run(change, &Options{MaxDuration: 1}); err != nil {
+		if err := c.Run(context.Background(), change, &Options{MaxDuration: 1}); err != nil {
 			t.Errorf("%s failed: %s", c.GetName(), err)
 		}
 	}
@@ -84,9 +101,12 @@ func TestChecksFailure(t *testing.T) {
 		}
 	}()
 	change := setup(t, td, badFiles)
-	for _, name := range getKnownChecks() {
-		c := KnownChecks[name]()
+	for _, name := range RegisteredChecks() {
+		c := mustNewCheck(t, name)
 		switch name {
+		case "analyzers":
+			// It requires golang.org/x/tools to be installed.
+			continue
 		case "build":
 			// This check is obsolete.
 			continue
@@ -112,8 +132,15 @@ func TestChecksFailure(t *testing.T) {
 			cov.Global.MaxCoverage = 100
 			cov.PerDirDefault.MinCoverage = 100
 			cov.PerDirDefault.MaxCoverage = 100
+		case "forbiddenimports":
+			c.(*ForbiddenImports).Rules = []ImportRule{{Import: "errors"}}
+		case "gocyclo":
+			c.(*Gocyclo).PerDirDefault.MaxComplexity = 1
+		case "gomodtidy":
+			// It requires a go.mod; see TestGomodtidy.
+			continue
 		}
-		if err := c.Run(change, &Options{MaxDuration: 1}); err == nil {
+		if err := c.Run(context.Background(), change, &Options{MaxDuration: 1}); err == nil {
 			t.Errorf("%s didn't fail but was expected to", c.GetName())
 		}
 	}
@@ -121,13 +148,60 @@ func TestChecksFailure(t *testing.T) {
 
 func TestChecksDescriptions(t *testing.T) {
 	t.Parallel()
-	for _, name := range getKnownChecks() {
-		c := KnownChecks[name]()
+	for _, name := range RegisteredChecks() {
+		c := mustNewCheck(t, name)
 		ut.AssertEqual(t, true, c.GetDescription() != "")
 		c.GetPrerequisites()
 	}
 }
 
+// registeredCheck is a check registered by TestRegister.
+type registeredCheck struct {
+	Value int `yaml:"value"`
+}
+
+func (r *registeredCheck) GetDescription() string                { return "registered" }
+func (r *registeredCheck) GetName() string                       { return "registered" }
+func (r *registeredCheck) GetPrerequisites() []CheckPrerequisite { return nil }
+func (r *registeredCheck) Run(ctx context.Context, change scm.Change, options *Options) error {
+	return nil
+}
+
+func TestRegister(t *testing.T) {
+	// Not parallel since the other tests run all the registered checks.
+	Register("registered", func() Check { return &registeredCheck{Value: 1} })
+	defer func() {
+		registry.Lock()
+		delete(KnownChecks, "registered")
+		registry.Unlock()
+	}()
+	found := false
+	for _, name := range RegisteredChecks() {
+		found = found || name == "registered"
+	}
+	ut.AssertEqual(t, true, found)
+	c, err := NewCheck("registered")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, &registeredCheck{Value: 1}, c)
+	_, err = NewCheck("unregistered")
+	ut.AssertEqual(t, errors.New("unknown check \"unregistered\""), err)
+
+	var config Config
+	ut.AssertEqual(t, nil, yaml.Unmarshal([]byte("modes:\n  lint:\n    checks:\n      registered:\n      - value: 2\n"), &config))
+	ut.AssertEqual(t, Checks{"registered": {&registeredCheck{Value: 2}}}, config.Modes[Lint].Checks)
+
+	panics := func(f func()) (out bool) {
+		defer func() {
+			out = recover() != nil
+		}()
+		f()
+		return
+	}
+	ut.AssertEqual(t, true, panics(func() { Register("registered", func() Check { return &registeredCheck{} }) }))
+	ut.AssertEqual(t, true, panics(func() { Register("other", func() Check { return &registeredCheck{} }) }))
+	ut.AssertEqual(t, true, panics(func() { Register("registered", nil) }))
+}
+
 func TestCustom(t *testing.T) {
 	t.Parallel()
 	p := []CheckPrerequisite{
@@ -146,6 +220,152 @@ func TestCustom(t *testing.T) {
 	ut.AssertEqual(t, p, c.GetPrerequisites())
 }
 
+func TestCustomCancelled(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c := &Custom{Command: []string{"sleep", "60"}}
+	start := time.Now()
+	ut.AssertEqual(t, context.DeadlineExceeded, c.Run(ctx, change, &Options{}))
+	ut.AssertEqual(t, true, time.Since(start) < 30*time.Second)
+}
+
+func TestGomodtidy(t *testing.T) {
+	// This test can't be parallel.
+	if testing.Short() {
+		t.SkipNow()
+	}
+	old := os.Getenv("GO111MODULE")
+	defer func() {
+		ut.ExpectEqual(t, nil, os.Setenv("GO111MODULE", old))
+	}()
+	ut.AssertEqual(t, nil, os.Setenv("GO111MODULE", "on"))
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{
+		"go.mod": "module foo\n\ngo 1.21\n",
+		"foo.go": "package foo\n",
+	})
+	root := change.Repo().Root()
+	g := &Gomodtidy{}
+	options := &Options{offline: true}
+	ut.AssertEqual(t, nil, g.Run(context.Background(), change, options))
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module foo\n\ngo 1.21\n\nrequire example.com/unused v1.0.0\n"), 0600))
+	err = g.Run(context.Background(), change, options)
+	ut.AssertEqual(t, true, err != nil)
+	ut.AssertEqual(t, true, strings.HasPrefix(err.Error(), "go.mod or go.sum is not tidy"))
+
+	// A failure to run go mod tidy is not reported as untidy files.
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(root, "go.mod"), []byte("module foo\n\ngo 1.21\n"), 0600))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(root, "foo.go"), []byte("package foo\n\nimport _ \"example.com/missing\"\n"), 0600))
+	err = g.Run(context.Background(), change, options)
+	ut.AssertEqual(t, true, err != nil)
+	ut.AssertEqual(t, true, strings.HasPrefix(err.Error(), "go mod tidy -diff failed with exit code 1:"))
+}
+
+func TestIsTidyDiff(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, false, isTidyDiff(""))
+	ut.AssertEqual(t, false, isTidyDiff("go: example.com/missing: module lookup disabled by GOPROXY=off\n"))
+	ut.AssertEqual(t, true, isTidyDiff("diff current/go.mod tidy/go.mod\n--- current/go.mod\n+++ tidy/go.mod\n"))
+	ut.AssertEqual(t, true, isTidyDiff("go: downloading example.com/foo v1.0.0\ndiff current/go.sum tidy/go.sum\n"))
+}
+
+func TestTestArgs(t *testing.T) {
+	t.Parallel()
+	c := &Test{ExtraArgs: []string{"-race"}}
+	env, args := c.args("./foo", "", "", "", 10)
+	ut.AssertEqual(t, []string(nil), env)
+	ut.AssertEqual(t, []string{"go", "test", "-timeout", "10s", "-race", "./foo"}, args)
+
+	env, args = c.args("./foo", "integration", runtime.GOOS+"/"+runtime.GOARCH, "^(TestA)$", 10)
+	ut.AssertEqual(t, []string{"GOOS=" + runtime.GOOS, "GOARCH=" + runtime.GOARCH}, env)
+	ut.AssertEqual(t, []string{"go", "test", "-timeout", "10s", "-race", "-run", "^(TestA)$", "-tags", "integration", "./foo"}, args)
+
+	env, args = c.args("./foo", "", "plan9/mips", "^(TestA)$", 10)
+	ut.AssertEqual(t, []string{"GOOS=plan9", "GOARCH=mips"}, env)
+	ut.AssertEqual(t, []string{"go", "test", "-c", "-o", os.DevNull, "./foo"}, args)
+
+	c.Platforms = []string{"windows"}
+	ut.AssertEqual(t, errors.New("test has invalid platform \"windows\"; expected GOOS/GOARCH"), c.Run(context.Background(), nil, &Options{}))
+}
+
+func TestTestFlaky(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	marker := filepath.Join(td, "marker")
+	files := map[string]string{
+		"foo_test.go": `package foo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFlaky(t *testing.T) {
+	if _, err := os.Stat(` + strconv.Quote(marker) + `); err != nil {
+		ioutil.WriteFile(` + strconv.Quote(marker) + `, nil, 0600)
+		t.Fail()
+	}
+}
+`,
+		"foo.go": "package foo\n",
+	}
+	change := setup(t, td, files)
+	artifacts := filepath.Join(td, "artifacts")
+	ut.AssertEqual(t, nil, os.Mkdir(artifacts, 0700))
+	// Only the first run of the test fails, since the marker file exists
+	// afterward.
+	c := &Test{}
+	ut.AssertEqual(t, true, c.Run(context.Background(), change, &Options{MaxDuration: 60}) != nil)
+	ut.AssertEqual(t, nil, os.Remove(marker))
+
+	c.Retries = 1
+	err = c.Run(context.Background(), change, &Options{MaxDuration: 60, ArtifactsDir: artifacts})
+	_, ok := err.(Warning)
+	ut.AssertEqual(t, true, ok)
+	content, err := ioutil.ReadFile(filepath.Join(artifacts, FlakyTestsFile))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, strings.HasSuffix(string(content), ": TestFlaky\n"))
+}
+
+func TestFailedTests(t *testing.T) {
+	t.Parallel()
+	out := "=== RUN   TestA\n--- FAIL: TestA (0.00s)\n    --- FAIL: TestA/sub (0.00s)\n--- PASS: TestB (0.00s)\n--- FAIL: TestC (0.01s)\nFAIL\n"
+	ut.AssertEqual(t, []string{"TestA", "TestC"}, failedTests(out))
+	ut.AssertEqual(t, []string{"<package>"}, failedTests("panic: boom\n"))
+
+	c := &Test{ReportFlaky: "maybe"}
+	ut.AssertEqual(t, errors.New("test has invalid report_flaky \"maybe\"; expected \"warn\" or \"fail\""), c.Run(context.Background(), nil, &Options{}))
+}
+
 // Private stuff.
 
 // This set of files passes all the tests.
@@ -175,16 +395,23 @@ func TestSuccess(t *testing.T) {
 
 // This set of files fails all the tests.
 var badFiles = map[string]string{
+	// Split the key so this file doesn't trigger the check itself.
+	"aws.ini": "aws_access_key_id = AKIA" + "IOSFODNN7EXAMPLE\n",
 	"foo.go": "// Foo\n\n// +build: incorrect\n\npackage foo\n" + `
 import "errors"
 
-// bad description.
+// bad description, it doesn't recieve anything.
 func MissingDesc() {
+	x := 1
+	x = 2
+	_ = x
 	// Error starts with upper case and ends with a dot.
 	return errors.New("Bad error.")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "" {
+	}
 }
 `,
 	"foo_test.go": `// Foo
@@ -207,8 +434,9 @@ func init() {
 		loop := true
 		for loop {
 			loop = false
-			for _, name := range getKnownChecks() {
-				for _, p := range KnownChecks[name]().GetPrerequisites() {
+			for _, name := range RegisteredChecks() {
+				c, _ := NewCheck(name)
+				for _, p := range c.GetPrerequisites() {
 					if !p.IsPresent() {
 						time.Sleep(10 * time.Millisecond)
 						loop = true
@@ -228,12 +456,12 @@ func setup(t *testing.T, td string, files map[string]string) scm.Change {
 		ut.AssertEqual(t, nil, os.MkdirAll(filepath.Dir(p), 0700))
 		ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte(c), 0600))
 	}
-	out, code, err := internal.Capture(fooDir, nil, "git", "init")
+	out, code, err := internal.Capture(context.Background(), fooDir, nil, "git", "init")
 	ut.AssertEqualf(t, 0, code, out)
 	ut.AssertEqual(t, nil, err)
 	// It's important to add the files to the index, otherwise they will be
 	// ignored.
-	out, code, err = internal.Capture(fooDir, nil, "git", "add", ".")
+	out, code, err = internal.Capture(context.Background(), fooDir, nil, "git", "add", ".")
 	ut.AssertEqualf(t, 0, code, out)
 	ut.AssertEqual(t, nil, err)
 
@@ -245,11 +473,8 @@ func setup(t *testing.T, td string, files map[string]string) scm.Change {
 	return change
 }
 
-func getKnownChecks() []string {
-	names := make([]string, 0, len(KnownChecks))
-	for name := range KnownChecks {
-		names = append(names, name)
-	}
-	sort.Strings(names)
-	return names
+func mustNewCheck(t *testing.T, name string) Check {
+	c, err := NewCheck(name)
+	ut.AssertEqual(t, nil, err)
+	return c
 }
@@ -7,7 +7,19 @@
 package checks
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/maruel/pre-commit-go/internal"
@@ -20,16 +32,30 @@ import (
 type Mode string
 
 // All predefined modes are executed automatically based on the context, except
-// for Lint which needs to be selected manually.
+// for Lint and Nightly which need to be selected manually.
+//
+// PreCommitMerge is optional; when defined, it is used instead of PreCommit
+// when committing a merge.
+//
+// Nightly is optional and meant for scheduled continuous integration runs,
+// e.g. a daily cron job, to run checks too slow to run on every push.
 const (
 	PreCommit             Mode = "pre-commit"
+	PreCommitMerge        Mode = "pre-commit-merge"
 	PrePush               Mode = "pre-push"
 	ContinuousIntegration Mode = "continuous-integration"
 	Lint                  Mode = "lint"
+	Nightly               Mode = "nightly"
 )
 
 // AllModes are all known valid modes that can be used in pre-commit-go.yml.
-var AllModes = []Mode{PreCommit, PrePush, ContinuousIntegration, Lint}
+var AllModes = []Mode{PreCommit, PreCommitMerge, PrePush, ContinuousIntegration, Lint, Nightly}
+
+// IsOptional returns true if the mode doesn't need to be defined in the
+// configuration.
+func (m Mode) IsOptional() bool {
+	return m == PreCommitMerge || m == Nightly
+}
 
 // UnmarshalYAML implements yaml.Unmarshaler.
 func (m *Mode) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -57,60 +83,454 @@ type Config struct {
 	Modes map[Mode]Settings `yaml:"modes"`
 	// IgnorePatterns is all paths glob patterns that should be ignored. By
 	// default, this include any file or directory starting with "." or "_", i.e.
-	// []string{".*", "_*"}.  This is a glob that is applied to each path
-	// component of each file.
+	// []string{".*", "_*"}. The patterns use the gitignore syntax, see
+	// scm.IgnorePatterns. Patterns in the .pcgignore file at the root of the
+	// repository are appended to these.
 	IgnorePatterns []string `yaml:"ignore_patterns"`
+	// ArtifactsDir, if set, is the directory where a per-run artifacts
+	// directory is created and preserved after the run, e.g. to be uploaded by
+	// the CI. If empty, a temporary directory is used and deleted after the run.
+	ArtifactsDir string `yaml:"artifacts_dir,omitempty"`
+	// ImportPath, if set, is the canonical import path of the repository, e.g.
+	// "github.com/maruel/pre-commit-go". When the checkout is not located at
+	// this path in GOPATH, e.g. in a contributor's fork, the checks are run
+	// through a temporary GOPATH where it resolves. It is ignored for go
+	// modules.
+	ImportPath string `yaml:"import_path,omitempty"`
+	// HermeticEnv controls whether the environment of the checks' subprocesses
+	// is scrubbed of the variables not in internal.DefaultEnvAllowlist or
+	// PassEnv, so variables like GOFLAGS, GIT_* or CDPATH don't change the
+	// checks' behavior between machines. One of "always", "never" or "" to only
+	// scrub on continuous integration.
+	HermeticEnv string `yaml:"hermetic_env,omitempty"`
+	// PassEnv lists additional environment variables to preserve when the
+	// environment is scrubbed. An entry ending with "*" matches a prefix.
+	PassEnv []string `yaml:"pass_env,omitempty"`
+	// ConfigFromCommit, if set in the checked in configuration file, makes
+	// pre-push load the configuration file as committed in each commit being
+	// pushed instead of the one in the working tree, so each commit is checked
+	// with the checks it expected. The working tree configuration is used when
+	// the commit has no valid configuration file.
+	ConfigFromCommit bool `yaml:"config_from_commit,omitempty"`
+	// RenameThreshold is the minimum similarity in percent for a deleted file
+	// and an added file to be reported as a rename in the change, see
+	// scm.Change.Renames. 0 uses the default of 50 and a negative value
+	// disables rename detection.
+	RenameThreshold int `yaml:"rename_threshold,omitempty"`
+	// Storage is the backend persisting the state of pcg in the repository,
+	// like the metrics history and the test cache: "file", the default, or
+	// "sqlite" for large repositories. See RegisterStorage.
+	Storage string `yaml:"storage,omitempty"`
+	// Thresholds gate the run on the metrics published by the checks. They are
+	// evaluated once all the checks completed.
+	Thresholds []Threshold `yaml:"thresholds,omitempty"`
+	// RequiredChecks lists the checks that must be enabled in the
+	// continuous-integration mode. They can't be skipped there and the run
+	// fails if one is missing. Since a change can edit this file, the checks
+	// listed in PCG_REQUIRED_CHECKS and in this file at PCG_POLICY_REF are
+	// required too.
+	RequiredChecks []string `yaml:"required_checks,omitempty"`
+	// ExperimentalChecks lists the checks that are run and reported, including
+	// their metrics, but whose failures are demoted to warnings, to roll out a
+	// new check before enforcing it. A required check is always enforced.
+	ExperimentalChecks []string `yaml:"experimental_checks,omitempty"`
+	// QuarantineFlaky demotes the failure of a flaky check to a warning until
+	// it stabilizes. See FlakyStats.
+	QuarantineFlaky bool `yaml:"quarantine_flaky,omitempty"`
+	// RecurseSubmodules runs the checks in each git submodule modified by the
+	// change, using the submodule's own configuration file.
+	RecurseSubmodules bool `yaml:"recurse_submodules,omitempty"`
+	// ToolsDir, if set, is the project-local directory where the
+	// prerequisites are installed instead of the GOPATH, e.g. ".tools". A
+	// relative path is relative to the repository root. Its "bin"
+	// subdirectory is prepended to PATH when running the checks.
+	ToolsDir string `yaml:"tools_dir,omitempty"`
+	// Offline, if set, never touches the network: the prerequisites are only
+	// resolved from ToolsBin(ToolsDir), which must match its
+	// ToolsChecksumsFile, and the go toolchain runs with GOPROXY=off so
+	// modules are only resolved from the module cache or the vendor directory.
+	Offline bool `yaml:"offline,omitempty"`
+	// OnboardingMessage, if set, enables printing an onboarding block the
+	// first time a hook fails in a checkout, e.g. for a new contributor. It
+	// explains what pcg is and how to investigate the failure, followed by
+	// this message, e.g. a link to the team's documentation of the
+	// thresholds.
+	OnboardingMessage string `yaml:"onboarding_message,omitempty"`
+	// SCM describes the source controls without native support, e.g. Fossil
+	// or Bazaar. They are only used when the checkout is not a git checkout.
+	SCM []scm.CLI `yaml:"scm,omitempty"`
+	// Pushgateway, if set, receives the results of the checks after each run on
+	// continuous integration.
+	Pushgateway *Pushgateway `yaml:"pushgateway,omitempty"`
+
+	// MaxOutput is the maximum size in bytes of the output of a process kept
+	// in memory, e.g. a verbose go test. Beyond, the middle of the output is
+	// truncated; its beginning and its end are kept for the error reports. If
+	// zero, DefaultMaxOutput is used. If negative, there is no limit.
+	MaxOutput int `yaml:"max_output,omitempty"`
 
-	// MaxConcurrent, if not zero, is the maximum number of concurrent processes
-	// to run. If zero, there is no maximum.
+	// MaxConcurrent is the maximum number of concurrent processes run by the
+	// checks. If zero, it defaults to the number of CPUs. If negative, there is
+	// no maximum.
 	MaxConcurrent int `yaml:"-"`
 }
 
 // EnabledChecks returns all the checks enabled.
+//
+// Checks of the modes inherited by modes are included. Checks enabled in
+// multiple modes are deduplicated, see MergedChecks. Only the options of modes
+// are used, the options of inherited modes are ignored.
 func (c *Config) EnabledChecks(modes []Mode) ([]Check, *Options) {
 	out := []Check{}
 	options := &Options{}
 
+	for _, e := range c.MergedChecks(modes) {
+		out = append(out, e.Check)
+	}
+	// Like the time limits, the most lenient setting wins: fail fast only if
+	// all the modes do.
+	failFast := len(modes) != 0
 	for _, mode := range modes {
-		for _, checks := range c.Modes[mode].Checks {
-			out = append(out, checks...)
-		}
 		options = options.merge(c.Modes[mode].Options)
+		failFast = failFast && c.Modes[mode].Options.FailFast
 	}
+	options.FailFast = failFast
 
-	if c.MaxConcurrent > 0 {
+	maxConcurrent := c.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = runtime.NumCPU()
+	}
+	if maxConcurrent > 0 {
 		// Allocate and populate a run token semaphore.
-		options.runTokens = make(chan struct{}, c.MaxConcurrent)
+		options.runTokens = make(chan struct{}, maxConcurrent)
+	}
+	options.metrics = &metricSet{values: map[string]float64{}}
+	if c.IsHermetic() {
+		options.scrubEnv = internal.ScrubEnv(append(append([]string{}, internal.DefaultEnvAllowlist...), c.PassEnv...))
+	}
+	options.offline = c.Offline
+	options.maxOutput = c.MaxOutput
+	if options.maxOutput == 0 {
+		options.maxOutput = DefaultMaxOutput
 	}
 	return out, options
 }
 
+// IsExperimental returns true if the check name is listed in
+// ExperimentalChecks.
+func (c *Config) IsExperimental(name string) bool {
+	for _, e := range c.ExperimentalChecks {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHermetic returns true if the environment of the checks is scrubbed, see
+// HermeticEnv.
+func (c *Config) IsHermetic() bool {
+	switch c.HermeticEnv {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return IsContinuousIntegration()
+	}
+}
+
+// ToolsPath returns the absolute path of ToolsDir for the repository at root,
+// or "" if ToolsDir is not set.
+func (c *Config) ToolsPath(root string) string {
+	if c.ToolsDir == "" {
+		return ""
+	}
+	if filepath.IsAbs(c.ToolsDir) {
+		return filepath.Clean(c.ToolsDir)
+	}
+	return filepath.Join(root, c.ToolsDir)
+}
+
+// EnabledCheck is a check enabled in one or multiple modes.
+type EnabledCheck struct {
+	Check Check
+	// Modes is the list of modes enabling this check. When there is more than
+	// one, Check may be the merge of the settings of each mode.
+	Modes []Mode
+}
+
+// MergedChecks returns the checks enabled in modes and the modes they
+// inherit, deduplicated across modes.
+//
+// Identical checks are only returned once. Checks of the same type with
+// different settings are merged into a single check with the strictest
+// settings when the check supports it, e.g. the highest minimum coverage wins.
+// Otherwise they are all returned.
+func (c *Config) MergedChecks(modes []Mode) []EnabledCheck {
+	var out []EnabledCheck
+	for _, mode := range c.ResolveModes(modes) {
+		checks := c.Modes[mode].Checks
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, check := range checks[name] {
+				out = addEnabledCheck(out, check, mode)
+			}
+		}
+	}
+	return out
+}
+
+// MissingChecks returns the checks in required that are not enabled in modes
+// or the modes they inherit, sorted.
+func (c *Config) MissingChecks(modes []Mode, required []string) []string {
+	enabled := map[string]bool{}
+	for _, e := range c.MergedChecks(modes) {
+		enabled[e.Check.GetName()] = true
+	}
+	var out []string
+	for _, name := range required {
+		if !enabled[name] {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ResolveModes returns modes followed by the modes they inherit from,
+// recursively. Each mode is returned once, so inheritance cycles are
+// harmless.
+func (c *Config) ResolveModes(modes []Mode) []Mode {
+	var out []Mode
+	seen := map[Mode]bool{}
+	pending := append([]Mode{}, modes...)
+	for len(pending) != 0 {
+		mode := pending[0]
+		pending = pending[1:]
+		if seen[mode] {
+			continue
+		}
+		seen[mode] = true
+		out = append(out, mode)
+		pending = append(pending, c.Modes[mode].Inherits...)
+	}
+	return out
+}
+
+// Warnings returns the likely misconfigurations found in the configuration,
+// sorted. An empty result doesn't mean the configuration is valid, only that
+// no common mistake was found.
+//
+// It flags checks enabled in pre-commit or pre-push but not in
+// continuous-integration, since they can be bypassed by pushing directly,
+// modes without any check and modes with a zero max_duration.
+func (c *Config) Warnings() []string {
+	var out []string
+	ci := map[string]bool{}
+	for _, e := range c.MergedChecks([]Mode{ContinuousIntegration}) {
+		ci[e.Check.GetName()] = true
+	}
+	for _, mode := range AllModes {
+		settings, ok := c.Modes[mode]
+		if !ok {
+			if !mode.IsOptional() {
+				out = append(out, fmt.Sprintf("mode %s is not defined", mode))
+			}
+			continue
+		}
+		if len(c.MergedChecks([]Mode{mode})) == 0 {
+			out = append(out, fmt.Sprintf("mode %s has no check", mode))
+		}
+		if settings.Options.MaxDuration <= 0 {
+			out = append(out, fmt.Sprintf("mode %s has max_duration %d; all checks will be reported as too slow", mode, settings.Options.MaxDuration))
+		}
+	}
+	for _, mode := range []Mode{PreCommit, PreCommitMerge} {
+		switch s := c.Modes[mode].Stash; s {
+		case "", "always", "auto", "never":
+		default:
+			out = append(out, fmt.Sprintf("mode %s has stash %q; expected \"always\", \"auto\", \"never\" or empty", mode, s))
+		}
+	}
+	for _, mode := range AllModes {
+		for _, check := range c.Modes[mode].Checks[(&Custom{}).GetName()] {
+			if cu, ok := check.(*Custom); ok && cu.Protocol != "" && cu.Protocol != "json" {
+				out = append(out, fmt.Sprintf("custom check %s in mode %s has protocol %q; expected \"json\" or empty", cu.DisplayName, mode, cu.Protocol))
+			}
+		}
+	}
+	for i := range c.SCM {
+		if err := c.SCM[i].Validate(); err != nil {
+			out = append(out, err.Error())
+		}
+	}
+	if c.Storage != "" && !contains(RegisteredStorages(), c.Storage) {
+		out = append(out, fmt.Sprintf("storage %q is invalid; expected one of %s", c.Storage, strings.Join(RegisteredStorages(), ", ")))
+	}
+	if c.Offline && c.ToolsDir == "" {
+		out = append(out, "offline requires tools_dir to resolve the prerequisites")
+	}
+	switch c.HermeticEnv {
+	case "", "always", "never":
+	default:
+		out = append(out, fmt.Sprintf("hermetic_env %q is invalid; expected \"always\", \"never\" or empty", c.HermeticEnv))
+	}
+	for _, t := range c.Thresholds {
+		if w := t.validate(); w != "" {
+			out = append(out, w)
+		}
+	}
+	if c.Pushgateway != nil {
+		if w := c.Pushgateway.validate(); w != "" {
+			out = append(out, w)
+		}
+	}
+	for _, name := range c.MissingChecks([]Mode{ContinuousIntegration}, c.RequiredChecks) {
+		out = append(out, fmt.Sprintf("required check %s is not enabled in %s", name, ContinuousIntegration))
+	}
+	for _, name := range c.RequiredChecks {
+		if c.IsExperimental(name) {
+			out = append(out, fmt.Sprintf("required check %s is experimental; its failures are enforced", name))
+		}
+	}
+	for _, mode := range []Mode{PreCommit, PrePush} {
+		if _, ok := c.Modes[mode]; !ok {
+			continue
+		}
+		for _, e := range c.MergedChecks([]Mode{mode}) {
+			if name := e.Check.GetName(); !ci[name] {
+				out = append(out, fmt.Sprintf("check %s is enabled in %s but not in %s; it can be bypassed by pushing directly", name, mode, ContinuousIntegration))
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
 // Settings is the settings used for a mode.
 type Settings struct {
 	// Checks is a map of all checks enabled for this mode, with the key being
 	// the check type.
 	Checks  Checks  `yaml:"checks"`
 	Options Options `yaml:",inline"`
+	// SkipMergeCommits skips all the checks when the commit being created is a
+	// merge commit. It is only meaningful for PreCommit.
+	SkipMergeCommits bool `yaml:"skip_merge_commits,omitempty"`
+	// Stash controls whether the modifications that are not in the index are
+	// stashed before running the checks, so the checks only see what is
+	// committed. It is only meaningful for PreCommit and PreCommitMerge. One
+	// of:
+	//   - "always" or "": stash when the checkout differs from the index.
+	//   - "auto": like "always" unless all the modified or untracked files
+	//     outside the index match the ignore patterns.
+	//   - "never": never stash; the checkout is checked as is against HEAD,
+	//     including the modifications that are not staged.
+	Stash string `yaml:"stash,omitempty"`
+	// Inherits lists the modes whose checks are also run in this mode, e.g.
+	// pre-push inheriting pre-commit. Inheritance is transitive.
+	Inherits []Mode `yaml:"inherits,omitempty"`
 }
 
+// DefaultMaxOutput is the default of Config.MaxOutput.
+const DefaultMaxOutput = 8 << 20
+
+// ArtifactsEnvVar is the environment variable set to Options.ArtifactsDir for
+// subprocesses.
+const ArtifactsEnvVar = "PCG_ARTIFACTS_DIR"
+
 // Options hold the settings for a mode shared by all checks.
 type Options struct {
 	// MaxDuration is the maximum allowed duration to run all the checks in
 	// seconds. If it takes more time than that, it is marked as failed.
 	MaxDuration int `yaml:"max_duration"`
+	// CheckTimeout is the hard time limit in seconds of each check. When
+	// reached, the check's processes are killed and the check fails. If 0 or
+	// negative, there is no hard limit.
+	CheckTimeout int `yaml:"check_timeout,omitempty"`
+	// FailFast cancels the other checks as soon as one fails, see
+	// Runner.FailFast.
+	FailFast bool `yaml:"fail_fast,omitempty"`
+
+	// ArtifactsDir is the directory where checks can write files that should be
+	// kept around, like coverage reports, benchmark results or SARIF files. It
+	// is exported to subprocesses as $PCG_ARTIFACTS_DIR. It is empty when no
+	// artifacts directory was set up.
+	ArtifactsDir string `yaml:"-"`
+	// Resume is set with -resume to skip the work that passed in a previous run
+	// interrupted by a timeout or a crash. The test check skips the packages
+	// that passed, as if its cache was enabled.
+	Resume bool `yaml:"-"`
+	// Shard is set with -shard to run only a part of the test packages. The
+	// coverage check then writes its profile in ArtifactsDir instead of
+	// enforcing the coverage; see Shard.CoverageProfile.
+	Shard Shard `yaml:"-"`
+	// Storage is where the checks persist their state between runs, e.g. the
+	// test cache. If nil, a FileStorage in the scm directory is used.
+	Storage Storage `yaml:"-"`
+
+	// scrubEnv is the list of environment variables to remove from the
+	// subprocesses, as returned by internal.ScrubEnv.
+	scrubEnv []string
 
 	// runTokens is a fixed-capacity semaphore channel.
 	//
 	// If nil, run token operations are no-ops.
 	runTokens chan struct{}
+
+	// procLock is held for reading while a process runs, or for writing by a
+	// check of WeightExclusive so its processes run alone. It is set by Runner.
+	procLock *sync.RWMutex
+
+	// exclusive is set for the checks of WeightExclusive.
+	exclusive bool
+
+	// metrics holds the metrics published by the checks.
+	//
+	// If nil, PublishMetric is a no-op.
+	metrics *metricSet
+
+	// output, if set, is called with each line of output of the processes run
+	// with CaptureEnv as it is produced. It is set by Runner for each check.
+	output func(line string)
+
+	// spawned, if set, is called with the command line of each process run
+	// with CaptureEnv or CaptureIO. It is set by Runner for each check.
+	spawned func(args []string)
+
+	// offline adds offlineEnv to the environment of the subprocesses; see
+	// Config.Offline.
+	offline bool
+
+	// maxOutput is the maximum size of the output of a process returned by
+	// CaptureEnv; see Config.MaxOutput.
+	maxOutput int
+
+	// counters holds the counters of the check, see Count. It is set by Runner
+	// for each check.
+	//
+	// If nil, Count is a no-op.
+	counters *counterSet
 }
 
 // LeaseRunToken returns a leased run token.
 //
 // A token must be returned after use via ReturnRunToken. This should be done
 // via defer, as failure to return a run token will result in throttling or
-// deadlock.
+// deadlock. Leases must not be nested. The check of WeightExclusive leases all
+// the processes instead of a token.
 func (o *Options) LeaseRunToken() {
+	if o.procLock != nil {
+		if o.exclusive {
+			o.procLock.Lock()
+			return
+		}
+		o.procLock.RLock()
+	}
 	if o.runTokens == nil {
 		return
 	}
@@ -119,29 +539,226 @@ func (o *Options) LeaseRunToken() {
 
 // ReturnRunToken returns a leased run token.
 func (o *Options) ReturnRunToken() {
-	if o.runTokens == nil {
+	if o.procLock != nil && o.exclusive {
+		o.procLock.Unlock()
 		return
 	}
-	<-o.runTokens
+	if o.runTokens != nil {
+		<-o.runTokens
+	}
+	if o.procLock != nil {
+		o.procLock.RUnlock()
+	}
+}
+
+// PublishMetric records a named numeric measurement made by a check, e.g.
+// "coverage.global". Publishing the same name twice keeps the last value. It
+// is safe to call concurrently.
+func (o *Options) PublishMetric(name string, value float64) {
+	if o.metrics == nil {
+		return
+	}
+	internal.Latency("checks.Options.PublishMetric")
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	o.metrics.values[name] = value
+}
+
+// Count adds n to the counter name of the running check, e.g. CounterPackages.
+// The counters are reported in Result.Counters. It is safe to call
+// concurrently.
+func (o *Options) Count(name string, n int) {
+	if o.counters == nil {
+		return
+	}
+	internal.Latency("checks.Options.Count")
+	o.counters.Lock()
+	defer o.counters.Unlock()
+	o.counters.values[name] += n
+}
+
+// RecordResult records whether a check, or a part of it, passed, to track its
+// flakiness. See FlakyStats. A failure overrides a success of the same name.
+func (o *Options) RecordResult(name string, ok bool) {
+	if o.metrics == nil {
+		return
+	}
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	if o.metrics.results == nil {
+		o.metrics.results = map[string]bool{}
+	}
+	if prev, found := o.metrics.results[name]; !found || prev {
+		o.metrics.results[name] = ok
+	}
+}
+
+// Results returns a copy of the results recorded so far.
+func (o *Options) Results() map[string]bool {
+	out := map[string]bool{}
+	if o.metrics == nil {
+		return out
+	}
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	for k, v := range o.metrics.results {
+		out[k] = v
+	}
+	return out
+}
+
+// SaveLog writes the output of a subprocess run by check to its own file in
+// the artifacts directory, logs/<check>/<name>.log, so the logs of each
+// package can be read and uploaded separately instead of one interleaved log.
+// The characters not valid in a file name are replaced. It is recorded as
+// "<check>:<name>" in Logs. Returns the path of the file, or "" when there is
+// no artifacts directory or on failure. It is safe to call concurrently.
+func (o *Options) SaveLog(check, name, out string) string {
+	if o.ArtifactsDir == "" || o.metrics == nil {
+		return ""
+	}
+	key := check + ":" + name
+	dir := filepath.Join(o.ArtifactsDir, "logs", logFileName(check))
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	if o.metrics.logs == nil {
+		o.metrics.logs = map[string]string{}
+	}
+	p, ok := o.metrics.logs[key]
+	if !ok {
+		// Disambiguate names that map to the same file name.
+		used := map[string]bool{}
+		for _, v := range o.metrics.logs {
+			used[v] = true
+		}
+		base := filepath.Join(dir, logFileName(name))
+		p = base + ".log"
+		for i := 2; used[p]; i++ {
+			p = fmt.Sprintf("%s-%d.log", base, i)
+		}
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Printf("failed to save log %s: %s", key, err)
+		return ""
+	}
+	if err := ioutil.WriteFile(p, []byte(out), 0666); err != nil {
+		log.Printf("failed to save log %s: %s", key, err)
+		return ""
+	}
+	o.metrics.logs[key] = p
+	return p
+}
+
+// Logs returns a copy of the log files saved so far, see SaveLog.
+func (o *Options) Logs() map[string]string {
+	out := map[string]string{}
+	if o.metrics == nil {
+		return out
+	}
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	for k, v := range o.metrics.logs {
+		out[k] = v
+	}
+	return out
+}
+
+// Metrics returns a copy of the metrics published so far.
+func (o *Options) Metrics() map[string]float64 {
+	out := map[string]float64{}
+	if o.metrics == nil {
+		return out
+	}
+	o.metrics.Lock()
+	defer o.metrics.Unlock()
+	for k, v := range o.metrics.values {
+		out[k] = v
+	}
+	return out
+}
+
+// Timeout returns the effective hard time limit of each check, see
+// CheckTimeout. Returns 0 if there is no limit.
+func (o *Options) Timeout() time.Duration {
+	if o.CheckTimeout <= 0 {
+		return 0
+	}
+	return time.Duration(o.CheckTimeout) * time.Second
 }
 
 // Capture sets GOPATH and executes a subprocess.
-func (o *Options) Capture(r scm.ReadOnlyRepo, args ...string) (string, int, time.Duration, error) {
+//
+// The repository's GOPATH is prepended to the inherited one instead of
+// replacing it, so tools and caches located in other GOPATH entries keep
+// working. GOPATH is not modified when the repository uses go modules. When
+// the configuration is hermetic, the environment variables not explicitly
+// allowed are removed. The subprocess is killed when ctx is done. The middle
+// of an output larger than Config.MaxOutput is truncated.
+func (o *Options) Capture(ctx context.Context, r scm.ReadOnlyRepo, args ...string) (string, int, time.Duration, error) {
+	return o.CaptureEnv(ctx, r, nil, args...)
+}
+
+// CaptureEnv is like Capture with additional environment variables, e.g.
+// GOOS and GOARCH.
+func (o *Options) CaptureEnv(ctx context.Context, r scm.ReadOnlyRepo, extra []string, args ...string) (string, int, time.Duration, error) {
 	o.LeaseRunToken()
 	defer o.ReturnRunToken()
 
+	env := append(append([]string{}, o.scrubEnv...), internal.GoEnv(r.Root(), r.GOPATH())...)
+	if o.ArtifactsDir != "" {
+		env = append(env, ArtifactsEnvVar+"="+o.ArtifactsDir)
+	}
+	if o.offline {
+		env = append(env, offlineEnv...)
+	}
+	env = append(env, extra...)
+	if o.spawned != nil {
+		o.spawned(args)
+	}
+	var w io.Writer
+	if o.output != nil {
+		lw := &lineWriter{line: o.output}
+		defer lw.flush()
+		w = lw
+	}
 	start := time.Now()
-	out, exitCode, err := internal.Capture(r.Root(), []string{"GOPATH=" + r.GOPATH()}, args...)
+	out, exitCode, err := internal.CaptureLimit(ctx, r.Root(), env, w, o.maxOutput, args...)
 	return out, exitCode, time.Since(start), err
 }
 
+// CaptureIO is like CaptureEnv but writes stdin to the process and returns
+// its stdout and stderr separately. The output is not truncated since it is
+// usually parsed, see Config.MaxOutput.
+func (o *Options) CaptureIO(ctx context.Context, r scm.ReadOnlyRepo, stdin []byte, args ...string) (string, string, int, time.Duration, error) {
+	o.LeaseRunToken()
+	defer o.ReturnRunToken()
+
+	env := append(append([]string{}, o.scrubEnv...), internal.GoEnv(r.Root(), r.GOPATH())...)
+	if o.ArtifactsDir != "" {
+		env = append(env, ArtifactsEnvVar+"="+o.ArtifactsDir)
+	}
+	if o.offline {
+		env = append(env, offlineEnv...)
+	}
+	if o.spawned != nil {
+		o.spawned(args)
+	}
+	start := time.Now()
+	stdout, stderr, exitCode, err := internal.CaptureIO(ctx, r.Root(), env, stdin, args...)
+	return stdout, stderr, exitCode, time.Since(start), err
+}
+
 // merge merges two options and returns a result.
 // This is used for multimode runs.
 func (o *Options) merge(r Options) *Options {
-	out := &Options{MaxDuration: o.MaxDuration}
+	out := &Options{MaxDuration: o.MaxDuration, CheckTimeout: o.CheckTimeout}
 	if out.MaxDuration < r.MaxDuration {
 		out.MaxDuration = r.MaxDuration
 	}
+	// The most lenient limit wins; a negative value means no limit.
+	if out.CheckTimeout > 0 && (r.CheckTimeout <= 0 || out.CheckTimeout < r.CheckTimeout) {
+		out.CheckTimeout = r.CheckTimeout
+	}
 	return out
 }
 
@@ -156,16 +773,15 @@ func (c *Checks) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	*c = Checks{}
 	for checkTypeName, checks := range encoded {
-		checkFactory, ok := KnownChecks[checkTypeName]
-		if !ok {
-			return fmt.Errorf("unknown check \"%s\"", checkTypeName)
+		if _, err := NewCheck(checkTypeName); err != nil {
+			return err
 		}
 		for _, checkData := range checks {
 			rawCheckData, err := yaml.Marshal(checkData)
 			if err != nil {
 				return err
 			}
-			check := checkFactory()
+			check, _ := NewCheck(checkTypeName)
 			if err = yaml.Unmarshal(rawCheckData, check); err != nil {
 				return err
 			}
@@ -186,6 +802,13 @@ func New(v string) *Config {
 					"gofmt": {
 						&Gofmt{},
 					},
+					"secrets": {
+						&Secrets{
+							Patterns:   []string{},
+							Allowlist:  []string{},
+							MinEntropy: 4.5,
+						},
+					},
 					"test": {
 						&Test{
 							ExtraArgs: []string{"-short"},
@@ -199,6 +822,9 @@ func New(v string) *Config {
 					"goimports": {
 						&Goimports{},
 					},
+					"ineffassign": {
+						&Ineffassign{},
+					},
 					"coverage": {
 						&Coverage{
 							UseCoveralls: false,
@@ -227,6 +853,9 @@ func New(v string) *Config {
 					"gofmt": {
 						&Gofmt{},
 					},
+					"gomodtidy": {
+						&Gomodtidy{},
+					},
 					"goimports": {
 						&Goimports{},
 					},
@@ -271,6 +900,22 @@ func New(v string) *Config {
 							Blacklist: []string{" composite literal uses unkeyed fields"},
 						},
 					},
+					"misspell": {
+						&Misspell{
+							Ignores: []string{},
+						},
+					},
+				},
+			},
+			Nightly: {
+				Options:  Options{MaxDuration: 600},
+				Inherits: []Mode{ContinuousIntegration},
+				Checks: Checks{
+					"stalebranches": {
+						&StaleBranches{
+							MaxBehind: 200,
+						},
+					},
 				},
 			},
 		},
@@ -283,3 +928,63 @@ func New(v string) *Config {
 		},
 	}
 }
+
+// Private stuff.
+
+// merger is implemented by checks that can merge their settings with another
+// instance enabled in another mode.
+type merger interface {
+	// merge returns a new check with the strictest settings of both, or nil if
+	// they can't be merged. Neither instance is modified.
+	merge(other Check) Check
+}
+
+// addEnabledCheck adds check enabled in mode to out, deduplicating or merging
+// it with a check already present.
+func addEnabledCheck(out []EnabledCheck, check Check, mode Mode) []EnabledCheck {
+	for i := range out {
+		if reflect.DeepEqual(out[i].Check, check) {
+			out[i].addMode(mode)
+			return out
+		}
+	}
+	for i := range out {
+		if reflect.TypeOf(out[i].Check) != reflect.TypeOf(check) {
+			continue
+		}
+		if m, ok := out[i].Check.(merger); ok {
+			if merged := m.merge(check); merged != nil {
+				out[i].Check = merged
+				out[i].addMode(mode)
+				return out
+			}
+		}
+	}
+	return append(out, EnabledCheck{check, []Mode{mode}})
+}
+
+func (e *EnabledCheck) addMode(mode Mode) {
+	for _, m := range e.Modes {
+		if m == mode {
+			return
+		}
+	}
+	e.Modes = append(e.Modes, mode)
+}
+
+// reLogFileName matches the characters replaced in a log file name.
+// offlineEnv prevents the go toolchain from downloading modules or another
+// toolchain; see Config.Offline.
+var offlineEnv = []string{"GOPROXY=off", "GOTOOLCHAIN=local"}
+
+var reLogFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// logFileName returns name usable as a file name. A package in relative
+// notation loses its "./" prefix and the root package is named "root".
+func logFileName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	if name == "." || name == "" {
+		return "root"
+	}
+	return strings.TrimRight(reLogFileName.ReplaceAllString(name, "_"), "_")
+}
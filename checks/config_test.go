@@ -6,21 +6,125 @@ package checks
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/maruel/pre-commit-go/internal"
 	"github.com/maruel/ut"
 	"gopkg.in/yaml.v2"
 )
 
 func TestConfigNew(t *testing.T) {
 	config := New("0.1")
-	ut.AssertEqual(t, 2, len(config.Modes[PreCommit].Checks))
-	ut.AssertEqual(t, 3, len(config.Modes[PrePush].Checks))
-	ut.AssertEqual(t, 4, len(config.Modes[ContinuousIntegration].Checks))
-	ut.AssertEqual(t, 3, len(config.Modes[Lint].Checks))
+	ut.AssertEqual(t, 3, len(config.Modes[PreCommit].Checks))
+	ut.AssertEqual(t, 4, len(config.Modes[PrePush].Checks))
+	ut.AssertEqual(t, 5, len(config.Modes[ContinuousIntegration].Checks))
+	ut.AssertEqual(t, 4, len(config.Modes[Lint].Checks))
 	checks, options := config.EnabledChecks([]Mode{PreCommit, PrePush, ContinuousIntegration, Lint})
-	ut.AssertEqual(t, Options{MaxDuration: 120}, *options)
-	ut.AssertEqual(t, 2+3+4+3, len(checks))
+	ut.AssertEqual(t, Options{MaxDuration: 120, runTokens: options.runTokens, metrics: options.metrics, maxOutput: DefaultMaxOutput}, *options)
+	// gofmt, goimports and test -v -race are deduplicated and coverage is
+	// merged.
+	ut.AssertEqual(t, 3+4+5+4-4, len(checks))
+	// nightly inherits continuous-integration.
+	ut.AssertEqual(t, 1, len(config.Modes[Nightly].Checks))
+	checks, options = config.EnabledChecks([]Mode{Nightly})
+	ut.AssertEqual(t, 600, options.MaxDuration)
+	ut.AssertEqual(t, 5+1, len(checks))
+}
+
+func TestConfigFailFast(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	s := config.Modes[PreCommit]
+	s.Options.FailFast = true
+	config.Modes[PreCommit] = s
+	_, options := config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, true, options.FailFast)
+	ut.AssertEqual(t, true, NewRunner(config, []Mode{PreCommit}).FailFast)
+	// The most lenient mode wins.
+	_, options = config.EnabledChecks([]Mode{PreCommit, PrePush})
+	ut.AssertEqual(t, false, options.FailFast)
+}
+
+func TestConfigMaxOutput(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	config.MaxOutput = 1024
+	_, options := config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, 1024, options.maxOutput)
+	config.MaxOutput = -1
+	_, options = config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, -1, options.maxOutput)
+}
+
+func TestConfigMaxConcurrent(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	_, options := config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, runtime.NumCPU(), cap(options.runTokens))
+
+	config.MaxConcurrent = 2
+	_, options = config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, 2, cap(options.runTokens))
+
+	config.MaxConcurrent = -1
+	_, options = config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, true, options.runTokens == nil)
+}
+
+func TestConfigMergedChecks(t *testing.T) {
+	config := New("0.1")
+	merged := config.MergedChecks([]Mode{PrePush, ContinuousIntegration})
+	var names []string
+	for _, e := range merged {
+		names = append(names, e.Check.GetName())
+	}
+	ut.AssertEqual(t, []string{"coverage", "goimports", "ineffassign", "test", "gofmt", "gomodtidy"}, names)
+	ut.AssertEqual(t, []Mode{PrePush, ContinuousIntegration}, merged[0].Modes)
+	cov := merged[0].Check.(*Coverage)
+	ut.AssertEqual(t, true, cov.UseCoveralls)
+	// The original checks are not modified.
+	ut.AssertEqual(t, false, config.Modes[PrePush].Checks["coverage"][0].(*Coverage).UseCoveralls)
+	ut.AssertEqual(t, []Mode{PrePush, ContinuousIntegration}, merged[3].Modes)
+	ut.AssertEqual(t, []Mode{ContinuousIntegration}, merged[4].Modes)
+}
+
+func TestCoverageMerge(t *testing.T) {
+	t.Parallel()
+	a := &Coverage{
+		Global:        CoverageSettings{MinCoverage: 50, MaxCoverage: 100},
+		PerDirDefault: CoverageSettings{MinCoverage: 10},
+		PerDir:        map[string]*CoverageSettings{"foo": {MinCoverage: 90}, "bar": nil},
+	}
+	b := &Coverage{
+		Global:        CoverageSettings{MinCoverage: 40, MaxCoverage: 90},
+		PerDirDefault: CoverageSettings{MinCoverage: 20, MaxCoverage: 95},
+	}
+	expected := &Coverage{
+		Global:        CoverageSettings{MinCoverage: 50, MaxCoverage: 90},
+		PerDirDefault: CoverageSettings{MinCoverage: 20, MaxCoverage: 95},
+		PerDir: map[string]*CoverageSettings{
+			"foo": {MinCoverage: 90, MaxCoverage: 95},
+			"bar": {MinCoverage: 20, MaxCoverage: 95},
+		},
+	}
+	ut.AssertEqual(t, expected, a.merge(b))
+	ut.AssertEqual(t, nil, a.merge(&Coverage{UseGlobalInference: true}))
+}
+
+func TestGocycloMerge(t *testing.T) {
+	t.Parallel()
+	a := &Gocyclo{PerDirDefault: GocycloSettings{MaxComplexity: 20}}
+	b := &Gocyclo{PerDir: map[string]*GocycloSettings{"foo": {MaxComplexity: 30}}}
+	expected := &Gocyclo{
+		PerDirDefault: GocycloSettings{MaxComplexity: 20},
+		PerDir:        map[string]*GocycloSettings{"foo": {MaxComplexity: 20}},
+	}
+	ut.AssertEqual(t, expected, a.merge(b))
 }
 
 func TestConfigYAML(t *testing.T) {
@@ -39,3 +143,182 @@ func TestConfigYAMLBadMode(t *testing.T) {
 	ut.AssertEqual(t, errors.New("invalid mode \"foo\""), yaml.Unmarshal(data, &v))
 	ut.AssertEqual(t, PreCommit, v)
 }
+
+func TestConfigInherits(t *testing.T) {
+	t.Parallel()
+	config := &Config{
+		Modes: map[Mode]Settings{
+			PreCommit: {
+				Options: Options{MaxDuration: 5},
+				Checks:  Checks{"gofmt": {&Gofmt{}}},
+				// A cycle is ignored.
+				Inherits: []Mode{ContinuousIntegration},
+			},
+			PrePush: {
+				Options:  Options{MaxDuration: 15},
+				Checks:   Checks{"test": {&Test{}}},
+				Inherits: []Mode{PreCommit},
+			},
+			ContinuousIntegration: {
+				Options:  Options{MaxDuration: 120},
+				Checks:   Checks{"gofmt": {&Gofmt{}}, "build": {&Build{}}},
+				Inherits: []Mode{PrePush},
+			},
+		},
+	}
+	ut.AssertEqual(t, []Mode{ContinuousIntegration, PrePush, PreCommit}, config.ResolveModes([]Mode{ContinuousIntegration}))
+	checks, options := config.EnabledChecks([]Mode{PrePush})
+	ut.AssertEqual(t, []Check{&Test{}, &Gofmt{}, &Build{}}, checks)
+	// The options of inherited modes are not used.
+	ut.AssertEqual(t, Options{MaxDuration: 15, runTokens: options.runTokens, metrics: options.metrics, maxOutput: DefaultMaxOutput}, *options)
+	merged := config.MergedChecks([]Mode{ContinuousIntegration})
+	ut.AssertEqual(t, 3, len(merged))
+	ut.AssertEqual(t, []Mode{ContinuousIntegration, PreCommit}, merged[1].Modes)
+}
+
+func TestConfigWarnings(t *testing.T) {
+	t.Parallel()
+	// The default configuration doesn't run all its local checks on CI.
+	expected := []string{
+		"check ineffassign is enabled in pre-push but not in continuous-integration; it can be bypassed by pushing directly",
+		"check secrets is enabled in pre-commit but not in continuous-integration; it can be bypassed by pushing directly",
+	}
+	ut.AssertEqual(t, expected, New("0.1").Warnings())
+
+	config := &Config{
+		Modes: map[Mode]Settings{
+			PreCommit: {
+				Options: Options{MaxDuration: 5},
+				Checks:  Checks{"gofmt": {&Gofmt{}}, "test": {&Test{}}},
+			},
+			PrePush: {
+				Options:  Options{MaxDuration: 15},
+				Inherits: []Mode{PreCommit},
+			},
+			ContinuousIntegration: {
+				Options: Options{MaxDuration: 0},
+				Checks:  Checks{"test": {&Test{}}},
+			},
+			Lint: {
+				Options: Options{MaxDuration: 15},
+			},
+		},
+	}
+	expected = []string{
+		"check gofmt is enabled in pre-commit but not in continuous-integration; it can be bypassed by pushing directly",
+		"check gofmt is enabled in pre-push but not in continuous-integration; it can be bypassed by pushing directly",
+		"mode continuous-integration has max_duration 0; all checks will be reported as too slow",
+		"mode lint has no check",
+	}
+	ut.AssertEqual(t, expected, config.Warnings())
+
+	config.RequiredChecks = []string{"test", "gofmt"}
+	ut.AssertEqual(t, []string{"gofmt"}, config.MissingChecks([]Mode{ContinuousIntegration}, config.RequiredChecks))
+	ut.AssertEqual(t, []string(nil), config.MissingChecks([]Mode{PrePush}, config.RequiredChecks))
+	expected = append(expected, "required check gofmt is not enabled in continuous-integration")
+	ut.AssertEqual(t, expected, config.Warnings())
+
+	config.ExperimentalChecks = []string{"test"}
+	ut.AssertEqual(t, true, config.IsExperimental("test"))
+	ut.AssertEqual(t, false, config.IsExperimental("gofmt"))
+	expected = append(expected, "required check test is experimental; its failures are enforced")
+	ut.AssertEqual(t, expected, config.Warnings())
+}
+
+func TestConfigHermeticEnv(t *testing.T) {
+	ut.AssertEqual(t, nil, os.Setenv("PCG_HERMETIC_TEST", "1"))
+	defer os.Unsetenv("PCG_HERMETIC_TEST")
+	config := New("0.1")
+	config.HermeticEnv = "never"
+	_, options := config.EnabledChecks([]Mode{PreCommit})
+	ut.AssertEqual(t, []string(nil), options.scrubEnv)
+
+	config.HermeticEnv = "always"
+	_, options = config.EnabledChecks([]Mode{PreCommit})
+	found := false
+	for _, e := range options.scrubEnv {
+		found = found || e == "PCG_HERMETIC_TEST="
+	}
+	ut.AssertEqual(t, true, found)
+
+	config.PassEnv = []string{"PCG_HERMETIC_TEST"}
+	_, options = config.EnabledChecks([]Mode{PreCommit})
+	for _, e := range options.scrubEnv {
+		ut.AssertEqual(t, false, e == "PCG_HERMETIC_TEST=")
+	}
+
+	config.HermeticEnv = "sometimes"
+	ut.AssertEqual(t, append(New("0.1").Warnings(), "hermetic_env \"sometimes\" is invalid; expected \"always\", \"never\" or empty"), config.Warnings())
+}
+
+func TestConfigToolsPath(t *testing.T) {
+	t.Parallel()
+	root := filepath.Join(string(filepath.Separator)+"src", "foo")
+	config := New("0.1")
+	ut.AssertEqual(t, "", config.ToolsPath(root))
+	config.ToolsDir = ".tools"
+	ut.AssertEqual(t, filepath.Join(root, ".tools"), config.ToolsPath(root))
+	abs := filepath.Join(string(filepath.Separator)+"opt", "tools")
+	config.ToolsDir = abs + string(filepath.Separator)
+	ut.AssertEqual(t, abs, config.ToolsPath(root))
+}
+
+func TestConfigCustomProtocol(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	settings := config.Modes[Lint]
+	settings.Checks["custom"] = []Check{&Custom{DisplayName: "sample", Command: []string{"sample"}, Protocol: "xml"}}
+	config.Modes[Lint] = settings
+	ut.AssertEqual(t, append(New("0.1").Warnings(), "custom check sample in mode lint has protocol \"xml\"; expected \"json\" or empty"), config.Warnings())
+}
+
+func TestConfigStash(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	settings := config.Modes[PreCommit]
+	settings.Stash = "sometimes"
+	config.Modes[PreCommit] = settings
+	ut.AssertEqual(t, append(New("0.1").Warnings(), "mode pre-commit has stash \"sometimes\"; expected \"always\", \"auto\", \"never\" or empty"), config.Warnings())
+	config.Modes[PreCommitMerge] = Settings{Options: Options{MaxDuration: 5}, Inherits: []Mode{PreCommit}, Stash: "maybe"}
+	ut.AssertEqual(t, append(New("0.1").Warnings(), "mode pre-commit has stash \"sometimes\"; expected \"always\", \"auto\", \"never\" or empty", "mode pre-commit-merge has stash \"maybe\"; expected \"always\", \"auto\", \"never\" or empty"), config.Warnings())
+}
+
+func TestOptionsTimeout(t *testing.T) {
+	t.Parallel()
+	// There is no hard limit by default.
+	ut.AssertEqual(t, time.Duration(0), (&Options{MaxDuration: 5}).Timeout())
+	for _, mode := range AllModes {
+		_, options := New("0.1").EnabledChecks([]Mode{mode})
+		ut.AssertEqual(t, time.Duration(0), options.Timeout())
+	}
+	ut.AssertEqual(t, 7*time.Second, (&Options{MaxDuration: 5, CheckTimeout: 7}).Timeout())
+	ut.AssertEqual(t, time.Duration(0), (&Options{MaxDuration: 5, CheckTimeout: -1}).Timeout())
+	// The most lenient limit wins, like MaxDuration.
+	ut.AssertEqual(t, Options{MaxDuration: 15, CheckTimeout: 30}, *(&Options{MaxDuration: 5, CheckTimeout: 7}).merge(Options{MaxDuration: 15, CheckTimeout: 30}))
+	ut.AssertEqual(t, Options{MaxDuration: 5, CheckTimeout: -1}, *(&Options{MaxDuration: 5, CheckTimeout: 30}).merge(Options{CheckTimeout: -1}))
+	ut.AssertEqual(t, Options{MaxDuration: 5}, *(&Options{MaxDuration: 5, CheckTimeout: 30}).merge(Options{}))
+}
+
+func TestOptionsSaveLog(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, internal.RemoveAll(td))
+	}()
+	ut.AssertEqual(t, "", (&Options{metrics: &metricSet{}}).SaveLog("test", ".", "ok"))
+	o := &Options{ArtifactsDir: td, metrics: &metricSet{}}
+	root := o.SaveLog("test", ".", "ok\n")
+	ut.AssertEqual(t, filepath.Join(td, "logs", "test", "root.log"), root)
+	foo := o.SaveLog("test", "./foo/bar tags=x", "FAIL\n")
+	ut.AssertEqual(t, filepath.Join(td, "logs", "test", "foo_bar_tags_x.log"), foo)
+	// Saving again overwrites the log.
+	ut.AssertEqual(t, foo, o.SaveLog("test", "./foo/bar tags=x", "ok\n"))
+	// A different name mapping to the same file name gets its own file.
+	ut.AssertEqual(t, filepath.Join(td, "logs", "test", "foo_bar_tags_x-2.log"), o.SaveLog("test", "./foo/bar tags:x", "ok\n"))
+	b, err := ioutil.ReadFile(foo)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "ok\n", string(b))
+	ut.AssertEqual(t, 3, len(o.Logs()))
+	ut.AssertEqual(t, root, o.Logs()["test:."])
+}
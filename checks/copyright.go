@@ -0,0 +1,151 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Copyright looks for copyright headers in all files.
+//
+// TODO(maruel): Add auto-fix support once checks can modify files, using the
+// current year for {{.Year}}.
+type Copyright struct {
+	// Header is the header that all .go files must start with. It is a
+	// text/template where {{.Year}} matches any year or range of years, e.g.
+	// "2015" or "2015-2016".
+	Header string
+	// Regexp, if set, is used instead of Header. It must match at the start of
+	// the file.
+	Regexp string `yaml:"regexp,omitempty"`
+	// PerExtension defines the header for other file types, e.g. ".proto" or
+	// ".sh". The ".go" entry, if present, overrides Header and Regexp.
+	PerExtension map[string]CopyrightHeader `yaml:"per_extension,omitempty"`
+	// Exclude is a list of patterns in gitignore syntax of files that are not
+	// checked, in addition to the global ignore_patterns.
+	Exclude []string `yaml:"exclude,omitempty"`
+	// NewFilesOnly only checks the files added by the change, e.g. to not
+	// require existing files to be fixed.
+	NewFilesOnly bool `yaml:"new_files_only,omitempty"`
+}
+
+// CopyrightHeader is the header for a file type. See Copyright for the
+// meaning of each field.
+type CopyrightHeader struct {
+	Header string `yaml:"header,omitempty"`
+	Regexp string `yaml:"regexp,omitempty"`
+}
+
+// GetDescription implements Check.
+func (c *Copyright) GetDescription() string {
+	return "enforces all .go sources have copyright"
+}
+
+// GetName implements Check.
+func (c *Copyright) GetName() string {
+	return "copyright"
+}
+
+// GetPrerequisites implements Check.
+func (c *Copyright) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (c *Copyright) Scope() Scope {
+	return ScopeFiles
+}
+
+// Weight implements Weighter.
+func (c *Copyright) Weight() Weight {
+	return WeightNative
+}
+
+// considers implements fileFilter.
+func (c *Copyright) considers(p string) bool {
+	ext := filepath.Ext(p)
+	if _, ok := c.PerExtension[ext]; !ok && ext != ".go" {
+		return false
+	}
+	exclude := scm.IgnorePatterns(c.Exclude)
+	return !exclude.Match(p)
+}
+
+// Run implements Check.
+func (c *Copyright) Run(ctx context.Context, change scm.Change, options *Options) error {
+	headers := map[string]*regexp.Regexp{}
+	h, err := CopyrightHeader{c.Header, c.Regexp}.compile()
+	if err != nil {
+		return err
+	}
+	headers[".go"] = h
+	for ext, header := range c.PerExtension {
+		if headers[ext], err = header.compile(); err != nil {
+			return err
+		}
+	}
+	exclude := scm.IgnorePatterns(c.Exclude)
+	var badFiles []string
+	// This this serially since it's I/O bound and will compete with process
+	// startup of other checks.
+	for _, f := range change.Changed().Files() {
+		re := headers[filepath.Ext(f)]
+		if re == nil || change.IsIgnored(f) || exclude.Match(f) || (c.NewFilesOnly && change.Status(f) != scm.Added) {
+			continue
+		}
+		if content := change.Content(f); content == nil || !re.Match(skipShebang(content)) {
+			badFiles = append(badFiles, f)
+		}
+	}
+	if len(badFiles) != 0 {
+		return fmt.Errorf("files have invalid copyright header:\n  %s", strings.Join(badFiles, "\n  "))
+	}
+	return nil
+}
+
+// Private stuff.
+
+// yearPlaceholder is used to find where {{.Year}} is in the template.
+const yearPlaceholder = "\x00year\x00"
+
+// compile returns the regexp to match at the start of a file.
+func (c CopyrightHeader) compile() (*regexp.Regexp, error) {
+	if c.Regexp != "" {
+		re, err := regexp.Compile("^(?:" + c.Regexp + ")")
+		if err != nil {
+			return nil, fmt.Errorf("copyright has invalid regexp: %s", err)
+		}
+		return re, nil
+	}
+	t, err := template.New("").Parse(c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("copyright has invalid header template: %s", err)
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, struct{ Year string }{yearPlaceholder}); err != nil {
+		return nil, fmt.Errorf("copyright has invalid header template: %s", err)
+	}
+	quoted := strings.Replace(regexp.QuoteMeta(b.String()), yearPlaceholder, `[0-9]{4}(?:-[0-9]{4})?`, -1)
+	return regexp.Compile("^" + quoted)
+}
+
+// skipShebang skips the first line if it's a shebang, e.g. "#!/bin/sh".
+func skipShebang(content []byte) []byte {
+	if bytes.HasPrefix(content, []byte("#!")) {
+		if i := bytes.IndexByte(content, '\n'); i != -1 {
+			return content[i+1:]
+		}
+		return nil
+	}
+	return content
+}
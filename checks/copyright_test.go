@@ -0,0 +1,72 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestCopyrightHeaderCompile(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		h        CopyrightHeader
+		content  string
+		expected bool
+	}{
+		{CopyrightHeader{}, "package foo", true},
+		{CopyrightHeader{Header: "// Foo"}, "// Foo\npackage foo", true},
+		{CopyrightHeader{Header: "// Foo"}, "package foo", false},
+		{CopyrightHeader{Header: "// (c) Foo."}, "// (c) Foo.\n", true},
+		{CopyrightHeader{Header: "// (c) Foo."}, "// (c) Foo!\n", false},
+		{CopyrightHeader{Header: "// Copyright {{.Year}} Foo"}, "// Copyright 2015 Foo\n", true},
+		{CopyrightHeader{Header: "// Copyright {{.Year}} Foo"}, "// Copyright 2015-2016 Foo\n", true},
+		{CopyrightHeader{Header: "// Copyright {{.Year}} Foo"}, "// Copyright 15 Foo\n", false},
+		{CopyrightHeader{Regexp: "// Copyright [0-9]+ (Foo|Bar)"}, "// Copyright 1 Bar\n", true},
+		{CopyrightHeader{Regexp: "// Copyright"}, "\n// Copyright\n", false},
+	}
+	for i, line := range data {
+		re, err := line.h.compile()
+		ut.AssertEqualIndex(t, i, nil, err)
+		ut.AssertEqualIndex(t, i, line.expected, re.MatchString(line.content))
+	}
+	_, err := CopyrightHeader{Header: "{{"}.compile()
+	ut.AssertEqual(t, true, err != nil)
+	_, err = CopyrightHeader{Regexp: "("}.compile()
+	ut.AssertEqual(t, true, err != nil)
+}
+
+func TestSkipShebang(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, "# Foo\n", string(skipShebang([]byte("#!/bin/sh\n# Foo\n"))))
+	ut.AssertEqual(t, "# Foo\n", string(skipShebang([]byte("# Foo\n"))))
+	ut.AssertEqual(t, "", string(skipShebang([]byte("#!/bin/sh"))))
+}
+
+func TestCopyrightNewFilesOnly(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	// All the files are new.
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n"})
+	c := &Copyright{Header: "// Foo", NewFilesOnly: true}
+	ut.AssertEqual(t, scm.Added, change.Status("foo.go"))
+	ut.AssertEqual(t, true, c.Run(context.Background(), change, &Options{}) != nil)
+	// The status of explicitly listed files is unknown.
+	change, err = scm.FromFiles(change.Repo(), []string{"foo.go"}, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, scm.Modified, change.Status("foo.go"))
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, &Options{}))
+}
@@ -11,6 +11,8 @@ package checks
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,12 +20,13 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/maruel/pre-commit-go/checks/internal/cover"
+	"github.com/maruel/pre-commit-go/cover"
 	"github.com/maruel/pre-commit-go/internal"
 	"github.com/maruel/pre-commit-go/scm"
 )
@@ -36,6 +39,40 @@ type Coverage struct {
 	PerDirDefault      CoverageSettings             `yaml:"per_dir_default"`
 	PerDir             map[string]*CoverageSettings `yaml:"per_dir"`
 	IgnorePathPatterns []string                     `yaml:"ignore_path_patterns"`
+	// Strict fails the check when an entry of the coverage profile can't be
+	// mapped to a file in the repository instead of ignoring it.
+	Strict bool `yaml:"strict,omitempty"`
+	// Ratchet raises the minimum coverage of the global profile, or of each
+	// package, to its last recorded value, so the coverage can only go up. The
+	// last value is read from RatchetFile if set, otherwise from the metrics
+	// history, preferably the run on the upstream commit. A package whose
+	// minimum coverage is 0 is not enforced either way.
+	Ratchet bool `yaml:"ratchet,omitempty"`
+	// RatchetFile is a file relative to the repository root, in the format of
+	// MetricsFile, with the coverage.* metrics to ratchet against. It is
+	// committed and updated manually, e.g. by copying metrics.json from the
+	// artifacts of a run.
+	RatchetFile string `yaml:"ratchet_file,omitempty"`
+	// CoverMode is the -covermode passed to go test: "set", "count" or
+	// "atomic". It defaults to "atomic" when Race is set, "count" otherwise.
+	CoverMode string `yaml:"cover_mode,omitempty"`
+	// Race runs the tests with the race detector, which requires the atomic
+	// mode.
+	Race bool `yaml:"race,omitempty"`
+	// ExtraPasses are additional runs of the tests, e.g. of the integration
+	// tests, whose coverage is merged into the same profile. They are meant to
+	// be configured in the continuous-integration mode only.
+	ExtraPasses []CoveragePass `yaml:"extra_passes,omitempty"`
+}
+
+// CoveragePass is an additional run of the tests of the Coverage check.
+type CoveragePass struct {
+	// Tags is the build tag set to run the tests with, passed as -tags, e.g.
+	// "integration,linux".
+	Tags string `yaml:"tags,omitempty"`
+	// ExtraArgs are additional arguments to go test, e.g. ["-run",
+	// "TestIntegration"].
+	ExtraArgs []string `yaml:"extra_args,omitempty"`
 }
 
 // CoverageSettings specifies coverage settings.
@@ -62,15 +99,47 @@ func (c *Coverage) GetPrerequisites() []CheckPrerequisite {
 	return nil
 }
 
+// Scope implements Scoper.
+func (c *Coverage) Scope() Scope {
+	if c.UseGlobalInference {
+		return ScopeRepository
+	}
+	return ScopeTestPackages
+}
+
+// Weight implements Weighter.
+func (c *Coverage) Weight() Weight {
+	return WeightPerPackage
+}
+
 // Run implements Check.
-func (c *Coverage) Run(change scm.Change, options *Options) error {
-	profile, err := c.RunProfile(change, options)
+func (c *Coverage) Run(ctx context.Context, change scm.Change, options *Options) error {
+	profile, err := c.RunProfile(ctx, change, options)
+	if err != nil {
+		return err
+	}
+	if options.Shard.Enabled() {
+		// The profile is only partial; it is enforced once merged with the
+		// profiles of the other shards.
+		return nil
+	}
+	return c.Enforce(change, profile, options)
+}
+
+// Enforce publishes the coverage metrics of profile and enforces the coverage
+// settings on it. Run calls it with the profile of the tests it ran; 'pcg
+// merge-coverage' with the merged profiles of the shards.
+func (c *Coverage) Enforce(change scm.Change, profile CoverageProfile, options *Options) error {
+	if profile.TotalLines() != 0 {
+		options.PublishMetric("coverage.global", profile.CoveragePercent())
+	}
+	baseline, err := c.ratchetBaseline(change, options)
 	if err != nil {
 		return err
 	}
 
 	if c.UseGlobalInference {
-		out, err := ProcessProfile(profile, &c.Global)
+		out, err := ProcessProfile(profile, ratchet(&c.Global, baseline, "coverage.global"))
 		if out != "" {
 			log.Printf("coverage for %s:\n%s\n", change.Repo().Root(), out)
 		}
@@ -80,10 +149,14 @@ func (c *Coverage) Run(change scm.Change, options *Options) error {
 	} else {
 		for _, testPkg := range change.Indirect().TestPackages() {
 			p := profile.Subset(pkgToDir(testPkg))
+			if p.TotalLines() != 0 {
+				options.PublishMetric("coverage."+pkgToDir(testPkg), p.CoveragePercent())
+			}
 			settings := c.SettingsForPkg(testPkg)
 			if settings.MinCoverage == 0 {
 				continue
 			}
+			settings = ratchet(settings, baseline, "coverage."+pkgToDir(testPkg))
 			out, err := ProcessProfile(p, settings)
 			if out != "" {
 				log.Printf("%s:\n%s\n", testPkg, out)
@@ -97,7 +170,7 @@ func (c *Coverage) Run(change scm.Change, options *Options) error {
 }
 
 // RunProfile runs a coverage run according to the settings and return results.
-func (c *Coverage) RunProfile(change scm.Change, options *Options) (profile CoverageProfile, err error) {
+func (c *Coverage) RunProfile(ctx context.Context, change scm.Change, options *Options) (profile CoverageProfile, err error) {
 	// go test accepts packages, not files.
 	var testPkgs []string
 	if c.UseGlobalInference {
@@ -105,10 +178,15 @@ func (c *Coverage) RunProfile(change scm.Change, options *Options) (profile Cove
 	} else {
 		testPkgs = change.Indirect().TestPackages()
 	}
+	testPkgs = options.Shard.Filter(testPkgs)
 	if len(testPkgs) == 0 {
 		// Sir, there's no test.
 		return nil, nil
 	}
+	if _, err := c.coverMode(); err != nil {
+		return nil, err
+	}
+	options.Count(CounterPackages, len(testPkgs))
 
 	tmpDir, err2 := ioutil.TempDir("", "pre-commit-go")
 	if err2 != nil {
@@ -122,15 +200,15 @@ func (c *Coverage) RunProfile(change scm.Change, options *Options) (profile Cove
 	}()
 
 	if c.UseGlobalInference {
-		profile, err = c.RunGlobal(change, options, tmpDir)
+		profile, err = c.RunGlobal(ctx, change, options, tmpDir)
 	} else {
-		profile, err = c.RunLocal(change, options, tmpDir)
+		profile, err = c.RunLocal(ctx, change, options, tmpDir)
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	if c.isGoverallsEnabled() {
+	if c.isGoverallsEnabled() && !options.Shard.Enabled() {
 		// Please send a pull request if the following doesn't work for you on your
 		// favorite CI system.
 		cmd := []string{
@@ -139,7 +217,7 @@ func (c *Coverage) RunProfile(change scm.Change, options *Options) (profile Cove
 		if len(c.IgnorePathPatterns) > 0 {
 			cmd = append(cmd, "-ignore", strings.Join(c.IgnorePathPatterns, ","))
 		}
-		out, _, _, err2 := options.Capture(change.Repo(), cmd...)
+		out, _, _, err2 := options.Capture(ctx, change.Repo(), cmd...)
 		// Don't fail the build.
 		if err2 != nil {
 			fmt.Printf("%s", out)
@@ -152,7 +230,7 @@ func (c *Coverage) RunProfile(change scm.Change, options *Options) (profile Cove
 //
 // This means that test can contribute coverage in any other package, even
 // outside their own package.
-func (c *Coverage) RunGlobal(change scm.Change, options *Options, tmpDir string) (CoverageProfile, error) {
+func (c *Coverage) RunGlobal(ctx context.Context, change scm.Change, options *Options, tmpDir string) (CoverageProfile, error) {
 	coverPkg := ""
 	for i, p := range change.All().Packages() {
 		if s := c.SettingsForPkg(p); s.MinCoverage != 0 {
@@ -166,49 +244,40 @@ func (c *Coverage) RunGlobal(change scm.Change, options *Options, tmpDir string)
 	// This part is similar to Test.Run() except that it passes a unique
 	// -coverprofile file name, so that all the files can later be merged into a
 	// single file.
-	testPkgs := change.All().TestPackages()
+	testPkgs := options.Shard.Filter(change.All().TestPackages())
 	type result struct {
 		file string
+		pkg  string
 		err  error
 	}
 	results := make(chan *result)
-	for index, tp := range testPkgs {
+	passes := c.passes()
+	for index, job := range passJobs(passes, testPkgs) {
 		f := filepath.Join(tmpDir, fmt.Sprintf("test%d.cov", index))
-		go func(f string, testPkg string) {
+		go func(f string, pass CoveragePass, testPkg string) {
 			// Maybe fallback to 'pkg + "/..."' and post process to remove
 			// uninteresting directories. The rationale is that it will eventually
 			// blow up the OS specific command argument length.
-			args := []string{
-				"go", "test", "-v", "-covermode=count", "-coverpkg", coverPkg,
-				"-coverprofile", f,
-				"-timeout", fmt.Sprintf("%ds", options.MaxDuration),
-				testPkg,
-			}
-			out, exitCode, duration, err := options.Capture(change.Repo(), args...)
+			args := c.testArgs(options, f, pass, "-coverpkg", coverPkg, testPkg)
+			out, exitCode, duration, err := options.Capture(ctx, change.Repo(), args...)
 			if duration > time.Second {
 				log.Printf("%s was slow: %s", args, round(duration, time.Millisecond))
 			}
 			if exitCode != 0 {
 				err = fmt.Errorf("%s %s failed:\n%s", strings.Join(args, " "), testPkg, processStackTrace(out))
 			}
-			results <- &result{f, err}
-		}(f, tp)
+			results <- &result{f, testPkg, err}
+		}(f, job.pass, job.pkg)
 	}
 
-	// Sends to coveralls.io if applicable. Do not write to disk unless needed.
-	var f readWriteSeekCloser
-	var err error
-	if c.isGoverallsEnabled() {
-		if f, err = os.Create(filepath.Join(tmpDir, "profile.cov")); err != nil {
-			return nil, err
-		}
-	} else {
-		f = &buffer{}
+	f, err := c.createProfile(tmpDir, options)
+	if err != nil {
+		return nil, err
 	}
 
 	// Aggregate all results.
-	counts := map[string]int{}
-	for i := 0; i < len(testPkgs); i++ {
+	raw := &rawCoverage{counts: map[string]int{}}
+	for i := 0; i < len(passes)*len(testPkgs); i++ {
 		result := <-results
 		if err != nil {
 			continue
@@ -217,7 +286,11 @@ func (c *Coverage) RunGlobal(change scm.Change, options *Options, tmpDir string)
 			err = result.err
 			continue
 		}
-		if err2 := loadRawCoverage(result.file, counts); err == nil {
+		if err2 := checkProfileFile(result.file, result.pkg); err2 != nil {
+			err = err2
+			continue
+		}
+		if err2 := loadRawCoverage(result.file, raw); err == nil {
 			// Wait for all tests to complete before returning.
 			err = err2
 		}
@@ -226,20 +299,22 @@ func (c *Coverage) RunGlobal(change scm.Change, options *Options, tmpDir string)
 		f.Close()
 		return nil, err
 	}
-	return loadMergeAndClose(f, counts, change)
+	return loadMergeAndClose(f, raw, change, c.Strict)
 }
 
 // RunLocal runs all tests and reports the merged coverage of each individual
 // covered package.
-func (c *Coverage) RunLocal(change scm.Change, options *Options, tmpDir string) (CoverageProfile, error) {
-	testPkgs := change.Indirect().TestPackages()
+func (c *Coverage) RunLocal(ctx context.Context, change scm.Change, options *Options, tmpDir string) (CoverageProfile, error) {
+	testPkgs := options.Shard.Filter(change.Indirect().TestPackages())
 	type result struct {
 		file string
+		pkg  string
 		err  error
 	}
 	results := make(chan *result)
-	for i, tp := range testPkgs {
-		go func(index int, testPkg string) {
+	passes := c.passes()
+	for i, job := range passJobs(passes, testPkgs) {
+		go func(index int, pass CoveragePass, testPkg string) {
 			settings := c.SettingsForPkg(testPkg)
 			// Skip coverage if disabled for this directory.
 			if settings.MinCoverage == 0 {
@@ -248,13 +323,8 @@ func (c *Coverage) RunLocal(change scm.Change, options *Options, tmpDir string)
 			}
 
 			p := filepath.Join(tmpDir, fmt.Sprintf("test%d.cov", index))
-			args := []string{
-				"go", "test", "-v", "-covermode=count",
-				"-coverprofile", p,
-				"-timeout", fmt.Sprintf("%ds", options.MaxDuration),
-				testPkg,
-			}
-			out, exitCode, duration, _ := options.Capture(change.Repo(), args...)
+			args := c.testArgs(options, p, pass, testPkg)
+			out, exitCode, duration, _ := options.Capture(ctx, change.Repo(), args...)
 			if duration > time.Second {
 				log.Printf("%s was slow: %s", args, round(duration, time.Millisecond))
 			}
@@ -262,24 +332,18 @@ func (c *Coverage) RunLocal(change scm.Change, options *Options, tmpDir string)
 				results <- &result{err: fmt.Errorf("%s %s failed:\n%s", strings.Join(args, " "), testPkg, processStackTrace(out))}
 				return
 			}
-			results <- &result{file: p}
-		}(i, tp)
+			results <- &result{file: p, pkg: testPkg}
+		}(i, job.pass, job.pkg)
 	}
 
-	// Sends to coveralls.io if applicable. Do not write to disk unless needed.
-	var f readWriteSeekCloser
-	var err error
-	if c.isGoverallsEnabled() {
-		if f, err = os.Create(filepath.Join(tmpDir, "profile.cov")); err != nil {
-			return nil, err
-		}
-	} else {
-		f = &buffer{}
+	f, err := c.createProfile(tmpDir, options)
+	if err != nil {
+		return nil, err
 	}
 
 	// Aggregate all results.
-	counts := map[string]int{}
-	for i := 0; i < len(testPkgs); i++ {
+	raw := &rawCoverage{counts: map[string]int{}}
+	for i := 0; i < len(passes)*len(testPkgs); i++ {
 		result := <-results
 		if err != nil {
 			continue
@@ -291,7 +355,11 @@ func (c *Coverage) RunLocal(change scm.Change, options *Options, tmpDir string)
 			err = result.err
 			continue
 		}
-		if err2 := loadRawCoverage(result.file, counts); err == nil {
+		if err2 := checkProfileFile(result.file, result.pkg); err2 != nil {
+			err = err2
+			continue
+		}
+		if err2 := loadRawCoverage(result.file, raw); err == nil {
 			// Wait for all tests to complete before returning.
 			err = err2
 		}
@@ -300,7 +368,44 @@ func (c *Coverage) RunLocal(change scm.Change, options *Options, tmpDir string)
 		f.Close()
 		return nil, err
 	}
-	return loadMergeAndClose(f, counts, change)
+	return loadMergeAndClose(f, raw, change, c.Strict)
+}
+
+// LoadProfiles loads and merges existing coverage profiles, e.g. written by
+// "go test -coverprofile". The profiles can be in any mode. The file names are
+// normalized like MergeProfiles does.
+func (c *Coverage) LoadProfiles(change scm.Change, files ...string) (CoverageProfile, error) {
+	raw := &rawCoverage{counts: map[string]int{}}
+	for _, file := range files {
+		if err := loadRawCoverage(file, raw); err != nil {
+			return nil, err
+		}
+	}
+	raw.normalize(change.Package())
+	return loadMergeAndClose(&buffer{}, raw, change, c.Strict)
+}
+
+// MergeProfiles merges the coverage profiles files into a single profile
+// written to out, e.g. the profiles of the shards of a CI run or of the jobs
+// of a build matrix, so the coverage is enforced on the aggregate.
+//
+// The counts of a statement found in multiple profiles are summed. Profiles
+// in count and atomic mode merge into count mode unless they are all atomic.
+//
+// pkg is the import path of the repository root, as returned by
+// scm.Change.Package(). The file names recorded under another path ending with
+// it, e.g. "_/home/ci/src/<pkg>/foo.go" when the tests were run outside of
+// GOPATH, are normalized to "<pkg>/foo.go", as are Windows path separators.
+// No path is rewritten when pkg is empty.
+func MergeProfiles(out io.Writer, pkg string, files ...string) error {
+	raw := &rawCoverage{counts: map[string]int{}}
+	for _, file := range files {
+		if err := loadRawCoverage(file, raw); err != nil {
+			return err
+		}
+	}
+	raw.normalize(pkg)
+	return mergeCoverage(raw, out)
 }
 
 // SettingsForPkg returns the settings for a particular package.
@@ -318,10 +423,185 @@ func (c *Coverage) SettingsForPkg(testPkg string) *CoverageSettings {
 	return &c.PerDirDefault
 }
 
+// createProfile returns where the merged profile is written: in the artifacts
+// directory when sharded, on disk to send it to coveralls.io if applicable,
+// otherwise in memory.
+func (c *Coverage) createProfile(tmpDir string, options *Options) (readWriteSeekCloser, error) {
+	p := ""
+	if options.Shard.Enabled() {
+		if options.ArtifactsDir == "" {
+			return nil, errors.New("coverage: an artifacts directory is required to keep the profile of the shard")
+		}
+		p = filepath.Join(options.ArtifactsDir, options.Shard.CoverageProfile())
+	} else if c.isGoverallsEnabled() {
+		p = filepath.Join(tmpDir, "profile.cov")
+	} else {
+		// Do not write to disk unless needed.
+		return &buffer{}, nil
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// coverMode returns the -covermode to use; see CoverMode.
+func (c *Coverage) coverMode() (string, error) {
+	switch c.CoverMode {
+	case "":
+		if c.Race {
+			return cover.ModeAtomic, nil
+		}
+		return cover.ModeCount, nil
+	case cover.ModeSet, cover.ModeCount:
+		if c.Race {
+			return "", fmt.Errorf("coverage: cover_mode %s can't be used with race, use %s", c.CoverMode, cover.ModeAtomic)
+		}
+		return c.CoverMode, nil
+	case cover.ModeAtomic:
+		return c.CoverMode, nil
+	default:
+		return "", fmt.Errorf("coverage: invalid cover_mode %q; expected %s, %s or %s", c.CoverMode, cover.ModeSet, cover.ModeCount, cover.ModeAtomic)
+	}
+}
+
+// testArgs returns the go test command line to run the tests of the pass
+// with coverage into the profile file. args are the additional arguments, the
+// package last.
+//
+// The mode must have been validated by coverMode.
+func (c *Coverage) testArgs(options *Options, profile string, pass CoveragePass, args ...string) []string {
+	mode, _ := c.coverMode()
+	out := []string{"go", "test", "-v", "-covermode=" + mode}
+	if c.Race {
+		out = append(out, "-race")
+	}
+	out = append(out, "-coverprofile", profile, "-timeout", fmt.Sprintf("%ds", options.MaxDuration))
+	out = append(out, pass.ExtraArgs...)
+	if pass.Tags != "" {
+		out = append(out, "-tags", pass.Tags)
+	}
+	return append(out, args...)
+}
+
+// passes returns the runs of the tests: the default one then ExtraPasses.
+func (c *Coverage) passes() []CoveragePass {
+	return append([]CoveragePass{{}}, c.ExtraPasses...)
+}
+
 func (c *Coverage) isGoverallsEnabled() bool {
 	return c.UseCoveralls && IsContinuousIntegration()
 }
 
+// merge implements merger.
+//
+// The highest minimum coverage and the lowest maximum coverage win. Both
+// checks must agree on UseGlobalInference, IgnorePathPatterns, CoverMode and
+// ExtraPasses.
+func (c *Coverage) merge(other Check) Check {
+	o, ok := other.(*Coverage)
+	if !ok || c.UseGlobalInference != o.UseGlobalInference || !reflect.DeepEqual(c.IgnorePathPatterns, o.IgnorePathPatterns) || c.CoverMode != o.CoverMode || !reflect.DeepEqual(c.ExtraPasses, o.ExtraPasses) {
+		return nil
+	}
+	ratchetFile := c.RatchetFile
+	if ratchetFile == "" {
+		ratchetFile = o.RatchetFile
+	} else if o.RatchetFile != "" && o.RatchetFile != ratchetFile {
+		return nil
+	}
+	out := &Coverage{
+		UseGlobalInference: c.UseGlobalInference,
+		UseCoveralls:       c.UseCoveralls || o.UseCoveralls,
+		Global:             strictestCoverage(c.Global, o.Global),
+		PerDirDefault:      strictestCoverage(c.PerDirDefault, o.PerDirDefault),
+		IgnorePathPatterns: c.IgnorePathPatterns,
+		Strict:             c.Strict || o.Strict,
+		Ratchet:            c.Ratchet || o.Ratchet,
+		RatchetFile:        ratchetFile,
+		CoverMode:          c.CoverMode,
+		Race:               c.Race || o.Race,
+		ExtraPasses:        c.ExtraPasses,
+	}
+	if c.PerDir != nil || o.PerDir != nil {
+		out.PerDir = map[string]*CoverageSettings{}
+	}
+	for _, m := range []map[string]*CoverageSettings{c.PerDir, o.PerDir} {
+		for dir := range m {
+			s := strictestCoverage(c.settingsForDir(dir), o.settingsForDir(dir))
+			out.PerDir[dir] = &s
+		}
+	}
+	return out
+}
+
+// settingsForDir is like SettingsForPkg but takes a directory.
+func (c *Coverage) settingsForDir(dir string) CoverageSettings {
+	if settings, ok := c.PerDir[dir]; ok {
+		if settings == nil {
+			return CoverageSettings{}
+		}
+		return *settings
+	}
+	return c.PerDirDefault
+}
+
+// ratchetBaseline returns the coverage metrics to ratchet against, or nil if
+// Ratchet is disabled or there is no previous value.
+func (c *Coverage) ratchetBaseline(change scm.Change, options *Options) (map[string]float64, error) {
+	if !c.Ratchet {
+		return nil, nil
+	}
+	if c.RatchetFile != "" {
+		content := change.Content(c.RatchetFile)
+		if content == nil {
+			return nil, fmt.Errorf("coverage: ratchet_file %s not found", c.RatchetFile)
+		}
+		var out map[string]float64
+		if err := json.Unmarshal(content, &out); err != nil {
+			return nil, fmt.Errorf("coverage: ratchet_file %s is invalid: %s", c.RatchetFile, err)
+		}
+		return out, nil
+	}
+	repo := change.Repo()
+	s := repoStorage(repo, options)
+	history, err := LoadMetricsHistory(s)
+	if err != nil {
+		return nil, err
+	}
+	out := BaselineMetrics(history, repo.Eval(string(scm.Upstream)), repo.Eval(string(scm.Head)))
+	if out == nil {
+		log.Printf("coverage: no recorded coverage to ratchet against")
+	}
+	return out, nil
+}
+
+// ratchet returns settings with the minimum coverage raised to the value of
+// metric in baseline, if higher.
+func ratchet(settings *CoverageSettings, baseline map[string]float64, metric string) *CoverageSettings {
+	b, ok := baseline[metric]
+	if !ok || b <= settings.MinCoverage {
+		return settings
+	}
+	log.Printf("coverage: ratcheting the minimum of %s from %g to %g", metric, settings.MinCoverage, b)
+	out := *settings
+	out.MinCoverage = b
+	return &out
+}
+
+// strictestCoverage returns the strictest settings of both. 0 means no
+// limit.
+func strictestCoverage(a, b CoverageSettings) CoverageSettings {
+	out := a
+	if b.MinCoverage > out.MinCoverage {
+		out.MinCoverage = b.MinCoverage
+	}
+	if b.MaxCoverage > 0 && (out.MaxCoverage == 0 || b.MaxCoverage < out.MaxCoverage) {
+		out.MaxCoverage = b.MaxCoverage
+	}
+	return out
+}
+
 // ProcessProfile generates output that can be optionally printed and an error if the check failed.
 func ProcessProfile(profile CoverageProfile, settings *CoverageSettings) (string, error) {
 	out := ""
@@ -507,6 +787,23 @@ func pkgToDir(p string) string {
 	return p[2:]
 }
 
+// passJob is a test package to run in a CoveragePass.
+type passJob struct {
+	pass CoveragePass
+	pkg  string
+}
+
+// passJobs returns each test package of each pass.
+func passJobs(passes []CoveragePass, testPkgs []string) []passJob {
+	out := make([]passJob, 0, len(passes)*len(testPkgs))
+	for _, pass := range passes {
+		for _, pkg := range testPkgs {
+			out = append(out, passJob{pass, pkg})
+		}
+	}
+	return out
+}
+
 type readWriteSeekCloser interface {
 	io.Reader
 	io.Writer
@@ -531,21 +828,74 @@ func (b *buffer) Seek(i int64, j int) (int64, error) {
 }
 
 // loadMergeAndClose calls mergeCoverage() then loadProfile().
-func loadMergeAndClose(f readWriteSeekCloser, counts map[string]int, change scm.Change) (CoverageProfile, error) {
+func loadMergeAndClose(f readWriteSeekCloser, raw *rawCoverage, change scm.Change, strict bool) (CoverageProfile, error) {
 	defer f.Close()
-	err := mergeCoverage(counts, f)
+	err := mergeCoverage(raw, f)
 	if err != nil {
 		return nil, err
 	}
 	if _, err = f.Seek(0, 0); err != nil {
 		return nil, err
 	}
-	return loadProfile(change, f)
+	return loadProfile(change, f, strict)
+}
+
+// rawCoverage is the merged content of coverage profiles, before
+// interpretation.
+type rawCoverage struct {
+	// mode is the mode of the merged profile; "" until a profile is loaded.
+	mode string
+	// counts is the count of each "file.go:XX.YY,ZZ.II J" statement.
+	counts map[string]int
+}
+
+// normalize rewrites the file name of each statement to be relative to the
+// import path pkg; see MergeProfiles.
+func (r *rawCoverage) normalize(pkg string) {
+	counts := make(map[string]int, len(r.counts))
+	for stm, count := range r.counts {
+		if i := strings.LastIndex(stm, ":"); i != -1 {
+			stm = normalizeProfileFile(stm[:i], pkg) + stm[i:]
+		}
+		counts[stm] += count
+	}
+	r.counts = counts
+}
+
+// normalizeProfileFile returns the file name of a coverage profile entry
+// relative to the import path pkg.
+func normalizeProfileFile(file, pkg string) string {
+	file = strings.Replace(file, "\\", "/", -1)
+	if pkg == "" || strings.HasPrefix(file, pkg+"/") {
+		return file
+	}
+	if i := strings.LastIndex(file, "/"+pkg+"/"); i != -1 {
+		return file[i+1:]
+	}
+	return file
+}
+
+// isCoverMode returns true if mode is a valid coverage profile mode.
+func isCoverMode(mode string) bool {
+	return mode == cover.ModeSet || mode == cover.ModeCount || mode == cover.ModeAtomic
+}
+
+// mergeMode returns the mode of the merge of a profile in mode b into a
+// profile in mode a.
+//
+// Profiles in the same mode keep it. Otherwise the result is a count profile;
+// a set profile contributes 0 or 1 to the counts.
+func mergeMode(a, b string) string {
+	if a == "" || a == b {
+		return b
+	}
+	return cover.ModeCount
 }
 
 // mergeCoverage merges multiple coverage profiles into out.
 //
-// It sums all the counts of each profile. It doesn't actually process it.
+// It sums all the counts of each profile. In set mode, the sum is normalized
+// back to 0 or 1. It doesn't actually process it.
 //
 // Format is "file.go:XX.YY,ZZ.II J K"
 // - file.go is path against GOPATH
@@ -553,25 +903,53 @@ func loadMergeAndClose(f readWriteSeekCloser, counts map[string]int, change scm.
 // - ZZ.II is the line/column end of the statement.
 // - J is number of statements,
 // - K is count.
-func mergeCoverage(counts map[string]int, out io.Writer) error {
-	stms := make([]string, 0, len(counts))
-	for k := range counts {
+func mergeCoverage(raw *rawCoverage, out io.Writer) error {
+	stms := make([]string, 0, len(raw.counts))
+	for k := range raw.counts {
 		stms = append(stms, k)
 	}
 	sort.Strings(stms)
-	if _, err := io.WriteString(out, "mode: count\n"); err != nil {
+	mode := raw.mode
+	if mode == "" {
+		mode = cover.ModeCount
+	}
+	if _, err := fmt.Fprintf(out, "mode: %s\n", mode); err != nil {
 		return err
 	}
 	for _, stm := range stms {
-		if _, err := fmt.Fprintf(out, "%s %d\n", stm, counts[stm]); err != nil {
+		count := raw.counts[stm]
+		if mode == cover.ModeSet && count > 1 {
+			count = 1
+		}
+		if _, err := fmt.Fprintf(out, "%s %d\n", stm, count); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// loadRawCoverage loads a coverage profile file without any interpretation.
-func loadRawCoverage(file string, counts map[string]int) error {
+// checkProfileFile verifies that a coverage profile was written by a test
+// process that succeeded.
+//
+// A missing or empty profile after a passing test run means the test binary
+// exited before flushing the coverage data, which happens when TestMain calls
+// os.Exit() without returning. Merging it would silently lower the coverage.
+func checkProfileFile(file, testPkg string) error {
+	fi, err := os.Stat(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err != nil || fi.Size() == 0 {
+		return fmt.Errorf("coverage for %s: tests passed but no coverage profile was written; does TestMain call os.Exit()? Return from TestMain instead (Go 1.15+) or call os.Exit(m.Run()) directly", testPkg)
+	}
+	return nil
+}
+
+// loadRawCoverage loads a coverage profile file without any interpretation
+// and merges it into raw.
+//
+// The profile can be in any mode; see mergeMode.
+func loadRawCoverage(file string, raw *rawCoverage) error {
 	f, err := os.Open(file)
 	if err != nil {
 		return err
@@ -580,11 +958,22 @@ func loadRawCoverage(file string, counts map[string]int) error {
 	s := bufio.NewScanner(f)
 	// Strip the first line.
 	s.Scan()
-	if line := s.Text(); line != "mode: count" {
+	line := s.Text()
+	mode := strings.TrimPrefix(line, "mode: ")
+	if !isCoverMode(mode) {
 		return fmt.Errorf("malformed %s: %s", file, line)
 	}
+	raw.mode = mergeMode(raw.mode, mode)
 	for s.Scan() {
 		line := s.Text()
+		if m := strings.TrimPrefix(line, "mode: "); m != line {
+			// Concatenated profiles.
+			if !isCoverMode(m) {
+				return fmt.Errorf("malformed %s: %s", file, line)
+			}
+			raw.mode = mergeMode(raw.mode, m)
+			continue
+		}
 		items := rsplitn(line, " ", 2)
 		if len(items) != 2 {
 			return fmt.Errorf("malformed %s", file)
@@ -597,15 +986,16 @@ func loadRawCoverage(file string, counts map[string]int) error {
 		if err != nil {
 			break
 		}
-		counts[items[0]] += int(count)
+		raw.counts[items[0]] += int(count)
 	}
 	return err
 }
 
 // loadProfile loads the raw results of a coverage profile.
 //
-// It is already pre-sorted.
-func loadProfile(change limitedChange, r io.Reader) (CoverageProfile, error) {
+// It is already pre-sorted. When strict is true, entries that can't be mapped
+// to a file in the repository make it fail.
+func loadProfile(change limitedChange, r io.Reader, strict bool) (CoverageProfile, error) {
 	rawProfile, err := cover.ParseProfiles(change, r)
 	if err != nil {
 		return nil, err
@@ -614,18 +1004,14 @@ func loadProfile(change limitedChange, r io.Reader) (CoverageProfile, error) {
 	// Take the raw profile into a real one. This permits us to not have to
 	// depend on "go tool cover" to save one process per package and reduce I/O
 	// by reusing the in-memory file cache.
-	pkg := change.Package()
-	pkgOffset := len(pkg)
-	if pkgOffset > 0 {
-		pkgOffset++
-	}
 	out := CoverageProfile{}
+	var unknown []string
 	for _, profile := range rawProfile {
-		// fn is in absolute package format based on $GOPATH. Transform to path.
-		source := profile.FileName[pkgOffset:]
-		content := change.Content(source)
+		source, content, tried := profileSource(change, profile.FileName)
 		if content == nil {
-			log.Printf("unknown file %s", source)
+			u := fmt.Sprintf("%s (package %q; tried %s)", profile.FileName, change.Package(), strings.Join(tried, ", "))
+			log.Printf("unknown file %s", u)
+			unknown = append(unknown, u)
 			continue
 		}
 		funcs, err := cover.FindFuncs(source, bytes.NewReader(content))
@@ -635,6 +1021,10 @@ func loadProfile(change limitedChange, r io.Reader) (CoverageProfile, error) {
 		}
 		// Now match up functions and profile blocks.
 		for _, f := range funcs {
+			if f.NoCover {
+				// Generated or excluded with //pcg:nocover.
+				continue
+			}
 			// Convert a FuncExtent to a funcCovered.
 			covered, missing := f.Coverage(profile)
 			t := covered + len(missing)
@@ -650,10 +1040,36 @@ func loadProfile(change limitedChange, r io.Reader) (CoverageProfile, error) {
 			})
 		}
 	}
+	if strict && len(unknown) != 0 {
+		return nil, fmt.Errorf("coverage profile has entries for unknown files:\n%s", strings.Join(unknown, "\n"))
+	}
 	sort.Sort(out)
 	return out, nil
 }
 
+// profileSource maps the file name of a coverage profile entry, which is in
+// absolute package format, to a path relative to the repository root.
+//
+// Returns the path and its content, or nil content and the quoted paths that
+// were tried.
+func profileSource(change limitedChange, fileName string) (string, []byte, []string) {
+	var candidates []string
+	if pkg := change.Package(); pkg != "" && strings.HasPrefix(fileName, pkg+"/") {
+		candidates = append(candidates, fileName[len(pkg)+1:])
+	}
+	// The file name is also tried as-is, which is the case when there's no
+	// package.
+	candidates = append(candidates, fileName)
+	tried := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if content := change.Content(c); content != nil {
+			return c, content, nil
+		}
+		tried = append(tried, strconv.Quote(c))
+	}
+	return "", nil, tried
+}
+
 // limitedChange is a subset of scm.Change
 type limitedChange interface {
 	IsIgnored(p string) bool
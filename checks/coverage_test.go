@@ -5,8 +5,13 @@
 package checks
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/maruel/pre-commit-go/internal"
@@ -40,7 +45,7 @@ func TestCoverageGlobal(t *testing.T) {
 		},
 		PerDir: map[string]*CoverageSettings{},
 	}
-	profile, err := c.RunProfile(change, &Options{MaxDuration: 1})
+	profile, err := c.RunProfile(context.Background(), change, &Options{MaxDuration: 1})
 	ut.AssertEqual(t, nil, err)
 	expected := CoverageProfile{
 		{
@@ -144,7 +149,7 @@ func TestCoverageLocal(t *testing.T) {
 		},
 		PerDir: map[string]*CoverageSettings{},
 	}
-	profile, err := c.RunProfile(change, &Options{MaxDuration: 1})
+	profile, err := c.RunProfile(context.Background(), change, &Options{MaxDuration: 1})
 	ut.AssertEqual(t, nil, err)
 	expected := CoverageProfile{
 		{
@@ -206,7 +211,143 @@ func TestCoverageLocal(t *testing.T) {
 	}
 	ut.AssertEqual(t, expected, profile.Subset("bar"))
 
-	ut.AssertEqual(t, nil, c.Run(change, &Options{MaxDuration: 1}))
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, &Options{MaxDuration: 1}))
+
+	// A statement counts the same in set mode.
+	c.CoverMode = "set"
+	profile, err = c.RunProfile(context.Background(), change, &Options{MaxDuration: 1})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 60., profile.CoveragePercent())
+}
+
+func TestCoverageShards(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, coverageFiles)
+	artifacts := filepath.Join(td, ".artifacts")
+	ut.AssertEqual(t, nil, os.Mkdir(artifacts, 0700))
+
+	c := &Coverage{PerDirDefault: CoverageSettings{MinCoverage: 50, MaxCoverage: 100}}
+	var files []string
+	for i := 1; i <= 2; i++ {
+		options := &Options{MaxDuration: 1, ArtifactsDir: artifacts, Shard: Shard{i, 2}}
+		ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+		files = append(files, filepath.Join(artifacts, options.Shard.CoverageProfile()))
+	}
+	profile, err := c.LoadProfiles(change, files...)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 60., profile.CoveragePercent())
+	ut.AssertEqual(t, nil, c.Enforce(change, profile, &Options{}))
+
+	// Each shard only covers its package.
+	profile, err = c.LoadProfiles(change, files[0])
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 1, len(profile))
+	ut.AssertEqual(t, "foo.go", profile[0].Source)
+
+	expected := errors.New("coverage: an artifacts directory is required to keep the profile of the shard")
+	ut.AssertEqual(t, expected, c.Run(context.Background(), change, &Options{MaxDuration: 1, Shard: Shard{1, 2}}))
+}
+
+func TestCoverageExtraPasses(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	files := map[string]string{
+		"bar/integration_test.go": `//go:build integration
+// +build integration
+
+package bar
+import "testing"
+func TestIntegration(t *testing.T) {
+  if Bar(3) != 4 || Baz(3) != 4 {
+    t.Fail()
+  }
+}
+func TestFails(t *testing.T) {
+  t.Fail()
+}
+`,
+	}
+	for k, v := range coverageFiles {
+		files[k] = v
+	}
+	change := setup(t, td, files)
+
+	settings := CoverageSettings{MinCoverage: 50, MaxCoverage: 100}
+	pass := CoveragePass{Tags: "integration", ExtraArgs: []string{"-run", "TestIntegration"}}
+	for _, global := range []bool{false, true} {
+		c := &Coverage{UseGlobalInference: global, Global: settings, PerDirDefault: settings}
+		profile, err := c.RunProfile(context.Background(), change, &Options{MaxDuration: 1})
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, 60., profile.CoveragePercent())
+
+		// The integration tests cover the rest of bar.
+		c.ExtraPasses = []CoveragePass{pass}
+		profile, err = c.RunProfile(context.Background(), change, &Options{MaxDuration: 1})
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, 100., profile.CoveragePercent())
+	}
+}
+
+func TestCoverageRatchet(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n", "ratchet.json": "{\"coverage.global\": 60}\n"})
+	profile := CoverageProfile{{Source: "foo.go", Line: 1, SourceRef: "foo.go:1", Name: "Foo", Covered: 50, Total: 100, Percent: 50}}
+	c := &Coverage{UseGlobalInference: true, Global: CoverageSettings{MinCoverage: 40}}
+	ut.AssertEqual(t, nil, c.Enforce(change, profile, &Options{}))
+
+	c.Ratchet = true
+	c.RatchetFile = "ratchet.json"
+	ut.AssertEqual(t, "coverage for "+change.Repo().Root()+": 50.0% (50/100) < 60.0% (min); Functions: 0 untested / 1 partially / 0 completely", c.Enforce(change, profile, &Options{}).Error())
+	// The configured floor wins when higher.
+	c.Global.MinCoverage = 65
+	profile[0].Covered = 62
+	ut.AssertEqual(t, "coverage for "+change.Repo().Root()+": 62.0% (62/100) < 65.0% (min); Functions: 0 untested / 1 partially / 0 completely", c.Enforce(change, profile, &Options{}).Error())
+	c.Global.MinCoverage = 45
+	profile[0].Covered = 55
+
+	c.RatchetFile = "missing.json"
+	ut.AssertEqual(t, errors.New("coverage: ratchet_file missing.json not found"), c.Enforce(change, profile, &Options{}))
+
+	// Without ratchet_file, the last recorded value is used.
+	c.RatchetFile = ""
+	s := &FileStorage{Dir: td}
+	options := &Options{Storage: s}
+	ut.AssertEqual(t, nil, c.Enforce(change, profile, options))
+	ut.AssertEqual(t, nil, AppendMetricsHistory(s, &MetricsRecord{Commit: "abc", Metrics: map[string]float64{"coverage.global": 56}}))
+	ut.AssertEqual(t, true, c.Enforce(change, profile, options) != nil)
+	profile[0].Covered = 56
+	ut.AssertEqual(t, nil, c.Enforce(change, profile, options))
+
+	o := &Coverage{UseGlobalInference: true, RatchetFile: "ratchet.json"}
+	ut.AssertEqual(t, &Coverage{UseGlobalInference: true, Global: CoverageSettings{MinCoverage: 45}, Ratchet: true, RatchetFile: "ratchet.json"}, c.merge(o))
+	other := &Coverage{UseGlobalInference: true, RatchetFile: "other.json"}
+	ut.AssertEqual(t, nil, other.merge(o))
 }
 
 var coverageFiles = map[string]string{
@@ -280,6 +421,150 @@ func TestCoverageEmpty(t *testing.T) {
 	ut.AssertEqual(t, &CoverageSettings{}, c.SettingsForPkg("foo"))
 }
 
+func TestCoverageMode(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		c        Coverage
+		expected []string
+		err      string
+	}{
+		{Coverage{}, []string{"-covermode=count"}, ""},
+		{Coverage{CoverMode: "set"}, []string{"-covermode=set"}, ""},
+		{Coverage{Race: true}, []string{"-covermode=atomic", "-race"}, ""},
+		{Coverage{CoverMode: "atomic", Race: true}, []string{"-covermode=atomic", "-race"}, ""},
+		{Coverage{CoverMode: "count", Race: true}, nil, "coverage: cover_mode count can't be used with race, use atomic"},
+		{Coverage{CoverMode: "foo"}, nil, "coverage: invalid cover_mode \"foo\"; expected set, count or atomic"},
+	}
+	for i, line := range data {
+		_, err := line.c.coverMode()
+		if line.err != "" {
+			ut.AssertEqualIndex(t, i, errors.New(line.err), err)
+			continue
+		}
+		ut.AssertEqualIndex(t, i, nil, err)
+		expected := append([]string{"go", "test", "-v"}, line.expected...)
+		expected = append(expected, "-coverprofile", "p.cov", "-timeout", "2s", "pkg")
+		ut.AssertEqualIndex(t, i, expected, line.c.testArgs(&Options{MaxDuration: 2}, "p.cov", CoveragePass{}, "pkg"))
+	}
+	pass := CoveragePass{Tags: "integration", ExtraArgs: []string{"-run", "TestIntegration"}}
+	expected := []string{"go", "test", "-v", "-covermode=count", "-coverprofile", "p.cov", "-timeout", "2s", "-run", "TestIntegration", "-tags", "integration", "pkg"}
+	ut.AssertEqual(t, expected, (&Coverage{}).testArgs(&Options{MaxDuration: 2}, "p.cov", pass, "pkg"))
+
+	a := &Coverage{CoverMode: "atomic"}
+	ut.AssertEqual(t, &Coverage{CoverMode: "atomic", Race: true}, a.merge(&Coverage{CoverMode: "atomic", Race: true}))
+	ut.AssertEqual(t, nil, a.merge(&Coverage{}))
+}
+
+func TestCheckProfileFile(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.ExpectEqual(t, nil, internal.RemoveAll(td))
+	}()
+	f := filepath.Join(td, "test0.cov")
+	expected := errors.New("coverage for ./foo: tests passed but no coverage profile was written; does TestMain call os.Exit()? Return from TestMain instead (Go 1.15+) or call os.Exit(m.Run()) directly")
+	ut.AssertEqual(t, expected, checkProfileFile(f, "./foo"))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(f, nil, 0600))
+	ut.AssertEqual(t, expected, checkProfileFile(f, "./foo"))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(f, []byte("mode: count\n"), 0600))
+	ut.AssertEqual(t, nil, checkProfileFile(f, "./foo"))
+}
+
+func TestLoadProfileStrict(t *testing.T) {
+	t.Parallel()
+	change := &fakeLimitedChange{"example.com/foo", map[string]string{"foo.go": "package foo\n\nfunc Foo() {\n}\n"}}
+	profile := "mode: count\nexample.com/foo/foo.go:3.12,4.2 0 1\nexample.com/foo/gen.go:3.12,4.2 0 1\n"
+	p, err := loadProfile(change, strings.NewReader(profile), false)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 1, len(p))
+	ut.AssertEqual(t, "foo.go", p[0].Source)
+	_, err = loadProfile(change, strings.NewReader(profile), true)
+	expected := errors.New("coverage profile has entries for unknown files:\nexample.com/foo/gen.go (package \"example.com/foo\"; tried \"gen.go\", \"example.com/foo/gen.go\")")
+	ut.AssertEqual(t, expected, err)
+	_, err = loadProfile(change, strings.NewReader("mode: count\nexample.com/foo/foo.go:3.12,4.2 0 1\n"), true)
+	ut.AssertEqual(t, nil, err)
+}
+
+func TestLoadProfileNoCover(t *testing.T) {
+	t.Parallel()
+	change := &fakeLimitedChange{"example.com/foo", map[string]string{
+		"foo.go":    "package foo\n\nfunc Foo() {\n}\n\n//pcg:nocover\nfunc Bar() {\n}\n",
+		"foo.pb.go": "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage foo\n\nfunc Baz() {\n}\n",
+	}}
+	profile := "mode: count\nexample.com/foo/foo.go:3.12,4.2 0 1\nexample.com/foo/foo.go:7.12,8.2 0 0\nexample.com/foo/foo.pb.go:5.12,6.2 0 0\n"
+	p, err := loadProfile(change, strings.NewReader(profile), true)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 1, len(p))
+	ut.AssertEqual(t, "Foo", p[0].Name)
+}
+
+func TestMergeCoverageModes(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.ExpectEqual(t, nil, internal.RemoveAll(td))
+	}()
+	write := func(name, content string) string {
+		p := filepath.Join(td, name)
+		ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte(content), 0600))
+		return p
+	}
+	set1 := write("set1.cov", "mode: set\na.go:1.1,2.1 1 1\nb.go:1.1,2.1 1 0\n")
+	set2 := write("set2.cov", "mode: set\na.go:1.1,2.1 1 1\nb.go:1.1,2.1 1 1\n")
+	count := write("count.cov", "mode: count\na.go:1.1,2.1 1 3\n")
+	atomic := write("atomic.cov", "mode: atomic\na.go:1.1,2.1 1 2\nmode: atomic\nb.go:1.1,2.1 1 1\n")
+	bad := write("bad.cov", "mode: foo\n")
+	data := []struct {
+		files    []string
+		expected string
+	}{
+		{nil, "mode: count\n"},
+		{[]string{set1, set2}, "mode: set\na.go:1.1,2.1 1 1\nb.go:1.1,2.1 1 1\n"},
+		{[]string{atomic}, "mode: atomic\na.go:1.1,2.1 1 2\nb.go:1.1,2.1 1 1\n"},
+		{[]string{set1, count}, "mode: count\na.go:1.1,2.1 1 4\nb.go:1.1,2.1 1 0\n"},
+		{[]string{count, atomic}, "mode: count\na.go:1.1,2.1 1 5\nb.go:1.1,2.1 1 1\n"},
+	}
+	for i, line := range data {
+		raw := &rawCoverage{counts: map[string]int{}}
+		for _, f := range line.files {
+			ut.AssertEqualIndex(t, i, nil, loadRawCoverage(f, raw))
+		}
+		b := &bytes.Buffer{}
+		ut.AssertEqualIndex(t, i, nil, mergeCoverage(raw, b))
+		ut.AssertEqualIndex(t, i, line.expected, b.String())
+	}
+	expected := errors.New("malformed " + bad + ": mode: foo")
+	ut.AssertEqual(t, expected, loadRawCoverage(bad, &rawCoverage{counts: map[string]int{}}))
+}
+
+func TestMergeProfiles(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.ExpectEqual(t, nil, internal.RemoveAll(td))
+	}()
+	a := filepath.Join(td, "a.cov")
+	b := filepath.Join(td, "b.cov")
+	ut.AssertEqual(t, nil, ioutil.WriteFile(a, []byte("mode: count\nexample.com/foo/a.go:1.1,2.1 1 3\nexample.com/foo/b/b.go:1.1,2.1 1 0\n"), 0600))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(b, []byte("mode: atomic\n_/home/ci/src/example.com/foo/a.go:1.1,2.1 1 2\nexample.com\\foo\\b\\b.go:1.1,2.1 1 1\nother.com/c.go:1.1,2.1 1 1\n"), 0600))
+
+	buf := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, MergeProfiles(buf, "example.com/foo", a, b))
+	expected := "mode: count\nexample.com/foo/a.go:1.1,2.1 1 5\nexample.com/foo/b/b.go:1.1,2.1 1 1\nother.com/c.go:1.1,2.1 1 1\n"
+	ut.AssertEqual(t, expected, buf.String())
+
+	// Without package, only the path separators are normalized.
+	buf.Reset()
+	ut.AssertEqual(t, nil, MergeProfiles(buf, "", a, b))
+	expected = "mode: count\n_/home/ci/src/example.com/foo/a.go:1.1,2.1 1 2\nexample.com/foo/a.go:1.1,2.1 1 3\nexample.com/foo/b/b.go:1.1,2.1 1 1\nother.com/c.go:1.1,2.1 1 1\n"
+	ut.AssertEqual(t, expected, buf.String())
+
+	ut.AssertEqual(t, true, MergeProfiles(buf, "", filepath.Join(td, "missing.cov")) != nil)
+}
+
 func TestRangeToString(t *testing.T) {
 	t.Parallel()
 	ut.AssertEqual(t, "", rangeToString(nil))
@@ -289,3 +574,23 @@ func TestRangeToString(t *testing.T) {
 	ut.AssertEqual(t, "1,3-4,6-8", rangeToString([]int{1, 3, 4, 6, 7, 8}))
 	ut.AssertEqual(t, "1,3-4,6", rangeToString([]int{1, 3, 4, 6}))
 }
+
+type fakeLimitedChange struct {
+	pkg   string
+	files map[string]string
+}
+
+func (f *fakeLimitedChange) IsIgnored(p string) bool {
+	return false
+}
+
+func (f *fakeLimitedChange) Package() string {
+	return f.pkg
+}
+
+func (f *fakeLimitedChange) Content(p string) []byte {
+	if c, ok := f.files[p]; ok {
+		return []byte(c)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import "time"
+
+// EventKind is the kind of an Event.
+type EventKind string
+
+// Kinds of Event.
+const (
+	// EventCheckStarted is sent when a check starts, after its prerequisites
+	// are ready.
+	EventCheckStarted EventKind = "check_started"
+	// EventCheckFinished is sent when a check completes. Event.Result is set.
+	EventCheckFinished EventKind = "check_finished"
+	// EventFinding is sent for each Finding of a check returning Findings,
+	// before its EventCheckFinished. Event.Finding is set.
+	EventFinding EventKind = "finding"
+	// EventSubprocess is sent when a check spawns a process with
+	// Options.Capture, Options.CaptureEnv or Options.CaptureIO. Event.Command
+	// is set.
+	EventSubprocess EventKind = "subprocess"
+	// EventPrerequisiteInstalled is sent by Runner.InstallPrerequisites for
+	// each prerequisite installed. Event.URL is set and Event.Check is empty.
+	EventPrerequisiteInstalled EventKind = "prerequisite_installed"
+)
+
+// Event is a step of the orchestration of the checks by Runner, so a program
+// embedding pre-commit-go can render the progress live, e.g. in a GUI.
+type Event struct {
+	Kind EventKind
+	// Time is when the event happened.
+	Time time.Time
+	// Check is the name of the check the event is about.
+	Check string
+	// Result is the result of the check for EventCheckFinished.
+	Result *Result
+	// Finding is the issue found for EventFinding.
+	Finding *Finding
+	// Command is the command line of the process for EventSubprocess.
+	Command []string
+	// URL is the prerequisite for EventPrerequisiteInstalled.
+	URL string
+}
+
+// EventChannel returns a function to use as Runner.Events that sends the
+// events to ch. It blocks while ch is full, so ch must be drained
+// concurrently.
+func EventChannel(ch chan<- Event) func(e Event) {
+	return func(e Event) {
+		ch <- e
+	}
+}
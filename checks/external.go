@@ -0,0 +1,213 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// ExternalProtocolVersion is the version of the "json" protocol of custom
+// checks, sent in ExternalRequest.Version.
+const ExternalProtocolVersion = 1
+
+// ExternalRequest is written as JSON on the stdin of a custom check using the
+// "json" protocol.
+type ExternalRequest struct {
+	// Version is ExternalProtocolVersion.
+	Version int `json:"version"`
+	// Check is the display name of the custom check.
+	Check string `json:"check"`
+	// Root is the absolute path of the repository root; the command runs from
+	// it. All the paths are relative to it and use forward slashes.
+	Root string `json:"root"`
+	// Package is the package name of the root, if known.
+	Package string `json:"package,omitempty"`
+	// Changed, Indirect and All are the sets of the change; see scm.Change.
+	Changed  ExternalSet `json:"changed"`
+	Indirect ExternalSet `json:"indirect"`
+	All      ExternalSet `json:"all"`
+	// Files are the files in Changed with their status and modified lines.
+	Files []ExternalFile `json:"files"`
+	// Deleted are the files deleted by the change.
+	Deleted []string `json:"deleted,omitempty"`
+	// Config is Custom.Config, as is.
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// ExternalSet is a scm.Set in an ExternalRequest.
+type ExternalSet struct {
+	Files        []string `json:"files"`
+	Packages     []string `json:"packages"`
+	TestPackages []string `json:"test_packages"`
+}
+
+// ExternalFile is a modified file in an ExternalRequest.
+type ExternalFile struct {
+	Path   string         `json:"path"`
+	Status scm.FileStatus `json:"status"`
+	// Hunks are the lines added or modified; see scm.Change.Hunks.
+	Hunks []ExternalHunk `json:"hunks"`
+}
+
+// ExternalHunk is a scm.Hunk in an ExternalRequest.
+type ExternalHunk struct {
+	Start int `json:"start"`
+	Count int `json:"count"`
+}
+
+// ExternalResponse is printed as JSON on stdout by a custom check using the
+// "json" protocol. The check fails if it reports any finding that is not a
+// warning.
+type ExternalResponse struct {
+	Findings []ExternalFinding `json:"findings"`
+	// Metrics are published like with "pcg-metric:" lines.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// ExternalFinding is an issue reported by a custom check. File, Line and
+// Column are optional.
+type ExternalFinding struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+	// Severity is "error", the default, or "warning".
+	Severity string `json:"severity,omitempty"`
+}
+
+// Findings is returned by Check.Run to report issues at specific locations,
+// so reporters don't have to parse them back from the error message.
+type Findings []Finding
+
+func (f Findings) Error() string {
+	lines := make([]string, 0, len(f))
+	for _, i := range f {
+		switch {
+		case i.Pos.Filename == "":
+			lines = append(lines, i.Message)
+		case i.Pos.Column != 0:
+			lines = append(lines, i.String())
+		case i.Pos.Line != 0:
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", i.Pos.Filename, i.Pos.Line, i.Message))
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %s", i.Pos.Filename, i.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Private stuff.
+
+// newExternalRequest returns the request describing change for c.
+func newExternalRequest(c *Custom, change scm.Change) *ExternalRequest {
+	r := &ExternalRequest{
+		Version:  ExternalProtocolVersion,
+		Check:    c.DisplayName,
+		Root:     change.Repo().Root(),
+		Package:  change.Package(),
+		Changed:  newExternalSet(change.Changed()),
+		Indirect: newExternalSet(change.Indirect()),
+		All:      newExternalSet(change.All()),
+		Files:    []ExternalFile{},
+		Deleted:  change.Deleted(),
+	}
+	for _, f := range change.Changed().Files() {
+		e := ExternalFile{Path: f, Status: change.Status(f), Hunks: []ExternalHunk{}}
+		for _, h := range change.Hunks(f) {
+			e.Hunks = append(e.Hunks, ExternalHunk{Start: h.Start, Count: h.Count})
+		}
+		r.Files = append(r.Files, e)
+	}
+	if len(c.Config) != 0 {
+		r.Config = jsonValue(c.Config).(map[string]interface{})
+	}
+	return r
+}
+
+func newExternalSet(s scm.Set) ExternalSet {
+	return ExternalSet{Files: s.Files(), Packages: s.Packages(), TestPackages: s.TestPackages()}
+}
+
+// runJSON runs c with the "json" protocol.
+func (c *Custom) runJSON(ctx context.Context, change scm.Change, options *Options) error {
+	req, err := json.Marshal(newExternalRequest(c, change))
+	if err != nil {
+		return err
+	}
+	stdout, stderr, exitCode, _, err := options.CaptureIO(ctx, change.Repo(), req, c.Command...)
+	if err != nil {
+		return err
+	}
+	var resp ExternalResponse
+	if err = json.Unmarshal([]byte(stdout), &resp); err != nil {
+		if exitCode != 0 {
+			return fmt.Errorf("\"%s\" failed with code %d:\n%s%s", strings.Join(c.Command, " "), exitCode, stdout, stderr)
+		}
+		return fmt.Errorf("\"%s\" printed an invalid response: %s\n%s", strings.Join(c.Command, " "), err, stdout)
+	}
+	for name, v := range resp.Metrics {
+		options.PublishMetric(name, v)
+	}
+	var errs, warnings Findings
+	for _, f := range resp.Findings {
+		i := Finding{Pos: token.Position{Filename: f.File, Line: f.Line, Column: f.Column}, Message: f.Message}
+		switch f.Severity {
+		case "", "error":
+			errs = append(errs, i)
+		case "warning":
+			warnings = append(warnings, i)
+		default:
+			return fmt.Errorf("\"%s\" returned a finding with invalid severity %q", strings.Join(c.Command, " "), f.Severity)
+		}
+	}
+	if len(errs) != 0 {
+		// The warnings are reported along the errors so they are not lost.
+		for _, w := range warnings {
+			w.Message = "warning: " + w.Message
+			errs = append(errs, w)
+		}
+		return errs
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("\"%s\" failed with code %d without reporting a finding:\n%s", strings.Join(c.Command, " "), exitCode, stderr)
+	}
+	if len(warnings) != 0 {
+		return Warning(warnings.Error())
+	}
+	return nil
+}
+
+// jsonValue converts the maps decoded from YAML, which have interface{} keys,
+// to maps with string keys so v can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, i := range t {
+			out[fmt.Sprint(k)] = jsonValue(i)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, i := range t {
+			out[k] = jsonValue(i)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for k, i := range t {
+			out[k] = jsonValue(i)
+		}
+		return out
+	default:
+		return v
+	}
+}
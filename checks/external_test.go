@@ -0,0 +1,83 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestCustomJSON(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n", "README.md": "hi\n"})
+	options := &Options{metrics: &metricSet{values: map[string]float64{}}}
+	// The command saves the request and prints the response.
+	custom := func(resp string) *Custom {
+		return &Custom{
+			DisplayName: "sample",
+			Command:     []string{"sh", "-c", "cat > .git/req.json; echo '" + resp + "'"},
+			Protocol:    "json",
+			Config:      map[string]interface{}{"max": 3, "nested": map[interface{}]interface{}{"a": []interface{}{"b"}}},
+		}
+	}
+
+	c := custom(`{"findings": [], "metrics": {"custom.n": 2}}`)
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 2., options.Metrics()["custom.n"])
+	b, err := ioutil.ReadFile(filepath.Join(change.Repo().Root(), ".git", "req.json"))
+	ut.AssertEqual(t, nil, err)
+	var req ExternalRequest
+	ut.AssertEqual(t, nil, json.Unmarshal(b, &req))
+	ut.AssertEqual(t, ExternalProtocolVersion, req.Version)
+	ut.AssertEqual(t, "sample", req.Check)
+	ut.AssertEqual(t, []string{"README.md", "foo.go"}, req.Changed.Files)
+	ut.AssertEqual(t, []string{"."}, req.Changed.Packages)
+	expectedFiles := []ExternalFile{
+		{Path: "README.md", Status: scm.Added, Hunks: []ExternalHunk{{1, 1}}},
+		{Path: "foo.go", Status: scm.Added, Hunks: []ExternalHunk{{1, 1}}},
+	}
+	ut.AssertEqual(t, expectedFiles, req.Files)
+	ut.AssertEqual(t, map[string]interface{}{"max": 3., "nested": map[string]interface{}{"a": []interface{}{"b"}}}, req.Config)
+
+	c = custom(`{"findings": [{"file": "README.md", "line": 1, "message": "typo"}, {"message": "meh", "severity": "warning"}]}`)
+	expected := Findings{
+		{Pos: token.Position{Filename: "README.md", Line: 1}, Message: "typo"},
+		{Message: "warning: meh"},
+	}
+	ut.AssertEqual(t, expected, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, "README.md:1: typo\nwarning: meh", expected.Error())
+
+	c = custom(`{"findings": [{"file": "foo.go", "message": "meh", "severity": "warning"}]}`)
+	ut.AssertEqual(t, Warning("foo.go: meh"), c.Run(context.Background(), change, options))
+
+	c = custom(`not json`)
+	ut.AssertEqual(t, errors.New("\"sh -c cat > .git/req.json; echo 'not json'\" printed an invalid response: invalid character 'o' in literal null (expecting 'u')\nnot json\n"), c.Run(context.Background(), change, options))
+
+	c = &Custom{Command: []string{"sh", "-c", "echo '{}'; exit 1"}, Protocol: "json"}
+	ut.AssertEqual(t, errors.New("\"sh -c echo '{}'; exit 1\" failed with code 1 without reporting a finding:\n"), c.Run(context.Background(), change, options))
+
+	c = &Custom{Command: []string{"true"}, Protocol: "xml"}
+	ut.AssertEqual(t, errors.New("invalid protocol \"xml\"; expected \"json\" or empty"), c.Run(context.Background(), change, options))
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FlakyWindow is the number of most recent recorded runs of a check used to
+// determine whether it is flaky. A flaky check stabilizes once its result
+// didn't flip in this many runs.
+const FlakyWindow = 20
+
+// FlakyStat is the recent history of the results of a check, or of the tests
+// of a package when named "test:<package>".
+type FlakyStat struct {
+	Name string
+	// Runs is the number of runs in the window.
+	Runs int
+	// Failures is the number of failed runs in the window.
+	Failures int
+	// Flips is the number of times the result changed between two runs on the
+	// same content, i.e. with the same MetricsRecord.Fingerprint.
+	Flips int
+}
+
+// IsFlaky returns true if the result flipped without a related change in the
+// window.
+func (f *FlakyStat) IsFlaky() bool {
+	return f.Flips != 0
+}
+
+// String returns a human readable summary.
+func (f *FlakyStat) String() string {
+	return fmt.Sprintf("%s flipped %d times without related change in its last %d runs (%d failed)", f.Name, f.Flips, f.Runs, f.Failures)
+}
+
+// FlakyStats returns the statistics of each check and test package recorded
+// in the history, which is oldest first, sorted by name.
+func FlakyStats(history []MetricsRecord) []FlakyStat {
+	// Look at the most recent runs of each name, newest first.
+	runs := map[string][]*MetricsRecord{}
+	for i := len(history) - 1; i >= 0; i-- {
+		for name := range history[i].Results {
+			if len(runs[name]) < FlakyWindow {
+				runs[name] = append(runs[name], &history[i])
+			}
+		}
+	}
+	out := make([]FlakyStat, 0, len(runs))
+	for name, recs := range runs {
+		s := FlakyStat{Name: name, Runs: len(recs)}
+		last := map[string]bool{}
+		for i := len(recs) - 1; i >= 0; i-- {
+			ok := recs[i].Results[name]
+			if !ok {
+				s.Failures++
+			}
+			f := recs[i].Fingerprint
+			if f == "" {
+				continue
+			}
+			if prev, seen := last[f]; seen && prev != ok {
+				s.Flips++
+			}
+			last[f] = ok
+		}
+		out = append(out, s)
+	}
+	sort.Sort(flakyStatsByName(out))
+	return out
+}
+
+// Private stuff.
+
+type flakyStatsByName []FlakyStat
+
+func (f flakyStatsByName) Len() int           { return len(f) }
+func (f flakyStatsByName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f flakyStatsByName) Less(i, j int) bool { return f[i].Name < f[j].Name }
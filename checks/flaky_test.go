@@ -0,0 +1,40 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestFlakyStats(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, []FlakyStat{}, FlakyStats(nil))
+	history := []MetricsRecord{
+		{Fingerprint: "a", Results: map[string]bool{"test": true, "gofmt": true}},
+		{Fingerprint: "a", Results: map[string]bool{"test": false, "gofmt": true}},
+		// A different content can legitimately fail.
+		{Fingerprint: "b", Results: map[string]bool{"test": true, "gofmt": false}},
+		{Fingerprint: "a", Results: map[string]bool{"test": true}},
+		{Results: map[string]bool{"test": false}},
+	}
+	expected := []FlakyStat{
+		{Name: "gofmt", Runs: 3, Failures: 1},
+		{Name: "test", Runs: 5, Failures: 2, Flips: 2},
+	}
+	stats := FlakyStats(history)
+	ut.AssertEqual(t, expected, stats)
+	ut.AssertEqual(t, false, stats[0].IsFlaky())
+	ut.AssertEqual(t, true, stats[1].IsFlaky())
+	ut.AssertEqual(t, "test flipped 2 times without related change in its last 5 runs (2 failed)", stats[1].String())
+
+	// It stabilizes once the flips are out of the window.
+	for i := 0; i < FlakyWindow; i++ {
+		history = append(history, MetricsRecord{Fingerprint: "c", Results: map[string]bool{"test": true}})
+	}
+	stats = FlakyStats(history)
+	ut.AssertEqual(t, FlakyStat{Name: "test", Runs: FlakyWindow}, stats[1])
+}
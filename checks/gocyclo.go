@@ -0,0 +1,200 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+	"path/filepath"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Gocyclo enforces a maximum cyclomatic complexity on modified functions.
+//
+// The complexity is calculated the same way as github.com/fzipp/gocyclo: 1 +
+// one per if, for, case, && and ||.
+type Gocyclo struct {
+	PerDirDefault GocycloSettings             `yaml:"per_dir_default"`
+	PerDir        map[string]*GocycloSettings `yaml:"per_dir"`
+}
+
+// GocycloSettings specifies the complexity settings.
+type GocycloSettings struct {
+	// MaxComplexity is the maximum cyclomatic complexity allowed for a
+	// function. If 0, the value is not enforced.
+	MaxComplexity int `yaml:"max_complexity"`
+}
+
+// GetDescription implements Check.
+func (g *Gocyclo) GetDescription() string {
+	return "enforces a maximum cyclomatic complexity on modified functions"
+}
+
+// GetName implements Check.
+func (g *Gocyclo) GetName() string {
+	return "gocyclo"
+}
+
+// GetPrerequisites implements Check.
+func (g *Gocyclo) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (g *Gocyclo) Scope() Scope {
+	return ScopeGoFiles
+}
+
+// Weight implements Weighter.
+func (g *Gocyclo) Weight() Weight {
+	return WeightNative
+}
+
+// Run implements Check.
+func (g *Gocyclo) Run(ctx context.Context, change scm.Change, options *Options) error {
+	r := &gocycloRun{Gocyclo: g, change: change}
+	err := RunAST(change, g.GetName(), r)
+	if r.funcs != 0 {
+		options.PublishMetric("gocyclo.max", float64(r.max))
+	}
+	return err
+}
+
+// VisitFunc implements FuncVisitor.
+func (g *Gocyclo) VisitFunc(f *ParsedFile, fn *ast.FuncDecl) []Finding {
+	settings := g.SettingsForDir(path.Dir(filepath.ToSlash(f.Path)))
+	if settings.MaxComplexity == 0 {
+		return nil
+	}
+	if c := complexity(fn); c > settings.MaxComplexity {
+		return []Finding{{f.Fset.Position(fn.Pos()), fmt.Sprintf("%s has complexity %d > %d", funcName(fn), c, settings.MaxComplexity)}}
+	}
+	return nil
+}
+
+// SettingsForDir returns the settings for a particular directory.
+//
+// If the PerDir value is set to a null pointer, returns empty settings.
+// Otherwise returns PerDirDefault.
+func (g *Gocyclo) SettingsForDir(dir string) *GocycloSettings {
+	if settings, ok := g.PerDir[dir]; ok {
+		if settings == nil {
+			settings = &GocycloSettings{}
+		}
+		return settings
+	}
+	return &g.PerDirDefault
+}
+
+// Private stuff.
+
+// gocycloRun records the highest complexity of the functions visited during a
+// run and only reports the functions modified by the change.
+type gocycloRun struct {
+	*Gocyclo
+	change scm.Change
+	funcs  int
+	max    int
+}
+
+// VisitFunc implements FuncVisitor.
+func (g *gocycloRun) VisitFunc(f *ParsedFile, fn *ast.FuncDecl) []Finding {
+	g.funcs++
+	if c := complexity(fn); c > g.max {
+		g.max = c
+	}
+	start := f.Fset.Position(fn.Pos()).Line
+	end := f.Fset.Position(fn.End()).Line
+	if !funcModified(g.change.Hunks(f.Path), start, end) {
+		return nil
+	}
+	return g.Gocyclo.VisitFunc(f, fn)
+}
+
+// funcModified returns true if one of the hunks modifies the lines start to
+// end, inclusively.
+func funcModified(hunks []scm.Hunk, start, end int) bool {
+	for _, h := range hunks {
+		if h.Count == 0 {
+			// Lines deleted between Start-1 and Start.
+			if start < h.Start && h.Start <= end {
+				return true
+			}
+		} else if h.Start <= end && h.Start+h.Count-1 >= start {
+			return true
+		}
+	}
+	return false
+}
+
+// merge implements merger. The lowest maximum complexity wins.
+func (g *Gocyclo) merge(other Check) Check {
+	o, ok := other.(*Gocyclo)
+	if !ok {
+		return nil
+	}
+	out := &Gocyclo{PerDirDefault: strictestGocyclo(g.PerDirDefault, o.PerDirDefault)}
+	if g.PerDir != nil || o.PerDir != nil {
+		out.PerDir = map[string]*GocycloSettings{}
+	}
+	for _, m := range []map[string]*GocycloSettings{g.PerDir, o.PerDir} {
+		for dir := range m {
+			s := strictestGocyclo(*g.SettingsForDir(dir), *o.SettingsForDir(dir))
+			out.PerDir[dir] = &s
+		}
+	}
+	return out
+}
+
+// strictestGocyclo returns the strictest settings of both. 0 means no limit.
+func strictestGocyclo(a, b GocycloSettings) GocycloSettings {
+	if b.MaxComplexity > 0 && (a.MaxComplexity == 0 || b.MaxComplexity < a.MaxComplexity) {
+		return b
+	}
+	return a
+}
+
+// complexity returns the cyclomatic complexity of a function.
+func complexity(fn *ast.FuncDecl) int {
+	c := 1
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			c++
+		case *ast.CaseClause:
+			if n.List != nil {
+				c++
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				c++
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				c++
+			}
+		}
+		return true
+	})
+	return c
+}
+
+// funcName returns the function name, including the receiver type if any.
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv != nil && len(fn.Recv.List) != 0 {
+		t := fn.Recv.List[0].Type
+		if s, ok := t.(*ast.StarExpr); ok {
+			t = s.X
+		}
+		if i, ok := t.(*ast.Ident); ok {
+			return "(" + i.Name + ")." + fn.Name.Name
+		}
+	}
+	return fn.Name.Name
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestGocycloComplexity(t *testing.T) {
+	t.Parallel()
+	src := `package foo
+func simple() {}
+func (f *Foo) branches(a, b bool, c chan int) {
+	if a && b || !a {
+	}
+	for i := range []int{} {
+		_ = i
+	}
+	switch {
+	case a:
+	default:
+	}
+	select {
+	case <-c:
+	default:
+	}
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.go", src, 0)
+	ut.AssertEqual(t, nil, err)
+	fn0 := f.Decls[0].(*ast.FuncDecl)
+	fn1 := f.Decls[1].(*ast.FuncDecl)
+	ut.AssertEqual(t, 1, complexity(fn0))
+	ut.AssertEqual(t, "simple", funcName(fn0))
+	ut.AssertEqual(t, 7, complexity(fn1))
+	ut.AssertEqual(t, "(Foo).branches", funcName(fn1))
+}
+
+func TestGocycloSettingsForDir(t *testing.T) {
+	t.Parallel()
+	g := &Gocyclo{
+		PerDirDefault: GocycloSettings{MaxComplexity: 10},
+		PerDir: map[string]*GocycloSettings{
+			"foo": {MaxComplexity: 20},
+			"bar": nil,
+		},
+	}
+	ut.AssertEqual(t, 10, g.SettingsForDir(".").MaxComplexity)
+	ut.AssertEqual(t, 20, g.SettingsForDir("foo").MaxComplexity)
+	ut.AssertEqual(t, 0, g.SettingsForDir("bar").MaxComplexity)
+}
+
+func TestGocycloFuncModified(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		hunks    []scm.Hunk
+		expected bool
+	}{
+		{nil, false},
+		{[]scm.Hunk{{Start: 1, Count: 9}}, false},
+		{[]scm.Hunk{{Start: 1, Count: 10}}, true},
+		{[]scm.Hunk{{Start: 12, Count: 1}}, true},
+		{[]scm.Hunk{{Start: 21, Count: 3}}, false},
+		{[]scm.Hunk{{Start: 20, Count: 1}}, true},
+		{[]scm.Hunk{{Start: 1, Count: 2}, {Start: 15, Count: 0}}, true},
+		{[]scm.Hunk{{Start: 10, Count: 0}}, false},
+		{[]scm.Hunk{{Start: 21, Count: 0}}, false},
+		{[]scm.Hunk{{Start: 20, Count: 0}}, true},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, funcModified(line.hunks, 10, 20))
+	}
+}
+
+func TestGocycloModifiedFuncs(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	src := `package foo
+func A(a bool) {
+	if a {
+	}
+}
+func B(b bool) {
+	if b {
+	}
+}
+`
+	change := setup(t, td, map[string]string{"foo.go": src})
+	g := &Gocyclo{PerDirDefault: GocycloSettings{MaxComplexity: 1}}
+	options := &Options{metrics: &metricSet{values: map[string]float64{}}}
+	expected := errors.New("gocyclo failed:\nfoo.go:2:1: A has complexity 2 > 1\nfoo.go:6:1: B has complexity 2 > 1")
+	ut.AssertEqual(t, expected, g.Run(context.Background(), change, options))
+
+	root := change.Repo().Root()
+	git := func(args ...string) {
+		args = append([]string{"git", "-c", "user.name=pcg", "-c", "user.email=pcg@localhost"}, args...)
+		out, code, err := internal.Capture(context.Background(), root, nil, args...)
+		ut.AssertEqualf(t, 0, code, out)
+		ut.AssertEqual(t, nil, err)
+	}
+	git("commit", "-q", "-m", "first")
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(root, "foo.go"), []byte(src+"// B\n"), 0600))
+	git("add", "foo.go")
+	change, err = change.Repo().Between(scm.Current, scm.Head, nil)
+	ut.AssertEqual(t, nil, err)
+	// Appending a comment doesn't modify any function.
+	ut.AssertEqual(t, nil, g.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 2., options.Metrics()["gocyclo.max"])
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(root, "foo.go"), []byte(strings.Replace(src, "if b {", "if !b {", 1)), 0600))
+	git("add", "foo.go")
+	change, err = change.Repo().Between(scm.Current, scm.Head, nil)
+	ut.AssertEqual(t, nil, err)
+	expected = errors.New("gocyclo failed:\nfoo.go:6:1: B has complexity 2 > 1")
+	ut.AssertEqual(t, expected, g.Run(context.Background(), change, options))
+}
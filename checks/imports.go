@@ -0,0 +1,151 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// ForbiddenImports fails when a modified file imports a forbidden package.
+//
+// It can be used to forbid "log" in library code, internal packages crossing
+// boundaries or deprecated packages.
+type ForbiddenImports struct {
+	Rules []ImportRule `yaml:"rules"`
+}
+
+// ImportRule is a single forbidden import.
+//
+// Package and directory patterns may end with "/..." to match the whole
+// subtree, like the go tool does. A package pattern starting with "./" is
+// relative to the repository's package.
+type ImportRule struct {
+	// Import is the forbidden package, e.g. "log" or "./internal/...".
+	Import string `yaml:"import"`
+	// Dirs is the list of directories where the import is forbidden, relative
+	// to the repository root in POSIX format. The root path is ".". If empty,
+	// the import is forbidden everywhere.
+	Dirs []string `yaml:"dirs"`
+	// Except is the list of directories where the import is allowed, taking
+	// precedence over Dirs.
+	Except []string `yaml:"except"`
+	// SkipTests allows the import in _test.go files.
+	SkipTests bool `yaml:"skip_tests"`
+	// Reason is printed alongside the failure, e.g. "use the logging package".
+	Reason string `yaml:"reason"`
+}
+
+// GetDescription implements Check.
+func (f *ForbiddenImports) GetDescription() string {
+	return "enforces modified files do not import forbidden packages"
+}
+
+// GetName implements Check.
+func (f *ForbiddenImports) GetName() string {
+	return "forbiddenimports"
+}
+
+// GetPrerequisites implements Check.
+func (f *ForbiddenImports) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (f *ForbiddenImports) Scope() Scope {
+	return ScopeGoFiles
+}
+
+// Weight implements Weighter.
+func (f *ForbiddenImports) Weight() Weight {
+	return WeightNative
+}
+
+// Run implements Check.
+func (f *ForbiddenImports) Run(ctx context.Context, change scm.Change, options *Options) error {
+	if len(f.Rules) == 0 {
+		return nil
+	}
+	var results []string
+	for _, file := range change.Changed().GoFiles() {
+		if change.IsIgnored(file) {
+			continue
+		}
+		content := change.Content(file)
+		if content == nil {
+			continue
+		}
+		_, imports := scm.GetImports(content)
+		dir := path.Dir(filepath.ToSlash(file))
+		isTest := strings.HasSuffix(file, "_test.go")
+		for _, imp := range imports {
+			for _, r := range f.Rules {
+				if r.forbids(change.Package(), dir, imp, isTest) {
+					msg := fmt.Sprintf("%s: import %q is forbidden", file, imp)
+					if r.Reason != "" {
+						msg += "; " + r.Reason
+					}
+					results = append(results, msg)
+					break
+				}
+			}
+		}
+	}
+	if len(results) != 0 {
+		sort.Strings(results)
+		return fmt.Errorf("forbiddenimports failed:\n%s", strings.Join(results, "\n"))
+	}
+	return nil
+}
+
+// Private stuff.
+
+// forbids returns true if the rule forbids importing imp from the directory
+// dir in the repository of package pkg.
+func (r *ImportRule) forbids(pkg, dir, imp string, isTest bool) bool {
+	if isTest && r.SkipTests {
+		return false
+	}
+	pattern := r.Import
+	if strings.HasPrefix(pattern, "./") {
+		pattern = path.Join(pkg, pattern[2:])
+	}
+	if !matchPkgPattern(pattern, imp) {
+		return false
+	}
+	for _, e := range r.Except {
+		if matchPkgPattern(e, dir) {
+			return false
+		}
+	}
+	if len(r.Dirs) == 0 {
+		return true
+	}
+	for _, d := range r.Dirs {
+		if matchPkgPattern(d, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPkgPattern returns true if p is matched by pattern, where a trailing
+// "/..." matches p itself and all its subdirectories.
+func matchPkgPattern(pattern, p string) bool {
+	if pattern == "./..." || pattern == "..." {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/...") {
+		prefix := pattern[:len(pattern)-4]
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+	return p == pattern
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestImportRuleForbids(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		r        ImportRule
+		dir      string
+		imp      string
+		isTest   bool
+		expected bool
+	}{
+		{ImportRule{Import: "log"}, ".", "log", false, true},
+		{ImportRule{Import: "log"}, ".", "log/syslog", false, false},
+		{ImportRule{Import: "log/..."}, ".", "log/syslog", false, true},
+		{ImportRule{Import: "log", SkipTests: true}, ".", "log", true, false},
+		{ImportRule{Import: "log", Dirs: []string{"lib/..."}}, "cmd/foo", "log", false, false},
+		{ImportRule{Import: "log", Dirs: []string{"lib/..."}}, "lib/foo", "log", false, true},
+		{ImportRule{Import: "log", Dirs: []string{"./..."}, Except: []string{"cmd/..."}}, "cmd/foo", "log", false, false},
+		{ImportRule{Import: "./internal/..."}, "cmd", "example.com/foo/internal/bar", false, true},
+		{ImportRule{Import: "./internal/..."}, "cmd", "example.com/foo/internalbar", false, false},
+		{ImportRule{Import: "./internal", Except: []string{"lib/..."}}, "lib", "example.com/foo/internal", false, false},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, line.r.forbids("example.com/foo", line.dir, line.imp, line.isTest))
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/internal"
+)
+
+// InstallAttempts is the number of times the installation of a prerequisite
+// is tried when it fails because of a transient network error.
+const InstallAttempts = 3
+
+// DefaultInstallParallelism is the number of prerequisites installed
+// concurrently by InstallEach by default.
+const DefaultInstallParallelism = 4
+
+// InstallResult is the outcome of the installation of a prerequisite.
+type InstallResult struct {
+	URL string
+	// Attempts is the number of times "go get" was run.
+	Attempts int
+	// Duration is how long it took including the retries.
+	Duration time.Duration
+	// Err is the failure of the last attempt, nil if it succeeded.
+	Err error
+}
+
+// InstallPrerequisites installs the prerequisites at urls with "go get" from
+// the directory wd.
+func InstallPrerequisites(ctx context.Context, wd string, urls []string) error {
+	return InstallPrerequisitesIn(ctx, wd, "", urls)
+}
+
+// InstallPrerequisitesIn is like InstallPrerequisites but installs them in the
+// project-local tools directory dir instead of the GOPATH, see
+// Config.ToolsDir. The sources are fetched in dir and the binaries are put in
+// ToolsBin(dir), so the tool versions are isolated per project. The GOPATH is
+// used if dir is empty.
+func InstallPrerequisitesIn(ctx context.Context, wd, dir string, urls []string) error {
+	return installError(InstallEach(ctx, wd, dir, urls, 0))
+}
+
+// InstallEach installs each prerequisite at urls with its own "go get", like
+// InstallPrerequisitesIn, and returns the result of each in the order of
+// urls, so one failure doesn't prevent installing the others.
+//
+// Up to parallel prerequisites are installed concurrently, or
+// DefaultInstallParallelism if parallel is 0. The installation of a
+// prerequisite failing because of a transient network error is retried up to
+// InstallAttempts times with an exponential backoff.
+//
+// In GOPATH mode, they are installed one at a time since concurrent "go get"
+// in the same GOPATH step on each other.
+func InstallEach(ctx context.Context, wd, dir string, urls []string, parallel int) []InstallResult {
+	if !internal.IsModuleMode(wd) {
+		parallel = 1
+	}
+	env := toolsEnv(dir)
+	return installEach(ctx, urls, parallel, func(ctx context.Context, url string) (string, int, error) {
+		return internal.Capture(ctx, wd, env, "go", "get", url)
+	})
+}
+
+// Private stuff.
+
+// installBackoff is the delay before the first retry; it doubles after each
+// attempt.
+var installBackoff = 2 * time.Second
+
+// transientErrors are lower case substrings of the output of "go get" denoting
+// a network failure worth retrying.
+var transientErrors = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"i/o timeout",
+	"tls handshake timeout",
+	"temporary failure in name resolution",
+	"unexpected EOF",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// installEach installs urls with run, up to parallel at a time.
+func installEach(ctx context.Context, urls []string, parallel int, run func(ctx context.Context, url string) (string, int, error)) []InstallResult {
+	if parallel <= 0 {
+		parallel = DefaultInstallParallelism
+	}
+	results := make([]InstallResult, len(urls))
+	tokens := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(res *InstallResult, url string) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+			*res = installOne(ctx, url, run)
+		}(&results[i], url)
+	}
+	wg.Wait()
+	return results
+}
+
+// installOne installs url with run, retrying the transient failures.
+func installOne(ctx context.Context, url string, run func(ctx context.Context, url string) (string, int, error)) InstallResult {
+	res := InstallResult{URL: url}
+	start := time.Now()
+	backoff := installBackoff
+	for {
+		res.Attempts++
+		out, code, err := run(ctx, url)
+		out = strings.TrimSpace(out)
+		if err == nil && code != 0 {
+			err = errors.New(out)
+		}
+		res.Err = err
+		if err == nil || res.Attempts == InstallAttempts || !isTransient(out) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			res.Err = ctx.Err()
+		case <-time.After(backoff):
+			backoff *= 2
+			continue
+		}
+		break
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// isTransient returns true if the output of "go get" denotes a network
+// failure.
+func isTransient(out string) bool {
+	out = strings.ToLower(out)
+	for _, t := range transientErrors {
+		if strings.Contains(out, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// installError returns an error listing the failed installations, nil if all
+// succeeded.
+func installError(results []InstallResult) error {
+	var lines []string
+	for _, res := range results {
+		if res.Err != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", res.URL, res.Err))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("prerequisites installation failed:\n%s", strings.Join(lines, "\n"))
+}
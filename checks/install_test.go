@@ -0,0 +1,80 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestInstallEach(t *testing.T) {
+	// Not parallel since it modifies installBackoff.
+	old := installBackoff
+	installBackoff = 0
+	defer func() { installBackoff = old }()
+
+	var lock sync.Mutex
+	calls := map[string]int{}
+	running, maxRunning := 0, 0
+	run := func(ctx context.Context, url string) (string, int, error) {
+		lock.Lock()
+		calls[url]++
+		n := calls[url]
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+		switch url {
+		case "flaky":
+			if n == 1 {
+				return "dial tcp: i/o timeout\n", 1, nil
+			}
+		case "down":
+			return "502 Bad Gateway", 1, nil
+		case "missing":
+			return "cannot find package \"missing\"\n", 1, nil
+		}
+		return "", 0, nil
+	}
+	urls := []string{"ok", "flaky", "down", "missing"}
+	results := installEach(context.Background(), urls, 2, run)
+	ut.AssertEqual(t, 4, len(results))
+	for i, res := range results {
+		ut.AssertEqual(t, urls[i], res.URL)
+		results[i].Duration = 0
+	}
+	expected := []InstallResult{
+		{URL: "ok", Attempts: 1},
+		{URL: "flaky", Attempts: 2},
+		{URL: "down", Attempts: InstallAttempts, Err: errors.New("502 Bad Gateway")},
+		{URL: "missing", Attempts: 1, Err: errors.New("cannot find package \"missing\"")},
+	}
+	ut.AssertEqual(t, expected, results)
+	ut.AssertEqual(t, true, maxRunning <= 2)
+	ut.AssertEqual(t, "prerequisites installation failed:\ndown: 502 Bad Gateway\nmissing: cannot find package \"missing\"", installError(results).Error())
+	ut.AssertEqual(t, nil, installError(results[:2]))
+}
+
+func TestInstallEachCanceled(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	run := func(ctx context.Context, url string) (string, int, error) {
+		cancel()
+		return "connection reset by peer", 1, nil
+	}
+	results := installEach(ctx, []string{"a"}, 0, run)
+	ut.AssertEqual(t, 1, results[0].Attempts)
+	ut.AssertEqual(t, context.Canceled, results[0].Err)
+}
@@ -0,0 +1,206 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Lane is the priority of the runs of a Runner, see Lanes.
+type Lane int
+
+const (
+	// Interactive is the lane of the runs a user waits for, e.g. from a git
+	// hook.
+	Interactive Lane = iota
+	// Background is the lane of the speculative runs, e.g. 'pcg watch'. They
+	// are preempted by the interactive runs.
+	Background
+)
+
+// LanesFile is the prefix of the files in the scm directory that exist while
+// an interactive run is in progress, see NewLanes. Each process has its own,
+// LanesFile.<pid>, so a process completing its interactive runs doesn't
+// resume the background runs while another one is still in progress.
+const LanesFile = "pcg-interactive"
+
+// Lanes schedules the runs of the Runners sharing it in two lanes, so the
+// interactive runs don't queue behind the background ones: an interactive run
+// cancels the background runs in progress and the background runs wait for
+// the interactive runs to complete.
+//
+// The processes sharing the same repository are scheduled together through
+// LanesFile, so a git hook preempts a 'pcg watch' running in another terminal.
+type Lanes struct {
+	// marker is the path of LanesFile of this process, empty to only schedule
+	// the runs of this process.
+	marker string
+
+	lock        sync.Mutex
+	interactive int
+	// heartbeat is closed to stop refreshing marker.
+	heartbeat  chan struct{}
+	background map[*laneRun]bool
+}
+
+// NewLanes returns the Lanes shared by the processes running checks on the
+// repository r. If r is nil, only the runs of this process are scheduled.
+func NewLanes(r scm.ReadOnlyRepo) *Lanes {
+	l := &Lanes{background: map[*laneRun]bool{}}
+	if r != nil {
+		if d, err := r.ScmDir(); err == nil {
+			l.marker = filepath.Join(d, fmt.Sprintf("%s.%d", LanesFile, os.Getpid()))
+		}
+	}
+	return l
+}
+
+// Private stuff.
+
+const (
+	// laneInterval is how often the background runs look for an interactive
+	// run of another process.
+	laneInterval = 100 * time.Millisecond
+	// laneStale is the age after which a LanesFile is considered left over
+	// by a process that crashed. It is refreshed every laneStale/4.
+	laneStale = 10 * time.Second
+)
+
+// laneRun is a background run in progress.
+type laneRun struct {
+	cancel    context.CancelFunc
+	preempted bool
+}
+
+// enter waits for the run to be allowed in lane and returns the context to
+// run with and the function to call once done. The function returns true if
+// the run was preempted.
+func (l *Lanes) enter(ctx context.Context, lane Lane) (context.Context, func() bool) {
+	if lane == Background {
+		return l.enterBackground(ctx)
+	}
+	l.enterInteractive()
+	return ctx, l.leaveInteractive
+}
+
+func (l *Lanes) enterInteractive() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for run := range l.background {
+		l.preempt(run)
+	}
+	l.interactive++
+	if l.interactive != 1 || l.marker == "" {
+		return
+	}
+	if err := ioutil.WriteFile(l.marker, nil, 0600); err != nil {
+		log.Printf("failed to write %s: %s", l.marker, err)
+		return
+	}
+	l.heartbeat = make(chan struct{})
+	go func(marker string, stop <-chan struct{}) {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(laneStale / 4):
+				now := time.Now()
+				_ = os.Chtimes(marker, now, now)
+			}
+		}
+	}(l.marker, l.heartbeat)
+}
+
+func (l *Lanes) leaveInteractive() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.interactive--; l.interactive == 0 && l.heartbeat != nil {
+		close(l.heartbeat)
+		l.heartbeat = nil
+		if err := os.Remove(l.marker); err != nil {
+			log.Printf("failed to remove %s: %s", l.marker, err)
+		}
+	}
+	return false
+}
+
+func (l *Lanes) enterBackground(ctx context.Context) (context.Context, func() bool) {
+	for {
+		l.lock.Lock()
+		if l.interactive == 0 && !l.markerActive() {
+			break
+		}
+		l.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx, func() bool { return false }
+		case <-time.After(laneInterval):
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	run := &laneRun{cancel: cancel}
+	l.background[run] = true
+	l.lock.Unlock()
+	done := make(chan struct{})
+	if l.marker != "" {
+		// Another process may start an interactive run at any time.
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-time.After(laneInterval):
+				}
+				if l.markerActive() {
+					l.lock.Lock()
+					l.preempt(run)
+					l.lock.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	return ctx, func() bool {
+		close(done)
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		delete(l.background, run)
+		cancel()
+		return run.preempted
+	}
+}
+
+// preempt cancels the background run. l.lock must be held.
+func (l *Lanes) preempt(run *laneRun) {
+	if !run.preempted {
+		log.Printf("preempting a background run")
+		run.preempted = true
+		run.cancel()
+	}
+}
+
+// markerActive returns true if an interactive run is in progress in any
+// process, including this one.
+func (l *Lanes) markerActive() bool {
+	if l.marker == "" {
+		return false
+	}
+	markers, _ := filepath.Glob(filepath.Join(filepath.Dir(l.marker), LanesFile+".*"))
+	for _, m := range markers {
+		if fi, err := os.Stat(m); err == nil && time.Since(fi.ModTime()) < laneStale {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+// blockingCheck is a check that runs until its context is done.
+type blockingCheck struct {
+	started chan struct{}
+}
+
+func (b *blockingCheck) GetDescription() string                { return "blocking" }
+func (b *blockingCheck) GetName() string                       { return "blocking" }
+func (b *blockingCheck) GetPrerequisites() []CheckPrerequisite { return nil }
+func (b *blockingCheck) Run(ctx context.Context, change scm.Change, options *Options) error {
+	close(b.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestLanesPreempt(t *testing.T) {
+	t.Parallel()
+	l := NewLanes(nil)
+	check := &blockingCheck{started: make(chan struct{})}
+	r := &Runner{Checks: []Check{check}, Options: &Options{MaxDuration: 120}, Lanes: l, Lane: Background}
+	reports := make(chan *Report)
+	go func() {
+		reports <- r.Run(context.Background(), nil)
+	}()
+	<-check.started
+	ctx, leave := l.enter(context.Background(), Interactive)
+	report := <-reports
+	ut.AssertEqual(t, true, report.Preempted)
+	ut.AssertEqual(t, true, report.Results[0].Interrupted)
+	ut.AssertEqual(t, errors.New("interrupted"), report.Results[0].Err)
+	ut.AssertEqual(t, nil, ctx.Err())
+
+	// A background run waits for the interactive runs to complete.
+	check = &blockingCheck{started: make(chan struct{})}
+	r.Checks = []Check{check}
+	go func() {
+		reports <- r.Run(context.Background(), nil)
+	}()
+	select {
+	case <-check.started:
+		t.Fatal("the background run didn't wait")
+	case <-time.After(2 * laneInterval):
+	}
+	ut.AssertEqual(t, false, leave())
+	<-check.started
+	l.lock.Lock()
+	for run := range l.background {
+		l.preempt(run)
+	}
+	l.lock.Unlock()
+	ut.AssertEqual(t, true, (<-reports).Preempted)
+
+	// An interactive run is never preempted.
+	r.Lane = Interactive
+	r.Checks = []Check{&runnerCheck{name: "pass"}}
+	report = r.Run(context.Background(), nil)
+	ut.AssertEqual(t, false, report.Preempted)
+	ut.AssertEqual(t, nil, report.Results[0].Err)
+}
+
+func TestLanesMarker(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	// Two processes sharing the same repository.
+	hook := &Lanes{marker: filepath.Join(td, LanesFile+".1"), background: map[*laneRun]bool{}}
+	watch := &Lanes{marker: filepath.Join(td, LanesFile+".2"), background: map[*laneRun]bool{}}
+
+	ctx, leaveBackground := watch.enter(context.Background(), Background)
+	_, leaveInteractive := hook.enter(context.Background(), Interactive)
+	_, err = os.Stat(hook.marker)
+	ut.AssertEqual(t, nil, err)
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the background run wasn't preempted")
+	}
+	ut.AssertEqual(t, true, leaveBackground())
+
+	// A concurrent hook completing doesn't resume the background runs while
+	// the other one is in progress.
+	other := &Lanes{marker: filepath.Join(td, LanesFile+".3"), background: map[*laneRun]bool{}}
+	_, leaveOther := other.enter(context.Background(), Interactive)
+	ut.AssertEqual(t, false, leaveInteractive())
+	_, err = os.Stat(hook.marker)
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+	ut.AssertEqual(t, true, watch.markerActive())
+	ut.AssertEqual(t, false, leaveOther())
+	ut.AssertEqual(t, false, watch.markerActive())
+
+	// A marker left over by a crashed process is ignored.
+	ut.AssertEqual(t, nil, ioutil.WriteFile(hook.marker, nil, 0600))
+	old := time.Now().Add(-2 * laneStale)
+	ut.AssertEqual(t, nil, os.Chtimes(hook.marker, old, old))
+	ctx, leaveBackground = watch.enter(context.Background(), Background)
+	ut.AssertEqual(t, nil, ctx.Err())
+	ut.AssertEqual(t, false, leaveBackground())
+}
@@ -0,0 +1,260 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// MetricsFile is the file in the artifacts directory containing the metrics
+// published by the checks as a JSON object.
+const MetricsFile = "metrics.json"
+
+// MetricsHistoryFile is the key in the root bucket of the Storage, e.g. the
+// file in .git, where the metrics of each run are appended, one MetricsRecord
+// per line, to track trends.
+const MetricsHistoryFile = "pcg-metrics.jsonl"
+
+// MetricsRecord is the metrics published during a run.
+type MetricsRecord struct {
+	// Commit is the commit that was checked.
+	Commit scm.Commit `json:"commit"`
+	Time   time.Time  `json:"time"`
+	Modes  []Mode     `json:"modes"`
+	// Metrics are the published metrics by name.
+	Metrics map[string]float64 `json:"metrics"`
+	// Results is whether each check, and the tests of each package as
+	// "test:<package>", passed. See Options.RecordResult.
+	Results map[string]bool `json:"results,omitempty"`
+	// Fingerprint identifies the content that was checked, so two runs with the
+	// same fingerprint are expected to have the same results. See FlakyStats.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Durations is how long each check took in seconds. See DurationTrends.
+	Durations map[string]float64 `json:"durations,omitempty"`
+}
+
+// InModes returns true if the run was in any of modes, or if modes is empty.
+func (m *MetricsRecord) InModes(modes []Mode) bool {
+	if len(modes) == 0 {
+		return true
+	}
+	for _, r := range m.Modes {
+		for _, mode := range modes {
+			if r == mode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AppendMetricsHistory appends the record to the MetricsHistoryFile in the
+// storage of the repository.
+func AppendMetricsHistory(s Storage, rec *MetricsRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.Append("", MetricsHistoryFile, append(b, '\n'))
+}
+
+// Threshold is a bound on a metric published by the checks.
+//
+// A threshold on a metric that wasn't published during the run is ignored,
+// e.g. because the check publishing it is not enabled in this mode.
+type Threshold struct {
+	// Metric is the name of the metric, e.g. "coverage.global". A trailing "*"
+	// matches all the metrics with this prefix, e.g. "binary_size.*".
+	Metric string `yaml:"metric"`
+	// Min, if set, is the minimum value.
+	Min *float64 `yaml:"min,omitempty"`
+	// Max, if set, is the maximum value.
+	Max *float64 `yaml:"max,omitempty"`
+	// MaxDeltaPct, if set, is the maximum change in percent compared to the
+	// baseline value, e.g. 5 to allow a 5% growth. It is ignored when there is
+	// no baseline value.
+	MaxDeltaPct *float64 `yaml:"max_delta_pct,omitempty"`
+	// MinDeltaPct, if set, is the minimum change in percent compared to the
+	// baseline value, e.g. -1 to allow a 1% decrease.
+	MinDeltaPct *float64 `yaml:"min_delta_pct,omitempty"`
+}
+
+// Pushgateway is a Prometheus Pushgateway receiving the duration and result of
+// each check and the coverage after each run on continuous integration, to
+// graph them over time.
+type Pushgateway struct {
+	// URL is the base url of the Pushgateway, e.g. http://pushgateway:9091.
+	URL string `yaml:"url"`
+	// Job is the job label of the metrics. Defaults to "pcg".
+	Job string `yaml:"job,omitempty"`
+	// Labels are additional labels grouping the metrics, e.g. the repository
+	// or the branch. Environment variables like $CI_COMMIT_BRANCH are expanded
+	// in the values.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// EvaluateThresholds returns the violations of the thresholds by the metrics
+// of a run, sorted.
+//
+// baseline is the metrics of a previous run to compare against for
+// MaxDeltaPct. It can be nil.
+func (c *Config) EvaluateThresholds(metrics, baseline map[string]float64) []string {
+	var out []string
+	for _, t := range c.Thresholds {
+		for _, name := range SortedMetricNames(metrics) {
+			if !t.matches(name) {
+				continue
+			}
+			v := metrics[name]
+			if t.Min != nil && v < *t.Min {
+				out = append(out, fmt.Sprintf("%s is %g; minimum is %g", name, v, *t.Min))
+			}
+			if t.Max != nil && v > *t.Max {
+				out = append(out, fmt.Sprintf("%s is %g; maximum is %g", name, v, *t.Max))
+			}
+			b, ok := baseline[name]
+			if !ok || b == 0 {
+				continue
+			}
+			delta := 100 * (v - b) / math.Abs(b)
+			if t.MaxDeltaPct != nil && delta > *t.MaxDeltaPct {
+				out = append(out, fmt.Sprintf("%s changed by %+.1f%% from %g to %g; maximum is %+g%%", name, delta, b, v, *t.MaxDeltaPct))
+			}
+			if t.MinDeltaPct != nil && delta < *t.MinDeltaPct {
+				out = append(out, fmt.Sprintf("%s changed by %+.1f%% from %g to %g; minimum is %+g%%", name, delta, b, v, *t.MinDeltaPct))
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NeedsBaseline returns true if a threshold compares against a previous run.
+func (c *Config) NeedsBaseline() bool {
+	for _, t := range c.Thresholds {
+		if t.MaxDeltaPct != nil || t.MinDeltaPct != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadMetricsHistory returns the records in the MetricsHistoryFile in the
+// storage of the repository, oldest first. Returns no record if there is none.
+// Corrupted lines are skipped.
+func LoadMetricsHistory(s Storage) ([]MetricsRecord, error) {
+	content, found, err := s.Get("", MetricsHistoryFile)
+	if err != nil || !found {
+		return nil, err
+	}
+	var out []MetricsRecord
+	for _, line := range strings.Split(string(content), "\n") {
+		var rec MetricsRecord
+		if line != "" && json.Unmarshal([]byte(line), &rec) == nil {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
+
+// BaselineMetrics returns the most recent metrics recorded for the commit
+// preferred, or else for the most recent commit other than current with
+// metrics. Returns nil if there is none.
+func BaselineMetrics(history []MetricsRecord, preferred, current scm.Commit) map[string]float64 {
+	var fallback map[string]float64
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Commit == preferred {
+			return history[i].Metrics
+		}
+		if fallback == nil && history[i].Commit != current && len(history[i].Metrics) != 0 {
+			fallback = history[i].Metrics
+		}
+	}
+	return fallback
+}
+
+// SortedMetricNames returns the names of the metrics sorted.
+func SortedMetricNames(metrics map[string]float64) []string {
+	out := make([]string, 0, len(metrics))
+	for name := range metrics {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Counters reported by the checks with Options.Count.
+const (
+	// CounterPackages is the number of packages a check processed.
+	CounterPackages = "packages"
+	// CounterCacheHits is the number of runs a check skipped because their
+	// result was cached.
+	CounterCacheHits = "cache_hits"
+)
+
+// Private stuff.
+
+// matches returns true if the threshold applies to the metric name.
+func (t *Threshold) matches(name string) bool {
+	if strings.HasSuffix(t.Metric, "*") {
+		return strings.HasPrefix(name, t.Metric[:len(t.Metric)-1])
+	}
+	return name == t.Metric
+}
+
+// validate returns a warning if the threshold is invalid.
+func (t *Threshold) validate() string {
+	switch {
+	case t.Metric == "":
+		return "threshold has no metric"
+	case t.Min == nil && t.Max == nil && t.MaxDeltaPct == nil && t.MinDeltaPct == nil:
+		return fmt.Sprintf("threshold on %s has no bound", t.Metric)
+	case t.Min != nil && t.Max != nil && *t.Min > *t.Max:
+		return fmt.Sprintf("threshold on %s has min %g greater than max %g", t.Metric, *t.Min, *t.Max)
+	case t.MinDeltaPct != nil && t.MaxDeltaPct != nil && *t.MinDeltaPct > *t.MaxDeltaPct:
+		return fmt.Sprintf("threshold on %s has min_delta_pct %g greater than max_delta_pct %g", t.Metric, *t.MinDeltaPct, *t.MaxDeltaPct)
+	}
+	return ""
+}
+
+// validate returns a warning if the Pushgateway settings are invalid.
+func (p *Pushgateway) validate() string {
+	if !strings.HasPrefix(p.URL, "http://") && !strings.HasPrefix(p.URL, "https://") {
+		return fmt.Sprintf("pushgateway has invalid url %q; expected http:// or https://", p.URL)
+	}
+	for k := range p.Labels {
+		if k == "job" || !reLabelName.MatchString(k) {
+			return fmt.Sprintf("pushgateway has invalid label %q", k)
+		}
+	}
+	return ""
+}
+
+// reLabelName matches a valid Prometheus label name.
+var reLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricSet is the metrics published by the checks during a run.
+type metricSet struct {
+	sync.Mutex
+	values  map[string]float64
+	results map[string]bool
+	// logs is the path of the log files saved by SaveLog.
+	logs map[string]string
+}
+
+// counterSet is the counters of a check during a run.
+type counterSet struct {
+	sync.Mutex
+	values map[string]int
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+	"gopkg.in/yaml.v2"
+)
+
+func TestOptionsMetrics(t *testing.T) {
+	t.Parallel()
+	o := &Options{}
+	o.PublishMetric("foo", 1)
+	ut.AssertEqual(t, map[string]float64{}, o.Metrics())
+
+	_, o = New("0.1").EnabledChecks([]Mode{PreCommit})
+	o.PublishMetric("foo", 1)
+	o.PublishMetric("bar", 2)
+	o.PublishMetric("foo", 3)
+	ut.AssertEqual(t, map[string]float64{"bar": 2, "foo": 3}, o.Metrics())
+	ut.AssertEqual(t, []string{"bar", "foo"}, SortedMetricNames(o.Metrics()))
+
+	o.RecordResult("test:./a", true)
+	o.RecordResult("test:./a", false)
+	o.RecordResult("test:./a", true)
+	o.RecordResult("gofmt", true)
+	ut.AssertEqual(t, map[string]bool{"gofmt": true, "test:./a": false}, o.Results())
+	ut.AssertEqual(t, map[string]bool{}, (&Options{}).Results())
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	files := map[string]string{
+		"foo.go":          "package foo\n\nfunc Foo(a bool) int {\n\tif a {\n\t\treturn 1\n\t}\n\treturn 0\n}\n",
+		"cmd/bar/main.go": "package main\n\nfunc main() {\n}\n",
+		"foo_test.go":     "package foo\n\nimport \"testing\"\n\nfunc TestFoo(t *testing.T) {\n\tFoo(true)\n}\n\nfunc TestBar(t *testing.T) {\n}\n",
+	}
+	change := setup(t, td, files)
+	options := &Options{MaxDuration: 60, metrics: &metricSet{values: map[string]float64{}}}
+	ut.AssertEqual(t, nil, (&Gocyclo{}).Run(context.Background(), change, options))
+	ut.AssertEqual(t, nil, (&Test{}).Run(context.Background(), change, options))
+	ut.AssertEqual(t, nil, (&Build{BinarySize: true}).Run(context.Background(), change, options))
+	if runtime.GOOS != "windows" {
+		c := &Custom{Command: []string{"sh", "-c", "echo pcg-metric: custom.size=42; echo pcg-metric: bad=x"}}
+		ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	}
+	metrics := options.Metrics()
+	ut.AssertEqual(t, 2., metrics["gocyclo.max"])
+	ut.AssertEqual(t, 2., metrics["test.count"])
+	ut.AssertEqual(t, true, metrics["test.duration.."] > 0)
+	ut.AssertEqual(t, true, metrics["binary_size.cmd/bar"] > 0)
+	_, ok := metrics["binary_size.."]
+	ut.AssertEqual(t, false, ok)
+	if runtime.GOOS != "windows" {
+		ut.AssertEqual(t, 42., metrics["custom.size"])
+		_, ok = metrics["bad"]
+		ut.AssertEqual(t, false, ok)
+	}
+
+	rec := &MetricsRecord{Commit: "abc", Time: time.Unix(1, 0).UTC(), Modes: []Mode{PrePush}, Metrics: map[string]float64{"gocyclo.max": 2}}
+	d, err := change.Repo().ScmDir()
+	ut.AssertEqual(t, nil, err)
+	s := &FileStorage{Dir: d}
+	ut.AssertEqual(t, nil, AppendMetricsHistory(s, rec))
+	ut.AssertEqual(t, nil, AppendMetricsHistory(s, rec))
+	content, err := ioutil.ReadFile(filepath.Join(d, MetricsHistoryFile))
+	ut.AssertEqual(t, nil, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	ut.AssertEqual(t, 2, len(lines))
+	var got MetricsRecord
+	ut.AssertEqual(t, nil, json.Unmarshal([]byte(lines[1]), &got))
+	ut.AssertEqual(t, *rec, got)
+	history, err := LoadMetricsHistory(s)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []MetricsRecord{*rec, *rec}, history)
+}
+
+func TestThresholds(t *testing.T) {
+	t.Parallel()
+	content := `thresholds:
+- metric: coverage.global
+  min: 70
+  min_delta_pct: -1
+- metric: binary_size.*
+  max_delta_pct: 5
+- metric: lint.issues
+  max: 0
+`
+	config := &Config{}
+	ut.AssertEqual(t, nil, yaml.Unmarshal([]byte(content), config))
+	ut.AssertEqual(t, true, config.NeedsBaseline())
+	metrics := map[string]float64{
+		"binary_size.cmd/a": 106,
+		"binary_size.cmd/b": 104,
+		"coverage.global":   65,
+		"lint.issues":       0,
+	}
+	baseline := map[string]float64{
+		"binary_size.cmd/a": 100,
+		"binary_size.cmd/b": 100,
+		"coverage.global":   80,
+	}
+	expected := []string{
+		"binary_size.cmd/a changed by +6.0% from 100 to 106; maximum is +5%",
+		"coverage.global changed by -18.8% from 80 to 65; minimum is -1%",
+		"coverage.global is 65; minimum is 70",
+	}
+	ut.AssertEqual(t, expected, config.EvaluateThresholds(metrics, baseline))
+	ut.AssertEqual(t, []string{"coverage.global is 65; minimum is 70"}, config.EvaluateThresholds(metrics, nil))
+	// Metrics not published are ignored.
+	ut.AssertEqual(t, []string(nil), config.EvaluateThresholds(map[string]float64{}, baseline))
+	ut.AssertEqual(t, false, (&Config{}).NeedsBaseline())
+
+	one, two := 1., 2.
+	config = &Config{Thresholds: []Threshold{{Min: &one}, {Metric: "foo"}, {Metric: "bar", Min: &two, Max: &one}}}
+	expected = []string{
+		"threshold has no metric",
+		"threshold on bar has min 2 greater than max 1",
+		"threshold on foo has no bound",
+	}
+	for _, w := range config.Warnings() {
+		if strings.HasPrefix(w, "threshold") {
+			ut.AssertEqual(t, expected[0], w)
+			expected = expected[1:]
+		}
+	}
+	ut.AssertEqual(t, 0, len(expected))
+}
+
+func TestBaselineMetrics(t *testing.T) {
+	t.Parallel()
+	history := []MetricsRecord{
+		{Commit: "upstream", Metrics: map[string]float64{"a": 1}},
+		{Commit: "other", Metrics: map[string]float64{"a": 2}},
+		{Commit: "head", Metrics: map[string]float64{"a": 3}},
+	}
+	ut.AssertEqual(t, map[string]float64{"a": 1}, BaselineMetrics(history, "upstream", "head"))
+	ut.AssertEqual(t, map[string]float64{"a": 2}, BaselineMetrics(history, "unknown", "head"))
+	ut.AssertEqual(t, map[string]float64(nil), BaselineMetrics(history[2:], "unknown", "head"))
+	// Records of runs without metrics are skipped.
+	history = append(history, MetricsRecord{Commit: "results", Results: map[string]bool{"test": true}})
+	ut.AssertEqual(t, map[string]float64{"a": 2}, BaselineMetrics(history, "unknown", "head"))
+}
+
+func TestPushgatewayValidate(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, "", (&Pushgateway{URL: "http://pushgateway:9091", Labels: map[string]string{"repo": "foo"}}).validate())
+	ut.AssertEqual(t, "pushgateway has invalid url \"pushgateway:9091\"; expected http:// or https://", (&Pushgateway{URL: "pushgateway:9091"}).validate())
+	ut.AssertEqual(t, "pushgateway has invalid label \"job\"", (&Pushgateway{URL: "https://p", Labels: map[string]string{"job": "foo"}}).validate())
+	ut.AssertEqual(t, "pushgateway has invalid label \"a-b\"", (&Pushgateway{URL: "https://p", Labels: map[string]string{"a-b": "foo"}}).validate())
+}
@@ -0,0 +1,454 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Runner runs checks concurrently on a change and returns their results. It
+// is what pcg uses to run the checks, so a program can embed pre-commit-go
+// instead of running pcg.
+//
+// The fields can be modified between NewRunner and Run, e.g. to remove checks
+// or to set Options.ArtifactsDir.
+type Runner struct {
+	// Checks are the checks to run.
+	Checks []Check
+	// Options are the options of the checks.
+	Options *Options
+	// PrereqReady, if set, is waited for before running a check with
+	// prerequisites, e.g. while they are being installed.
+	PrereqReady *sync.WaitGroup
+	// Observer, if set, is notified of the progress of the checks.
+	Observer Observer
+	// Events, if set, is called with each Event of the run, including the
+	// processes spawned by the checks and the prerequisites installed. It is
+	// called concurrently from the goroutines running the checks and must not
+	// block for long; see EventChannel.
+	Events func(e Event)
+	// Experimental are the names of the checks whose failures don't fail the
+	// run, see Config.ExperimentalChecks.
+	Experimental map[string]bool
+	// FailFast cancels the checks still running or not started yet as soon
+	// as a check that is not experimental fails.
+	FailFast bool
+	// Lanes, if set, schedules Run with the runs of the other Runners and
+	// processes sharing it, according to Lane.
+	Lanes *Lanes
+	// Lane is the lane of Run in Lanes. A Background run waits for the
+	// Interactive runs to complete and is interrupted when one starts.
+	Lane Lane
+}
+
+// Observer is notified by Runner of the progress of the checks as they run,
+// e.g. to display a progress bar or live logs.
+//
+// The methods are called concurrently from the goroutines running the checks
+// and must not block for long.
+type Observer interface {
+	// OnCheckStart is called when the check name starts, after its
+	// prerequisites are ready.
+	OnCheckStart(name string)
+	// OnOutput is called with each line of output of the processes run by the
+	// check name with Options.Capture or Options.CaptureEnv, as it is
+	// produced. The lines of concurrent processes are interleaved.
+	OnOutput(name, line string)
+	// OnCheckDone is called as soon as a check completes.
+	OnCheckDone(r *Result)
+}
+
+// Result is the result of a check run by Runner.
+type Result struct {
+	// Name is the name of the check.
+	Name string
+	// Duration is how long the check took.
+	Duration time.Duration
+	// Err is the failure of the check, nil if it passed.
+	Err error
+	// TimedOut is true if the check was killed after Options.Timeout.
+	TimedOut bool
+	// Interrupted is true if the check was stopped because the context passed
+	// to Run was done.
+	Interrupted bool
+	// Canceled is true if the check was stopped or not run because another
+	// check failed first with Runner.FailFast.
+	Canceled bool
+	// Panicked is true if the check panicked. The panic is reported in Err
+	// and the other checks are not affected.
+	Panicked bool
+	// Experimental is true if the check is in Runner.Experimental. Its
+	// failure is reported but doesn't fail the run.
+	Experimental bool
+	// Warnings are the non fatal issues, e.g. a Warning returned by the check or
+	// the check taking more than Options.MaxDuration.
+	Warnings []error
+	// Counters are the counters reported by the check with Options.Count, e.g.
+	// CounterPackages. It is nil if the check reported none.
+	Counters map[string]int
+}
+
+// Report is the result of Runner.Run.
+type Report struct {
+	// Results are the results of each check, in the order of Runner.Checks.
+	Results []Result
+	// Metrics are the metrics published by the checks, see
+	// Options.PublishMetric.
+	Metrics map[string]float64
+	// Logs are the logs saved by the checks, see Options.SaveLog.
+	Logs map[string]string
+	// Duration is how long it took to run all the checks.
+	Duration time.Duration
+	// Preempted is true if the run was in the Background lane and was
+	// interrupted by an Interactive run. The checks interrupted are reported
+	// as such.
+	Preempted bool
+}
+
+// Failed returns the results of the checks that failed, excluding the
+// experimental and the canceled ones.
+func (r *Report) Failed() []Result {
+	var out []Result
+	for _, res := range r.Results {
+		if res.Err != nil && !res.Experimental && !res.Canceled {
+			out = append(out, res)
+		}
+	}
+	return out
+}
+
+// NewRunner returns a Runner for the checks enabled in modes.
+func NewRunner(config *Config, modes []Mode) *Runner {
+	c, options := config.EnabledChecks(modes)
+	r := &Runner{Checks: c, Options: options, FailFast: options.FailFast}
+	for _, name := range config.ExperimentalChecks {
+		if r.Experimental == nil {
+			r.Experimental = map[string]bool{}
+		}
+		r.Experimental[name] = true
+	}
+	return r
+}
+
+// Run runs the checks on change concurrently and waits for all of them.
+//
+// The checks of WeightNative are run first, then the other ones are started
+// by increasing weight, see Weighter. Each check is killed once
+// Options.Timeout is reached. When ctx is done, the checks still running are
+// interrupted and fail. With FailFast, the first check failing cancels the
+// other ones. With Lanes, the run may first wait for its turn; see Lane.
+func (r *Runner) Run(ctx context.Context, change scm.Change) *Report {
+	report := &Report{Results: make([]Result, len(r.Checks))}
+	start := time.Now()
+	var leave func() bool
+	if r.Lanes != nil {
+		ctx, leave = r.Lanes.enter(ctx, r.Lane)
+	}
+	s := &schedule{procLock: &sync.RWMutex{}}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	defer s.cancel()
+	var byWeight [WeightExclusive + 1][]int
+	for i, c := range r.Checks {
+		w := CheckWeight(c)
+		if w < WeightNative || w > WeightExclusive {
+			w = WeightProcess
+		}
+		byWeight[w] = append(byWeight[w], i)
+	}
+	var wg sync.WaitGroup
+	for w, indexes := range byWeight {
+		for _, i := range indexes {
+			wg.Add(1)
+			go func(res *Result, check Check) {
+				defer wg.Done()
+				r.runCheck(ctx, s, change, check, res)
+				if r.FailFast && res.Err != nil && !res.Experimental && !res.Interrupted && !res.Canceled {
+					s.fail(res.Name)
+				}
+				if f, ok := res.Err.(Findings); ok {
+					for i := range f {
+						r.emit(Event{Kind: EventFinding, Check: res.Name, Finding: &f[i]})
+					}
+				}
+				r.emit(Event{Kind: EventCheckFinished, Check: res.Name, Result: res})
+				if r.Observer != nil {
+					r.Observer.OnCheckDone(res)
+				}
+			}(&report.Results[i], r.Checks[i])
+		}
+		if Weight(w) == WeightNative {
+			// The native checks are fast; let them fail before starting any
+			// process.
+			wg.Wait()
+		}
+	}
+	wg.Wait()
+	if leave != nil {
+		report.Preempted = leave()
+	}
+	report.Duration = time.Since(start)
+	report.Metrics = r.Options.Metrics()
+	report.Logs = r.Options.Logs()
+	return report
+}
+
+// MissingPrerequisites returns the sorted URLs of the prerequisites of the
+// checks that are not installed.
+func (r *Runner) MissingPrerequisites() []string {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	m := map[string]bool{}
+	number := 0
+	for _, check := range r.Checks {
+		for _, p := range check.GetPrerequisites() {
+			number++
+			wg.Add(1)
+			go func(prereq CheckPrerequisite) {
+				defer wg.Done()
+				if !prereq.IsPresent() {
+					lock.Lock()
+					m[prereq.URL] = true
+					lock.Unlock()
+				}
+			}(p)
+		}
+	}
+	wg.Wait()
+	log.Printf("Checked for %d prerequisites", number)
+	urls := make([]string, 0, len(m))
+	for url := range m {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// InstallPrerequisites installs the missing prerequisites of the checks in the
+// tools directory dir, or in the GOPATH if dir is empty, with "go get" from
+// the directory wd; see InstallEach. An EventPrerequisiteInstalled is sent for
+// each one installed.
+func (r *Runner) InstallPrerequisites(ctx context.Context, wd, dir string) error {
+	results := InstallEach(ctx, wd, dir, r.MissingPrerequisites(), 0)
+	for _, res := range results {
+		if res.Err == nil {
+			r.emit(Event{Kind: EventPrerequisiteInstalled, URL: res.URL})
+		}
+	}
+	return installError(results)
+}
+
+// SetLatencyHook sets a function called at the synchronization points of the
+// concurrent code of the checks and scm packages, e.g. when a check starts or
+// when a file content is cached, with the name of the point like
+// "checks.ParseFile". nil removes it.
+//
+// It is meant for tests: injecting random delays shakes out the data races and
+// the ordering assumptions, especially under the race detector. See
+// 'pcg stress'. f is called concurrently and must be safe for it.
+func SetLatencyHook(f func(point string)) {
+	internal.SetLatencyHook(f)
+}
+
+// ToolsBin returns the directory containing the binaries installed in the
+// tools directory dir, to prepend to PATH.
+func ToolsBin(dir string) string {
+	return filepath.Join(dir, "bin")
+}
+
+// Private stuff.
+
+// toolsEnv returns the environment variables to override to install the
+// prerequisites in the tools directory dir, nil if dir is empty.
+//
+// The module cache is shared with the GOPATH since it is immutable.
+func toolsEnv(dir string) []string {
+	if dir == "" {
+		return nil
+	}
+	env := []string{"GOPATH=" + dir, "GOBIN=" + ToolsBin(dir)}
+	if os.Getenv("GOMODCACHE") == "" {
+		if l := filepath.SplitList(build.Default.GOPATH); len(l) != 0 {
+			env = append(env, "GOMODCACHE="+filepath.Join(l[0], "pkg", "mod"))
+		}
+	}
+	return env
+}
+
+// schedule is the state shared by the checks of a Runner.Run.
+type schedule struct {
+	// procLock serializes the processes of the checks of WeightExclusive, see
+	// Options.LeaseRunToken.
+	procLock *sync.RWMutex
+	// ctx is canceled by fail.
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	// failed is the check that failed first; it is set before ctx is
+	// canceled.
+	failed string
+}
+
+// fail cancels the remaining checks because the check name failed.
+func (s *schedule) fail(name string) {
+	s.once.Do(func() {
+		s.failed = name
+		s.cancel()
+	})
+}
+
+// runCheck runs a single check and fills res.
+//
+// ctx is the context passed to Run; the check itself is run with s.ctx.
+func (r *Runner) runCheck(ctx context.Context, s *schedule, change scm.Change, check Check, res *Result) {
+	res.Name = check.GetName()
+	res.Experimental = r.Experimental[res.Name]
+	internal.Latency("checks.Runner.start")
+	if len(check.GetPrerequisites()) != 0 && r.PrereqReady != nil {
+		// If this check has prerequisites, wait for all prerequisites to be
+		// checked for presence, unless the run is canceled meanwhile.
+		ready := make(chan struct{})
+		go func() {
+			r.PrereqReady.Wait()
+			close(ready)
+		}()
+		select {
+		case <-ready:
+		case <-s.ctx.Done():
+		}
+	}
+	// Each check gets its own copy of the options to tag its output and
+	// counters.
+	options := *r.Options
+	options.counters = &counterSet{values: map[string]int{}}
+	options.procLock = s.procLock
+	options.exclusive = CheckWeight(check) == WeightExclusive
+	r.emit(Event{Kind: EventCheckStarted, Check: res.Name})
+	if r.Observer != nil {
+		r.Observer.OnCheckStart(res.Name)
+		options.output = func(line string) { r.Observer.OnOutput(res.Name, line) }
+	}
+	if ctx.Err() == nil && s.ctx.Err() != nil {
+		res.Err = fmt.Errorf("canceled since check %s failed", s.failed)
+		res.Canceled = true
+		log.Printf("... %s %s", res.Name, res.Err)
+		return
+	}
+	if r.Events != nil {
+		options.spawned = func(args []string) {
+			r.emit(Event{Kind: EventSubprocess, Check: res.Name, Command: args})
+		}
+	}
+	log.Printf("%s...", res.Name)
+	timeout := r.Options.Timeout()
+	var checkCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		checkCtx, cancel = context.WithTimeout(s.ctx, timeout)
+	} else {
+		checkCtx, cancel = context.WithCancel(s.ctx)
+	}
+	start := time.Now()
+	internal.Latency("checks.Runner.run")
+	err := runSafely(checkCtx, change, check, &options, &res.Panicked)
+	res.Duration = time.Since(start)
+	cancel()
+	options.counters.Lock()
+	if len(options.counters.values) != 0 {
+		res.Counters = options.counters.values
+	}
+	options.counters.Unlock()
+	if ctx.Err() != nil {
+		err = errors.New("interrupted")
+		res.Interrupted = true
+	} else if err != nil && s.ctx.Err() != nil {
+		err = fmt.Errorf("canceled since check %s failed", s.failed)
+		res.Canceled = true
+	} else if checkCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s and was killed; see check_timeout", timeout)
+		res.TimedOut = true
+	}
+	if w, ok := err.(Warning); ok {
+		res.Warnings = append(res.Warnings, fmt.Errorf("check %s: %s", res.Name, w))
+		err = nil
+	}
+	if !res.Interrupted && !res.Canceled {
+		r.Options.RecordResult(res.Name, err == nil)
+	}
+	res.Err = err
+	if err != nil {
+		log.Printf("... %s in %1.2fs FAILED\n%s", res.Name, res.Duration.Seconds(), err)
+		return
+	}
+	log.Printf("... %s in %1.2fs", res.Name, res.Duration.Seconds())
+	// A check that took too long is a check that failed.
+	max := time.Duration(r.Options.MaxDuration) * time.Second
+	if res.Duration > max {
+		res.Warnings = append(res.Warnings, fmt.Errorf("check %s took %1.2fs -> IT IS TOO SLOW (limit: %s)", res.Name, res.Duration.Seconds(), max))
+	}
+}
+
+// runSafely runs check and converts a panic into an error, so a crashing
+// check doesn't abort the whole run. panicked is set if it happened.
+//
+// A panic in a goroutine started by the check still crashes the process.
+func runSafely(ctx context.Context, change scm.Change, check Check, options *Options, panicked *bool) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			*panicked = true
+			err = fmt.Errorf("panicked: %v\n%s", v, debug.Stack())
+		}
+	}()
+	return check.Run(ctx, change, options)
+}
+
+// emit sends e to Events, if set.
+func (r *Runner) emit(e Event) {
+	if r.Events != nil {
+		e.Time = time.Now()
+		r.Events(e)
+	}
+}
+
+// lineWriter calls line with each line written to it, without the line
+// terminator.
+type lineWriter struct {
+	line func(line string)
+	buf  []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i == -1 {
+			return len(p), nil
+		}
+		l.line(strings.TrimSuffix(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// flush sends the last line if it is not terminated.
+func (l *lineWriter) flush() {
+	if len(l.buf) != 0 {
+		l.line(strings.TrimSuffix(string(l.buf), "\r"))
+		l.buf = nil
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+// runnerCheck is a check that returns err, publishes a metric and counts
+// packages. It panics if panics is set.
+type runnerCheck struct {
+	name     string
+	err      error
+	packages int
+	panics   bool
+}
+
+func (r *runnerCheck) GetDescription() string                { return r.name }
+func (r *runnerCheck) GetName() string                       { return r.name }
+func (r *runnerCheck) GetPrerequisites() []CheckPrerequisite { return nil }
+func (r *runnerCheck) Run(ctx context.Context, change scm.Change, options *Options) error {
+	options.PublishMetric(r.name, 1)
+	if r.panics {
+		panic("boom")
+	}
+	if r.packages != 0 {
+		options.Count(CounterPackages, r.packages)
+	}
+	return r.err
+}
+
+// weightedCheck is a runnerCheck of a given weight.
+type weightedCheck struct {
+	runnerCheck
+	weight Weight
+}
+
+func (w *weightedCheck) Weight() Weight { return w.weight }
+
+// recorder is an Observer recording the events.
+type recorder struct {
+	lock    sync.Mutex
+	started []string
+	output  []string
+	done    []string
+}
+
+func (r *recorder) OnCheckStart(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.started = append(r.started, name)
+}
+
+func (r *recorder) OnOutput(name, line string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.output = append(r.output, name+": "+line)
+}
+
+func (r *recorder) OnCheckDone(res *Result) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.done = append(r.done, res.Name)
+}
+
+func TestRunner(t *testing.T) {
+	t.Parallel()
+	rec := &recorder{}
+	r := &Runner{
+		Checks: []Check{
+			&runnerCheck{name: "pass", packages: 3},
+			&runnerCheck{name: "fail", err: errors.New("failed")},
+			&runnerCheck{name: "warn", err: Warning("careful")},
+		},
+		Options:  &Options{MaxDuration: 120, metrics: &metricSet{values: map[string]float64{}}},
+		Observer: rec,
+	}
+	report := r.Run(context.Background(), nil)
+	ut.AssertEqual(t, 3, len(report.Results))
+	ut.AssertEqual(t, "pass", report.Results[0].Name)
+	ut.AssertEqual(t, nil, report.Results[0].Err)
+	ut.AssertEqual(t, []error(nil), report.Results[0].Warnings)
+	ut.AssertEqual(t, map[string]int{CounterPackages: 3}, report.Results[0].Counters)
+	ut.AssertEqual(t, map[string]int(nil), report.Results[1].Counters)
+	ut.AssertEqual(t, "fail", report.Results[1].Name)
+	ut.AssertEqual(t, errors.New("failed"), report.Results[1].Err)
+	ut.AssertEqual(t, "warn", report.Results[2].Name)
+	ut.AssertEqual(t, nil, report.Results[2].Err)
+	ut.AssertEqual(t, []error{errors.New("check warn: careful")}, report.Results[2].Warnings)
+	ut.AssertEqual(t, []Result{report.Results[1]}, report.Failed())
+	ut.AssertEqual(t, map[string]float64{"pass": 1, "fail": 1, "warn": 1}, report.Metrics)
+	sort.Strings(rec.started)
+	ut.AssertEqual(t, []string{"fail", "pass", "warn"}, rec.started)
+	sort.Strings(rec.done)
+	ut.AssertEqual(t, []string{"fail", "pass", "warn"}, rec.done)
+}
+
+func TestRunnerExperimental(t *testing.T) {
+	t.Parallel()
+	r := &Runner{
+		Checks: []Check{
+			&runnerCheck{name: "crash", panics: true},
+			&runnerCheck{name: "fail", err: errors.New("failed")},
+			&runnerCheck{name: "new", err: errors.New("failed")},
+		},
+		Options:      &Options{MaxDuration: 120, metrics: &metricSet{values: map[string]float64{}}},
+		Experimental: map[string]bool{"new": true},
+	}
+	report := r.Run(context.Background(), nil)
+	ut.AssertEqual(t, true, report.Results[0].Panicked)
+	ut.AssertEqual(t, true, strings.HasPrefix(report.Results[0].Err.Error(), "panicked: boom\n"))
+	ut.AssertEqual(t, false, report.Results[1].Panicked)
+	ut.AssertEqual(t, false, report.Results[1].Experimental)
+	ut.AssertEqual(t, true, report.Results[2].Experimental)
+	ut.AssertEqual(t, errors.New("failed"), report.Results[2].Err)
+	// The experimental check is reported but isn't a failure.
+	ut.AssertEqual(t, []Result{report.Results[0], report.Results[1]}, report.Failed())
+	ut.AssertEqual(t, map[string]float64{"crash": 1, "fail": 1, "new": 1}, report.Metrics)
+}
+
+func TestRunnerFailFast(t *testing.T) {
+	t.Parallel()
+	r := &Runner{
+		Checks: []Check{
+			&runnerCheck{name: "test"},
+			&weightedCheck{runnerCheck{name: "lint", err: errors.New("failed")}, WeightNative},
+		},
+		Options:  &Options{MaxDuration: 120, metrics: &metricSet{values: map[string]float64{}}},
+		FailFast: true,
+	}
+	report := r.Run(context.Background(), nil)
+	// The native checks are run first, so the failure of lint cancels test
+	// before it starts.
+	ut.AssertEqual(t, true, report.Results[0].Canceled)
+	ut.AssertEqual(t, errors.New("canceled since check lint failed"), report.Results[0].Err)
+	ut.AssertEqual(t, false, report.Results[1].Canceled)
+	ut.AssertEqual(t, []Result{report.Results[1]}, report.Failed())
+	ut.AssertEqual(t, map[string]float64{"lint": 1}, report.Metrics)
+	ut.AssertEqual(t, map[string]bool{"lint": false}, r.Options.Results())
+
+	// An experimental check doesn't cancel the other ones.
+	r.Checks[1] = &weightedCheck{runnerCheck{name: "new", err: errors.New("failed")}, WeightNative}
+	r.Experimental = map[string]bool{"new": true}
+	report = r.Run(context.Background(), nil)
+	ut.AssertEqual(t, nil, report.Results[0].Err)
+	ut.AssertEqual(t, []Result(nil), report.Failed())
+}
+
+func TestNewRunnerExperimental(t *testing.T) {
+	t.Parallel()
+	config := New("0.1")
+	ut.AssertEqual(t, map[string]bool(nil), NewRunner(config, []Mode{PreCommit}).Experimental)
+	config.ExperimentalChecks = []string{"secrets"}
+	ut.AssertEqual(t, map[string]bool{"secrets": true}, NewRunner(config, []Mode{PreCommit}).Experimental)
+}
+
+func TestRunnerOutput(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n"})
+	rec := &recorder{}
+	r := &Runner{
+		Checks:   []Check{&Custom{DisplayName: "custom", Command: []string{"sh", "-c", "echo a; echo b >&2; printf c"}}},
+		Options:  &Options{MaxDuration: 120},
+		Observer: rec,
+	}
+	report := r.Run(context.Background(), change)
+	ut.AssertEqual(t, nil, report.Results[0].Err)
+	ut.AssertEqual(t, []string{"custom"}, rec.started)
+	ut.AssertEqual(t, []string{"custom: a", "custom: b", "custom: c"}, rec.output)
+	ut.AssertEqual(t, []string{"custom"}, rec.done)
+}
+
+func TestRunnerInterrupted(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &Runner{Checks: []Check{&runnerCheck{name: "pass"}}, Options: &Options{MaxDuration: 120}}
+	report := r.Run(ctx, nil)
+	ut.AssertEqual(t, errors.New("interrupted"), report.Results[0].Err)
+	ut.AssertEqual(t, true, report.Results[0].Interrupted)
+}
+
+func TestToolsEnv(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, []string(nil), toolsEnv(""))
+	dir := filepath.Join(string(filepath.Separator)+"src", "foo", ".tools")
+	env := toolsEnv(dir)
+	ut.AssertEqual(t, "GOPATH="+dir, env[0])
+	ut.AssertEqual(t, "GOBIN="+filepath.Join(dir, "bin"), env[1])
+}
+
+func TestRunnerEvents(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n"})
+	var lock sync.Mutex
+	var events []Event
+	r := &Runner{
+		Checks: []Check{
+			&Custom{DisplayName: "custom", Command: []string{"sh", "-c", "echo a"}},
+			&runnerCheck{name: "findings", err: Findings{{Message: "a"}, {Message: "b"}}},
+		},
+		Options: &Options{MaxDuration: 120},
+		Events: func(e Event) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, e)
+		},
+	}
+	report := r.Run(context.Background(), change)
+	ut.AssertEqual(t, nil, report.Results[0].Err)
+	var got []string
+	for _, e := range events {
+		ut.AssertEqual(t, false, e.Time.IsZero())
+		switch e.Kind {
+		case EventCheckFinished:
+			ut.AssertEqual(t, e.Check, e.Result.Name)
+		case EventFinding:
+			got = append(got, e.Check+" "+string(e.Kind)+" "+e.Finding.Message)
+			continue
+		case EventSubprocess:
+			ut.AssertEqual(t, []string{"sh", "-c", "echo a"}, e.Command)
+		}
+		got = append(got, e.Check+" "+string(e.Kind))
+	}
+	sort.Strings(got)
+	expected := []string{
+		"custom check_finished",
+		"custom check_started",
+		"custom subprocess",
+		"findings check_finished",
+		"findings check_started",
+		"findings finding a",
+		"findings finding b",
+	}
+	ut.AssertEqual(t, expected, got)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Scope is the part of a change a check considers.
+type Scope string
+
+// Scopes of the checks.
+const (
+	// ScopeFiles is the modified files, Change.Changed().Files().
+	ScopeFiles Scope = "modified files"
+	// ScopeGoFiles is the modified Go source files,
+	// Change.Changed().GoFiles().
+	ScopeGoFiles Scope = "modified Go files"
+	// ScopePackages is the packages of the modified Go files,
+	// Change.Changed().Packages().
+	ScopePackages Scope = "modified packages"
+	// ScopeIndirect is the modified packages and the packages importing them,
+	// Change.Indirect().Packages().
+	ScopeIndirect Scope = "modified and importing packages"
+	// ScopeTestPackages is the packages with tests in ScopeIndirect,
+	// Change.Indirect().TestPackages().
+	ScopeTestPackages Scope = "modified and importing packages with tests"
+	// ScopeRepository is the whole checkout, whatever the change.
+	ScopeRepository Scope = "whole repository"
+)
+
+// Scoper is implemented by the checks to tell which part of a change they
+// consider, e.g. to explain why a file didn't trigger a check.
+type Scoper interface {
+	// Scope returns the part of the change the check considers.
+	Scope() Scope
+}
+
+// CheckScope returns the scope of c, or ScopeRepository if c doesn't
+// implement Scoper.
+func CheckScope(c Check) Scope {
+	if s, ok := c.(Scoper); ok {
+		return s.Scope()
+	}
+	return ScopeRepository
+}
+
+// Considers returns true if the check c considers the file p of change: p is
+// included in the scope of c and is not filtered out by the settings of c,
+// e.g. the file extensions of copyright.
+func Considers(c Check, change scm.Change, p string) bool {
+	if !CheckScope(c).Includes(change, p) {
+		return false
+	}
+	if f, ok := c.(fileFilter); ok {
+		return f.considers(p)
+	}
+	return true
+}
+
+// Includes returns true if the scope includes something of the change for the
+// file p, relative to the repository root with forward slashes.
+//
+// For the package scopes, it is true when a package is selected because of p,
+// even if the check reports on other files of the package.
+func (s Scope) Includes(change scm.Change, p string) bool {
+	if change == nil || change.IsIgnored(p) {
+		return false
+	}
+	switch s {
+	case ScopeFiles:
+		return contains(change.Changed().Files(), p)
+	case ScopeGoFiles:
+		return contains(change.Changed().GoFiles(), p)
+	case ScopePackages:
+		return len(change.Changed().Packages()) != 0
+	case ScopeIndirect:
+		return len(change.Indirect().Packages()) != 0
+	case ScopeTestPackages:
+		return len(change.Indirect().TestPackages()) != 0
+	default:
+		return true
+	}
+}
+
+// Private stuff.
+
+// fileFilter is implemented by the checks that only consider some of the
+// files in their scope.
+type fileFilter interface {
+	// considers returns true if the check considers the file p.
+	considers(p string) bool
+}
+
+// contains returns true if s is in l.
+func contains(l []string, s string) bool {
+	for _, i := range l {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestConsiders(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	all := setup(t, td, map[string]string{
+		"a/a.go":      "package a\n",
+		"a/a_test.go": "package a\n",
+		"b/b.go":      "package b\n\nimport _ \"foo/a\"\n",
+		"README.md":   "Hi\n",
+	})
+	goFile, err := scm.FromFiles(all.Repo(), []string{"a/a.go"}, nil)
+	ut.AssertEqual(t, nil, err)
+	doc, err := scm.FromFiles(all.Repo(), []string{"README.md"}, nil)
+	ut.AssertEqual(t, nil, err)
+
+	data := []struct {
+		c      Check
+		goFile bool
+		doc    bool
+	}{
+		{&Gofmt{}, true, true},
+		{&Golint{}, true, false},
+		{&Test{}, true, false},
+		{&Coverage{}, true, false},
+		{&Secrets{}, true, true},
+		{&Copyright{}, true, false},
+		{&Copyright{PerExtension: map[string]CopyrightHeader{".md": {}}}, true, true},
+		{&Copyright{Exclude: []string{"a/"}}, false, false},
+		{&Misspell{}, true, false},
+		{&Misspell{IncludeMarkdown: true}, true, true},
+		{&Custom{}, true, true},
+		{&Custom{Protocol: "json"}, true, true},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.goFile, Considers(line.c, goFile, "a/a.go"))
+		ut.AssertEqualIndex(t, i, line.doc, Considers(line.c, doc, "README.md"))
+	}
+	ut.AssertEqual(t, ScopeIndirect, CheckScope(&Test{}))
+	ut.AssertEqual(t, ScopeRepository, CheckScope(&Coverage{UseGlobalInference: true}))
+	ut.AssertEqual(t, ScopeRepository, CheckScope(&runnerCheck{}))
+	ut.AssertEqual(t, false, ScopeRepository.Includes(nil, "a/a.go"))
+}
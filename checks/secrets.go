@@ -0,0 +1,164 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Secrets looks for likely credentials in the modified files.
+//
+// It is a native check that scans the content of all the modified files, not
+// only the .go files.
+type Secrets struct {
+	// Patterns is a list of additional regexps flagging a secret. They are used
+	// in addition to the builtin patterns for AWS access keys, private keys and
+	// common API tokens.
+	Patterns []string `yaml:"patterns"`
+	// Allowlist is a list of regexps. A line matching any of them is never
+	// flagged, e.g. to allow a well known test key.
+	Allowlist []string `yaml:"allowlist"`
+	// MinEntropy is the minimum Shannon entropy in bits per character of a
+	// quoted string of at least 20 alphanumeric characters to be flagged as a
+	// token. 0 disables the entropy based detection.
+	MinEntropy float64 `yaml:"min_entropy"`
+}
+
+// GetDescription implements Check.
+func (s *Secrets) GetDescription() string {
+	return "enforces modified files do not contain credentials like keys or tokens"
+}
+
+// GetName implements Check.
+func (s *Secrets) GetName() string {
+	return "secrets"
+}
+
+// GetPrerequisites implements Check.
+func (s *Secrets) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (s *Secrets) Scope() Scope {
+	return ScopeFiles
+}
+
+// Weight implements Weighter.
+func (s *Secrets) Weight() Weight {
+	return WeightNative
+}
+
+// Run implements Check.
+func (s *Secrets) Run(ctx context.Context, change scm.Change, options *Options) error {
+	patterns, err := compileRegexps(append(append([]string{}, secretPatterns...), s.Patterns...))
+	if err != nil {
+		return fmt.Errorf("secrets has invalid pattern: %s", err)
+	}
+	allowlist, err := compileRegexps(s.Allowlist)
+	if err != nil {
+		return fmt.Errorf("secrets has invalid allowlist: %s", err)
+	}
+	var results []string
+	for _, f := range change.Changed().Files() {
+		if change.IsIgnored(f) {
+			continue
+		}
+		content := change.Content(f)
+		if content == nil || bytes.IndexByte(content, 0) != -1 {
+			// Deleted or binary file.
+			continue
+		}
+		for i, line := range strings.Split(string(content), "\n") {
+			if reason := s.findSecret(line, patterns); reason != "" && !matchAny(allowlist, line) {
+				results = append(results, fmt.Sprintf("%s:%d: %s", f, i+1, reason))
+			}
+		}
+	}
+	if len(results) != 0 {
+		sort.Strings(results)
+		return fmt.Errorf("secrets failed; use allowlist for false positives:\n%s", strings.Join(results, "\n"))
+	}
+	return nil
+}
+
+// Private stuff.
+
+// secretPatterns are the builtin patterns for well known credentials.
+var secretPatterns = []string{
+	`AKIA[0-9A-Z]{16}`,
+	`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`,
+	`gh[pousr]_[A-Za-z0-9]{36}`,
+	`xox[abprs]-[A-Za-z0-9-]{10,}`,
+	`AIza[0-9A-Za-z_\-]{35}`,
+}
+
+// reQuotedToken matches a quoted string that looks like a token.
+var reQuotedToken = regexp.MustCompile("[\"'`]([A-Za-z0-9+/=_\\-]{20,})[\"'`]")
+
+// findSecret returns a description of the secret found in line, if any.
+func (s *Secrets) findSecret(line string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return fmt.Sprintf("matches %s", re)
+		}
+	}
+	if s.MinEntropy > 0 {
+		for _, m := range reQuotedToken.FindAllStringSubmatch(line, -1) {
+			if !strings.ContainsAny(m[1], "0123456789") {
+				// Likely an identifier or a sentence.
+				continue
+			}
+			if e := entropy(m[1]); e >= s.MinEntropy {
+				return fmt.Sprintf("high entropy string (%.1f bits/char)", e)
+			}
+		}
+	}
+	return ""
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	e := 0.
+	l := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / l
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	out := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestSecretsFindSecret(t *testing.T) {
+	t.Parallel()
+	s := &Secrets{MinEntropy: 4.5}
+	patterns, err := compileRegexps(secretPatterns)
+	ut.AssertEqual(t, nil, err)
+	data := []struct {
+		line     string
+		expected string
+	}{
+		{"", ""},
+		{"func main() {}", ""},
+		{"key := \"AKIA" + "IOSFODNN7EXAMPLE\"", "matches AKIA[0-9A-Z]{16}"},
+		{"-----BEGIN RSA " + "PRIVATE KEY-----", "matches -----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----"},
+		// A git hash has low entropy.
+		{"c := \"c9b5f312ec8eefb58beeaf8c3684bb832fdefef7\"", ""},
+		{"name := \"ThisIsALongIdentifierWithoutDigits\"", ""},
+		{"token := 'q8Zr2LxP0vT7nKw4YbJ6sHd1FgM3'", "high entropy string (4.8 bits/char)"},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, s.findSecret(line.line, patterns))
+	}
+	s.MinEntropy = 0
+	ut.AssertEqual(t, "", s.findSecret("token := 'q8Zr2LxP0vT7nKw4YbJ6sHd1FgM3'", patterns))
+}
+
+func TestSecretsEntropy(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, 0., entropy("aaaa"))
+	ut.AssertEqual(t, 1., entropy("abab"))
+	ut.AssertEqual(t, 2., entropy("abcd"))
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Shard is the part of the test packages run by a machine when the tests are
+// split across multiple CI machines, e.g. "2/5" is the second of five shards.
+//
+// It implements flag.Value. The zero value runs all the packages.
+type Shard struct {
+	// Index is the 1-based index of the shard.
+	Index int
+	// Count is the number of shards; 0 or 1 disables sharding.
+	Count int
+}
+
+// ParseShard parses a shard in the form "index/count", e.g. "2/5".
+func ParseShard(s string) (Shard, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Shard{}, fmt.Errorf("invalid shard %q; expected index/count, e.g. 2/5", s)
+	}
+	index, err1 := strconv.Atoi(parts[0])
+	count, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return Shard{}, fmt.Errorf("invalid shard %q; expected index/count with 1 <= index <= count, e.g. 2/5", s)
+	}
+	return Shard{index, count}, nil
+}
+
+// Set implements flag.Value.
+func (s *Shard) Set(v string) error {
+	out, err := ParseShard(v)
+	if err != nil {
+		return err
+	}
+	*s = out
+	return nil
+}
+
+// String implements flag.Value.
+func (s *Shard) String() string {
+	if !s.Enabled() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Enabled returns true if the packages are split across multiple shards.
+func (s *Shard) Enabled() bool {
+	return s.Count > 1
+}
+
+// Filter returns the packages of pkgs run by this shard.
+//
+// The packages are sorted and dealt round-robin to the shards, so all the
+// shards of a run partition the same list the same way, without a package
+// being run twice or skipped.
+func (s *Shard) Filter(pkgs []string) []string {
+	if !s.Enabled() {
+		return pkgs
+	}
+	sorted := make([]string, len(pkgs))
+	copy(sorted, pkgs)
+	sort.Strings(sorted)
+	out := []string{}
+	for i := s.Index - 1; i < len(sorted); i += s.Count {
+		out = append(out, sorted[i])
+	}
+	return out
+}
+
+// CoverageProfile returns the name of the coverage profile the coverage check
+// writes in the artifacts directory instead of enforcing the coverage, to be
+// combined with the profiles of the other shards by 'pcg merge-coverage'.
+func (s *Shard) CoverageProfile() string {
+	return fmt.Sprintf("coverage-shard-%d-of-%d.cov", s.Index, s.Count)
+}
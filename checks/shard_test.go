@@ -0,0 +1,47 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestParseShard(t *testing.T) {
+	t.Parallel()
+	s, err := ParseShard("2/5")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, Shard{2, 5}, s)
+	ut.AssertEqual(t, "2/5", s.String())
+	ut.AssertEqual(t, "coverage-shard-2-of-5.cov", s.CoverageProfile())
+	for _, v := range []string{"", "2", "a/5", "0/5", "6/5", "1/0", "1/2/3"} {
+		_, err := ParseShard(v)
+		ut.AssertEqual(t, true, err != nil)
+	}
+	_, err = ParseShard("2")
+	ut.AssertEqual(t, errors.New("invalid shard \"2\"; expected index/count, e.g. 2/5"), err)
+
+	s = Shard{}
+	ut.AssertEqual(t, nil, s.Set("1/1"))
+	ut.AssertEqual(t, false, s.Enabled())
+	ut.AssertEqual(t, "", s.String())
+}
+
+func TestShardFilter(t *testing.T) {
+	t.Parallel()
+	pkgs := []string{"./e", "./a", "./d", "./b", "./c"}
+	ut.AssertEqual(t, pkgs, (&Shard{}).Filter(pkgs))
+	var all []string
+	for i, expected := range [][]string{{"./a", "./d"}, {"./b", "./e"}, {"./c"}} {
+		s := &Shard{i + 1, 3}
+		ut.AssertEqual(t, expected, s.Filter(pkgs))
+		all = append(all, s.Filter(pkgs)...)
+	}
+	ut.AssertEqual(t, 5, len(all))
+	ut.AssertEqual(t, []string{"./e", "./a", "./d", "./b", "./c"}, pkgs)
+	ut.AssertEqual(t, []string{}, (&Shard{2, 3}).Filter([]string{"./a"}))
+}
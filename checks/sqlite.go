@@ -0,0 +1,103 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+)
+
+// SQLiteFile is the database in the scm directory, e.g. .git, used by the
+// "sqlite" storage.
+const SQLiteFile = "pcg.sqlite"
+
+// Private stuff.
+
+// sqliteDriver is the database/sql driver used by the "sqlite" storage. It is
+// only linked in when pcg is built with -tags sqlite; see sqlite_driver.go.
+const sqliteDriver = "sqlite3"
+
+// sqliteSchema creates the table holding all the values.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS state (
+  bucket TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value BLOB NOT NULL,
+  PRIMARY KEY (bucket, key)
+)`
+
+// sqliteStorage is a Storage keeping all the values in a single SQLite
+// database, which is faster than thousands of small files on large
+// repositories.
+type sqliteStorage struct {
+	db *sql.DB
+}
+
+// openSQLiteStorage opens or creates the database SQLiteFile in dir.
+func openSQLiteStorage(dir string) (Storage, error) {
+	if !isDriverLinked(sqliteDriver) {
+		return nil, errors.New("the sqlite storage requires pcg to be built with -tags sqlite")
+	}
+	// busy_timeout waits for the other pcg processes instead of failing.
+	db, err := sql.Open(sqliteDriver, "file:"+filepath.Join(dir, SQLiteFile)+"?_busy_timeout=10000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &sqliteStorage{db: db}, nil
+}
+
+func (s *sqliteStorage) Get(bucket, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM state WHERE bucket = ? AND key = ?", bucket, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (s *sqliteStorage) Put(bucket, key string, value []byte) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO state (bucket, key, value) VALUES (?, ?, ?)", bucket, key, nonNil(value))
+	return err
+}
+
+func (s *sqliteStorage) Append(bucket, key string, value []byte) error {
+	_, err := s.db.Exec("INSERT INTO state (bucket, key, value) VALUES (?, ?, ?) ON CONFLICT (bucket, key) DO UPDATE SET value = CAST(value || excluded.value AS BLOB)", bucket, key, nonNil(value))
+	return err
+}
+
+func (s *sqliteStorage) Delete(bucket, key string) error {
+	_, err := s.db.Exec("DELETE FROM state WHERE bucket = ? AND key = ?", bucket, key)
+	return err
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+// isDriverLinked returns true if the database/sql driver name is registered.
+func isDriverLinked(name string) bool {
+	for _, d := range sql.Drivers() {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nonNil returns b, or an empty slice if b is nil, since a nil []byte is
+// stored as NULL.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
@@ -0,0 +1,11 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build sqlite
+
+package checks
+
+// The driver of the "sqlite" storage. github.com/mattn/go-sqlite3 requires cgo,
+// which is why it is only linked when built with -tags sqlite.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,109 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// StaleBranches reports the remote branches whose base is far behind the
+// default branch, e.g. abandoned or long lived branches that will be hard to
+// merge.
+//
+// It is meant for the nightly mode and needs a clone with all the remote
+// branches fetched; git is run directly.
+type StaleBranches struct {
+	// Remote is the remote whose branches are inspected. Defaults to "origin".
+	Remote string `yaml:"remote,omitempty"`
+	// DefaultBranch is the branch the others are compared to. Defaults to the
+	// remote HEAD, e.g. "main".
+	DefaultBranch string `yaml:"default_branch,omitempty"`
+	// MaxBehind is the maximum number of commits of the default branch that a
+	// branch can be missing.
+	MaxBehind int `yaml:"max_behind"`
+	// Fail makes the check fail instead of reporting a warning.
+	Fail bool `yaml:"fail,omitempty"`
+}
+
+// GetDescription implements Check.
+func (s *StaleBranches) GetDescription() string {
+	return "reports the branches far behind the default branch"
+}
+
+// GetName implements Check.
+func (s *StaleBranches) GetName() string {
+	return "stalebranches"
+}
+
+// GetPrerequisites implements Check.
+func (s *StaleBranches) GetPrerequisites() []CheckPrerequisite {
+	return nil
+}
+
+// Scope implements Scoper.
+func (s *StaleBranches) Scope() Scope {
+	return ScopeRepository
+}
+
+// Run implements Check.
+func (s *StaleBranches) Run(ctx context.Context, change scm.Change, options *Options) error {
+	remote := s.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+	r := change.Repo()
+	def := remote + "/" + s.DefaultBranch
+	if s.DefaultBranch == "" {
+		out, exitCode, _, err := options.Capture(ctx, r, "git", "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+		if err != nil || exitCode != 0 {
+			return fmt.Errorf("stalebranches failed to find the default branch of %s; set default_branch: %v\n%s", remote, err, out)
+		}
+		def = strings.TrimSpace(out)
+	}
+	out, exitCode, _, err := options.Capture(ctx, r, "git", "for-each-ref", "--format=%(refname:short)", "refs/remotes/"+remote)
+	if err != nil || exitCode != 0 {
+		return fmt.Errorf("stalebranches failed to list the branches of %s: %v\n%s", remote, err, out)
+	}
+	var stale []string
+	for _, branch := range strings.Split(strings.TrimSpace(out), "\n") {
+		if branch == "" || branch == def || branch == remote+"/HEAD" || branch == remote {
+			continue
+		}
+		behind, err := s.behind(ctx, r, options, branch, def)
+		if err != nil {
+			return err
+		}
+		if behind > s.MaxBehind {
+			stale = append(stale, fmt.Sprintf("%s is %d commits behind %s", branch, behind, def))
+		}
+	}
+	options.PublishMetric("stalebranches.count", float64(len(stale)))
+	if len(stale) == 0 {
+		return nil
+	}
+	sort.Strings(stale)
+	msg := fmt.Sprintf("%d stale branches; maximum is %d commits behind:\n%s", len(stale), s.MaxBehind, strings.Join(stale, "\n"))
+	if s.Fail {
+		return fmt.Errorf("%s", msg)
+	}
+	return Warning(msg)
+}
+
+// Private stuff.
+
+// behind returns the number of commits in def that are not in branch.
+func (s *StaleBranches) behind(ctx context.Context, r scm.ReadOnlyRepo, options *Options, branch, def string) (int, error) {
+	out, exitCode, _, err := options.Capture(ctx, r, "git", "rev-list", "--count", branch+".."+def)
+	if err != nil || exitCode != 0 {
+		return 0, fmt.Errorf("stalebranches failed to compare %s with %s: %v\n%s", branch, def, err, out)
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
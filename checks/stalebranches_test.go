@@ -0,0 +1,60 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestStaleBranches(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	change := setup(t, td, map[string]string{"foo.go": "package foo\n"})
+	root := change.Repo().Root()
+	git := func(args ...string) {
+		args = append([]string{"git", "-c", "user.name=pcg", "-c", "user.email=pcg@localhost"}, args...)
+		out, code, err := internal.Capture(context.Background(), root, nil, args...)
+		ut.AssertEqualf(t, 0, code, out)
+		ut.AssertEqual(t, nil, err)
+	}
+	git("commit", "-q", "-m", "first")
+	git("update-ref", "refs/remotes/origin/old", "HEAD")
+	git("update-ref", "refs/remotes/origin/recent", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	git("update-ref", "refs/remotes/origin/recent", "HEAD")
+	git("commit", "-q", "--allow-empty", "-m", "third")
+	git("update-ref", "refs/remotes/origin/main", "HEAD")
+
+	options := &Options{MaxDuration: 1, metrics: &metricSet{values: map[string]float64{}}}
+	s := &StaleBranches{MaxBehind: 1}
+	expected := errors.New("stalebranches failed to find the default branch of origin; set default_branch: <nil>\n")
+	err = s.Run(context.Background(), change, options)
+	ut.AssertEqual(t, true, err != nil)
+	ut.AssertEqual(t, expected.Error(), err.Error()[:len(expected.Error())])
+
+	git("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main")
+	ut.AssertEqual(t, Warning("1 stale branches; maximum is 1 commits behind:\norigin/old is 2 commits behind origin/main"), s.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 1., options.Metrics()["stalebranches.count"])
+
+	s = &StaleBranches{DefaultBranch: "main", Fail: true}
+	ut.AssertEqual(t, errors.New("2 stale branches; maximum is 0 commits behind:\norigin/old is 2 commits behind origin/main\norigin/recent is 1 commits behind origin/main"), s.Run(context.Background(), change, options))
+	s.MaxBehind = 2
+	ut.AssertEqual(t, nil, s.Run(context.Background(), change, options))
+}
@@ -0,0 +1,301 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// Storage persists the state of pcg between runs, like the metrics history,
+// the test cache and the checkpoints of -resume.
+//
+// The values are organized in buckets, e.g. TestCacheDir, and are identified
+// by a key in their bucket. The root bucket is "". The methods must be safe to
+// call concurrently, including from multiple processes.
+type Storage interface {
+	// Get returns the value of key in bucket, or false if it doesn't exist.
+	Get(bucket, key string) ([]byte, bool, error)
+	// Put sets the value of key in bucket.
+	Put(bucket, key string, value []byte) error
+	// Append appends value to the value of key in bucket, creating it if
+	// needed. Concurrent appends must not be lost nor interleaved.
+	Append(bucket, key string, value []byte) error
+	// Delete deletes key in bucket. It is not an error if it doesn't exist.
+	Delete(bucket, key string) error
+	// Close releases the resources of the storage.
+	Close() error
+}
+
+// StorageOpener returns the Storage of a repository; dir is its scm
+// directory, e.g. .git.
+type StorageOpener func(dir string) (Storage, error)
+
+// DefaultStorage is the name of the storage used when Config.Storage is
+// empty.
+const DefaultStorage = "file"
+
+// RegisterStorage makes the storage backend available as name, e.g. to store
+// the state remotely. It panics if name is already registered.
+func RegisterStorage(name string, open StorageOpener) {
+	if open == nil {
+		panic("checks: RegisterStorage open is nil")
+	}
+	storageRegistry.Lock()
+	defer storageRegistry.Unlock()
+	if _, ok := storageRegistry.openers[name]; ok {
+		panic(fmt.Sprintf("checks: RegisterStorage called twice for storage %q", name))
+	}
+	storageRegistry.openers[name] = open
+}
+
+// RegisteredStorages returns the sorted names of the storage backends.
+func RegisteredStorages() []string {
+	storageRegistry.RLock()
+	defer storageRegistry.RUnlock()
+	out := make([]string, 0, len(storageRegistry.openers))
+	for name := range storageRegistry.openers {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// OpenStorage returns the storage backend name of the repository, or
+// DefaultStorage if name is empty. The caller must close it.
+//
+// If the repository has no scm directory, e.g. a checkout of a source control
+// without native support, a MemoryStorage is returned instead so the checks
+// can still run; nothing is persisted between runs.
+func OpenStorage(r scm.ReadOnlyRepo, name string) (Storage, error) {
+	if name == "" {
+		name = DefaultStorage
+	}
+	storageRegistry.RLock()
+	open, ok := storageRegistry.openers[name]
+	storageRegistry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage %q; expected one of %s", name, strings.Join(RegisteredStorages(), ", "))
+	}
+	d, err := r.ScmDir()
+	if err != nil {
+		log.Printf("%s; the state of pcg is not persisted", err)
+		return &MemoryStorage{}, nil
+	}
+	return open(d)
+}
+
+// FileStorage is the default Storage. Each value is a file named after its key
+// in the subdirectory named after its bucket, e.g. .git/pcg-test-cache/<key>.
+type FileStorage struct {
+	// Dir is the root directory, e.g. .git.
+	Dir string
+}
+
+// Get implements Storage.
+func (f *FileStorage) Get(bucket, key string) ([]byte, bool, error) {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+	content, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// Put implements Storage.
+func (f *FileStorage) Put(bucket, key string, value []byte) error {
+	p, err := f.mkdir(bucket, key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, value, 0600)
+}
+
+// Append implements Storage.
+//
+// The value is written in a single write to a file opened in append mode, so
+// concurrent appends are not interleaved.
+func (f *FileStorage) Append(bucket, key string, value []byte) error {
+	p, err := f.mkdir(bucket, key)
+	if err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(value)
+	if err2 := fd.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Delete implements Storage.
+func (f *FileStorage) Delete(bucket, key string) error {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Close implements Storage.
+func (f *FileStorage) Close() error {
+	return nil
+}
+
+// MemoryStorage is a Storage keeping the values in memory, for the
+// repositories without an scm directory to persist them in.
+type MemoryStorage struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+// Get implements Storage.
+func (m *MemoryStorage) Get(bucket, key string) ([]byte, bool, error) {
+	k, err := memoryKey(bucket, key)
+	if err != nil {
+		return nil, false, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.values[k]
+	return append([]byte{}, v...), ok, nil
+}
+
+// Put implements Storage.
+func (m *MemoryStorage) Put(bucket, key string, value []byte) error {
+	k, err := memoryKey(bucket, key)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.values == nil {
+		m.values = map[string][]byte{}
+	}
+	m.values[k] = append([]byte{}, value...)
+	return nil
+}
+
+// Append implements Storage.
+func (m *MemoryStorage) Append(bucket, key string, value []byte) error {
+	k, err := memoryKey(bucket, key)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.values == nil {
+		m.values = map[string][]byte{}
+	}
+	m.values[k] = append(m.values[k], value...)
+	return nil
+}
+
+// Delete implements Storage.
+func (m *MemoryStorage) Delete(bucket, key string) error {
+	k, err := memoryKey(bucket, key)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.values, k)
+	return nil
+}
+
+// Close implements Storage.
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// Private stuff.
+
+// storageRegistry holds the storage backends by name.
+var storageRegistry = struct {
+	sync.RWMutex
+	openers map[string]StorageOpener
+}{
+	openers: map[string]StorageOpener{
+		DefaultStorage: func(dir string) (Storage, error) { return &FileStorage{Dir: dir}, nil },
+		"sqlite":       openSQLiteStorage,
+	},
+}
+
+// repoStorage returns options.Storage, or the FileStorage of the repository
+// r if not set.
+func repoStorage(r scm.ReadOnlyRepo, options *Options) Storage {
+	if options.Storage != nil {
+		return options.Storage
+	}
+	d, err := r.ScmDir()
+	if err != nil {
+		return &MemoryStorage{}
+	}
+	return &FileStorage{Dir: d}
+}
+
+// memoryKey returns the key in MemoryStorage of key in bucket.
+func memoryKey(bucket, key string) (string, error) {
+	if err := validateStorageName(bucket, true); err != nil {
+		return "", err
+	}
+	if err := validateStorageName(key, false); err != nil {
+		return "", err
+	}
+	return bucket + "/" + key, nil
+}
+
+// path returns the path of the file of key in bucket.
+func (f *FileStorage) path(bucket, key string) (string, error) {
+	if err := validateStorageName(bucket, true); err != nil {
+		return "", err
+	}
+	if err := validateStorageName(key, false); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.Dir, bucket, key), nil
+}
+
+// mkdir returns the path of the file of key in bucket after creating the
+// directory of the bucket.
+func (f *FileStorage) mkdir(bucket, key string) (string, error) {
+	p, err := f.path(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return p, os.MkdirAll(filepath.Dir(p), 0700)
+}
+
+// validateStorageName returns an error if s can't be used as a bucket or a
+// key, since it could escape the directory of the storage.
+func validateStorageName(s string, isBucket bool) error {
+	if s == "" && isBucket {
+		return nil
+	}
+	if s == "" || s == "." || s == ".." || strings.ContainsAny(s, `/\`) {
+		return fmt.Errorf("invalid storage name %q", s)
+	}
+	return nil
+}
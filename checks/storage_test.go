@@ -0,0 +1,121 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestFileStorage(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	var s Storage = &FileStorage{Dir: td}
+	_, found, err := s.Get("bucket", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, found)
+
+	ut.AssertEqual(t, nil, s.Put("bucket", "key", []byte("a")))
+	ut.AssertEqual(t, nil, s.Append("bucket", "key", []byte("b")))
+	ut.AssertEqual(t, nil, s.Append("", "root", []byte("c")))
+	ut.AssertEqual(t, nil, s.Put("bucket", "empty", nil))
+	v, found, err := s.Get("bucket", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, found)
+	ut.AssertEqual(t, "ab", string(v))
+	v, found, err = s.Get("bucket", "empty")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, found)
+	ut.AssertEqual(t, 0, len(v))
+	content, err := ioutil.ReadFile(filepath.Join(td, "root"))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "c", string(content))
+
+	ut.AssertEqual(t, nil, s.Delete("bucket", "key"))
+	ut.AssertEqual(t, nil, s.Delete("bucket", "key"))
+	_, err = os.Stat(filepath.Join(td, "bucket", "key"))
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+
+	for _, name := range []string{"", ".", "..", "a/b", `a\b`} {
+		if err = s.Put("bucket", name, nil); err == nil {
+			t.Errorf("key %q: expected error", name)
+		}
+	}
+	if _, _, err = s.Get("..", "key"); err == nil {
+		t.Errorf("expected error")
+	}
+	ut.AssertEqual(t, nil, s.Close())
+}
+
+func TestMemoryStorage(t *testing.T) {
+	t.Parallel()
+	var s Storage = &MemoryStorage{}
+	_, found, err := s.Get("bucket", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, found)
+	ut.AssertEqual(t, nil, s.Put("bucket", "key", []byte("a")))
+	ut.AssertEqual(t, nil, s.Append("bucket", "key", []byte("b")))
+	ut.AssertEqual(t, nil, s.Append("", "key", []byte("c")))
+	v, found, err := s.Get("bucket", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, found)
+	ut.AssertEqual(t, "ab", string(v))
+	v, _, err = s.Get("", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "c", string(v))
+	ut.AssertEqual(t, nil, s.Delete("bucket", "key"))
+	_, found, err = s.Get("bucket", "key")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, found)
+	if err = s.Put("bucket", "a/b", nil); err == nil {
+		t.Errorf("expected error")
+	}
+	ut.AssertEqual(t, nil, s.Close())
+}
+
+func TestOpenStorage(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	repo := setup(t, td, map[string]string{"foo.go": "package foo\n"}).Repo()
+	d, err := repo.ScmDir()
+	ut.AssertEqual(t, nil, err)
+	s, err := OpenStorage(repo, "")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, &FileStorage{Dir: d}, s)
+	ut.AssertEqual(t, nil, s.Close())
+
+	// A repository without scm directory still gets a storage.
+	dir, err := scm.GetDir(td, "")
+	ut.AssertEqual(t, nil, err)
+	s, err = OpenStorage(dir, "")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, &MemoryStorage{}, s)
+
+	_, err = OpenStorage(repo, "foo")
+	ut.AssertEqual(t, "unknown storage \"foo\"; expected one of file, sqlite", err.Error())
+	ut.AssertEqual(t, []string{"file", "sqlite"}, RegisteredStorages())
+	if !isDriverLinked(sqliteDriver) {
+		_, err = OpenStorage(repo, "sqlite")
+		ut.AssertEqual(t, "the sqlite storage requires pcg to be built with -tags sqlite", err.Error())
+	}
+}
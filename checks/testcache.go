@@ -0,0 +1,124 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// TestCacheDir is the bucket of the Storage, e.g. the directory in .git, where
+// the test results are cached.
+const TestCacheDir = "pcg-test-cache"
+
+// testCache records the go test invocations that passed, keyed by a hash of
+// the command, the go version and the content of all the non standard
+// packages the test depends on.
+//
+// Each passing key is an empty value in the TestCacheDir bucket of the
+// storage, so concurrent processes can safely share the cache.
+type testCache struct {
+	storage   Storage
+	goVersion string
+}
+
+// newTestCache returns the test cache of the repository in the storage of
+// options.
+func newTestCache(ctx context.Context, r scm.ReadOnlyRepo, options *Options) (*testCache, error) {
+	s := repoStorage(r, options)
+	out, exitCode, _, err := options.Capture(ctx, r, "go", "version")
+	if err != nil || exitCode != 0 {
+		return nil, fmt.Errorf("go version failed: %s\n%s", err, out)
+	}
+	return &testCache{storage: s, goVersion: strings.TrimSpace(out)}, nil
+}
+
+// key returns the cache key to run args with the environment variables env.
+//
+// testPkg must be the last item in args.
+func (t *testCache) key(ctx context.Context, r scm.ReadOnlyRepo, options *Options, env, args []string) (string, error) {
+	testPkg := args[len(args)-1]
+	listArgs := []string{"go", "list", "-deps", "-test", "-f", "{{if not .Standard}}{{.Dir}}{{end}}"}
+	for i, a := range args {
+		// The build tags change the dependencies.
+		if a == "-tags" && i+1 < len(args) {
+			listArgs = append(listArgs, a, args[i+1])
+		}
+	}
+	out, exitCode, _, err := options.CaptureEnv(ctx, r, env, append(listArgs, testPkg)...)
+	if err != nil || exitCode != 0 {
+		return "", fmt.Errorf("go list failed: %s\n%s", err, out)
+	}
+	seen := map[string]bool{}
+	var dirs []string
+	for _, d := range strings.Split(out, "\n") {
+		if d = strings.TrimSpace(d); d != "" && !seen[d] {
+			seen[d] = true
+			dirs = append(dirs, d)
+		}
+	}
+	sort.Strings(dirs)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%q\n%q\n", t.goVersion, env, args)
+	for _, d := range dirs {
+		if err = hashDir(h, d); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// has returns true if key passed before.
+func (t *testCache) has(key string) bool {
+	_, found, err := t.storage.Get(TestCacheDir, key)
+	return err == nil && found
+}
+
+// add records that key passed.
+func (t *testCache) add(key string) error {
+	return t.storage.Put(TestCacheDir, key, nil)
+}
+
+// hashDir hashes the name and content of the files in the directory d,
+// including the testdata subdirectory.
+func hashDir(h io.Writer, d string) error {
+	testdata := filepath.Join(d, "testdata")
+	return filepath.Walk(d, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == d || p == testdata || isUnder(p, testdata) {
+				return nil
+			}
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\n%d\n", p, len(content))
+		_, err = h.Write(content)
+		return err
+	})
+}
+
+// isUnder returns true if p is located in the directory d.
+func isUnder(p, d string) bool {
+	return strings.HasPrefix(p, d+string(filepath.Separator))
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestTestCache(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	// Each run of the test appends to the log file.
+	log := filepath.Join(td, "log")
+	files := map[string]string{
+		"foo.go": "package foo\n",
+		"foo_test.go": `package foo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	f, err := os.OpenFile(` + strconv.Quote(log) + `, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("x"))
+	f.Close()
+}
+`,
+	}
+	change := setup(t, td, files)
+	runs := func() int {
+		content, _ := ioutil.ReadFile(log)
+		return len(content)
+	}
+	// -count=1 disables go's own test cache.
+	c := &Test{ExtraArgs: []string{"-count=1"}, Cache: true}
+	options := &Options{MaxDuration: 60}
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 1, runs())
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 1, runs())
+
+	// Different arguments are cached separately.
+	c.ExtraArgs = append(c.ExtraArgs, "-v")
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 2, runs())
+
+	// A modified input invalidates the cache.
+	p := filepath.Join(td, "src", "foo", "foo.go")
+	ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte("package foo\n\n// Foo.\nconst Foo = 1\n"), 0600))
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 3, runs())
+	ut.AssertEqual(t, nil, c.Run(context.Background(), change, options))
+	ut.AssertEqual(t, 3, runs())
+
+	_, err = os.Stat(filepath.Join(td, "src", "foo", ".git", TestCacheDir))
+	ut.AssertEqual(t, nil, err)
+}
@@ -0,0 +1,195 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// affectedTests returns the -run regexp to use per package to only run the
+// tests that plausibly exercise the Go files modified in change.
+//
+// Packages that are not in the map, e.g. packages only indirectly affected or
+// for which a modified file couldn't be parsed, must run all their tests.
+func affectedTests(change scm.Change) map[string]string {
+	changed := map[string]map[string][]byte{}
+	for _, f := range change.Changed().GoFiles() {
+		d := filepath.Dir(f)
+		if changed[d] == nil {
+			changed[d] = map[string][]byte{}
+		}
+		changed[d][f] = change.Content(f)
+	}
+	out := map[string]string{}
+	for d, files := range changed {
+		tests := map[string][]byte{}
+		for _, f := range change.All().GoFiles() {
+			if filepath.Dir(f) == d && strings.HasSuffix(f, "_test.go") {
+				tests[f] = change.Content(f)
+			}
+		}
+		if run, ok := selectTests(files, tests); ok {
+			out[dirToPkg(d)] = run
+		}
+	}
+	return out
+}
+
+// selectTests returns the -run regexp matching the tests in the test files
+// that reference a top level declaration of the modified files of the same
+// package. A modified test file selects all its tests.
+//
+// Test helpers referencing a modified declaration are followed, so a test
+// calling a helper that calls a modified function is selected. Returns false
+// if a file can't be parsed.
+func selectTests(modified, tests map[string][]byte) (string, bool) {
+	names := map[string]bool{}
+	selected := map[string]bool{}
+	for f, content := range modified {
+		if content == nil {
+			// Deleted file, its declarations are unknown.
+			return "", false
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), f, content, 0)
+		if err != nil {
+			return "", false
+		}
+		for _, d := range file.Decls {
+			for _, name := range declNames(d) {
+				if strings.HasSuffix(f, "_test.go") && isTestFunc(d) {
+					selected[name] = true
+				} else {
+					names[name] = true
+				}
+			}
+		}
+	}
+
+	var funcs []*ast.FuncDecl
+	for f, content := range tests {
+		file, err := parser.ParseFile(token.NewFileSet(), f, content, 0)
+		if err != nil {
+			return "", false
+		}
+		for _, d := range file.Decls {
+			if fn, ok := d.(*ast.FuncDecl); ok && fn.Body != nil {
+				funcs = append(funcs, fn)
+			}
+		}
+	}
+	// Loop until no more helper is found.
+	for found := true; found; {
+		found = false
+		for _, fn := range funcs {
+			name := fn.Name.Name
+			if names[name] || selected[name] || !references(fn.Body, names) {
+				continue
+			}
+			if isTestFunc(fn) {
+				selected[name] = true
+			} else {
+				names[name] = true
+				found = true
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		// Still build the tests but run none.
+		return "^$", true
+	}
+	out := make([]string, 0, len(selected))
+	for name := range selected {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return "^(" + strings.Join(out, "|") + ")$", true
+}
+
+// countTests returns the number of tests declared in the packages pkgs.
+// Files that can't be parsed are skipped.
+func countTests(change scm.Change, pkgs []string) int {
+	dirs := map[string]bool{}
+	for _, p := range pkgs {
+		dirs[p] = true
+	}
+	count := 0
+	for _, f := range change.All().GoFiles() {
+		if !strings.HasSuffix(f, "_test.go") || !dirs[dirToPkg(filepath.Dir(f))] {
+			continue
+		}
+		if file, err := ParseFile(change, f); err == nil && file != nil {
+			for _, d := range file.File.Decls {
+				if isTestFunc(d) {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// declNames returns the names declared by a top level declaration.
+func declNames(d ast.Decl) []string {
+	var out []string
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		out = append(out, d.Name.Name)
+	case *ast.GenDecl:
+		for _, s := range d.Specs {
+			switch s := s.(type) {
+			case *ast.TypeSpec:
+				out = append(out, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, n := range s.Names {
+					out = append(out, n.Name)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// isTestFunc returns true if d is a function selectable with go test -run.
+func isTestFunc(d ast.Decl) bool {
+	fn, ok := d.(*ast.FuncDecl)
+	if !ok || fn.Recv != nil {
+		return false
+	}
+	for _, prefix := range []string{"Test", "Example", "Fuzz"} {
+		if strings.HasPrefix(fn.Name.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirToPkg returns the relative package notation of a relative directory, as
+// returned by scm.Set.Packages().
+func dirToPkg(d string) string {
+	if d == "." {
+		return d
+	}
+	return "./" + filepath.ToSlash(d)
+}
+
+// references returns true if node uses any identifier in names.
+func references(node ast.Node, names map[string]bool) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && names[id.Name] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestSelectTests(t *testing.T) {
+	t.Parallel()
+	tests := map[string][]byte{
+		"foo_test.go": []byte(`package foo
+import "testing"
+func helper() int { return Foo() }
+func TestFoo(t *testing.T) { helper() }
+func TestBar(t *testing.T) { _ = Bar{} }
+func TestOther(t *testing.T) {}
+`),
+		"x_test.go": []byte(`package foo_test
+import "foo"
+func ExampleBar() { foo.Bar{}.Do() }
+`),
+	}
+
+	run, ok := selectTests(map[string][]byte{"foo.go": []byte("package foo\nfunc Foo() int { return 1 }\n")}, tests)
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, "^(TestFoo)$", run)
+
+	run, ok = selectTests(map[string][]byte{"bar.go": []byte("package foo\ntype Bar struct{}\n")}, tests)
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, "^(ExampleBar|TestBar)$", run)
+
+	run, ok = selectTests(map[string][]byte{"foo_test.go": tests["foo_test.go"]}, tests)
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, "^(TestBar|TestFoo|TestOther)$", run)
+
+	run, ok = selectTests(map[string][]byte{"baz.go": []byte("package foo\nconst Baz = 1\n")}, tests)
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, "^$", run)
+
+	_, ok = selectTests(map[string][]byte{"baz.go": nil}, tests)
+	ut.AssertEqual(t, false, ok)
+	_, ok = selectTests(map[string][]byte{"baz.go": []byte("package")}, tests)
+	ut.AssertEqual(t, false, ok)
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ToolsChecksumsFile is the file in Config.ToolsDir listing the SHA-256 of
+// each binary in ToolsBin, in the format of sha256sum, e.g.
+// "<hex>  bin/golint". It can be verified with "sha256sum -c" from the tools
+// directory.
+const ToolsChecksumsFile = "SHA256SUMS"
+
+// RecordToolsChecksums writes ToolsChecksumsFile in the tools directory dir
+// with the SHA-256 of each binary in ToolsBin(dir), so the directory can be
+// vendored or cached and then verified with VerifyTools.
+func RecordToolsChecksums(dir string) error {
+	sums, err := hashTools(dir)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", sums[name], name)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ToolsChecksumsFile), b.Bytes(), 0600)
+}
+
+// VerifyTools returns an error if ToolsChecksumsFile in the tools directory
+// dir is missing, or if a binary in ToolsBin(dir) is not listed in it or
+// doesn't match its checksum.
+func VerifyTools(dir string) error {
+	content, err := ioutil.ReadFile(filepath.Join(dir, ToolsChecksumsFile))
+	if err != nil {
+		return fmt.Errorf("can't verify the tools in %s: %s", dir, err)
+	}
+	expected := map[string]string{}
+	for i, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 2 {
+			return fmt.Errorf("%s:%d: invalid line %q", ToolsChecksumsFile, i+1, line)
+		}
+		// sha256sum prefixes the name with "*" in binary mode.
+		expected[strings.TrimPrefix(f[1], "*")] = strings.ToLower(f[0])
+	}
+	actual, err := hashTools(dir)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for name, sum := range actual {
+		switch e, ok := expected[name]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not listed in %s", name, ToolsChecksumsFile))
+		case e != sum:
+			problems = append(problems, fmt.Sprintf("%s doesn't match its checksum", name))
+		}
+	}
+	for name := range expected {
+		if _, ok := actual[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is listed in %s but missing", name, ToolsChecksumsFile))
+		}
+	}
+	if len(problems) != 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("the tools in %s are not the expected ones:\n%s", dir, strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Private stuff.
+
+// hashTools returns the SHA-256 of each file in ToolsBin(dir) keyed by its
+// slash separated path relative to dir.
+func hashTools(dir string) (map[string]string, error) {
+	bin := ToolsBin(dir)
+	out := map[string]string{}
+	err := filepath.Walk(bin, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == bin {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(content)
+		out[filepath.ToSlash(rel)] = hex.EncodeToString(h[:])
+		return nil
+	})
+	return out, err
+}
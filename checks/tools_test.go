@@ -0,0 +1,74 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestToolsChecksums(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	if err = VerifyTools(td); err == nil {
+		t.Fatal("expected error without checksums")
+	}
+	bin := ToolsBin(td)
+	ut.AssertEqual(t, nil, os.MkdirAll(bin, 0700))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(bin, "tool"), []byte("a"), 0700))
+	ut.AssertEqual(t, nil, RecordToolsChecksums(td))
+	content, err := ioutil.ReadFile(filepath.Join(td, ToolsChecksumsFile))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  bin/tool\n", string(content))
+	ut.AssertEqual(t, nil, VerifyTools(td))
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(bin, "tool"), []byte("b"), 0700))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(bin, "other"), []byte("c"), 0700))
+	err = VerifyTools(td)
+	ut.AssertEqual(t, true, err != nil)
+	expected := []string{
+		"the tools in " + td + " are not the expected ones:",
+		"bin/other is not listed in SHA256SUMS",
+		"bin/tool doesn't match its checksum",
+	}
+	ut.AssertEqual(t, expected, strings.Split(err.Error(), "\n"))
+
+	ut.AssertEqual(t, nil, os.Remove(filepath.Join(bin, "other")))
+	ut.AssertEqual(t, nil, os.Remove(filepath.Join(bin, "tool")))
+	ut.AssertEqual(t, "the tools in "+td+" are not the expected ones:\nbin/tool is listed in SHA256SUMS but missing", VerifyTools(td).Error())
+}
+
+func TestCheckPrerequisiteIsPresentIn(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(td, "tool"), []byte("#!/bin/sh\nexit 2\n"), 0700))
+	ut.AssertEqual(t, true, (&CheckPrerequisite{HelpCommand: []string{"tool", "-h"}, ExpectedExitCode: 2}).IsPresentIn(td))
+	ut.AssertEqual(t, false, (&CheckPrerequisite{HelpCommand: []string{"tool", "-h"}, ExpectedExitCode: 0}).IsPresentIn(td))
+	// sh is in PATH but not in td.
+	ut.AssertEqual(t, false, (&CheckPrerequisite{HelpCommand: []string{"sh", "-c", "exit 0"}}).IsPresentIn(td))
+	ut.AssertEqual(t, true, (&CheckPrerequisite{HelpCommand: []string{"go", "version"}}).IsPresentIn(td))
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"sort"
+	"strings"
+)
+
+// TrendWindow is the number of most recent recorded runs compared to the
+// runs before them to detect a trend, e.g. a check getting slower.
+const TrendWindow = 10
+
+// Trend is the evolution of a value recorded in the metrics history, e.g. the
+// duration of a check or a coverage percentage.
+type Trend struct {
+	Name string
+	// Runs is the number of recorded runs with a value.
+	Runs int
+	// Failures is the number of recorded runs where the check failed. It is
+	// only set for the durations of the checks.
+	Failures int
+	// Last is the most recent value.
+	Last float64
+	// Recent is the median of the last TrendWindow values.
+	Recent float64
+	// Previous is the median of the TrendWindow values before them, 0 if
+	// there are none.
+	Previous float64
+}
+
+// ChangePct returns the change of Recent compared to Previous in percent, 0
+// if there is no previous value.
+func (t *Trend) ChangePct() float64 {
+	if t.Previous == 0 {
+		return 0
+	}
+	return 100 * (t.Recent - t.Previous) / t.Previous
+}
+
+// DurationTrends returns the trends of the duration of each check recorded in
+// the history, which is oldest first, in the runs of any of modes, or of all
+// the runs if modes is empty. They are sorted by decreasing ChangePct, so the
+// checks getting slower are first.
+func DurationTrends(history []MetricsRecord, modes []Mode) []Trend {
+	series := map[string][]float64{}
+	failures := map[string]int{}
+	for i := range history {
+		if !history[i].InModes(modes) {
+			continue
+		}
+		for name, d := range history[i].Durations {
+			series[name] = append(series[name], d)
+			if ok, found := history[i].Results[name]; found && !ok {
+				failures[name]++
+			}
+		}
+	}
+	out := trends(series)
+	for i := range out {
+		out[i].Failures = failures[out[i].Name]
+	}
+	sort.Sort(trendsByChange(out))
+	return out
+}
+
+// MetricTrends returns the trends of the metrics starting with prefix, e.g.
+// "coverage.", in the runs of any of modes, or of all the runs if modes is
+// empty. They are sorted by name.
+func MetricTrends(history []MetricsRecord, modes []Mode, prefix string) []Trend {
+	series := map[string][]float64{}
+	for i := range history {
+		if !history[i].InModes(modes) {
+			continue
+		}
+		for name, v := range history[i].Metrics {
+			if strings.HasPrefix(name, prefix) {
+				series[name] = append(series[name], v)
+			}
+		}
+	}
+	out := trends(series)
+	sort.Sort(trendsByName(out))
+	return out
+}
+
+// Private stuff.
+
+// trends returns the trend of each series of values, oldest first.
+func trends(series map[string][]float64) []Trend {
+	out := make([]Trend, 0, len(series))
+	for name, values := range series {
+		t := Trend{Name: name, Runs: len(values), Last: values[len(values)-1]}
+		recent := values
+		if len(values) > TrendWindow {
+			recent = values[len(values)-TrendWindow:]
+			previous := values[:len(values)-TrendWindow]
+			if len(previous) > TrendWindow {
+				previous = previous[len(previous)-TrendWindow:]
+			}
+			t.Previous = median(previous)
+		}
+		t.Recent = median(recent)
+		out = append(out, t)
+	}
+	return out
+}
+
+// median returns the median of values, which must not be empty.
+func median(values []float64) float64 {
+	s := append([]float64{}, values...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
+
+type trendsByName []Trend
+
+func (t trendsByName) Len() int           { return len(t) }
+func (t trendsByName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t trendsByName) Less(i, j int) bool { return t[i].Name < t[j].Name }
+
+type trendsByChange []Trend
+
+func (t trendsByChange) Len() int      { return len(t) }
+func (t trendsByChange) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t trendsByChange) Less(i, j int) bool {
+	if a, b := t[i].ChangePct(), t[j].ChangePct(); a != b {
+		return a > b
+	}
+	return t[i].Name < t[j].Name
+}
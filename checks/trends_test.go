@@ -0,0 +1,54 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestDurationTrends(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, []Trend{}, DurationTrends(nil, nil))
+	var history []MetricsRecord
+	for i := 0; i < 2*TrendWindow; i++ {
+		d := 1.
+		if i >= TrendWindow {
+			d = 2
+		}
+		history = append(history, MetricsRecord{
+			Modes:     []Mode{PrePush},
+			Durations: map[string]float64{"gotest": d, "gofmt": 0.5},
+			Results:   map[string]bool{"gotest": i != 3, "gofmt": true},
+		})
+	}
+	history = append(history, MetricsRecord{Modes: []Mode{PreCommit}, Durations: map[string]float64{"gofmt": 0.25}})
+	expected := []Trend{
+		{Name: "gotest", Runs: 20, Failures: 1, Last: 2, Recent: 2, Previous: 1},
+		{Name: "gofmt", Runs: 21, Last: 0.25, Recent: 0.5, Previous: 0.5},
+	}
+	trends := DurationTrends(history, nil)
+	ut.AssertEqual(t, expected, trends)
+	ut.AssertEqual(t, 100., trends[0].ChangePct())
+	ut.AssertEqual(t, 0., trends[1].ChangePct())
+
+	expected = []Trend{{Name: "gofmt", Runs: 1, Last: 0.25, Recent: 0.25}}
+	ut.AssertEqual(t, expected, DurationTrends(history, []Mode{PreCommit}))
+}
+
+func TestMetricTrends(t *testing.T) {
+	t.Parallel()
+	history := []MetricsRecord{
+		{Metrics: map[string]float64{"coverage.global": 50, "coverage.foo": 80, "binary_size.pcg": 10}},
+		{Metrics: map[string]float64{"coverage.global": 60}},
+		{Metrics: map[string]float64{"coverage.global": 70}},
+	}
+	expected := []Trend{
+		{Name: "coverage.foo", Runs: 1, Last: 80, Recent: 80},
+		{Name: "coverage.global", Runs: 3, Last: 70, Recent: 60},
+	}
+	ut.AssertEqual(t, expected, MetricTrends(history, nil, "coverage."))
+}
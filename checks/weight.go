@@ -0,0 +1,41 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+// Weight is how a check loads the machine. Runner uses it to schedule the
+// checks: the lightest are started first so their failures are reported
+// quickly.
+type Weight int
+
+// Weights of the checks, from the lightest.
+const (
+	// WeightNative is a check running in process without starting any
+	// process, e.g. inspecting the AST of the modified files. The native
+	// checks are run before the other ones.
+	WeightNative Weight = iota
+	// WeightProcess is a check starting a few processes. It is the default.
+	WeightProcess
+	// WeightPerPackage is a check starting a process per package, e.g. go
+	// test. Each process leases its own run token.
+	WeightPerPackage
+	// WeightExclusive is a check starting processes that already use all the
+	// CPUs, e.g. go build. Its processes are serialized with all the others.
+	WeightExclusive
+)
+
+// Weighter is implemented by the checks to tell Runner how to schedule them.
+type Weighter interface {
+	// Weight returns how the check loads the machine.
+	Weight() Weight
+}
+
+// CheckWeight returns the weight of c, or WeightProcess if c doesn't
+// implement Weighter.
+func CheckWeight(c Check) Weight {
+	if w, ok := c.(Weighter); ok {
+		return w.Weight()
+	}
+	return WeightProcess
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestCheckWeight(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, WeightNative, CheckWeight(&Secrets{}))
+	ut.AssertEqual(t, WeightProcess, CheckWeight(&Gofmt{}))
+	ut.AssertEqual(t, WeightPerPackage, CheckWeight(&Test{}))
+	ut.AssertEqual(t, WeightExclusive, CheckWeight(&Build{}))
+	ut.AssertEqual(t, WeightProcess, CheckWeight(&runnerCheck{}))
+}
+
+func TestLeaseRunTokenExclusive(t *testing.T) {
+	t.Parallel()
+	l := &sync.RWMutex{}
+	o := &Options{runTokens: make(chan struct{}, 2), procLock: l}
+	e := &Options{runTokens: o.runTokens, procLock: l, exclusive: true}
+	o.LeaseRunToken()
+	ut.AssertEqual(t, 1, len(o.runTokens))
+	o.ReturnRunToken()
+	ut.AssertEqual(t, 0, len(o.runTokens))
+	// The exclusive lease takes the lock instead of a token.
+	e.LeaseRunToken()
+	ut.AssertEqual(t, 0, len(o.runTokens))
+	e.ReturnRunToken()
+	// Both leases were returned.
+	l.Lock()
+	l.Unlock()
+}
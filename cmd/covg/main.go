@@ -6,6 +6,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -44,13 +45,28 @@ func printProfile(settings *checks.CoverageSettings, profile checks.CoverageProf
 }
 
 func mainImpl() error {
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		return mergeImpl(os.Args[2:])
+	}
 	minFlag := flag.Float64("min", 1, "minimum expected coverage in %")
 	maxFlag := flag.Float64("max", 100, "maximum expected coverage in %")
 	globalFlag := flag.Bool("g", false, "use global coverage")
 	verboseFlag := flag.Bool("v", false, "enable logging")
 	ignoreFlag := scm.IgnorePatterns{}
 	flag.Var(&ignoreFlag, "i", "glob to ignore, use multiple times")
+	profileFlag := profiles{}
+	flag.Var(&profileFlag, "profile", "existing coverage profile to analyze instead of running the tests, in any mode; additional profiles can be listed as arguments")
+	strictFlag := flag.Bool("strict", false, "fail when a profile entry can't be mapped to a file in the tree")
+	watchFlag := flag.Bool("watch", false, "after the first run, re-run the coverage of the packages with modified files each time a file is saved, until Ctrl-C")
 	flag.Parse()
+	if *watchFlag && len(profileFlag) != 0 {
+		return errors.New("-watch can't be used with -profile")
+	}
+	if len(profileFlag) != 0 {
+		profileFlag = append(profileFlag, flag.Args()...)
+	} else if flag.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments %s; use -profile to analyze existing profiles", flag.Args())
+	}
 
 	log.SetFlags(log.Lmicroseconds)
 	if !*verboseFlag {
@@ -76,6 +92,7 @@ func mainImpl() error {
 			MinCoverage: *minFlag,
 			MaxCoverage: *maxFlag,
 		},
+		Strict: *strictFlag,
 	}
 
 	// TODO(maruel): Run tests ala pcg; e.g. determine what diff to use.
@@ -85,31 +102,113 @@ func mainImpl() error {
 	if err != nil {
 		return err
 	}
-	log.Printf("Packages: %s\n", change.All().TestPackages())
-	profile, err := c.RunProfile(change, &checks.Options{MaxDuration: 999})
+	// Profiles produced elsewhere may cover packages without tests, e.g. with
+	// -coverpkg.
+	pkgs := change.All().TestPackages()
+	var profile checks.CoverageProfile
+	if len(profileFlag) != 0 {
+		pkgs = change.All().Packages()
+		profile, err = c.LoadProfiles(change, profileFlag...)
+	} else {
+		profile, err = c.RunProfile(context.Background(), change, &checks.Options{MaxDuration: 999})
+	}
+	log.Printf("Packages: %s\n", pkgs)
 	if err != nil {
 		return err
 	}
+	err = printReport(&c, pkgs, profile)
+	if *watchFlag {
+		if err == errSilent {
+			err = nil
+		}
+		if err != nil {
+			fmt.Printf("%s\n", err)
+		}
+		return watch(repo, &c, ignoreFlag)
+	}
+	return err
+}
 
-	if *globalFlag {
+// printReport prints the per-function table of profile, globally or for each
+// package of pkgs. It returns errSilent if the coverage is out of the
+// thresholds.
+func printReport(c *checks.Coverage, pkgs []string, profile checks.CoverageProfile) error {
+	if c.UseGlobalInference {
 		if !printProfile(&c.Global, profile, "") {
 			return errSilent
 		}
-	} else {
-		for _, pkg := range change.All().TestPackages() {
-			d := pkgToDir(pkg)
-			subset := profile.Subset(d)
-			if len(subset) != 0 {
-				fmt.Printf("%s\n", d)
-				if !printProfile(&c.Global, subset, "  ") {
-					err = errSilent
-				}
-			} else {
-				log.Printf("%s is empty", pkg)
+		return nil
+	}
+	var err error
+	for _, pkg := range pkgs {
+		d := pkgToDir(pkg)
+		subset := profile.Subset(d)
+		if len(subset) != 0 {
+			fmt.Printf("%s\n", d)
+			if !printProfile(&c.Global, subset, "  ") {
+				err = errSilent
 			}
+		} else {
+			log.Printf("%s is empty", pkg)
 		}
 	}
-	return nil
+	return err
+}
+
+// mergeImpl implements 'covg merge a.cov b.cov -o merged.cov'.
+func mergeImpl(args []string) error {
+	fs := flag.NewFlagSet("covg merge", flag.ExitOnError)
+	outFlag := fs.String("o", "-", "file to write the merged profile to; - for stdout")
+	pkgFlag := fs.String("pkg", "", "import path the file names in the profiles are normalized to; defaults to the one of the repository in the current directory")
+	verboseFlag := fs.Bool("v", false, "enable logging")
+	// The profiles and the flags can be interleaved.
+	var files []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		files = append(files, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(files) == 0 {
+		return errors.New("usage: covg merge <profile>... [-o merged.cov]")
+	}
+
+	log.SetFlags(log.Lmicroseconds)
+	if !*verboseFlag {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	pkg := *pkgFlag
+	if pkg == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if repo, err := scm.GetRepo(cwd, ""); err != nil {
+			log.Printf("not normalizing the file names: %s", err)
+		} else if change, err := repo.Between(scm.Current, scm.Initial, nil); err != nil {
+			log.Printf("not normalizing the file names: %s", err)
+		} else if change != nil {
+			pkg = change.Package()
+		}
+	}
+	log.Printf("Package: %s", pkg)
+	if *outFlag == "-" {
+		return checks.MergeProfiles(os.Stdout, pkg, files...)
+	}
+	f, err := os.Create(*outFlag)
+	if err != nil {
+		return err
+	}
+	if err := checks.MergeProfiles(f, pkg, files...); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
 func main() {
@@ -121,6 +220,18 @@ func main() {
 	}
 }
 
+// profiles is a flag.Value accumulating coverage profile file names.
+type profiles []string
+
+func (p *profiles) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *profiles) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 func pkgToDir(p string) string {
 	if p == "." {
 		return p
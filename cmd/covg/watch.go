@@ -0,0 +1,94 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// watchQuiet is how long the files must stop changing before 'covg -watch'
+// runs the tests, so a save of multiple files triggers a single run.
+const watchQuiet = 100 * time.Millisecond
+
+// watch re-runs the coverage of the packages with modified Go files, and of
+// the packages importing them, each time files are saved and prints their
+// per-function table, until Ctrl-C. With global inference, all the tests are
+// run again.
+func watch(repo scm.ReadOnlyRepo, c *checks.Coverage, ignore scm.IgnorePatterns) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+	go func() {
+		select {
+		case <-interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	scm.CacheImports(true)
+	defer scm.CacheImports(false)
+	w, err := internal.NewWatcher(repo.Root(), ignore.Match)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	fmt.Printf("\nwatching %s for modifications; press Ctrl-C to stop\n", repo.Root())
+	for {
+		modified, err := w.Next(ctx, watchQuiet)
+		if err != nil {
+			return err
+		}
+		if modified == nil {
+			return nil
+		}
+		if files := goFiles(modified); len(files) != 0 {
+			fmt.Printf("\n%s %s\n", time.Now().Format("15:04:05"), strings.Join(files, ", "))
+			if err := rerun(ctx, repo, c, files, ignore); err != nil && err != errSilent && ctx.Err() == nil {
+				fmt.Printf("%s\n", err)
+			}
+		}
+	}
+}
+
+// rerun runs the coverage of the packages affected by the modified files and
+// prints it.
+func rerun(ctx context.Context, repo scm.ReadOnlyRepo, c *checks.Coverage, files []string, ignore scm.IgnorePatterns) error {
+	change, err := scm.FromFiles(repo, files, ignore)
+	if err != nil || change == nil {
+		return err
+	}
+	pkgs := change.Indirect().TestPackages()
+	log.Printf("Packages: %s\n", pkgs)
+	profile, err := c.RunProfile(ctx, change, &checks.Options{MaxDuration: 999})
+	if err != nil {
+		return err
+	}
+	return printReport(c, pkgs, profile)
+}
+
+// goFiles returns the Go source files of files.
+func goFiles(files []string) []string {
+	var out []string
+	for _, f := range files {
+		if path.Ext(f) == ".go" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,382 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// doctorStatus is the outcome of a verification of 'pcg doctor'.
+type doctorStatus int
+
+const (
+	doctorOK doctorStatus = iota
+	// doctorWarn is a likely problem that doesn't prevent pcg from working.
+	doctorWarn
+	// doctorFail prevents pcg from working correctly.
+	doctorFail
+)
+
+func (d doctorStatus) String() string {
+	switch d {
+	case doctorOK:
+		return "ok"
+	case doctorWarn:
+		return "warn"
+	default:
+		return "FAIL"
+	}
+}
+
+// diagnosis is the result of a verification; fix is the remediation step, if
+// any.
+type diagnosis struct {
+	status doctorStatus
+	msg    string
+	fix    string
+}
+
+// doctorSection is a group of verifications.
+type doctorSection struct {
+	name      string
+	diagnoses []diagnosis
+}
+
+// cmdDoctor verifies the environment end to end and prints the remediation
+// steps of the problems found. Returns an error if pcg can't work correctly.
+func (a *application) cmdDoctor(ctx context.Context, w io.Writer, repo scm.Repo, configPath string) error {
+	root := repo.Root()
+	sections := []doctorSection{
+		{"git", doctorGit(ctx, root)},
+		{"hooks", doctorHooks(ctx, root)},
+		{"configuration", a.doctorConfig(repo, configPath)},
+		{"prerequisites", a.doctorPrerequisites(ctx)},
+		{"go environment", a.doctorGoEnv(ctx, repo)},
+		{"stash", a.doctorStash(ctx, repo)},
+	}
+	return printDiagnoses(w, sections)
+}
+
+// printDiagnoses prints the diagnoses and returns an error if a verification
+// failed.
+func printDiagnoses(w io.Writer, sections []doctorSection) error {
+	failures := 0
+	warnings := 0
+	for i, s := range sections {
+		if i != 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "%s:\n", s.name)
+		for _, d := range s.diagnoses {
+			switch d.status {
+			case doctorWarn:
+				warnings++
+			case doctorFail:
+				failures++
+			}
+			fmt.Fprintf(w, "  %-4s  %s\n", d.status, d.msg)
+			if d.fix != "" {
+				fmt.Fprintf(w, "        fix: %s\n", d.fix)
+			}
+		}
+	}
+	if failures != 0 {
+		return fmt.Errorf("found %d problems and %d warnings", failures, warnings)
+	}
+	if warnings != 0 {
+		_, err := fmt.Fprintf(w, "\nFound %d warnings.\n", warnings)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nNo problem found.\n")
+	return err
+}
+
+// doctorGit verifies git works and supports the features pcg uses.
+func doctorGit(ctx context.Context, root string) []diagnosis {
+	out, code, err := internal.Capture(ctx, root, nil, "git", "version")
+	out = strings.TrimSpace(out)
+	if err != nil || code != 0 {
+		return []diagnosis{{doctorFail, fmt.Sprintf("git doesn't work: %s %s", err, out), "install git and add it to PATH"}}
+	}
+	v := scm.ParseGitVersion(out)
+	if v == nil {
+		return []diagnosis{{doctorWarn, fmt.Sprintf("unrecognized git version %q", out), ""}}
+	}
+	return diagnoseGitFeatures(out, v)
+}
+
+// diagnoseGitFeatures reports the features of pcg that the git version v
+// doesn't support. A feature with a fallback is only a warning.
+func diagnoseGitFeatures(out string, v scm.GitVersion) []diagnosis {
+	var diags []diagnosis
+	for _, f := range scm.GitFeatures {
+		if v.AtLeast(f.Min) {
+			continue
+		}
+		status := doctorFail
+		if f == scm.GitStashPush || f == scm.GitWorktreeRemove {
+			status = doctorWarn
+		}
+		diags = append(diags, diagnosis{status, fmt.Sprintf("%s lacks %s (git %s)", out, f.Name, f.Min), fmt.Sprintf("upgrade git to %s or later", f.Min)})
+	}
+	if len(diags) == 0 {
+		diags = append(diags, diagnosis{doctorOK, out, ""})
+	}
+	return diags
+}
+
+// doctorHooks verifies the hooks are installed and can run pcg.
+func doctorHooks(ctx context.Context, root string) []diagnosis {
+	if checks.IsContinuousIntegration() {
+		return []diagnosis{{doctorOK, "running under CI; the hooks are not needed", ""}}
+	}
+	if err := scm.GitPath.Require(); err != nil {
+		return []diagnosis{{doctorFail, err.Error(), fmt.Sprintf("upgrade git to %s or later", scm.GitPath.Min)}}
+	}
+	// It takes core.hooksPath into account.
+	out, code, err := internal.Capture(ctx, root, nil, "git", "rev-parse", "--git-path", "hooks")
+	if err != nil || code != 0 {
+		return []diagnosis{{doctorFail, fmt.Sprintf("failed to find the hooks directory: %s %s", err, out), ""}}
+	}
+	dir := strings.TrimSpace(out)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	var diags []diagnosis
+	for _, t := range []string{"pre-commit", "pre-push"} {
+		diags = append(diags, diagnoseHook(dir, t))
+	}
+	return append(diags, diagnosePcg(ctx, root))
+}
+
+// diagnoseHook verifies the hook t in dir.
+func diagnoseHook(dir, t string) diagnosis {
+	p := filepath.Join(dir, t)
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return diagnosis{doctorWarn, fmt.Sprintf("%s is not installed", p), "run 'pcg install'"}
+	}
+	if err != nil {
+		return diagnosis{doctorFail, err.Error(), ""}
+	}
+	if !bytes.Contains(b, []byte(hookMarker)) {
+		return diagnosis{doctorWarn, fmt.Sprintf("%s is not pcg's hook", p), fmt.Sprintf("run 'pcg install' to move it to %s%s and run it from pcg's hook", t, chainedSuffix)}
+	}
+	if fi, err := os.Stat(p); err == nil && fi.Mode()&0111 == 0 && runtime.GOOS != "windows" {
+		return diagnosis{doctorFail, fmt.Sprintf("%s is not executable so git ignores it", p), fmt.Sprintf("run 'chmod +x %s'", p)}
+	}
+	if _, err := os.Stat(p + chainedSuffix); err == nil {
+		return diagnosis{doctorOK, fmt.Sprintf("%s is installed and runs %s%s first", p, t, chainedSuffix), ""}
+	}
+	return diagnosis{doctorOK, fmt.Sprintf("%s is installed", p), ""}
+}
+
+// diagnosePcg verifies the hooks run the same pcg.
+func diagnosePcg(ctx context.Context, root string) diagnosis {
+	p, err := exec.LookPath("pcg")
+	if err != nil {
+		return diagnosis{doctorFail, "pcg is not in PATH so the hooks can't run it", "run 'go get github.com/maruel/pre-commit-go/cmd/pcg' and add $GOPATH/bin to PATH"}
+	}
+	out, _, _ := internal.Capture(ctx, root, nil, p, "version")
+	if v := strings.TrimSpace(out); v != version {
+		return diagnosis{doctorWarn, fmt.Sprintf("the hooks run %s version %q, not %s", p, v, version), "reinstall pcg or fix PATH"}
+	}
+	return diagnosis{doctorOK, fmt.Sprintf("the hooks run %s", p), ""}
+}
+
+// doctorConfig verifies the configuration is found and valid.
+func (a *application) doctorConfig(repo scm.ReadOnlyRepo, configPath string) []diagnosis {
+	if configPath == "<N/A>" {
+		p := filepath.Join(repo.Root(), a.configName)
+		if content, err := ioutil.ReadFile(p); err == nil {
+			if _, err = decodeConfig(content, p); err != nil {
+				return []diagnosis{{doctorFail, fmt.Sprintf("%s; the default configuration is used instead", err), "fix it; see CONFIGURATION.md"}}
+			}
+		}
+		return []diagnosis{{doctorWarn, fmt.Sprintf("no %s found; the default configuration is used", a.configName), "run 'pcg writeconfig' and commit it"}}
+	}
+	out := []diagnosis{{doctorOK, fmt.Sprintf("using %s", configPath), ""}}
+	for _, w := range a.config.Warnings() {
+		out = append(out, diagnosis{doctorWarn, w, "see CONFIGURATION.md"})
+	}
+	return out
+}
+
+// doctorPrerequisites verifies the prerequisites of the checks enabled in any
+// mode are installed.
+func (a *application) doctorPrerequisites(ctx context.Context) []diagnosis {
+	enabled, _ := a.config.EnabledChecks(checks.AllModes)
+	prereqs := map[string]checks.CheckPrerequisite{}
+	users := map[string]map[string]bool{}
+	for _, c := range enabled {
+		for _, p := range c.GetPrerequisites() {
+			prereqs[p.URL] = p
+			if users[p.URL] == nil {
+				users[p.URL] = map[string]bool{}
+			}
+			users[p.URL][c.GetName()] = true
+		}
+	}
+	if len(prereqs) == 0 {
+		return []diagnosis{{doctorOK, "no enabled check needs a prerequisite", ""}}
+	}
+	urls := make([]string, 0, len(prereqs))
+	for url := range prereqs {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	out := make([]diagnosis, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(d *diagnosis, url string) {
+			defer wg.Done()
+			p := prereqs[url]
+			if !p.IsPresent() {
+				names := make([]string, 0, len(users[url]))
+				for name := range users[url] {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				*d = diagnosis{doctorFail, fmt.Sprintf("%s is missing; needed by %s", url, strings.Join(names, ", ")), "run 'pcg prereq'"}
+				return
+			}
+			*d = diagnosis{doctorOK, url + prereqVersion(ctx, &p), ""}
+		}(&out[i], url)
+	}
+	wg.Wait()
+	return out
+}
+
+// prereqVersion returns the path and the version of the executable of the
+// prerequisite p, if known.
+func prereqVersion(ctx context.Context, p *checks.CheckPrerequisite) string {
+	if len(p.HelpCommand) == 0 || p.HelpCommand[0] == "go" {
+		// It's a package, not an executable.
+		return ""
+	}
+	exe, err := exec.LookPath(p.HelpCommand[0])
+	if err != nil {
+		return ""
+	}
+	out, _, _ := internal.Capture(ctx, "", nil, "go", "version", "-m", exe)
+	if v := moduleVersion(out); v != "" {
+		return fmt.Sprintf(": %s %s", exe, v)
+	}
+	return ": " + exe
+}
+
+// moduleVersion returns the version of the main module in the output of
+// 'go version -m', "" if unknown.
+func moduleVersion(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); len(f) >= 3 && f[0] == "mod" {
+			if f[2] == "(devel)" {
+				return ""
+			}
+			return f[2]
+		}
+	}
+	return ""
+}
+
+// doctorGoEnv verifies the go toolchain can resolve the packages of the
+// repository the same way as in the user's shell.
+func (a *application) doctorGoEnv(ctx context.Context, repo scm.ReadOnlyRepo) []diagnosis {
+	root := repo.Root()
+	out, code, err := internal.Capture(ctx, root, nil, "go", "version")
+	out = strings.TrimSpace(out)
+	if err != nil || code != 0 {
+		return []diagnosis{{doctorFail, fmt.Sprintf("go doesn't work: %s %s", err, out), "install go and add it to PATH"}}
+	}
+	diags := []diagnosis{{doctorOK, out, ""}}
+	_, errMod := os.Stat(filepath.Join(root, "go.mod"))
+	switch {
+	case internal.IsModuleMode(root):
+		diags = append(diags, diagnosis{doctorOK, "module mode", ""})
+	case errMod == nil:
+		diags = append(diags, diagnosis{doctorWarn, "go.mod is ignored since GO111MODULE=off", "unset GO111MODULE"})
+	case a.config.ImportPath != "":
+		diags = append(diags, diagnosis{doctorOK, fmt.Sprintf("GOPATH mode; the checks run in a temporary GOPATH as %s", a.config.ImportPath), ""})
+	default:
+		pkg := ""
+		if change, err := repo.Between(scm.Current, scm.Initial, a.ignorePatterns); err == nil && change != nil {
+			pkg = change.Package()
+		}
+		if pkg == "" {
+			diags = append(diags, diagnosis{doctorFail, fmt.Sprintf("%s is not in GOPATH %s and has no go.mod so its imports can't be resolved", root, repo.GOPATH()), "add a go.mod, set import_path in the configuration or clone it in $GOPATH/src"})
+		} else {
+			diags = append(diags, diagnosis{doctorOK, fmt.Sprintf("GOPATH mode as %s", pkg), ""})
+		}
+	}
+	lines, mismatches, err := goEnvReport(repo)
+	switch {
+	case err != nil:
+		diags = append(diags, diagnosis{doctorWarn, fmt.Sprintf("failed to get go env: %s", err), ""})
+	case mismatches != 0:
+		var differ []string
+		for _, l := range lines {
+			if strings.Contains(l, "(shell: ") {
+				differ = append(differ, l)
+			}
+		}
+		diags = append(diags, diagnosis{doctorWarn, fmt.Sprintf("the checks run with a different go environment than the shell: %s", strings.Join(differ, ", ")), "this is expected for GOPATH; otherwise, unset the variable or set it in the environment of git"})
+	default:
+		diags = append(diags, diagnosis{doctorOK, "the checks run with the go environment of the shell", ""})
+	}
+	return diags
+}
+
+// doctorStash verifies the modifications not in the index can be stashed and
+// restored safely around the pre-commit checks.
+func (a *application) doctorStash(ctx context.Context, repo scm.Repo) []diagnosis {
+	s := a.config.Modes[checks.PreCommit].Stash
+	if s == "never" {
+		return []diagnosis{{doctorOK, "stash is disabled; the pre-commit checks see the modifications not in the index", ""}}
+	}
+	var diags []diagnosis
+	if repo.Eval(string(scm.Head)) == scm.Invalid {
+		diags = append(diags, diagnosis{doctorWarn, "there is no commit yet so the modifications not in the index can't be stashed", "commit once; the first commit is checked as is"})
+	}
+	if repo.IsMerging() {
+		diags = append(diags, diagnosis{doctorWarn, "a merge is in progress; stashing fails while conflicts are unresolved", "resolve the conflicts or run 'git merge --abort'"})
+	}
+	if d, err := repo.ScmDir(); err == nil {
+		for _, n := range []string{"rebase-merge", "rebase-apply"} {
+			if _, err := os.Stat(filepath.Join(d, n)); err == nil {
+				diags = append(diags, diagnosis{doctorWarn, "a rebase is in progress", "run 'git rebase --continue' or 'git rebase --abort'"})
+				break
+			}
+		}
+	}
+	if out, code, err := internal.Capture(ctx, repo.Root(), nil, "git", "stash", "list"); err == nil && code == 0 {
+		if out = strings.TrimSpace(out); out != "" {
+			n := len(strings.Split(out, "\n"))
+			diags = append(diags, diagnosis{doctorWarn, fmt.Sprintf("%d stash entries exist; one may be left over by an interrupted pcg run", n), "review them with 'git stash list' and 'git stash pop' the one holding your modifications"})
+		}
+	}
+	if len(diags) == 0 {
+		if s == "" {
+			s = "always"
+		}
+		diags = append(diags, diagnosis{doctorOK, fmt.Sprintf("the modifications not in the index are stashed safely (stash: %s)", s), ""})
+	}
+	return diags
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestDiagnoseGitFeatures(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, []diagnosis{{doctorOK, "git version 2.39.5", ""}}, diagnoseGitFeatures("git version 2.39.5", scm.GitVersion{2, 39, 5}))
+	expected := []diagnosis{
+		{doctorWarn, "git version 2.7.4 lacks git stash push (git 2.13)", "upgrade git to 2.13 or later"},
+		{doctorWarn, "git version 2.7.4 lacks git worktree remove (git 2.17)", "upgrade git to 2.17 or later"},
+	}
+	diags := diagnoseGitFeatures("git version 2.7.4", scm.GitVersion{2, 7, 4})
+	ut.AssertEqual(t, doctorFail, diags[0].status)
+	ut.AssertEqual(t, expected, diags[1:])
+}
+
+func TestModuleVersion(t *testing.T) {
+	t.Parallel()
+	out := "/go/bin/golint: go1.20\n\tpath\tgolang.org/x/lint/golint\n\tmod\tgolang.org/x/lint\tv0.0.0-20210508222113-6edffad5e616\th1:abc=\n"
+	ut.AssertEqual(t, "v0.0.0-20210508222113-6edffad5e616", moduleVersion(out))
+	ut.AssertEqual(t, "", moduleVersion("/go/bin/foo: go1.20\n\tpath\tfoo\n\tmod\tfoo\t(devel)\t\n"))
+	ut.AssertEqual(t, "", moduleVersion(""))
+}
+
+func TestDiagnoseHook(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	p := filepath.Join(td, "pre-commit")
+	ut.AssertEqual(t, diagnosis{doctorWarn, p + " is not installed", "run 'pcg install'"}, diagnoseHook(td, "pre-commit"))
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte("#!/bin/sh\nexit 0\n"), 0777))
+	ut.AssertEqual(t, diagnosis{doctorWarn, p + " is not pcg's hook", "run 'pcg install' to move it to pre-commit.local and run it from pcg's hook"}, diagnoseHook(td, "pre-commit"))
+
+	ut.AssertEqual(t, nil, installHook(td, "pre-commit", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, diagnosis{doctorOK, p + " is installed and runs pre-commit.local first", ""}, diagnoseHook(td, "pre-commit"))
+}
+
+func TestPrintDiagnoses(t *testing.T) {
+	t.Parallel()
+	b := &bytes.Buffer{}
+	sections := []doctorSection{
+		{"git", []diagnosis{{doctorOK, "git version 2.39.5", ""}}},
+		{"hooks", []diagnosis{{doctorWarn, "pre-push is not installed", "run 'pcg install'"}}},
+	}
+	ut.AssertEqual(t, nil, printDiagnoses(b, sections))
+	expected := "git:\n  ok    git version 2.39.5\n\nhooks:\n  warn  pre-push is not installed\n        fix: run 'pcg install'\n\nFound 1 warnings.\n"
+	ut.AssertEqual(t, expected, b.String())
+
+	b.Reset()
+	sections[0].diagnoses[0].status = doctorFail
+	ut.AssertEqual(t, "found 1 problems and 1 warnings", printDiagnoses(b, sections).Error())
+}
+
+func TestDecodeConfig(t *testing.T) {
+	t.Parallel()
+	_, err := decodeConfig([]byte("min_version: 1000.0.0\n"), "foo.yml")
+	ut.AssertEqual(t, "foo.yml requires newer version 1000.0.0", err.Error())
+	_, err = decodeConfig([]byte("modes: [\n"), "foo.yml")
+	ut.AssertEqual(t, true, err != nil)
+	config, err := decodeConfig([]byte("min_version: 0.1\n"), "foo.yml")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "0.1", config.MinVersion)
+}
@@ -0,0 +1,136 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// exitSummaryEnvVar is the environment variable equivalent to -exit-summary,
+// so it can be set for the hooks run by git.
+const exitSummaryEnvVar = "PCG_EXIT_SUMMARY"
+
+// exitSummaryVersion is the version of the format of exitSummary. It is only
+// increased on incompatible changes; fields may be added without notice.
+const exitSummaryVersion = 1
+
+// exitSummary is the machine readable result of a pcg invocation, written as
+// a single JSON object when it exits, so a wrapper doesn't have to parse the
+// console output.
+type exitSummary struct {
+	Version    int    `json:"version"`
+	PcgVersion string `json:"pcg_version"`
+	Command    string `json:"command"`
+	// Success is false when pcg exits with a non-zero exit code.
+	Success bool `json:"success"`
+	// Error is the message printed by pcg on failure.
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"`
+	// Checks are the checks run, in the order of the runs, e.g. once per
+	// commit pushed.
+	Checks []exitSummaryCheck `json:"checks"`
+
+	start time.Time
+	dest  string
+}
+
+// exitSummaryCheck is the result of a check in exitSummary.
+type exitSummaryCheck struct {
+	Name  string        `json:"name"`
+	Modes []checks.Mode `json:"modes"`
+	// Result is one of "pass", "warning", "fail", "timeout", "interrupted" or
+	// "canceled".
+	Result   string   `json:"result"`
+	Duration float64  `json:"duration"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// newExitSummary returns the summary to write to dest when pcg exits, or nil
+// if dest is empty.
+//
+// dest is either a file descriptor number opened by the caller, e.g. 3, or
+// a path.
+func newExitSummary(command, dest string) *exitSummary {
+	if dest == "" {
+		return nil
+	}
+	return &exitSummary{
+		Version:    exitSummaryVersion,
+		PcgVersion: version,
+		Command:    command,
+		Checks:     []exitSummaryCheck{},
+		start:      time.Now(),
+		dest:       dest,
+	}
+}
+
+// add records the results of the checks run in modes.
+func (e *exitSummary) add(modes []checks.Mode, report *checks.Report) {
+	for i := range report.Results {
+		r := &report.Results[i]
+		c := exitSummaryCheck{Name: r.Name, Modes: modes, Result: resultName(r), Duration: r.Duration.Seconds()}
+		if r.Err != nil {
+			c.Error = r.Err.Error()
+		}
+		for _, w := range r.Warnings {
+			c.Warnings = append(c.Warnings, w.Error())
+		}
+		e.Checks = append(e.Checks, c)
+	}
+}
+
+// write writes the summary with the final error of pcg.
+func (e *exitSummary) write(err error) error {
+	e.Success = err == nil
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.Duration = time.Since(e.start).Seconds()
+	w, err := openExitSummary(e.dest)
+	if err != nil {
+		return err
+	}
+	err = writeExitSummary(w, e)
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// writeExitSummary writes e as JSON to w.
+func writeExitSummary(w io.Writer, e *exitSummary) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// openExitSummary opens the file descriptor number or the file dest.
+func openExitSummary(dest string) (io.WriteCloser, error) {
+	if fd, err := strconv.Atoi(dest); err == nil {
+		if fd <= 2 {
+			return nil, fmt.Errorf("-exit-summary %d would mix with the console output; use 3 or more", fd)
+		}
+		f := os.NewFile(uintptr(fd), "fd"+dest)
+		if f == nil {
+			return nil, fmt.Errorf("-exit-summary file descriptor %d is invalid", fd)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("-exit-summary file descriptor %d is not open", fd)
+		}
+		return f, nil
+	}
+	return os.Create(dest)
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestExitSummary(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	ut.AssertEqual(t, (*exitSummary)(nil), newExitSummary("run", ""))
+
+	p := filepath.Join(tmpDir, "summary.json")
+	e := newExitSummary("run-hook", p)
+	e.add([]checks.Mode{checks.PreCommit}, &checks.Report{Results: []checks.Result{
+		{Name: "gofmt", Duration: time.Second},
+		{Name: "test", Duration: 2 * time.Second, Err: errors.New("it broke"), Warnings: []error{errors.New("slow")}},
+	}})
+	ut.AssertEqual(t, nil, e.write(errors.New("checks failed in 2.00s")))
+	content, err := ioutil.ReadFile(p)
+	ut.AssertEqual(t, nil, err)
+	var actual map[string]interface{}
+	ut.AssertEqual(t, nil, json.Unmarshal(content, &actual))
+	ut.AssertEqual(t, float64(exitSummaryVersion), actual["version"])
+	ut.AssertEqual(t, "run-hook", actual["command"])
+	ut.AssertEqual(t, false, actual["success"])
+	ut.AssertEqual(t, "checks failed in 2.00s", actual["error"])
+	expected := []interface{}{
+		map[string]interface{}{"name": "gofmt", "modes": []interface{}{"pre-commit"}, "result": "pass", "duration": 1.},
+		map[string]interface{}{"name": "test", "modes": []interface{}{"pre-commit"}, "result": "fail", "duration": 2., "error": "it broke", "warnings": []interface{}{"slow"}},
+	}
+	ut.AssertEqual(t, expected, actual["checks"])
+}
+
+func TestExitSummaryFD(t *testing.T) {
+	// Not parallel: write closes the file descriptor owned by w, which must
+	// not be reused before w is closed.
+	r, w, err := os.Pipe()
+	ut.AssertEqual(t, nil, err)
+	defer r.Close()
+	e := newExitSummary("run", strconv.Itoa(int(w.Fd())))
+	ut.AssertEqual(t, nil, e.write(nil))
+	_ = w.Close()
+	content, err := ioutil.ReadAll(r)
+	ut.AssertEqual(t, nil, err)
+	var actual exitSummary
+	ut.AssertEqual(t, nil, json.Unmarshal(content, &actual))
+	ut.AssertEqual(t, true, actual.Success)
+	ut.AssertEqual(t, []exitSummaryCheck{}, actual.Checks)
+
+	ut.AssertEqual(t, errors.New("-exit-summary 2 would mix with the console output; use 3 or more"), newExitSummary("run", "2").write(nil))
+}
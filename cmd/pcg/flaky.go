@@ -0,0 +1,81 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// flakyChecks returns the checks currently flaky according to the metrics
+// history in the storage s.
+func flakyChecks(s checks.Storage) map[string]checks.FlakyStat {
+	history, err := checks.LoadMetricsHistory(s)
+	if err != nil {
+		log.Printf("failed to load %s: %s", checks.MetricsHistoryFile, err)
+		return nil
+	}
+	out := map[string]checks.FlakyStat{}
+	for _, s := range checks.FlakyStats(history) {
+		if s.IsFlaky() {
+			out[s.Name] = s
+		}
+	}
+	return out
+}
+
+// fingerprint returns a hash identifying the content checked in modes: the
+// HEAD commit and the content of the changed files.
+func fingerprint(change scm.Change, modes []checks.Mode) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", modes, change.Repo().Eval(string(scm.Head)))
+	for _, f := range change.Changed().Files() {
+		content := change.Content(f)
+		fmt.Fprintf(h, "%s\x00%d\x00", f, len(content))
+		_, _ = h.Write(content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cmdFlaky prints the recent results of the checks and test packages recorded
+// in the metrics history.
+func (a *application) cmdFlaky(w io.Writer, repo scm.ReadOnlyRepo) error {
+	history, err := a.loadMetricsHistory(repo)
+	if err != nil {
+		return err
+	}
+	stats := checks.FlakyStats(history)
+	if len(stats) == 0 {
+		_, err = fmt.Fprintf(w, "No check result recorded yet.\n")
+		return err
+	}
+	max := len("name")
+	for _, s := range stats {
+		if len(s.Name) > max {
+			max = len(s.Name)
+		}
+	}
+	fmt.Fprintf(w, "%-*s  runs  failures  flips\n", max, "name")
+	for _, s := range stats {
+		status := ""
+		if s.IsFlaky() {
+			status = "  FLAKY"
+			if a.config.QuarantineFlaky {
+				status += " (quarantined)"
+			}
+		}
+		if _, err = fmt.Fprintf(w, "%-*s  %4d  %8d  %5d%s\n", max, s.Name, s.Runs, s.Failures, s.Flips, status); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "\nStatistics over the last %d runs of each; a check is flaky when its result\nflipped without related change.\n", checks.FlakyWindow)
+	return err
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// gerritConfig is the configuration to publish robot comments on a Gerrit
+// change.
+type gerritConfig struct {
+	// URL is the Gerrit server base url, e.g. https://review.example.com.
+	URL      string
+	Change   string
+	Patchset string
+	Username string
+	Password string
+}
+
+// gerritFromEnv returns the Gerrit configuration as defined by the
+// environment, or nil if not running against a Gerrit change.
+//
+// GERRIT_CHANGE_NUMBER and GERRIT_PATCHSET_NUMBER are set by the Jenkins Gerrit
+// Trigger plugin and most Gerrit aware CI systems. GERRIT_URL, GERRIT_USERNAME
+// and GERRIT_PASSWORD (HTTP password) must be set explicitly.
+func gerritFromEnv() *gerritConfig {
+	g := &gerritConfig{
+		URL:      strings.TrimRight(os.Getenv("GERRIT_URL"), "/"),
+		Change:   os.Getenv("GERRIT_CHANGE_NUMBER"),
+		Patchset: os.Getenv("GERRIT_PATCHSET_NUMBER"),
+		Username: os.Getenv("GERRIT_USERNAME"),
+		Password: os.Getenv("GERRIT_PASSWORD"),
+	}
+	if g.URL == "" || g.Change == "" || g.Patchset == "" || g.Username == "" || g.Password == "" {
+		return nil
+	}
+	return g
+}
+
+// gerritReporter posts the findings as robot comments on a Gerrit change in
+// addition to forwarding everything to another reporter.
+type gerritReporter struct {
+	inner    reporter
+	config   *gerritConfig
+	client   *http.Client
+	findings []finding
+	values   map[string]float64
+}
+
+func newGerritReporter(inner reporter, config *gerritConfig) *gerritReporter {
+	return &gerritReporter{
+		inner:  inner,
+		config: config,
+		client: &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (g *gerritReporter) failure(check string, err error) {
+	g.inner.failure(check, err)
+	g.findings = append(g.findings, parseFindings(check, err)...)
+}
+
+func (g *gerritReporter) results(r []checks.Result) {
+	g.inner.results(r)
+}
+
+func (g *gerritReporter) warning(check string, err error) {
+	g.inner.warning(check, err)
+}
+
+func (g *gerritReporter) metrics(m map[string]float64) {
+	g.inner.metrics(m)
+	g.values = m
+}
+
+func (g *gerritReporter) logs(l map[string]string) {
+	g.inner.logs(l)
+}
+
+func (g *gerritReporter) flush() error {
+	err := g.inner.flush()
+	if len(g.findings) == 0 && len(g.values) == 0 {
+		return err
+	}
+	if err2 := g.publish(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// publish sends a review with robot comments.
+//
+// See https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#set-review
+func (g *gerritReporter) publish() error {
+	type robotComment struct {
+		RobotID    string `json:"robot_id"`
+		RobotRunID string `json:"robot_run_id"`
+		Line       int    `json:"line,omitempty"`
+		Message    string `json:"message"`
+	}
+	review := struct {
+		Message       string                    `json:"message"`
+		RobotComments map[string][]robotComment `json:"robot_comments,omitempty"`
+	}{RobotComments: map[string][]robotComment{}}
+	runID := time.Now().UTC().Format("20060102T150405Z")
+	var general []string
+	for _, f := range g.findings {
+		if f.file == "" {
+			general = append(general, fmt.Sprintf("%s: %s", f.check, f.message))
+			continue
+		}
+		c := robotComment{
+			RobotID:    "pcg-" + f.check,
+			RobotRunID: runID,
+			Line:       f.line,
+			Message:    f.message,
+		}
+		review.RobotComments[f.file] = append(review.RobotComments[f.file], c)
+	}
+	review.Message = fmt.Sprintf("pcg found %d issue(s)", len(g.findings))
+	if len(general) != 0 {
+		review.Message += ":\n\n" + strings.Join(general, "\n\n")
+	}
+	if len(g.values) != 0 {
+		review.Message += "\n\nMetrics:"
+		for _, name := range checks.SortedMetricNames(g.values) {
+			review.Message += fmt.Sprintf("\n  %s: %g", name, g.values[name])
+		}
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/a/changes/%s/revisions/%s/review", g.config.URL, g.config.Change, g.config.Patchset)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.SetBasicAuth(g.config.Username, g.config.Password)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish to gerrit: %s", err)
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to publish to gerrit: %s\n%s", resp.Status, out)
+	}
+	log.Printf("published %d findings to %s", len(g.findings), url)
+	return nil
+}
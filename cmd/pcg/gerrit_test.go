@@ -0,0 +1,49 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestGerritReporter(t *testing.T) {
+	var body map[string]interface{}
+	path := ""
+	user := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, _, _ = r.BasicAuth()
+		ut.AssertEqual(t, nil, json.NewDecoder(r.Body).Decode(&body))
+		w.Write([]byte(")]}'\n{}"))
+	}))
+	defer ts.Close()
+
+	b := &bytes.Buffer{}
+	g := newGerritReporter(&textReporter{w: b}, &gerritConfig{ts.URL, "12", "3", "bot", "secret"})
+	ut.AssertEqual(t, nil, g.flush())
+	ut.AssertEqual(t, "", path)
+
+	g.failure("golint", errors.New("golint failed:\nfoo.go:3:1: bad"))
+	g.failure("test", errors.New("it broke"))
+	ut.AssertEqual(t, nil, g.flush())
+	ut.AssertEqual(t, "/a/changes/12/revisions/3/review", path)
+	ut.AssertEqual(t, "bot", user)
+	ut.AssertEqual(t, "pcg found 2 issue(s):\n\ntest: it broke", body["message"])
+	comments := body["robot_comments"].(map[string]interface{})["foo.go"].([]interface{})
+	ut.AssertEqual(t, 1, len(comments))
+	ut.AssertEqual(t, "bad", comments[0].(map[string]interface{})["message"])
+	ut.AssertEqual(t, "--- FAIL: golint\ngolint failed:\nfoo.go:3:1: bad\n--- FAIL: test\nit broke\n", b.String())
+
+	g.metrics(map[string]float64{"gocyclo.max": 12, "coverage.global": 75.5})
+	ut.AssertEqual(t, nil, g.flush())
+	ut.AssertEqual(t, "pcg found 2 issue(s):\n\ntest: it broke\n\nMetrics:\n  coverage.global: 75.5\n  gocyclo.max: 12", body["message"])
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// goEnvReport compares the go environment seen by the checks' subprocesses
+// with the one of the user's shell.
+//
+// Mismatches are a common reason for "it passes in my terminal but fails in
+// the hook". It returns one line per variable and the number of mismatches.
+func goEnvReport(repo scm.ReadOnlyRepo) ([]string, int, error) {
+	shell, err := internal.EffectiveGoEnv(repo.Root(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	sub, err := internal.EffectiveGoEnv(repo.Root(), internal.GoEnv(repo.Root(), repo.GOPATH()))
+	if err != nil {
+		return nil, 0, err
+	}
+	mismatches := 0
+	lines := make([]string, 0, len(internal.GoEnvVars))
+	for _, k := range internal.GoEnvVars {
+		if shell[k] == sub[k] {
+			lines = append(lines, fmt.Sprintf("%s=%q", k, sub[k]))
+		} else {
+			mismatches++
+			lines = append(lines, fmt.Sprintf("%s=%q (shell: %q)", k, sub[k], shell[k]))
+		}
+	}
+	return lines, mismatches, nil
+}
+
+// logGoEnv logs the go environment used by checks.
+func logGoEnv(repo scm.ReadOnlyRepo) {
+	lines, mismatches, err := goEnvReport(repo)
+	if err != nil {
+		log.Printf("failed to get go env: %s", err)
+		return
+	}
+	log.Printf("go env for checks (%d differ from shell):", mismatches)
+	for _, l := range lines {
+		log.Printf("  %s", l)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// cmdHistory prints the trends of the duration of the checks and of the
+// coverage recorded in the metrics history, or the recorded runs of the
+// check named in args.
+func (a *application) cmdHistory(w io.Writer, repo scm.ReadOnlyRepo, modes []checks.Mode, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("specify at most one check, e.g. 'pcg history gotest'")
+	}
+	history, err := a.loadMetricsHistory(repo)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return printCheckHistory(w, history, modes, args[0])
+	}
+	durations := checks.DurationTrends(history, modes)
+	if len(durations) == 0 {
+		_, err = fmt.Fprintf(w, "No check duration recorded yet.\n")
+		return err
+	}
+	max := len("name")
+	for _, t := range durations {
+		if len(t.Name) > max {
+			max = len(t.Name)
+		}
+	}
+	fmt.Fprintf(w, "%-*s  runs  failures      last    recent  previous   change\n", max, "name")
+	for i := range durations {
+		t := &durations[i]
+		fmt.Fprintf(w, "%-*s  %4d  %8d  %8s  %8s  %8s  %s\n", max, t.Name, t.Runs, t.Failures, formatSeconds(t.Last), formatSeconds(t.Recent), formatSeconds(t.Previous), formatChange(t))
+	}
+	if coverage := checks.MetricTrends(history, modes, "coverage."); len(coverage) != 0 {
+		max = len("coverage")
+		for _, t := range coverage {
+			if len(t.Name) > max {
+				max = len(t.Name)
+			}
+		}
+		fmt.Fprintf(w, "\n%-*s  runs     last   recent  previous   change\n", max, "coverage")
+		for i := range coverage {
+			t := &coverage[i]
+			fmt.Fprintf(w, "%-*s  %4d  %6.1f%%  %6.1f%%  %7s  %s\n", max, t.Name, t.Runs, t.Last, t.Recent, formatPct(t.Previous), formatChange(t))
+		}
+	}
+	_, err = fmt.Fprintf(w, "\nrecent is the median of the last %d runs, previous of the %d runs before them.\n", checks.TrendWindow, checks.TrendWindow)
+	return err
+}
+
+// loadMetricsHistory returns the metrics history in the configured storage of
+// the repository.
+func (a *application) loadMetricsHistory(repo scm.ReadOnlyRepo) (history []checks.MetricsRecord, err error) {
+	s, err := checks.OpenStorage(repo, a.config.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err2 := s.Close(); err == nil {
+			err = err2
+		}
+	}()
+	return checks.LoadMetricsHistory(s)
+}
+
+// printCheckHistory prints each recorded run of the check name, oldest first.
+func printCheckHistory(w io.Writer, history []checks.MetricsRecord, modes []checks.Mode, name string) error {
+	found := false
+	for i := range history {
+		rec := &history[i]
+		d, ok := rec.Durations[name]
+		if !ok || !rec.InModes(modes) {
+			continue
+		}
+		if !found {
+			fmt.Fprintf(w, "%-19s  %-12s  %8s  result  modes\n", "time", "commit", "duration")
+			found = true
+		}
+		result := "pass"
+		if ok, recorded := rec.Results[name]; recorded && !ok {
+			result = "fail"
+		}
+		commit := string(rec.Commit)
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		m := make([]string, 0, len(rec.Modes))
+		for _, mode := range rec.Modes {
+			m = append(m, string(mode))
+		}
+		if _, err := fmt.Fprintf(w, "%-19s  %-12s  %8s  %-6s  %s\n", rec.Time.Local().Format("2006-01-02 15:04:05"), commit, formatSeconds(d), result, strings.Join(m, ",")); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("no run of %s recorded", name)
+	}
+	return nil
+}
+
+// formatSeconds returns the duration d in seconds for display, "-" if it is
+// 0.
+func formatSeconds(d float64) string {
+	if d == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%1.2fs", d)
+}
+
+// formatPct returns the percentage v for display, "-" if it is 0.
+func formatPct(v float64) string {
+	if v == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", v)
+}
+
+// formatChange returns the change of the trend t in percent, "-" if there is
+// no previous value.
+func formatChange(t *checks.Trend) string {
+	if t.Previous == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+6.1f%%", t.ChangePct())
+}
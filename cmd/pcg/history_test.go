@@ -0,0 +1,34 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestPrintCheckHistory(t *testing.T) {
+	t.Parallel()
+	when := time.Date(2016, 3, 4, 5, 6, 7, 0, time.Local)
+	history := []checks.MetricsRecord{
+		{Commit: "0123456789abcdef", Time: when, Modes: []checks.Mode{checks.PrePush}, Durations: map[string]float64{"gotest": 1.5}, Results: map[string]bool{"gotest": false}},
+		{Commit: "fedcba", Time: when, Modes: []checks.Mode{checks.PreCommit}, Durations: map[string]float64{"gofmt": 0.1}},
+		{Commit: "fedcba", Time: when, Modes: []checks.Mode{checks.PrePush}, Durations: map[string]float64{"gotest": 0.25}},
+	}
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, printCheckHistory(b, history, nil, "gotest"))
+	expected := "time                 commit        duration  result  modes\n" +
+		"2016-03-04 05:06:07  0123456789ab     1.50s  fail    pre-push\n" +
+		"2016-03-04 05:06:07  fedcba           0.25s  pass    pre-push\n"
+	ut.AssertEqual(t, expected, b.String())
+
+	b.Reset()
+	ut.AssertEqual(t, "no run of gotest recorded", printCheckHistory(b, history, []checks.Mode{checks.PreCommit}, "gotest").Error())
+	ut.AssertEqual(t, "", b.String())
+}
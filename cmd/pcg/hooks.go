@@ -0,0 +1,197 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// hookTarget is where the hooks are installed.
+type hookTarget int
+
+const (
+	// repoHooks is the hooks directory of the repository.
+	repoHooks hookTarget = iota
+	// templateHooks is the hooks directory of init.templateDir; see
+	// templateHookPath.
+	templateHooks
+	// globalHooks is core.hooksPath, with dispatchers; see globalHookPath.
+	globalHooks
+)
+
+// hookMarker identifies the hooks written by pcg, which can be overwritten.
+const hookMarker = "# AUTOGENERATED BY pcg."
+
+// chainedSuffix is appended to the name of a hook that was not written by pcg,
+// e.g. one copied from a git template directory, when pcg's hook is installed
+// in its place. pcg's hook runs it first.
+const chainedSuffix = ".local"
+
+const hookContent = `#!/bin/sh
+# AUTOGENERATED BY pcg.
+#
+# For more information, run:
+#   pcg help
+#
+# or visit https://github.com/maruel/pre-commit-go
+
+set -e
+`
+
+// hookSaveStdin saves the stdin of the hooks reading it, like pre-push, so
+// multiple commands can read it.
+const hookSaveStdin = `input="$(mktemp)"
+trap 'rm -f "$input"' EXIT
+cat > "$input"
+`
+
+// hookChained runs the hook that was present before pcg was installed.
+const hookChained = `if [ -x "$0` + chainedSuffix + `" ]; then
+  "$0` + chainedSuffix + `" "$@"%[1]s
+fi
+`
+
+// hookGlobal is the dispatcher installed in core.hooksPath. git ignores the
+// repository's hooks when core.hooksPath is set, so it runs them; when it's
+// pcg's own hook, it does everything. Otherwise, pcg is only run in the
+// repositories with a configuration file.
+const hookGlobal = `hook="$(git rev-parse --git-common-dir)/hooks/%[2]s"
+if [ -x "$hook" ]; then
+  "$hook" "$@"%[1]s
+  if grep -q "` + hookMarker + `" "$hook"; then
+    exit 0
+  fi
+fi
+if [ ! -f "$(git rev-parse --show-toplevel)/%[3]s" ]; then
+  exit 0
+fi
+`
+
+// hookRun runs pcg.
+const hookRun = `pcg run-hook %[2]s%[1]s
+`
+
+// defaultGlobalHookPath is the value of core.hooksPath set by
+// 'pcg install -global' when it is not set yet.
+const defaultGlobalHookPath = "~/.config/pcg/hooks"
+
+// hookScript returns the content of the hook t.
+//
+// If chained is true, the hook runs <t>.local first. If global is true, the
+// hook is a dispatcher for core.hooksPath that runs pcg only in the
+// repositories containing configName.
+func hookScript(t string, chained, global bool, configName string) string {
+	redirect := ""
+	out := hookContent
+	if t == "pre-push" && (chained || global) {
+		out += hookSaveStdin
+		redirect = ` < "$input"`
+	}
+	if chained {
+		out += fmt.Sprintf(hookChained, redirect)
+	}
+	if global {
+		out += fmt.Sprintf(hookGlobal, redirect, t, configName)
+	}
+	return out + fmt.Sprintf(hookRun, redirect, t)
+}
+
+// installHook installs the hook t in hookDir. If global is true, it is the
+// dispatcher for core.hooksPath; see hookScript.
+//
+// A hook not written by pcg is moved to <t>.local and run by pcg's hook, so
+// the hooks provided by a git template directory are not lost. It is
+// overwritten instead if force is true.
+func installHook(hookDir, t string, force, global bool, configName string) error {
+	p := filepath.Join(hookDir, t)
+	local := p + chainedSuffix
+	if b, err := ioutil.ReadFile(p); err == nil && !bytes.Contains(b, []byte(hookMarker)) {
+		if force {
+			log.Printf("Overwriting %s", p)
+		} else {
+			if _, err := os.Lstat(local); err == nil {
+				return fmt.Errorf(tr(msgHookConflict), p, local, p)
+			}
+			// Rename also moves a symlink as is.
+			if err := os.Rename(p, local); err != nil {
+				return err
+			}
+			log.Printf("Moved %s to %s; it is run by pcg's hook", p, local)
+		}
+	}
+	_, err := os.Lstat(local)
+	chained := err == nil
+	// Always remove hook first if it exists, in case it's a symlink.
+	_ = os.Remove(p)
+	return ioutil.WriteFile(p, []byte(hookScript(t, chained, global, configName)), 0777)
+}
+
+// templateHookPath returns the hooks directory of init.templateDir, which git
+// copies into the repositories it creates, e.g. with git clone.
+func templateHookPath(ctx context.Context, wd string) (string, error) {
+	out, code, err := internal.Capture(ctx, wd, nil, "git", "config", "--path", "--get", "init.templateDir")
+	if err != nil {
+		return "", err
+	}
+	d := strings.TrimSpace(out)
+	if code != 0 || d == "" {
+		return "", errors.New(tr(msgTemplateDirUnset))
+	}
+	if !filepath.IsAbs(d) {
+		d = filepath.Join(wd, d)
+	}
+	d = filepath.Join(d, "hooks")
+	if err = os.MkdirAll(d, 0777); err != nil {
+		return "", err
+	}
+	return d, nil
+}
+
+// globalHookPath returns core.hooksPath from the user's git configuration,
+// after setting it to defaultGlobalHookPath if it is not set yet.
+func globalHookPath(ctx context.Context, wd string) (string, error) {
+	if err := scm.GitHooksPath.Require(); err != nil {
+		return "", err
+	}
+	get := func() (string, int, error) {
+		out, code, err := internal.Capture(ctx, wd, nil, "git", "config", "--global", "--path", "--get", "core.hooksPath")
+		return strings.TrimSpace(out), code, err
+	}
+	d, code, err := get()
+	if err != nil {
+		return "", err
+	}
+	if code != 0 || d == "" {
+		log.Printf("Setting core.hooksPath to %s", defaultGlobalHookPath)
+		out, code, err := internal.Capture(ctx, wd, nil, "git", "config", "--global", "core.hooksPath", defaultGlobalHookPath)
+		if err != nil {
+			return "", err
+		}
+		if code != 0 {
+			return "", fmt.Errorf("failed to set core.hooksPath: %s", out)
+		}
+		if d, code, err = get(); err != nil {
+			return "", err
+		}
+		if code != 0 || d == "" {
+			return "", errors.New("failed to read core.hooksPath")
+		}
+	}
+	if err = os.MkdirAll(d, 0777); err != nil {
+		return "", err
+	}
+	return d, nil
+}
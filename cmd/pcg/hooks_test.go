@@ -0,0 +1,116 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestInstallHook(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	read := func(name string) string {
+		b, err := ioutil.ReadFile(filepath.Join(tmpDir, name))
+		ut.AssertEqual(t, nil, err)
+		return string(b)
+	}
+	p := filepath.Join(tmpDir, "pre-push")
+
+	// A fresh install.
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", false, false, "pre-commit-go.yml"), read("pre-push"))
+
+	// pcg's own hook is overwritten.
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", false, false, "pre-commit-go.yml"), read("pre-push"))
+
+	// A hook from a template is chained.
+	ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte("#!/bin/sh\nlint\n"), 0777))
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", true, false, "pre-commit-go.yml"), read("pre-push"))
+	ut.AssertEqual(t, "#!/bin/sh\nlint\n", read("pre-push.local"))
+
+	// Reinstalling keeps chaining.
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", true, false, "pre-commit-go.yml"), read("pre-push"))
+
+	// Two foreign hooks can't be merged.
+	ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte("#!/bin/sh\nother\n"), 0777))
+	err = installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml")
+	ut.AssertEqual(t, errors.New(p+" was not installed by pcg and "+p+".local already exists; merge them or use -force to overwrite "+p), err)
+	ut.AssertEqual(t, "#!/bin/sh\nother\n", read("pre-push"))
+
+	// Unless forced.
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", true, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", true, false, "pre-commit-go.yml"), read("pre-push"))
+	ut.AssertEqual(t, "#!/bin/sh\nlint\n", read("pre-push.local"))
+
+	ut.AssertEqual(t, nil, os.Remove(p+".local"))
+	ut.AssertEqual(t, nil, installHook(tmpDir, "pre-push", false, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, hookScript("pre-push", false, false, "pre-commit-go.yml"), read("pre-push"))
+}
+
+func TestHookScriptGlobal(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	// A fake pcg and the global hooks directory.
+	binDir := filepath.Join(tmpDir, "bin")
+	hookDir := filepath.Join(tmpDir, "hooks")
+	repoDir := filepath.Join(tmpDir, "repo")
+	for _, d := range []string{binDir, hookDir, repoDir} {
+		ut.AssertEqual(t, nil, os.Mkdir(d, 0700))
+	}
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(binDir, "pcg"), []byte("#!/bin/sh\necho pcg \"$@\"; cat\n"), 0777))
+	ut.AssertEqual(t, nil, installHook(hookDir, "pre-push", false, true, "pre-commit-go.yml"))
+	_, code, err := internal.Capture(context.Background(), repoDir, nil, "git", "init")
+	ut.AssertEqual(t, 0, code)
+	ut.AssertEqual(t, nil, err)
+	env := []string{"PATH=" + binDir + string(os.PathListSeparator) + os.Getenv("PATH")}
+	push := func() string {
+		out, stderr, code, err := internal.CaptureIO(context.Background(), repoDir, env, []byte("in\n"), filepath.Join(hookDir, "pre-push"), "origin")
+		ut.AssertEqualf(t, 0, code, stderr)
+		ut.AssertEqual(t, nil, err)
+		return out
+	}
+
+	// Without configuration, pcg is not run.
+	ut.AssertEqual(t, "", push())
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(repoDir, "pre-commit-go.yml"), nil, 0600))
+	ut.AssertEqual(t, "pcg run-hook pre-push\nin\n", push())
+
+	// The repository's own hook is run first.
+	local := filepath.Join(repoDir, ".git", "hooks", "pre-push")
+	ut.AssertEqual(t, nil, ioutil.WriteFile(local, []byte("#!/bin/sh\necho local \"$@\"; cat\n"), 0777))
+	ut.AssertEqual(t, "local origin\nin\npcg run-hook pre-push\nin\n", push())
+
+	// pcg's hook in the repository does everything.
+	ut.AssertEqual(t, nil, installHook(filepath.Dir(local), "pre-push", true, false, "pre-commit-go.yml"))
+	ut.AssertEqual(t, "pcg run-hook pre-push\nin\n", push())
+}
@@ -10,6 +10,8 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -17,6 +19,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -42,38 +45,66 @@ import (
 // version.
 const version = "0.4.7"
 
-const hookContent = `#!/bin/sh
-# AUTOGENERATED BY pcg.
-#
-# For more information, run:
-#   pcg help
-#
-# or visit https://github.com/maruel/pre-commit-go
-
-set -e
-pcg run-hook %s
-`
-
 const gitNilCommit = "0000000000000000000000000000000000000000"
 
-const helpModes = "Supported modes (with shortcut names):\n- pre-commit / fast / pc\n- pre-push / slow / pp  (default)\n- continous-integration / full / ci\n- lint\n- all: includes both continuous-integration and lint"
+const helpModes = "Supported modes (with shortcut names):\n- pre-commit / fast / pc\n- pre-commit-merge / merge\n- pre-push / slow / pp  (default)\n- continous-integration / full / ci\n- lint\n- nightly\n- all: includes both continuous-integration and lint"
 
 // http://git-scm.com/docs/githooks#_pre_push
 var rePrePush = regexp.MustCompile("^(.+?) ([0-9a-f]{40}) (.+?) ([0-9a-f]{40})$")
 
-var helpText = template.Must(template.New("help").Parse(`pcg: runs pre-commit checks on Go projects, fast.
+// helpText is the template of 'pcg help'.
+const helpText = `pcg: runs pre-commit checks on Go projects, fast.
 
 Supported commands are:
+  doctor      - verifies the environment: git, hooks, configuration,
+                prerequisites, go environment and stash safety, and prints
+                how to fix the problems found
+  flaky       - prints the checks whose result flipped without related change
   help        - this page
+  history     - prints how the duration of the checks and the coverage evolve
+                over the recorded runs, the slowing down checks first; use
+                'history <check>' to list the runs of a check
   prereq      - installs prerequisites, e.g.: errcheck, golint, goimports,
                 govet, etc as applicable for the enabled checks
   info        - prints the current configuration used
   install     - runs 'prereq' then installs the git commit hook as
-                .git/hooks/pre-commit
+                .git/hooks/pre-commit; an existing hook is kept as
+                .git/hooks/pre-commit.local and run first unless -force is
+                used; use -template to install in init.templateDir instead
+                or -global to install in core.hooksPath for all repositories
   installrun  - runs 'prereq', 'install' then 'run'
-  run         - runs all enabled checks
-  run-hook    - used by hooks (pre-commit, pre-push) exclusively
+  merge-coverage
+              - merges the coverage profiles written by the shards of a run
+                with -shard and enforces the coverage settings of mode
+                continuous-integration, or -m, on the result, e.g.
+                'pcg merge-coverage */coverage-shard-*.cov'
+  messages    - prints the catalog of the messages to translate for the
+                language set in LANG; see ~/.config/pcg-messages
+  run         - runs all enabled checks; use -files to check an explicit list
+                of files, e.g. 'pcg run -files a.go b/c.go', or -patch to
+                check each patch of a series, e.g. 'pcg run -patch s.mbox',
+                or -archive to check extracted sources, e.g.
+                'pcg run -archive src.tar.gz', or -merge to check the merge of
+                HEAD into a branch, e.g. 'pcg run -merge origin/master'
+  run-hook    - used by hooks (pre-commit, pre-push) and CI
+                (continuous-integration, nightly) exclusively
+  serve       - like 'watch' but serves the findings over JSON-RPC on stdin
+                and stdout for editor plugins; see 'Editor integration' in
+                README.md
+  stats       - prints the usage statistics recorded on this machine; use
+                'pcg stats enable' to opt in, 'pcg stats disable' to opt out
+                and delete them and 'pcg stats export' to print them as JSON
+  stress      - repeatedly computes the changes of a synthetic repository
+                and runs the native checks on them while injecting random
+                latency in the internals, e.g. 'pcg stress 100'; build pcg
+                with -race to also detect the data races
+  validate    - reports likely misconfigurations, e.g. checks enabled in
+                pre-commit but not in continuous-integration
   version     - print the tool version number
+  watch       - runs the pre-commit checks on the local changes each time a
+                file is saved, until Ctrl-C; use -m to select other modes
+  why         - explains which ignore pattern, package, test packages and
+                checks of each mode a file maps to, e.g. 'pcg why foo/bar.go'
   writeconfig - writes (or rewrite) a pre-commit-go.yml
 
 When executed without command, it does the equivalent of 'installrun'.
@@ -88,7 +119,7 @@ Supported checks:
     - {{printf "%-*s" $.Max .GetName}} : {{.GetDescription}}{{end}}
 
 No check ever modify any file.
-`))
+`
 
 const yamlHeader = `# https://github.com/maruel/pre-commit-go configuration file to run checks
 # automatically on commit, on push and on continuous integration service after
@@ -105,6 +136,42 @@ var parsedVersion []int
 type application struct {
 	config        *checks.Config
 	maxConcurrent int
+	reporter      reporter
+	// ignorePatterns is config.IgnorePatterns followed by the patterns in
+	// .pcgignore.
+	ignorePatterns scm.IgnorePatterns
+	// configName is the requested config file name and configCheckedIn is
+	// true if the config was loaded from <repo root>/<configName>.
+	configName      string
+	configCheckedIn bool
+	// resume skips the checks that passed in a previous run on the same change;
+	// see checkpoint.
+	resume bool
+	// shard is the part of the test packages to run, set with -shard.
+	shard checks.Shard
+	// failFast cancels the remaining checks as soon as one fails.
+	failFast bool
+	// progress displays the status of the checks as they run; see progress.
+	progress bool
+	// stats is the usage record of this invocation, nil unless the usage
+	// statistics are enabled; see statsFile.
+	stats *usageRecord
+	// exit is the machine readable summary written when pcg exits, nil unless
+	// requested; see exitSummary.
+	exit *exitSummary
+	// summary, if set, receives the checks sorted by duration after a run; see
+	// printSummary.
+	summary io.Writer
+	// toolsDir is the absolute path of config.ToolsDir, where the
+	// prerequisites are installed, "" to use the GOPATH.
+	toolsDir string
+	// onboarding is set when running from a git hook, to print the onboarding
+	// message on failure; see printOnboarding.
+	onboarding bool
+	// lanes schedules the runs with the other pcg processes of the checkout
+	// and lane is the lane of the runs of this process, see checks.Lanes.
+	lanes *checks.Lanes
+	lane  checks.Lane
 }
 
 // Utils.
@@ -137,12 +204,28 @@ func loadConfigFile(pathname string) *checks.Config {
 	if err != nil {
 		return nil
 	}
-	config := &checks.Config{}
-	if err := yaml.Unmarshal(content, config); err != nil {
+	return parseConfig(content, pathname)
+}
+
+// parseConfig returns the Config in content or nil if it is invalid or
+// requires a newer version. name is only used for logging.
+func parseConfig(content []byte, name string) *checks.Config {
+	config, err := decodeConfig(content, name)
+	if err != nil {
 		// Log but ignore the error, recreate a new config instance.
-		log.Printf("failed to parse %s: %s", pathname, err)
+		log.Printf("%s", err)
 		return nil
 	}
+	return config
+}
+
+// decodeConfig returns the Config in content or an error if it is invalid or
+// requires a newer version. name is only used in the error.
+func decodeConfig(content []byte, name string) (*checks.Config, error) {
+	config := &checks.Config{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", name, err)
+	}
 	configVersion, err := parseVersion(config.MinVersion)
 	if err != nil {
 		log.Printf("invalid version %s", config.MinVersion)
@@ -153,18 +236,16 @@ func loadConfigFile(pathname string) *checks.Config {
 				// 3.0 == 3.0.0
 				continue
 			}
-			log.Printf("requires newer version %s", config.MinVersion)
-			return nil
+			return nil, fmt.Errorf("%s requires newer version %s", name, config.MinVersion)
 		}
 		if parsedVersion[i] > v {
 			break
 		}
 		if parsedVersion[i] < v {
-			log.Printf("requires newer version %s", config.MinVersion)
-			return nil
+			return nil, fmt.Errorf("%s requires newer version %s", name, config.MinVersion)
 		}
 	}
-	return config
+	return config, nil
 }
 
 // loadConfig loads the on disk configuration or use the default configuration
@@ -189,14 +270,7 @@ func loadConfig(repo scm.ReadOnlyRepo, path string) (string, *checks.Config) {
 			return file, config
 		}
 
-		if user, err := user.Current(); err == nil && user.HomeDir != "" {
-			if runtime.GOOS == "windows" {
-				// ~/<path>
-				file = filepath.Join(user.HomeDir, path)
-			} else {
-				// ~/.config/<path>
-				file = filepath.Join(user.HomeDir, ".config", path)
-			}
+		if file = userConfigPath(path); file != "" {
 			if config := loadConfigFile(file); config != nil {
 				return file, config
 			}
@@ -205,79 +279,478 @@ func loadConfig(repo scm.ReadOnlyRepo, path string) (string, *checks.Config) {
 	return "<N/A>", checks.New(version)
 }
 
-func callRun(check checks.Check, change scm.Change, options *checks.Options) (time.Duration, error) {
-	start := time.Now()
-	err := check.Run(change, options)
-	return time.Now().Sub(start), err
+// userConfigPath returns the path of the configuration file in the user's
+// configuration directory or "" if unknown.
+func userConfigPath(path string) string {
+	user, err := user.Current()
+	if err != nil || user.HomeDir == "" {
+		return ""
+	}
+	if runtime.GOOS == "windows" {
+		// ~/<path>
+		return filepath.Join(user.HomeDir, path)
+	}
+	// ~/.config/<path>
+	return filepath.Join(user.HomeDir, ".config", path)
+}
+
+// getRepo returns the git checkout containing wd or, if there is none, the
+// checkout of the first source control in the scm section of the
+// configuration that recognizes wd.
+//
+// Since the checkout root is unknown, the configuration is searched in wd and
+// its parents then in the user's configuration directory.
+func getRepo(wd, path string) (scm.Repo, error) {
+	repo, err := scm.GetRepo(wd, "")
+	if err == nil {
+		return repo, nil
+	}
+	var files []string
+	if filepath.IsAbs(path) {
+		files = append(files, path)
+	} else {
+		for d := wd; ; d = filepath.Dir(d) {
+			files = append(files, filepath.Join(d, path))
+			if filepath.Dir(d) == d {
+				break
+			}
+		}
+		if file := userConfigPath(path); file != "" {
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		config := loadConfigFile(file)
+		if config == nil {
+			continue
+		}
+		for i := range config.SCM {
+			r, err2 := scm.GetCLIRepo(wd, "", &config.SCM[i])
+			if err2 == nil {
+				log.Printf("using scm %s from %s", config.SCM[i].Name, file)
+				return r, nil
+			}
+			log.Printf("%s", err2)
+		}
+	}
+	return nil, err
+}
+
+// configAt returns the configuration committed at commit c if the current
+// configuration was loaded from the checked in file and enables
+// config_from_commit. Otherwise, or if the committed file is missing or
+// invalid, the current configuration is returned.
+//
+// The settings specific to this run, like the artifacts directory, are kept.
+func (a *application) configAt(repo scm.ReadOnlyRepo, c scm.Commit) (*checks.Config, scm.IgnorePatterns) {
+	if !a.config.ConfigFromCommit || !a.configCheckedIn {
+		return a.config, a.ignorePatterns
+	}
+	content, err := repo.ContentAt(c, a.configName)
+	if err != nil {
+		log.Printf("using the current config: %s", err)
+		return a.config, a.ignorePatterns
+	}
+	config := parseConfig(content, fmt.Sprintf("%s at %s", a.configName, c))
+	if config == nil {
+		return a.config, a.ignorePatterns
+	}
+	log.Printf("using config %s at %s", a.configName, c)
+	config.ArtifactsDir = a.config.ArtifactsDir
+	config.MaxConcurrent = a.config.MaxConcurrent
+	return config, loadIgnorePatterns(repo, config)
+}
+
+// loadIgnorePatterns returns config.IgnorePatterns followed by the patterns in
+// .pcgignore.
+func loadIgnorePatterns(repo scm.ReadOnlyRepo, config *checks.Config) scm.IgnorePatterns {
+	patterns := append(scm.IgnorePatterns{}, config.IgnorePatterns...)
+	if extra, err := scm.ReadIgnoreFile(filepath.Join(repo.Root(), scm.IgnoreFile)); err != nil {
+		log.Printf("failed to read %s: %s", scm.IgnoreFile, err)
+	} else {
+		patterns = append(patterns, extra...)
+	}
+	return patterns
+}
+
+// runObserver logs the output of the checks as it is produced, visible with
+// -v, updates the progress display and records the checks that passed in the
+// checkpoint, if any.
+type runObserver struct {
+	cp       *checkpoint
+	progress *progress
+}
+
+func (r *runObserver) OnCheckStart(name string) {
+	if r.progress != nil {
+		r.progress.start(name)
+	}
+}
+
+func (r *runObserver) OnOutput(name, line string) {
+	log.Printf("%s: %s", name, line)
+}
+
+func (r *runObserver) OnCheckDone(res *checks.Result) {
+	if r.progress != nil {
+		r.progress.end(res)
+	}
+	if res.Err != nil || r.cp == nil {
+		return
+	}
+	if err := r.cp.record(res.Name); err != nil {
+		log.Printf("failed to record %s in the checkpoint: %s", res.Name, err)
+	}
+}
+
+func (a *application) runChecks(ctx context.Context, change scm.Change, modes []checks.Mode, skip skipList, prereqReady *sync.WaitGroup) error {
+	err := a.runChange(ctx, change, modes, skip, prereqReady)
+	if change != nil && a.config.RecurseSubmodules && len(change.Submodules()) != 0 {
+		if err2 := a.runSubmodules(ctx, change, modes, skip, prereqReady); err == nil {
+			err = err2
+		}
+	}
+	return err
 }
 
-func (a *application) runChecks(change scm.Change, modes []checks.Mode, prereqReady *sync.WaitGroup) error {
+// runChange runs the enabled checks on a change, ignoring its submodules.
+func (a *application) runChange(ctx context.Context, change scm.Change, modes []checks.Mode, skip skipList, prereqReady *sync.WaitGroup) error {
 	enabledChecks, options := a.config.EnabledChecks(modes)
 	log.Printf("mode: %s; %d checks; %d max seconds allowed", modes, len(enabledChecks), options.MaxDuration)
 	if change == nil {
 		log.Printf("no change")
 		return nil
 	}
-	var wg sync.WaitGroup
-	errs := make(chan error, len(enabledChecks))
-	warnings := make(chan error, len(enabledChecks))
+	storage, err := checks.OpenStorage(change.Repo(), a.config.Storage)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := storage.Close(); err2 != nil {
+			log.Printf("failed to close the storage: %s", err2)
+		}
+	}()
+	options.Storage = storage
+	artifactsDir, err := a.makeArtifactsDir()
+	if err != nil {
+		return err
+	}
+	options.ArtifactsDir = artifactsDir
+	defer func() {
+		if a.config.ArtifactsDir == "" {
+			if err2 := internal.RemoveAll(artifactsDir); err2 != nil {
+				log.Printf("failed to remove %s: %s", artifactsDir, err2)
+			}
+		} else {
+			log.Printf("artifacts: %s", artifactsDir)
+		}
+	}()
+	type failure struct {
+		name string
+		err  error
+	}
+	// One more for the thresholds and one for the required checks.
+	errs := make(chan failure, len(enabledChecks)+2)
+	// Each check can emit a warning and be too slow, or be skipped or be
+	// required and not skipped.
+	warnings := make(chan failure, 3*len(enabledChecks))
+	var policy *policyRecord
+	if enforcesPolicy(a.config, modes) {
+		required, err := a.requiredChecks(change.Repo())
+		if err != nil {
+			return err
+		}
+		var notices []error
+		skip, notices = required.enforce(skip, enabledChecks)
+		for _, n := range notices {
+			log.Printf("%s", n)
+			warnings <- failure{"", n}
+		}
+		policy = &policyRecord{Modes: modes, Required: required, Missing: a.config.MissingChecks(modes, required.names())}
+		if len(policy.Missing) != 0 {
+			var lines []string
+			for _, name := range policy.Missing {
+				lines = append(lines, fmt.Sprintf("%s (required by %s)", name, required[name]))
+			}
+			err := fmt.Errorf("required checks are not enabled:\n%s", strings.Join(lines, "\n"))
+			log.Printf("%s", err)
+			errs <- failure{"required_checks", err}
+		}
+	}
+	enabledChecks, notices := skip.filter(enabledChecks)
+	for _, n := range notices {
+		log.Printf("%s", n)
+		warnings <- failure{"", n}
+	}
+	if policy != nil {
+		for _, c := range enabledChecks {
+			policy.Run = append(policy.Run, c.GetName())
+		}
+		for name := range skip {
+			policy.Skipped = append(policy.Skipped, name)
+		}
+		sort.Strings(policy.Skipped)
+		writePolicy(artifactsDir, a.config, policy)
+	}
+	var cp *checkpoint
+	options.Shard = a.shard
+	if a.resume {
+		options.Resume = true
+		var err2 error
+		if cp, err2 = openCheckpoint(storage, change, modes, a.config); err2 != nil {
+			log.Printf("can't resume: %s", err2)
+		} else {
+			var done []string
+			enabledChecks, done = cp.filter(enabledChecks)
+			for _, name := range done {
+				log.Printf("%s passed in a previous run; skipping", name)
+			}
+		}
+	}
+	flaky := flakyChecks(storage)
 	start := time.Now()
-	for _, c := range enabledChecks {
-		wg.Add(1)
-		go func(check checks.Check) {
-			defer wg.Done()
-			if len(check.GetPrerequisites()) != 0 {
-				// If this check has prerequisites, wait for all prerequisites to be
-				// checked for presence.
-				prereqReady.Wait()
-			}
-			log.Printf("%s...", check.GetName())
-			duration, err := callRun(check, change, options)
-			if err != nil {
-				log.Printf("... %s in %1.2fs FAILED\n%s", check.GetName(), duration.Seconds(), err)
-				errs <- err
-				return
+	obs := &runObserver{cp: cp}
+	if a.progress {
+		obs.progress = newProgress(os.Stdout, enabledChecks, useColor(os.Stdout))
+	}
+	runner := &checks.Runner{
+		Checks:      enabledChecks,
+		Options:     options,
+		PrereqReady: prereqReady,
+		Observer:    obs,
+		FailFast:    a.failFast || options.FailFast,
+		Lanes:       a.lanes,
+		Lane:        a.lane,
+	}
+	for _, name := range a.config.ExperimentalChecks {
+		// A required check is always enforced.
+		if policy != nil {
+			if _, ok := policy.Required[name]; ok {
+				continue
 			}
-			log.Printf("... %s in %1.2fs", check.GetName(), duration.Seconds())
-			// A check that took too long is a check that failed.
-			max := time.Duration(options.MaxDuration) * time.Second
-			if duration > max {
-				warnings <- fmt.Errorf("check %s took %1.2fs -> IT IS TOO SLOW (limit: %s)", check.GetName(), duration.Seconds(), max)
+		}
+		if runner.Experimental == nil {
+			runner.Experimental = map[string]bool{}
+		}
+		runner.Experimental[name] = true
+	}
+	report := runner.Run(ctx, change)
+	if obs.progress != nil {
+		obs.progress.close()
+	}
+	if report.Preempted {
+		return errPreempted
+	}
+	if a.stats != nil {
+		a.stats.add(modes, report)
+	}
+	if a.exit != nil {
+		a.exit.add(modes, report)
+	}
+	for _, res := range report.Results {
+		for _, w := range res.Warnings {
+			warnings <- failure{res.Name, w}
+		}
+		if res.Err == nil {
+			continue
+		}
+		err := res.Err
+		if res.Canceled {
+			warnings <- failure{res.Name, err}
+			continue
+		}
+		if res.Experimental {
+			warnings <- failure{res.Name, fmt.Errorf("check %s is experimental; its failure is demoted to a warning:\n%s", res.Name, err)}
+			continue
+		}
+		if s, ok := flaky[res.Name]; ok {
+			err = fmt.Errorf("%s\nFLAKY: %s", err, s.String())
+			if a.config.QuarantineFlaky {
+				warnings <- failure{res.Name, fmt.Errorf("check %s is quarantined as flaky; its failure is demoted to a warning:\n%s", res.Name, err)}
+				continue
 			}
-		}(c)
+		}
+		errs <- failure{res.Name, err}
 	}
-	wg.Wait()
 
-	var err error
+	r := a.reporter
+	if r == nil {
+		r = &textReporter{w: os.Stdout}
+	}
+	r.results(report.Results)
+	metrics := report.Metrics
+	var baseline map[string]float64
+	if a.config.NeedsBaseline() {
+		baseline = loadBaseline(storage, change.Repo())
+	}
+	if violations := a.config.EvaluateThresholds(metrics, baseline); len(violations) != 0 {
+		err := fmt.Errorf("thresholds failed:\n%s", strings.Join(violations, "\n"))
+		log.Printf("%s", err)
+		errs <- failure{"thresholds", err}
+	}
+	if len(metrics) != 0 {
+		recordMetrics(artifactsDir, metrics)
+		r.metrics(metrics)
+	}
+	if logs := report.Logs; len(logs) != 0 && a.config.ArtifactsDir != "" {
+		// Only reference the logs when the artifacts directory is preserved.
+		r.logs(logs)
+	}
+	recordHistory(storage, change, modes, report, options.Results())
+	var failed []string
 	for {
 		select {
-		case err = <-errs:
-			fmt.Printf("%s\n", err)
-		case warning := <-warnings:
-			fmt.Printf("warning: %s\n", warning)
+		case f := <-errs:
+			err = f.err
+			failed = append(failed, f.name)
+			r.failure(f.name, f.err)
+		case w := <-warnings:
+			r.warning(w.name, w.err)
 		default:
+			if err2 := r.flush(); err2 != nil {
+				return err2
+			}
+			if a.summary != nil {
+				if err2 := printSummary(a.summary, report.Results); err2 != nil {
+					return err2
+				}
+			}
 			if err != nil {
+				names := make([]string, 0, len(enabledChecks))
+				for _, c := range enabledChecks {
+					names = append(names, c.GetName())
+				}
+				a.printOnboarding(os.Stdout, storage, modes, failed, names)
 				duration := time.Now().Sub(start)
-				return fmt.Errorf("checks failed in %1.2fs", duration.Seconds())
+				return fmt.Errorf(tr(msgChecksFailed), duration.Seconds())
+			}
+			if cp != nil {
+				if err2 := cp.remove(); err2 != nil {
+					log.Printf("failed to remove the checkpoint: %s", err2)
+				}
 			}
 			return err
 		}
 	}
 }
 
-func (a *application) runPreCommit(repo scm.Repo) error {
+// recordMetrics saves the metrics published during a run in the artifacts
+// directory.
+//
+// Failures are logged but otherwise ignored.
+func recordMetrics(artifactsDir string, metrics map[string]float64) {
+	for _, name := range checks.SortedMetricNames(metrics) {
+		log.Printf("metric %s: %g", name, metrics[name])
+	}
+	if b, err := json.MarshalIndent(metrics, "", "  "); err != nil {
+		log.Printf("failed to marshal metrics: %s", err)
+	} else if err = ioutil.WriteFile(filepath.Join(artifactsDir, checks.MetricsFile), append(b, '\n'), 0644); err != nil {
+		log.Printf("failed to write %s: %s", checks.MetricsFile, err)
+	}
+}
+
+// recordHistory appends the metrics and the results of the checks of a run to
+// the metrics history of the repository.
+//
+// Failures are logged but otherwise ignored.
+func recordHistory(s checks.Storage, change scm.Change, modes []checks.Mode, report *checks.Report, results map[string]bool) {
+	if len(report.Metrics) == 0 && len(results) == 0 && len(report.Results) == 0 {
+		return
+	}
+	durations := map[string]float64{}
+	for _, r := range report.Results {
+		if !r.Interrupted && !r.Canceled {
+			durations[r.Name] = r.Duration.Seconds()
+		}
+	}
+	repo := change.Repo()
+	rec := &checks.MetricsRecord{
+		Commit:      repo.Eval(string(scm.Head)),
+		Time:        time.Now().UTC(),
+		Modes:       modes,
+		Metrics:     report.Metrics,
+		Results:     results,
+		Fingerprint: fingerprint(change, modes),
+		Durations:   durations,
+	}
+	if err := checks.AppendMetricsHistory(s, rec); err != nil {
+		log.Printf("failed to append to %s: %s", checks.MetricsHistoryFile, err)
+	}
+}
+
+// loadBaseline returns the metrics to compare against, preferably the ones
+// recorded for the upstream commit. Returns nil if there is none.
+func loadBaseline(s checks.Storage, repo scm.ReadOnlyRepo) map[string]float64 {
+	history, err := checks.LoadMetricsHistory(s)
+	if err != nil {
+		log.Printf("failed to load %s: %s", checks.MetricsHistoryFile, err)
+		return nil
+	}
+	baseline := checks.BaselineMetrics(history, repo.Eval(string(scm.Upstream)), repo.Eval(string(scm.Head)))
+	if baseline == nil {
+		log.Printf("no baseline metrics; delta thresholds are skipped")
+	}
+	return baseline
+}
+
+// makeArtifactsDir creates the artifacts directory for a run.
+//
+// It is a new directory inside Config.ArtifactsDir if set, otherwise a
+// temporary directory.
+func (a *application) makeArtifactsDir() (string, error) {
+	base := a.config.ArtifactsDir
+	if base != "" {
+		if err := os.MkdirAll(base, 0777); err != nil {
+			return "", err
+		}
+	}
+	return ioutil.TempDir(base, "pcg-artifacts-")
+}
+
+func (a *application) runPreCommit(ctx context.Context, repo scm.Repo) error {
+	mode := checks.PreCommit
+	if repo.IsMerging() {
+		if _, ok := a.config.Modes[checks.PreCommitMerge]; ok {
+			log.Printf("merge commit; using mode %s", checks.PreCommitMerge)
+			mode = checks.PreCommitMerge
+		} else if a.config.Modes[checks.PreCommit].SkipMergeCommits {
+			log.Printf("merge commit; skipping checks")
+			return nil
+		}
+	}
+	// git commit --amend replaces HEAD, so the to-be-committed content must be
+	// compared to its parent, otherwise the diff may be empty.
+	old := scm.Head
+	if isAmending() {
+		if old = repo.Eval("HEAD~1"); old == scm.Invalid {
+			old = scm.Initial
+		}
+		log.Printf("amend commit; checking against %s", old)
+	}
 	// First, stash index and work dir, keeping only the to-be-committed changes
 	// in the working directory.
-	// TODO(maruel): When running for an git commit --amend run, use HEAD~1.
-	stashed, err := repo.Stash()
+	stashed, err := a.stash(repo, mode)
 	if err != nil {
 		return err
 	}
+	// Check the content that will be committed, even when unstaged
+	// modifications were not stashed.
+	recent := scm.Index
+	if a.config.Modes[mode].Stash == "never" {
+		// Check the checkout as is.
+		recent = scm.Current
+		if old == scm.Head {
+			// Compare the checkout with the HEAD commit instead of the index.
+			if old = repo.Eval(string(scm.Head)); old == scm.Invalid {
+				old = scm.Initial
+			}
+		}
+	}
 	// Run the checks.
 	var change scm.Change
-	change, err = repo.Between(scm.Current, scm.Head, a.config.IgnorePatterns)
+	change, err = repo.Between(recent, old, a.ignorePatterns)
 	if change != nil {
-		err = a.runChecks(change, []checks.Mode{checks.PreCommit}, &sync.WaitGroup{})
+		err = a.runChecks(ctx, change, []checks.Mode{mode}, skipFor(repo, scm.Current), &sync.WaitGroup{})
 	}
 	// If stashed is false, everything was in the index so no stashing was needed.
 	if stashed {
@@ -288,7 +761,71 @@ func (a *application) runPreCommit(repo scm.Repo) error {
 	return err
 }
 
-func (a *application) runPrePush(repo scm.Repo) (err error) {
+// stash stashes the modifications not in the index before the pre-commit
+// checks, according to the stash setting of mode. Returns true if the
+// modifications must be restored.
+func (a *application) stash(repo scm.Repo, mode checks.Mode) (bool, error) {
+	switch s := a.config.Modes[mode].Stash; s {
+	case "", "always":
+	case "auto":
+		dirty, err := repo.Dirty(a.ignorePatterns)
+		if err != nil {
+			return false, err
+		}
+		if len(dirty) == 0 {
+			log.Printf("only ignored files are not in the index; not stashing")
+			return false, nil
+		}
+	case "never":
+		log.Printf("stash is disabled; checking the checkout as is")
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid stash %q; expected \"always\", \"auto\", \"never\" or empty", s)
+	}
+	return repo.Stash()
+}
+
+// isAmending returns true if the pre-commit hook is run by git commit --amend.
+//
+// git doesn't tell the hook, so the command lines of the processes running
+// the hook are inspected. The environment variable PCG_AMEND overrides the
+// detection, e.g. on Windows where it is not supported.
+func isAmending() bool {
+	if v := os.Getenv("PCG_AMEND"); v != "" {
+		return v == "1"
+	}
+	// The hook is a shell script run by git, which may itself be run through a
+	// wrapper.
+	for _, cmdline := range internal.AncestorsCommandLine(4) {
+		if isAmendCommandLine(cmdline) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAmendCommandLine returns true if cmdline is a git commit --amend
+// invocation.
+func isAmendCommandLine(cmdline string) bool {
+	args := strings.Fields(cmdline)
+	if len(args) == 0 || filepath.Base(args[0]) != "git" && filepath.Base(args[0]) != "git.exe" {
+		return false
+	}
+	commit := false
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "--":
+			return false
+		case arg == "commit":
+			commit = true
+		case arg == "--amend" && commit:
+			return true
+		}
+	}
+	return false
+}
+
+func (a *application) runPrePush(ctx context.Context, repo scm.Repo) (err error) {
 	previous := scm.Head
 	// Will be "" if the current checkout was detached.
 	previousRef := repo.Ref(scm.Head)
@@ -345,11 +882,7 @@ func (a *application) runPrePush(repo scm.Repo) (err error) {
 		if from == gitNilCommit {
 			from = scm.Initial
 		}
-		change, err := repo.Between(to, from, a.config.IgnorePatterns)
-		if err != nil {
-			return err
-		}
-		if err = a.runChecks(change, []checks.Mode{checks.PrePush}, &sync.WaitGroup{}); err != nil {
+		if err = a.runPrePushCommit(ctx, repo, to, from); err != nil {
 			return err
 		}
 	}
@@ -359,6 +892,23 @@ func (a *application) runPrePush(repo scm.Repo) (err error) {
 	return
 }
 
+// runPrePushCommit runs the pre-push checks on the commits between from and
+// the checked out commit to, with the configuration committed at to when
+// config_from_commit is set.
+func (a *application) runPrePushCommit(ctx context.Context, repo scm.Repo, to, from scm.Commit) error {
+	config, ignorePatterns := a.configAt(repo, to)
+	change, err := repo.Between(to, from, ignorePatterns)
+	if err != nil {
+		return err
+	}
+	saved := a.config
+	a.config = config
+	defer func() {
+		a.config = saved
+	}()
+	return a.runChecks(ctx, change, []checks.Mode{checks.PrePush}, skipFor(repo, to), &sync.WaitGroup{})
+}
+
 func processModes(modeFlag string) ([]checks.Mode, error) {
 	if len(modeFlag) == 0 {
 		return nil, nil
@@ -371,14 +921,18 @@ func processModes(modeFlag string) ([]checks.Mode, error) {
 				modes = append(modes, checks.ContinuousIntegration, checks.Lint)
 			case string(checks.PreCommit), "fast", "pc":
 				modes = append(modes, checks.PreCommit)
+			case string(checks.PreCommitMerge), "merge":
+				modes = append(modes, checks.PreCommitMerge)
 			case string(checks.PrePush), "slow", "pp":
 				modes = append(modes, checks.PrePush)
 			case string(checks.ContinuousIntegration), "full", "ci":
 				modes = append(modes, checks.ContinuousIntegration)
 			case string(checks.Lint):
 				modes = append(modes, checks.Lint)
+			case string(checks.Nightly):
+				modes = append(modes, checks.Nightly)
 			default:
-				return nil, fmt.Errorf("invalid mode \"%s\"\n\n%s", p, helpModes)
+				return nil, fmt.Errorf("invalid mode \"%s\"\n\n%s", p, tr(helpModes))
 			}
 		}
 	}
@@ -405,11 +959,11 @@ func (a *application) cmdHelp(usage string) error {
 		sortedChecks{},
 		sortedChecks{},
 	}
-	for name, factory := range checks.KnownChecks {
+	for _, name := range checks.RegisteredChecks() {
 		if v := len(name); v > s.Max {
 			s.Max = v
 		}
-		c := factory()
+		c, _ := checks.NewCheck(name)
 		if len(c.GetPrerequisites()) == 0 {
 			s.NativeChecks = append(s.NativeChecks, c)
 		} else {
@@ -418,7 +972,12 @@ func (a *application) cmdHelp(usage string) error {
 	}
 	sort.Sort(s.NativeChecks)
 	sort.Sort(s.OtherChecks)
-	return helpText.Execute(os.Stdout, s)
+	t, err := template.New("help").Parse(tr(helpText))
+	if err != nil {
+		log.Printf("invalid translation of the help: %s", err)
+		t = template.Must(template.New("help").Parse(helpText))
+	}
+	return t.Execute(os.Stdout, s)
 }
 
 // cmdInfo displays the current configuration used.
@@ -427,17 +986,24 @@ func (a *application) cmdInfo(repo scm.ReadOnlyRepo, modes []checks.Mode, config
 	fmt.Printf("Repo: %s\n", repo.Root())
 
 	fmt.Printf("MinVersion: %s\n", a.config.MinVersion)
-	content, err := yaml.Marshal(a.config.IgnorePatterns)
+	content, err := yaml.Marshal(a.ignorePatterns)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("IgnorePatterns:\n%s", content)
+	if a.config.IsHermetic() {
+		allowed := append(append([]string{}, internal.DefaultEnvAllowlist...), a.config.PassEnv...)
+		fmt.Printf("HermeticEnv: %s\n", strings.Join(allowed, ", "))
+	}
 
 	if len(modes) == 0 {
 		modes = checks.AllModes
 	}
 	for _, mode := range modes {
-		settings := a.config.Modes[mode]
+		settings, ok := a.config.Modes[mode]
+		if !ok && mode.IsOptional() {
+			continue
+		}
 		maxLen := 0
 		for _, checks := range settings.Checks {
 			for _, check := range checks {
@@ -447,6 +1013,13 @@ func (a *application) cmdInfo(repo scm.ReadOnlyRepo, modes []checks.Mode, config
 			}
 		}
 		fmt.Printf("\n%s:\n  %-*s %d seconds\n", mode, maxLen+1, "Limit:", settings.Options.MaxDuration)
+		if len(settings.Inherits) != 0 {
+			names := make([]string, len(settings.Inherits))
+			for i, m := range settings.Inherits {
+				names[i] = string(m)
+			}
+			fmt.Printf("  %-*s %s\n", maxLen+1, "Inherits:", strings.Join(names, ", "))
+		}
 		for _, checks := range settings.Checks {
 			for _, check := range checks {
 				name := check.GetName()
@@ -465,49 +1038,76 @@ func (a *application) cmdInfo(repo scm.ReadOnlyRepo, modes []checks.Mode, config
 			}
 		}
 	}
+
+	// Print the checks that are run only once when the modes are run together.
+	first := true
+	for _, e := range a.config.MergedChecks(modes) {
+		if len(e.Modes) < 2 {
+			continue
+		}
+		if first {
+			fmt.Printf("\nDeduplicated across modes:\n")
+			first = false
+		}
+		names := make([]string, len(e.Modes))
+		for i, m := range e.Modes {
+			names[i] = string(m)
+		}
+		fmt.Printf("  %s: %s\n", e.Check.GetName(), strings.Join(names, ", "))
+	}
+
+	if len(a.config.Thresholds) != 0 {
+		content, err := yaml.Marshal(a.config.Thresholds)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nThresholds:\n%s", content)
+	}
+
+	if warnings := a.config.Warnings(); len(warnings) != 0 {
+		fmt.Printf("\nWarnings:\n")
+		for _, w := range warnings {
+			fmt.Printf("  %s\n", w)
+		}
+	}
 	return nil
 }
 
-// cmdInstallPrereq installs all the packages needed to run the enabled checks.
-func (a *application) cmdInstallPrereq(repo scm.ReadOnlyRepo, modes []checks.Mode, noUpdate bool) error {
-	var wg sync.WaitGroup
-	enabledChecks, _ := a.config.EnabledChecks(modes)
-	number := 0
-	c := make(chan string, len(enabledChecks))
-	for _, check := range enabledChecks {
-		for _, p := range check.GetPrerequisites() {
-			number++
-			wg.Add(1)
-			go func(prereq checks.CheckPrerequisite) {
-				defer wg.Done()
-				if !prereq.IsPresent() {
-					c <- prereq.URL
-				}
-			}(p)
-		}
+// cmdValidate prints the likely misconfigurations and fails if any is found.
+func (a *application) cmdValidate(configPath string) error {
+	warnings := a.config.Warnings()
+	for _, w := range warnings {
+		fmt.Printf("%s: %s\n", configPath, w)
 	}
-	wg.Wait()
-	log.Printf("Checked for %d prerequisites", number)
-	loop := true
-	// Use a map to remove duplicates.
-	m := map[string]bool{}
-	for loop {
-		select {
-		case url := <-c:
-			m[url] = true
-		default:
-			loop = false
+	if len(warnings) != 0 {
+		return fmt.Errorf("found %d configuration issues", len(warnings))
+	}
+	return nil
+}
+
+// cmdInstallPrereq installs all the packages needed to run the enabled checks.
+//
+// When offline, it only verifies they are in the tools directory.
+func (a *application) cmdInstallPrereq(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, noUpdate bool) error {
+	if a.config.Offline {
+		if urls := a.missingTools(modes); len(urls) != 0 {
+			return a.errMissingTools(urls)
 		}
+		log.Printf("Prerequisites are present in %s", a.toolsDir)
+		return nil
 	}
-	urls := make([]string, 0, len(m))
-	for url := range m {
-		urls = append(urls, url)
+	var urls []string
+	if a.toolsDir != "" {
+		// Install the tools found elsewhere in PATH too, so the tools directory
+		// is self-contained.
+		urls = a.missingTools(modes)
+	} else {
+		urls = checks.NewRunner(a.config, modes).MissingPrerequisites()
 	}
 	wd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	sort.Strings(urls)
 	if len(urls) != 0 {
 		if noUpdate {
 			out := "-n is specified but prerequites are missing:\n"
@@ -517,16 +1117,27 @@ func (a *application) cmdInstallPrereq(repo scm.ReadOnlyRepo, modes []checks.Mod
 			return errors.New(out)
 		}
 		fmt.Printf("Installing:\n")
-		for _, url := range urls {
-			fmt.Printf("  %s\n", url)
+		failed := 0
+		for _, res := range checks.InstallEach(ctx, wd, a.toolsDir, urls, a.config.MaxConcurrent) {
+			retries := ""
+			if res.Attempts > 1 {
+				retries = fmt.Sprintf(" after %d attempts", res.Attempts)
+			}
+			if res.Err != nil {
+				failed++
+				fmt.Printf("  FAIL  %s%s: %s\n", res.URL, retries, res.Err)
+				continue
+			}
+			fmt.Printf("  ok    %s in %1.2fs%s\n", res.URL, res.Duration.Seconds(), retries)
 		}
-
-		out, _, err := internal.Capture(wd, nil, append([]string{"go", "get"}, urls...)...)
-		if len(out) != 0 {
-			return fmt.Errorf("prerequisites installation failed: %s", out)
+		if failed != 0 {
+			return fmt.Errorf("failed to install %d of %d prerequisites", failed, len(urls))
 		}
-		if err != nil {
-			return fmt.Errorf("prerequisites installation failed: %s", err)
+	}
+	if a.toolsDir != "" {
+		// Record the checksums so the tools directory can be used offline.
+		if err := checks.RecordToolsChecksums(a.toolsDir); err != nil {
+			return err
 		}
 	}
 	log.Printf("Prerequisites installation succeeded")
@@ -538,11 +1149,15 @@ func (a *application) cmdInstallPrereq(repo scm.ReadOnlyRepo, modes []checks.Mod
 //
 // Silently ignore installing the hooks when running under a CI. In
 // particular, circleci.com doesn't create the directory .git/hooks.
-func (a *application) cmdInstall(repo scm.ReadOnlyRepo, modes []checks.Mode, noUpdate bool, prereqReady *sync.WaitGroup) (err error) {
+//
+// target selects where the hooks are installed: in the repository, in
+// init.templateDir so the repositories created afterward get them, or in
+// core.hooksPath so all the repositories of the user get them.
+func (a *application) cmdInstall(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, noUpdate, force bool, target hookTarget, prereqReady *sync.WaitGroup) (err error) {
 	errCh := make(chan error, 1)
 	go func() {
 		defer prereqReady.Done()
-		errCh <- a.cmdInstallPrereq(repo, modes, noUpdate)
+		errCh <- a.cmdInstallPrereq(ctx, repo, modes, noUpdate)
 	}()
 
 	defer func() {
@@ -556,15 +1171,20 @@ func (a *application) cmdInstall(repo scm.ReadOnlyRepo, modes []checks.Mode, noU
 		return nil
 	}
 	log.Printf("Installing hooks")
-	hookDir, err2 := repo.HookPath()
-	if err2 != nil {
-		return err2
+	var hookDir string
+	switch target {
+	case templateHooks:
+		hookDir, err = templateHookPath(ctx, repo.Root())
+	case globalHooks:
+		hookDir, err = globalHookPath(ctx, repo.Root())
+	default:
+		hookDir, err = repo.HookPath()
+	}
+	if err != nil {
+		return err
 	}
 	for _, t := range []string{"pre-commit", "pre-push"} {
-		// Always remove hook first if it exists, in case it's a symlink.
-		p := filepath.Join(hookDir, t)
-		_ = os.Remove(p)
-		if err = ioutil.WriteFile(p, []byte(fmt.Sprintf(hookContent, t)), 0777); err != nil {
+		if err = installHook(hookDir, t, force, target == globalHooks, a.configName); err != nil {
 			return err
 		}
 	}
@@ -573,9 +1193,17 @@ func (a *application) cmdInstall(repo scm.ReadOnlyRepo, modes []checks.Mode, noU
 }
 
 // cmdRun runs all the enabled checks.
-func (a *application) cmdRun(repo scm.ReadOnlyRepo, modes []checks.Mode, against string, prereqReady *sync.WaitGroup) error {
+//
+// The old end of the diff is determined by against or since; if both are
+// empty, the upstream is used.
+func (a *application) cmdRun(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, against, since string, prereqReady *sync.WaitGroup) error {
 	var old scm.Commit
-	if against != "" {
+	if since != "" {
+		if old = repo.Before(since); old == scm.Invalid {
+			return fmt.Errorf("invalid -since %q", since)
+		}
+		log.Printf("-since %s resolved to %s", since, old)
+	} else if against != "" {
 		if old = repo.Eval(against); old == scm.Invalid {
 			return errors.New("invalid commit 'against'")
 		}
@@ -584,34 +1212,208 @@ func (a *application) cmdRun(repo scm.ReadOnlyRepo, modes []checks.Mode, against
 			return errors.New("no upstream")
 		}
 	}
-	change, err := repo.Between(scm.Current, old, a.config.IgnorePatterns)
+	change, err := repo.Between(scm.Current, old, a.ignorePatterns)
 	if err != nil {
 		return err
 	}
-	return a.runChecks(change, modes, prereqReady)
+	return a.runChecks(ctx, change, modes, skipFor(repo, scm.Current), prereqReady)
 }
 
-// cmdRunHook runs the checks in a git repository.
+// cmdRunPatch applies each patch of the series in mbox in a temporary worktree
+// at base and runs the enabled checks on each patch, like a bot reviewing
+// patches sent to a mailing list.
 //
-// Use a precise "stash, run checks, unstash" to ensure that the check is
-// properly run on the data in the index.
-func (a *application) cmdRunHook(repo scm.Repo, mode string, noUpdate bool) error {
-	switch checks.Mode(mode) {
-	case checks.PreCommit:
-		return a.runPreCommit(repo)
-
-	case checks.PrePush:
-		return a.runPrePush(repo)
-
-	case checks.ContinuousIntegration:
-		// Always runs all tests on CI.
-		change, err := repo.Between(scm.Current, scm.Initial, a.config.IgnorePatterns)
-		if err != nil {
-			return err
+// All the patches are checked even if one fails the checks but a patch that
+// doesn't apply stops the run.
+func (a *application) cmdRunPatch(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, mbox, base string, prereqReady *sync.WaitGroup) error {
+	if base == "" {
+		base = string(scm.Head)
+	}
+	head := repo.Eval(base)
+	if head == scm.Invalid {
+		return fmt.Errorf("invalid commit %q", base)
+	}
+	tmpDir, err := ioutil.TempDir("", "pcg-patches")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			log.Printf("failed to delete %s: %s", tmpDir, err)
 		}
-		mode := []checks.Mode{checks.ContinuousIntegration}
-
-		// This is a special case, some users want reproducible builds and in this
+	}()
+	patches, err := scm.SplitPatches(mbox, tmpDir)
+	if err != nil {
+		return err
+	}
+	wt, cleanup, err := scm.NewWorktree(repo, head)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cleanup(); err != nil {
+			log.Printf("failed to delete worktree: %s", err)
+		}
+	}()
+	r, cleanupImport, err := scm.ImportAs(wt, a.config.ImportPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cleanupImport(); err != nil {
+			log.Printf("failed to delete temporary GOPATH: %s", err)
+		}
+	}()
+	r.SetRenameThreshold(a.config.RenameThreshold)
+	var failed []string
+	for i, p := range patches {
+		if err := r.Apply(p); err != nil {
+			return fmt.Errorf("patch %d/%d: %s", i+1, len(patches), err)
+		}
+		to := r.Eval(string(scm.Head))
+		subject, _ := r.Message(to)
+		if eol := strings.IndexByte(subject, '\n'); eol != -1 {
+			subject = subject[:eol]
+		}
+		log.Printf("patch %d/%d: %s", i+1, len(patches), subject)
+		change, err := r.Between(to, r.Eval(string(to)+"~1"), a.ignorePatterns)
+		if err != nil {
+			return err
+		}
+		if err := a.runChecks(ctx, change, modes, skipFor(r, to), prereqReady); err != nil {
+			failed = append(failed, fmt.Sprintf("patch %d/%d %q: %s", i+1, len(patches), subject, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("%d of %d patches failed:\n%s", len(failed), len(patches), strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// cmdRunArchive extracts archive in a temporary directory and runs the enabled
+// checks on all its files, e.g. to validate a vendored drop or a release
+// artifact with the policy of the repository.
+func (a *application) cmdRunArchive(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, archive string, prereqReady *sync.WaitGroup) error {
+	tmpDir, err := ioutil.TempDir("", "pcg-archive")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			log.Printf("failed to delete %s: %s", tmpDir, err)
+		}
+	}()
+	if err = internal.Extract(archive, tmpDir); err != nil {
+		return err
+	}
+	root := tmpDir
+	// Archives commonly contain a single top level directory, e.g. foo-1.0/.
+	if entries, err := ioutil.ReadDir(tmpDir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(tmpDir, entries[0].Name())
+	}
+	d, err := scm.GetDir(root, repo.GOPATH())
+	if err != nil {
+		return err
+	}
+	change, err := d.Between(scm.Current, scm.Initial, a.ignorePatterns)
+	if err != nil {
+		return err
+	}
+	return a.runChecks(ctx, change, modes, skipFor(d, scm.Current), prereqReady)
+}
+
+// cmdRunFiles runs all the enabled checks on an explicit list of files
+// instead of diffing against a commit.
+//
+// files are relative to the current directory. An entry "-" is replaced with
+// the list of files read from stdin, one per line.
+func (a *application) cmdRunFiles(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, files []string, prereqReady *sync.WaitGroup) error {
+	var paths []string
+	for _, f := range files {
+		if f != "-" {
+			paths = append(paths, f)
+			continue
+		}
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			if l := strings.TrimSpace(s.Text()); l != "" {
+				paths = append(paths, l)
+			}
+		}
+		if err := s.Err(); err != nil {
+			return fmt.Errorf("failed to read files from stdin: %s", err)
+		}
+	}
+	rel, err := relToRoot(repo, paths)
+	if err != nil {
+		return err
+	}
+	change, err := scm.FromFiles(repo, rel, a.ignorePatterns)
+	if err != nil {
+		return err
+	}
+	return a.runChecks(ctx, change, modes, skipFor(repo, scm.Current), prereqReady)
+}
+
+// relToRoot returns the files, relative to the current directory, relative to
+// the root of repo.
+func relToRoot(repo scm.ReadOnlyRepo, files []string) ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	// The repository may be accessed through a symlink, see scm.ImportAs.
+	root, err := filepath.EvalSymlinks(repo.Root())
+	if err != nil {
+		return nil, err
+	}
+	if cwd, err = filepath.EvalSymlinks(cwd); err != nil {
+		return nil, err
+	}
+	out := make([]string, len(files))
+	for i, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cwd, f)
+		}
+		if out[i], err = filepath.Rel(root, f); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// cmdRunHook runs the checks in a git repository.
+//
+// Use a precise "stash, run checks, unstash" to ensure that the check is
+// properly run on the data in the index.
+func (a *application) cmdRunHook(ctx context.Context, repo scm.Repo, mode string, noUpdate bool) error {
+	switch checks.Mode(mode) {
+	case checks.PreCommit:
+		a.onboarding = true
+		return a.runPreCommit(ctx, repo)
+
+	case checks.PrePush:
+		a.onboarding = true
+		return a.runPrePush(ctx, repo)
+
+	case checks.ContinuousIntegration, checks.Nightly:
+		// Always runs all tests on CI.
+		checked := scm.ReadOnlyRepo(repo)
+		if base := os.Getenv(mergeBaseEnvVar); base != "" {
+			r, _, cleanup, err := a.mergeWorktree(repo, base, repo.Eval(string(scm.Head)))
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			checked = r
+		}
+		change, err := checked.Between(scm.Current, scm.Initial, a.ignorePatterns)
+		if err != nil {
+			return err
+		}
+		mode := []checks.Mode{checks.Mode(mode)}
+
+		// This is a special case, some users want reproducible builds and in this
 		// case they do not want any external reference and want to enforce
 		// noUpdate, but many people may not care (yet). So default to fetching but
 		// it can be overriden.
@@ -620,9 +1422,9 @@ func (a *application) cmdRunHook(repo scm.Repo, mode string, noUpdate bool) erro
 		prereqReady.Add(1)
 		go func() {
 			defer prereqReady.Done()
-			errCh <- a.cmdInstallPrereq(repo, mode, noUpdate)
+			errCh <- a.cmdInstallPrereq(ctx, repo, mode, noUpdate)
 		}()
-		err = a.runChecks(change, mode, &prereqReady)
+		err = a.runChecks(ctx, change, mode, skipFor(repo, scm.Head), &prereqReady)
 		if err2 := <-errCh; err2 != nil {
 			return err2
 		}
@@ -644,7 +1446,7 @@ func (a *application) cmdWriteConfig(repo scm.ReadOnlyRepo, configPath string) e
 }
 
 // mainImpl implements pcg.
-func mainImpl() error {
+func mainImpl() (err error) {
 	a := application{}
 
 	exec, args := os.Args[0], os.Args[1:]
@@ -675,20 +1477,97 @@ func mainImpl() error {
 	verboseFlag := fs.Bool("v", checks.IsContinuousIntegration() || os.Getenv("VERBOSE") != "", "enables verbose logging output")
 	allFlag := fs.Bool("a", false, "runs checks as if all files had been modified")
 	againstFlag := fs.String("r", "", "runs checks on files modified since this revision, as evaluated by your scm repo")
+	sinceFlag := fs.String("since", "", "runs checks on files modified since the newest commit older than this date or duration, e.g. 2.weeks")
 	noUpdateFlag := fs.Bool("n", false, "disallow using go get even if a prerequisite is missing; bail out instead")
 	configPathFlag := fs.String("c", "pre-commit-go.yml", "file name of the config to load")
 	modeFlag := fs.String("m", "", "comma separated list of modes to process; default depends on the command")
-	fs.IntVar(&a.maxConcurrent, "C", 0, "maximum number of concurrent processes")
+	fs.IntVar(&a.maxConcurrent, "C", 0, "maximum number of concurrent processes; defaults to the number of CPUs, -1 for no maximum")
+	artifactsFlag := fs.String("artifacts", "", "directory where run artifacts are preserved; overrides artifacts_dir")
+	filesFlag := fs.Bool("files", false, "runs checks on the files listed as arguments instead of diffing; use - to read the list from stdin")
+	archiveFlag := fs.String("archive", "", "runs checks on all the files of this .tar, .tar.gz or .zip archive instead of the checkout")
+	patchFlag := fs.String("patch", "", "runs checks on each patch of this mbox file, e.g. from git format-patch --stdout, applied in a temporary worktree at -r or HEAD")
+	mergeFlag := fs.String("merge", "", "runs checks on the result of merging HEAD into this revision in a temporary worktree, e.g. the target branch of a pull request")
+	fs.BoolVar(&a.failFast, "fail-fast", false, "cancels the remaining checks as soon as one fails; see fail_fast")
+	fs.Var(&a.shard, "shard", "runs only this part of the test packages, e.g. 2/5 for the second of five CI machines; combine the coverage with merge-coverage")
+	fs.BoolVar(&a.resume, "resume", false, "skips the checks that passed in a previous run on the same files, e.g. one interrupted by a timeout")
+	forceFlag := fs.Bool("force", false, "overwrites the existing hooks not installed by pcg instead of running them from pcg's hooks")
+	templateFlag := fs.Bool("template", false, "installs the hooks in init.templateDir instead of the checkout, so new clones get them")
+	globalFlag := fs.Bool("global", false, "installs the hooks in core.hooksPath instead of the checkout, so all the repositories with a pre-commit-go.yml get them")
+	exitSummaryFlag := fs.String("exit-summary", "", "writes the result as JSON to this file descriptor number, e.g. 3, or path when pcg exits; defaults to $"+exitSummaryEnvVar)
+	summaryFlag := fs.Bool("summary", false, "prints the checks sorted by duration with their package count and cache hits after the run")
+	reporterFlag := fs.String("reporter", "text", "output format of check failures; one of "+strings.Join(reporterNames(), ", "))
 	if err := fs.Parse(flags); err != nil {
 		return err
 	}
+	dest := *exitSummaryFlag
+	if dest == "" {
+		dest = os.Getenv(exitSummaryEnvVar)
+	}
+	// Don't leak it to the checks' processes, which may run pcg.
+	os.Unsetenv(exitSummaryEnvVar)
+	if a.exit = newExitSummary(commands[0], dest); a.exit != nil {
+		defer func() {
+			if err2 := a.exit.write(err); err2 != nil && err == nil {
+				err = err2
+			}
+		}()
+	}
 
+	newReporter, ok := knownReporters[*reporterFlag]
+	if !ok {
+		return fmt.Errorf("invalid -reporter %q; supported values: %s", *reporterFlag, strings.Join(reporterNames(), ", "))
+	}
+	a.reporter = newReporter(os.Stdout)
+	// The progress display would corrupt the verbose logs and the structured
+	// reports.
+	a.progress = !*verboseFlag && *reporterFlag == "text" && isTerminal(os.Stdout)
+	if t, ok := a.reporter.(*textReporter); ok {
+		t.color = useColor(os.Stdout)
+		t.summary = !a.progress && !*summaryFlag
+	}
+	if *summaryFlag {
+		// Don't corrupt the structured reports.
+		a.summary = os.Stderr
+		if *reporterFlag == "text" {
+			a.summary = os.Stdout
+		}
+	}
+	if checks.IsContinuousIntegration() {
+		if g := gerritFromEnv(); g != nil {
+			a.reporter = newGerritReporter(a.reporter, g)
+		}
+	}
+
+	if *sinceFlag != "" && *againstFlag != "" {
+		return errors.New("-since can't be used with -r")
+	}
+	if *mergeFlag != "" && (*filesFlag || *againstFlag != "" || *sinceFlag != "" || *patchFlag != "" || *archiveFlag != "") {
+		return errors.New("-merge can't be used with -files, -r, -since, -patch or -archive")
+	}
 	if *allFlag {
 		if *againstFlag != "" {
 			return errors.New("-a can't be used with -r")
 		}
+		if *sinceFlag != "" {
+			return errors.New("-a can't be used with -since")
+		}
 		*againstFlag = string(scm.Initial)
 	}
+	files := fs.Args()
+	if *filesFlag {
+		if *allFlag || *againstFlag != "" || *sinceFlag != "" {
+			return errors.New("-files can't be used with -a, -r or -since")
+		}
+		if len(files) == 0 {
+			return errors.New("-files requires at least one file; use - to read from stdin")
+		}
+	}
+	if *patchFlag != "" && (*filesFlag || *allFlag || *sinceFlag != "") {
+		return errors.New("-patch can't be used with -files, -a or -since")
+	}
+	if *archiveFlag != "" && (*filesFlag || *allFlag || *againstFlag != "" || *sinceFlag != "" || *patchFlag != "") {
+		return errors.New("-archive can't be used with -files, -a, -r, -since or -patch")
+	}
 
 	log.SetFlags(log.Lmicroseconds)
 	if !*verboseFlag {
@@ -704,7 +1583,7 @@ func mainImpl() error {
 	if err != nil {
 		return err
 	}
-	repo, err := scm.GetRepo(cwd, "")
+	repo, err := getRepo(cwd, *configPathFlag)
 	if err != nil {
 		return err
 	}
@@ -712,11 +1591,143 @@ func mainImpl() error {
 	var configPath string
 	configPath, a.config = loadConfig(repo, *configPathFlag)
 	log.Printf("config: %s", configPath)
-	if a.maxConcurrent > 0 {
-		log.Printf("using %d maximum concurrent goroutines", a.maxConcurrent)
+	a.configName = *configPathFlag
+	a.configCheckedIn = configPath == filepath.Join(repo.Root(), *configPathFlag)
+	a.ignorePatterns = loadIgnorePatterns(repo, a.config)
+	if a.toolsDir = a.config.ToolsPath(repo.Root()); a.toolsDir != "" {
+		// The checks and the detection of the prerequisites find the tools
+		// installed in the project-local directory first.
+		log.Printf("tools: %s", a.toolsDir)
+		if err := os.Setenv("PATH", internal.MergeGOPATH(checks.ToolsBin(a.toolsDir), os.Getenv("PATH"))); err != nil {
+			return err
+		}
+	}
+	a.lanes = checks.NewLanes(repo)
+	switch commands[0] {
+	case "install", "i", "installrun", "prereq", "p", "run", "r", "run-hook", "serve", "watch":
+		if err := a.loadOffline(); err != nil {
+			return err
+		}
+	}
+	if a.config.Pushgateway != nil && checks.IsContinuousIntegration() {
+		a.reporter = newPushgatewayReporter(a.reporter, a.config.Pushgateway)
+	}
+	if a.config.ImportPath != "" {
+		switch commands[0] {
+		case "installrun", "merge-coverage", "run", "r", "run-hook", "serve", "watch":
+			r, cleanup, err := scm.ImportAs(repo, a.config.ImportPath)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := cleanup(); err != nil {
+					log.Printf("failed to delete temporary GOPATH: %s", err)
+				}
+			}()
+			repo = r
+		}
+	}
+	repo.SetRenameThreshold(a.config.RenameThreshold)
+	if *verboseFlag {
+		switch commands[0] {
+		case "installrun", "run", "r", "run-hook", "serve", "watch":
+			logGoEnv(repo)
+		}
+	}
+	if *artifactsFlag != "" {
+		a.config.ArtifactsDir = *artifactsFlag
+	}
+	if a.maxConcurrent != 0 {
+		log.Printf("using %d maximum concurrent processes", a.maxConcurrent)
 		a.config.MaxConcurrent = a.maxConcurrent
 	}
 
+	if *filesFlag {
+		switch commands[0] {
+		case "installrun", "run", "r":
+		default:
+			return fmt.Errorf("-files can't be used with %s", commands[0])
+		}
+	}
+	if a.resume {
+		switch commands[0] {
+		case "installrun", "run", "r", "run-hook":
+		default:
+			return fmt.Errorf("-resume can't be used with %s", commands[0])
+		}
+	}
+	if a.shard.Enabled() {
+		switch commands[0] {
+		case "installrun", "run", "r", "run-hook":
+		default:
+			return fmt.Errorf("-shard can't be used with %s", commands[0])
+		}
+		if a.config.ArtifactsDir == "" {
+			return errors.New("-shard requires -artifacts or artifacts_dir to keep the coverage profile of the shard")
+		}
+	}
+	if a.failFast {
+		switch commands[0] {
+		case "installrun", "run", "r", "run-hook", "serve", "watch":
+		default:
+			return fmt.Errorf("-fail-fast can't be used with %s", commands[0])
+		}
+	}
+	if *summaryFlag {
+		switch commands[0] {
+		case "installrun", "run", "r", "run-hook":
+		default:
+			return fmt.Errorf("-summary can't be used with %s", commands[0])
+		}
+	}
+	if *forceFlag {
+		switch commands[0] {
+		case "install", "i", "installrun":
+		default:
+			return fmt.Errorf("-force can't be used with %s", commands[0])
+		}
+	}
+	if *templateFlag || *globalFlag {
+		if *templateFlag && *globalFlag {
+			return errors.New("-template can't be used with -global")
+		}
+		switch commands[0] {
+		case "install", "i":
+		default:
+			return fmt.Errorf("-template and -global can't be used with %s", commands[0])
+		}
+	}
+	if *patchFlag != "" || *archiveFlag != "" || *mergeFlag != "" {
+		switch commands[0] {
+		case "run", "r":
+		default:
+			return fmt.Errorf("-patch, -archive and -merge can't be used with %s", commands[0])
+		}
+	}
+
+	// Ctrl-C cancels the checks, which kills their subprocesses.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+	go func() {
+		select {
+		case <-interrupted:
+			log.Printf("interrupted")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if commands[0] != "stats" {
+		if a.stats = newUsageRecord(commands[0]); a.stats != nil {
+			defer func() {
+				a.stats.save(err)
+			}()
+		}
+	}
+
 	switch cmd := commands[0]; cmd {
 	case "help", "-help", "-h":
 		cmd = "help"
@@ -765,7 +1776,13 @@ func mainImpl() error {
 		}
 		var prereqReady sync.WaitGroup
 		prereqReady.Add(1)
-		return a.cmdInstall(repo, modes, *noUpdateFlag, &prereqReady)
+		target := repoHooks
+		if *templateFlag {
+			target = templateHooks
+		} else if *globalFlag {
+			target = globalHooks
+		}
+		return a.cmdInstall(ctx, repo, modes, *noUpdateFlag, *forceFlag, target, &prereqReady)
 
 	case "installrun":
 		if len(modes) == 0 {
@@ -777,9 +1794,14 @@ func mainImpl() error {
 		prereqReady.Add(1)
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- a.cmdInstall(repo, modes, *noUpdateFlag, &prereqReady)
+			errCh <- a.cmdInstall(ctx, repo, modes, *noUpdateFlag, *forceFlag, repoHooks, &prereqReady)
 		}()
-		err := a.cmdRun(repo, modes, *againstFlag, &prereqReady)
+		var err error
+		if *filesFlag {
+			err = a.cmdRunFiles(ctx, repo, modes, files, &prereqReady)
+		} else {
+			err = a.cmdRun(ctx, repo, modes, *againstFlag, *sinceFlag, &prereqReady)
+		}
 		if err2 := <-errCh; err2 != nil {
 			return err2
 		}
@@ -796,17 +1818,38 @@ func mainImpl() error {
 		if len(modes) == 0 {
 			modes = checks.AllModes
 		}
-		return a.cmdInstallPrereq(repo, modes, *noUpdateFlag)
+		return a.cmdInstallPrereq(ctx, repo, modes, *noUpdateFlag)
 
 	case "run", "r":
 		cmd = "run"
 		if *noUpdateFlag != false {
 			return fmt.Errorf("-n can't be used with %s", cmd)
 		}
+		if *patchFlag != "" {
+			if len(modes) == 0 {
+				modes = []checks.Mode{checks.ContinuousIntegration}
+			}
+			return a.cmdRunPatch(ctx, repo, modes, *patchFlag, *againstFlag, &sync.WaitGroup{})
+		}
+		if *archiveFlag != "" {
+			if len(modes) == 0 {
+				modes = []checks.Mode{checks.ContinuousIntegration}
+			}
+			return a.cmdRunArchive(ctx, repo, modes, *archiveFlag, &sync.WaitGroup{})
+		}
+		if *mergeFlag != "" {
+			if len(modes) == 0 {
+				modes = []checks.Mode{checks.ContinuousIntegration}
+			}
+			return a.cmdRunMerge(ctx, repo, modes, *mergeFlag, *allFlag, &sync.WaitGroup{})
+		}
 		if len(modes) == 0 {
 			modes = []checks.Mode{checks.PrePush}
 		}
-		return a.cmdRun(repo, modes, *againstFlag, &sync.WaitGroup{})
+		if *filesFlag {
+			return a.cmdRunFiles(ctx, repo, modes, files, &sync.WaitGroup{})
+		}
+		return a.cmdRun(ctx, repo, modes, *againstFlag, *sinceFlag, &sync.WaitGroup{})
 
 	case "run-hook":
 		if modes != nil {
@@ -822,7 +1865,152 @@ func mainImpl() error {
 		if len(commands) < 2 {
 			return errors.New("run-hook is only meant to be used by hooks")
 		}
-		return a.cmdRunHook(repo, commands[1], *noUpdateFlag)
+		return a.cmdRunHook(ctx, repo, commands[1], *noUpdateFlag)
+
+	case "validate":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdValidate(configPath)
+
+	case "why":
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdWhy(os.Stdout, repo, modes, commands[1:])
+
+	case "merge-coverage":
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		if len(modes) == 0 {
+			modes = []checks.Mode{checks.ContinuousIntegration}
+		}
+		return a.cmdMergeCoverage(os.Stdout, repo, modes, *againstFlag, append(commands[1:], files...))
+
+	case "messages":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdMessages(os.Stdout)
+
+	case "serve":
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		if len(modes) == 0 {
+			modes = []checks.Mode{checks.PreCommit}
+		}
+		// stdout is the JSON-RPC connection; nothing else may be printed there.
+		a.progress = false
+		a.onboarding = false
+		return a.cmdServe(ctx, newStdioConn(os.Stdin, os.Stdout), repo, modes, *againstFlag)
+
+	case "stats":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		action := ""
+		if len(commands) > 1 {
+			action = commands[1]
+		}
+		return a.cmdStats(os.Stdout, action)
+
+	case "doctor":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdDoctor(ctx, os.Stdout, repo, configPath)
+
+	case "flaky":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdFlaky(os.Stdout, repo)
+
+	case "history":
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return a.cmdHistory(os.Stdout, repo, modes, commands[1:])
+
+	case "stress":
+		if modes != nil {
+			return fmt.Errorf("-m can't be used with %s", cmd)
+		}
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *againstFlag != "" {
+			return fmt.Errorf("-r can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		return cmdStress(os.Stdout, commands[1:])
 
 	case "version":
 		if modes != nil {
@@ -840,6 +2028,18 @@ func mainImpl() error {
 		fmt.Println(version)
 		return nil
 
+	case "watch":
+		if *allFlag != false {
+			return fmt.Errorf("-a can't be used with %s", cmd)
+		}
+		if *noUpdateFlag != false {
+			return fmt.Errorf("-n can't be used with %s", cmd)
+		}
+		if len(modes) == 0 {
+			modes = []checks.Mode{checks.PreCommit}
+		}
+		return a.cmdWatch(ctx, os.Stdout, repo, modes, *againstFlag)
+
 	case "writeconfig", "w":
 		if modes != nil {
 			return fmt.Errorf("-m can't be used with %s", cmd)
@@ -854,7 +2054,7 @@ func mainImpl() error {
 		return a.cmdWriteConfig(repo, *configPathFlag)
 
 	default:
-		return fmt.Errorf("unknown command %q, try 'help'", cmd)
+		return fmt.Errorf(tr(msgUnknownCommand), cmd)
 	}
 }
 
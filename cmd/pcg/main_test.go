@@ -5,10 +5,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
 	"github.com/maruel/ut"
 )
 
@@ -22,10 +31,12 @@ func TestProcessModes(t *testing.T) {
 		{"", nil, nil},
 		{"pc", []checks.Mode{checks.PreCommit}, nil},
 		{"fast", []checks.Mode{checks.PreCommit}, nil},
+		{"merge", []checks.Mode{checks.PreCommitMerge}, nil},
 		{"pp", []checks.Mode{checks.PrePush}, nil},
 		{"slow", []checks.Mode{checks.PrePush}, nil},
 		{"ci", []checks.Mode{checks.ContinuousIntegration}, nil},
 		{"full", []checks.Mode{checks.ContinuousIntegration}, nil},
+		{"nightly", []checks.Mode{checks.Nightly}, nil},
 		{"foo", nil, errors.New("invalid mode \"foo\"\n\n" + helpModes)},
 	}
 	for i, line := range data {
@@ -34,3 +45,94 @@ func TestProcessModes(t *testing.T) {
 		ut.AssertEqualIndex(t, i, line.err, err)
 	}
 }
+
+func TestIsAmendCommandLine(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		in       string
+		expected bool
+	}{
+		{"git commit --amend", true},
+		{"/usr/bin/git -c core.editor=vi commit -a --amend --no-edit", true},
+		{"git commit -m foo", false},
+		{"git commit -- --amend", false},
+		{"git rebase --amend", false},
+		{"/bin/sh .git/hooks/pre-commit", false},
+		{"", false},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, isAmendCommandLine(line.in))
+	}
+}
+
+func TestStashMode(t *testing.T) {
+	t.Parallel()
+	config := checks.New("0.1")
+	s := config.Modes[checks.PreCommit]
+	s.Stash = "sometimes"
+	config.Modes[checks.PreCommit] = s
+	config.Modes[checks.PreCommitMerge] = checks.Settings{Stash: "never"}
+	a := &application{config: config}
+	// The setting of the mode being run is used; neither needs the repository.
+	stashed, err := a.stash(nil, checks.PreCommitMerge)
+	ut.AssertEqual(t, false, stashed)
+	ut.AssertEqual(t, nil, err)
+	stashed, err = a.stash(nil, checks.PreCommit)
+	ut.AssertEqual(t, false, stashed)
+	ut.AssertEqual(t, errors.New("invalid stash \"sometimes\"; expected \"always\", \"auto\", \"never\" or empty"), err)
+}
+
+func TestRunChangeCLI(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	// A fake source control, which has no scm directory to keep pcg's state.
+	change := fakeChange(t, tmpDir)
+	b := &bytes.Buffer{}
+	a := &application{config: failingConfig(), reporter: knownReporters["json"](b)}
+	err = a.runChange(context.Background(), change, []checks.Mode{checks.PreCommit}, nil, nil)
+	// The check ran and its failure is reported.
+	ut.AssertEqual(t, true, err != nil)
+	ut.AssertEqual(t, true, strings.Contains(b.String(), `"message": "bad"`))
+}
+
+// Private stuff.
+
+// fakeChange returns the change of all the files of a checkout in tmpDir of a
+// source control driven by scm.CLI.
+func fakeChange(t *testing.T, tmpDir string) scm.Change {
+	for name, content := range map[string]string{".fake/files": "a.go\n", "a.go": "package a\n"} {
+		p := filepath.Join(tmpDir, name)
+		ut.AssertEqual(t, nil, os.MkdirAll(filepath.Dir(p), 0700))
+		ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte(content), 0600))
+	}
+	repo, err := scm.GetCLIRepo(tmpDir, "", &scm.CLI{
+		Name:    "fake",
+		Root:    []string{"sh", "-c", "test -d .fake && pwd -P"},
+		Files:   []string{"cat", ".fake/files"},
+		Changed: []string{"cat", ".fake/files"},
+		Head:    []string{"echo", "r1"},
+		Content: []string{"cat", "{path}"},
+	})
+	ut.AssertEqual(t, nil, err)
+	change, err := repo.Between(scm.Current, scm.Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	return change
+}
+
+// failingConfig returns a configuration with a check failing on a.go in
+// pre-commit.
+func failingConfig() *checks.Config {
+	custom := &checks.Custom{DisplayName: "lint", Command: []string{"sh", "-c", "echo a.go:1: bad; exit 1"}, CheckExitCode: true}
+	return &checks.Config{Modes: map[checks.Mode]checks.Settings{
+		checks.PreCommit: {Checks: checks.Checks{"custom": {custom}}, Options: checks.Options{MaxDuration: 60}},
+	}}
+}
@@ -0,0 +1,78 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// mergeBaseEnvVar is the environment variable that makes run-hook
+// continuous-integration check the merge of HEAD into this revision instead of
+// HEAD, e.g. the target branch of a pull request.
+const mergeBaseEnvVar = "PCG_MERGE_BASE"
+
+// mergeWorktree returns a temporary worktree of repo with head merged into
+// base, like the merge ref created by GitHub for a pull request. Conflicts are
+// reported as an error.
+//
+// cleanup must be called to delete the worktree.
+func (a *application) mergeWorktree(repo scm.ReadOnlyRepo, base string, head scm.Commit) (scm.Repo, scm.Commit, func(), error) {
+	b := repo.Eval(base)
+	if b == scm.Invalid {
+		return nil, "", nil, fmt.Errorf("invalid commit %q", base)
+	}
+	wt, cleanupWorktree, err := scm.NewWorktree(repo, b)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	r, cleanupImport, err := scm.ImportAs(wt, a.config.ImportPath)
+	if err != nil {
+		_ = cleanupWorktree()
+		return nil, "", nil, err
+	}
+	cleanup := func() {
+		if err := cleanupImport(); err != nil {
+			log.Printf("failed to delete temporary GOPATH: %s", err)
+		}
+		if err := cleanupWorktree(); err != nil {
+			log.Printf("failed to delete worktree: %s", err)
+		}
+	}
+	if err := r.Merge(head); err != nil {
+		cleanup()
+		return nil, "", nil, err
+	}
+	r.SetRenameThreshold(a.config.RenameThreshold)
+	log.Printf("checking the merge of %s into %s", head, b)
+	return r, b, cleanup, nil
+}
+
+// cmdRunMerge runs the enabled checks on the files modified by HEAD when
+// merged into base, in a temporary worktree. It catches the semantic conflicts
+// between HEAD and the changes that landed in base in the meantime. With all,
+// all the files of the merge are checked.
+func (a *application) cmdRunMerge(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, base string, all bool, prereqReady *sync.WaitGroup) error {
+	head := repo.Eval(string(scm.Head))
+	r, b, cleanup, err := a.mergeWorktree(repo, base, head)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	old := b
+	if all {
+		old = scm.Initial
+	}
+	change, err := r.Between(r.Eval(string(scm.Head)), old, a.ignorePatterns)
+	if err != nil {
+		return err
+	}
+	return a.runChecks(ctx, change, modes, skipFor(repo, head), prereqReady)
+}
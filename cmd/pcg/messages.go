@@ -0,0 +1,195 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// catalogDir is the directory in the user's configuration directory holding
+// the message catalogs, one <language>.yml file per language, e.g. fr.yml or
+// pt_BR.yml; see userConfigPath.
+//
+// A catalog maps the English messages to their translation. Run
+// 'pcg messages' to get a catalog to translate. The output of the checks is
+// never translated.
+const catalogDir = "pcg-messages"
+
+// User facing messages. They are the keys of the catalogs.
+const (
+	msgUnknownCommand   = "unknown command %q, try 'help'"
+	msgChecksFailed     = "checks failed in %1.2fs"
+	msgWarning          = "warning:"
+	msgGroupFail        = "--- FAIL: %s"
+	msgGroupWarn        = "--- WARN: %s"
+	msgStatusOK         = "ok"
+	msgStatusFail       = "FAIL"
+	msgStatusWarn       = "warn"
+	msgColumnCheck      = "check"
+	msgColumnResult     = "result"
+	msgColumnDuration   = "duration"
+	msgColumnShare      = "share"
+	msgColumnPackages   = "packages"
+	msgColumnCacheHits  = "cache hits"
+	msgTotal            = "total"
+	msgProgress         = "[%d/%d] %d running, %d failed"
+	msgHookConflict     = "%s was not installed by pcg and %s already exists; merge them or use -force to overwrite %s"
+	msgTemplateDirUnset = "init.templateDir is not set; set it first, e.g. with: git config --global init.templateDir ~/.git-templates"
+	msgStatsEnabled     = "Usage statistics are recorded in %s.\nThey never leave this machine; use 'pcg stats export' to share them.\n"
+	msgStatsDisabled    = "Usage statistics are disabled and deleted.\n"
+	msgStatsOff         = "Usage statistics are disabled; run 'pcg stats enable' to record them locally.\n"
+	msgOnboarding       = "\nThis repository runs pcg (pre-commit-go) on each commit and push to check the\nchanges with the checks configured in %s.\n\nTo see the details of the failure:\n    %s\nTo run only the failed checks (%s):\n    %s\nIn an emergency, 'git commit --no-verify' or 'git push --no-verify' bypasses\nthe hook.\n"
+)
+
+// messages are all the messages that can be translated, in the order of
+// 'pcg messages'.
+var messages = []string{
+	helpText,
+	helpModes,
+	msgUnknownCommand,
+	msgChecksFailed,
+	msgWarning,
+	msgGroupFail,
+	msgGroupWarn,
+	msgStatusOK,
+	msgStatusFail,
+	msgStatusWarn,
+	msgColumnCheck,
+	msgColumnResult,
+	msgColumnDuration,
+	msgColumnShare,
+	msgColumnPackages,
+	msgColumnCacheHits,
+	msgTotal,
+	msgProgress,
+	msgHookConflict,
+	msgTemplateDirUnset,
+	msgStatsEnabled,
+	msgStatsDisabled,
+	msgStatsOff,
+	msgOnboarding,
+}
+
+// reVerb matches the fmt verbs and the template actions, which must be kept
+// by the translations.
+var reVerb = regexp.MustCompile(`%[-+# 0-9.*]*[a-zA-Z%]|\{\{[^}]*\}\}`)
+
+var (
+	catalogOnce sync.Once
+	catalog     map[string]string
+)
+
+// tr returns the translation of the English message s in the user's locale,
+// or s if there is none.
+func tr(s string) string {
+	catalogOnce.Do(func() {
+		if lang := parseLocale(os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")); lang != "" {
+			if dir := userConfigPath(catalogDir); dir != "" {
+				catalog = loadCatalog(dir, lang)
+			}
+		}
+	})
+	if t := catalog[s]; t != "" {
+		return t
+	}
+	return s
+}
+
+// parseLocale returns the language of the first locale set in values, in
+// decreasing priority, e.g. "pt_BR" for "pt_BR.UTF-8". It returns "" for
+// English and the C locale.
+func parseLocale(values ...string) string {
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if i := strings.IndexAny(v, ".@"); i != -1 {
+			v = v[:i]
+		}
+		if v == "C" || v == "POSIX" || v == "en" || strings.HasPrefix(v, "en_") {
+			return ""
+		}
+		return v
+	}
+	return ""
+}
+
+// loadCatalog returns the catalog of lang in dir. The catalog of the language
+// without the territory, e.g. pt.yml for pt_BR, provides the missing
+// messages.
+//
+// The translations that don't keep the fmt verbs and template actions of the
+// message are ignored, so a mistake doesn't break the output.
+func loadCatalog(dir, lang string) map[string]string {
+	out := map[string]string{}
+	names := []string{lang}
+	if i := strings.IndexByte(lang, '_'); i != -1 {
+		names = append(names, lang[:i])
+	}
+	for _, name := range names {
+		p := filepath.Join(dir, name+".yml")
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("failed to read %s: %s", p, err)
+			}
+			continue
+		}
+		c := map[string]string{}
+		if err = yaml.Unmarshal(content, &c); err != nil {
+			log.Printf("failed to parse %s: %s", p, err)
+			continue
+		}
+		for k, v := range c {
+			if v == "" || out[k] != "" {
+				continue
+			}
+			if strings.Join(reVerb.FindAllString(k, -1), " ") != strings.Join(reVerb.FindAllString(v, -1), " ") {
+				log.Printf("%s: ignoring the translation of %q which doesn't keep its verbs", p, k)
+				continue
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// cmdMessages writes the catalog of the user's locale to translate, with the
+// existing translations.
+func (a *application) cmdMessages(w io.Writer) error {
+	c := yaml.MapSlice{}
+	for _, m := range messages {
+		t := tr(m)
+		if t == m {
+			t = ""
+		}
+		c = append(c, yaml.MapItem{Key: m, Value: t})
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "# pcg message catalog; save as ~/.config/%s/<language>.yml\n# and fill in the translations. An empty one keeps the English message.\n%s", catalogDir, b)
+	return err
+}
+
+// pad returns s followed by spaces to be at least n characters wide.
+func pad(s string, n int) string {
+	if l := utf8.RuneCountInString(s); l < n {
+		return s + strings.Repeat(" ", n-l)
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParseLocale(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		values   []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"", "", "fr_CA.UTF-8"}, "fr_CA"},
+		{[]string{"de_DE@euro", "", "fr_CA.UTF-8"}, "de_DE"},
+		{[]string{"", "pt", ""}, "pt"},
+		{[]string{"C.UTF-8"}, ""},
+		{[]string{"POSIX"}, ""},
+		{[]string{"en_US.UTF-8"}, ""},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, parseLocale(line.values...))
+	}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	pt := "ok: certo\nFAIL: FALHA\n\"checks failed in %1.2fs\": \"verificações falharam\"\n"
+	ptBR := "FAIL: FALHOU\nwarn: \"\"\n"
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(tmpDir, "pt.yml"), []byte(pt), 0600))
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(tmpDir, "pt_BR.yml"), []byte(ptBR), 0600))
+	// The territory specific catalog has precedence, the translation missing
+	// the verb is ignored.
+	ut.AssertEqual(t, map[string]string{"ok": "certo", "FAIL": "FALHOU"}, loadCatalog(tmpDir, "pt_BR"))
+	ut.AssertEqual(t, map[string]string{"ok": "certo", "FAIL": "FALHA"}, loadCatalog(tmpDir, "pt"))
+	ut.AssertEqual(t, map[string]string{}, loadCatalog(tmpDir, "fr"))
+}
+
+func TestMessages(t *testing.T) {
+	t.Parallel()
+	seen := map[string]bool{}
+	for _, m := range messages {
+		ut.AssertEqual(t, false, seen[m])
+		seen[m] = true
+	}
+	_, err := template.New("help").Parse(helpText)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"%-*s", "%1.2f"}, reVerb.FindAllString("{{.Usage}} %-*s and %1.2fs", -1)[1:])
+
+	// The catalog to translate can be loaded back.
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, (&application{}).cmdMessages(b))
+	c := map[string]string{}
+	ut.AssertEqual(t, nil, yaml.Unmarshal(b.Bytes(), &c))
+	ut.AssertEqual(t, len(messages), len(c))
+	for _, m := range messages {
+		_, ok := c[m]
+		ut.AssertEqual(t, true, ok)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// offlineEnvVar overrides the offline setting of the configuration, e.g. "1"
+// on an air-gapped CI or "0" to populate the tools directory on a connected
+// machine.
+const offlineEnvVar = "PCG_OFFLINE"
+
+// loadOffline applies offlineEnvVar to the configuration and, when offline,
+// verifies the tools directory against its checksums.
+func (a *application) loadOffline() error {
+	if v := os.Getenv(offlineEnvVar); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid $%s: %s", offlineEnvVar, err)
+		}
+		a.config.Offline = b
+	}
+	if !a.config.Offline {
+		return nil
+	}
+	if a.toolsDir == "" {
+		return fmt.Errorf("offline requires tools_dir to resolve the prerequisites; see CONFIGURATION.md")
+	}
+	return checks.VerifyTools(a.toolsDir)
+}
+
+// missingTools returns the sorted URLs of the prerequisites of the checks
+// enabled in modes that are not in the tools directory.
+func (a *application) missingTools(modes []checks.Mode) []string {
+	bin := checks.ToolsBin(a.toolsDir)
+	m := map[string]bool{}
+	for _, c := range checks.NewRunner(a.config, modes).Checks {
+		for _, p := range c.GetPrerequisites() {
+			if !m[p.URL] && !p.IsPresentIn(bin) {
+				m[p.URL] = true
+			}
+		}
+	}
+	urls := make([]string, 0, len(m))
+	for url := range m {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// errMissingTools returns the error reported when prerequisites are missing
+// from the tools directory in offline mode.
+func (a *application) errMissingTools(urls []string) error {
+	return fmt.Errorf("offline but the prerequisites are not in %s:\n  %s\nrun 'PCG_OFFLINE=0 pcg prereq' on a connected machine to install them and record their checksums",
+		checks.ToolsBin(a.toolsDir), strings.Join(urls, "\n  "))
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// onboardedKey is the key in the root bucket of the storage recording that
+// the onboarding message was printed in this checkout.
+const onboardedKey = "pcg-onboarded"
+
+// printOnboarding prints the onboarding block the first time a hook fails in
+// the checkout, when onboarding_message is configured. failed are the names of
+// the checks that failed and enabled the names of all the checks run.
+//
+// Failures are logged but otherwise ignored.
+func (a *application) printOnboarding(w io.Writer, s checks.Storage, modes []checks.Mode, failed, enabled []string) {
+	if !a.onboarding || a.config.OnboardingMessage == "" || len(failed) == 0 {
+		return
+	}
+	if _, found, err := s.Get("", onboardedKey); err != nil || found {
+		if err != nil {
+			log.Printf("failed to read %s: %s", onboardedKey, err)
+		}
+		return
+	}
+	if _, err := io.WriteString(w, onboardingText(a.configName, a.config.OnboardingMessage, modes, failed, enabled)); err != nil {
+		log.Printf("failed to print the onboarding message: %s", err)
+		return
+	}
+	if err := s.Put("", onboardedKey, nil); err != nil {
+		log.Printf("failed to write %s: %s", onboardedKey, err)
+	}
+}
+
+// onboardingText returns the onboarding block followed by the message of the
+// team.
+func onboardingText(configName, message string, modes []checks.Mode, failed, enabled []string) string {
+	m := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		m = append(m, string(mode))
+	}
+	run := "pcg run -m " + strings.Join(m, ",")
+	if configName != "pre-commit-go.yml" {
+		run += " -c " + configName
+	}
+	// PCG_SKIP skips the checks that passed.
+	isFailed := map[string]bool{}
+	for _, name := range failed {
+		isFailed[name] = true
+	}
+	var others []string
+	for _, name := range enabled {
+		if !isFailed[name] {
+			others = append(others, name)
+		}
+	}
+	only := run
+	if len(others) != 0 {
+		only = skipEnvVar + "=" + strings.Join(others, ",") + " " + run
+	}
+	out := fmt.Sprintf(tr(msgOnboarding), configName, run+" -v", strings.Join(failed, ", "), only)
+	return out + "\n" + strings.TrimRight(message, "\n") + "\n"
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestOnboardingText(t *testing.T) {
+	t.Parallel()
+	out := onboardingText("pre-commit-go.yml", "See https://example.com/pcg for our thresholds.\n", []checks.Mode{checks.PreCommit}, []string{"gofmt"}, []string{"build", "gofmt", "test"})
+	expected := []string{
+		"",
+		"This repository runs pcg (pre-commit-go) on each commit and push to check the",
+		"changes with the checks configured in pre-commit-go.yml.",
+		"",
+		"To see the details of the failure:",
+		"    pcg run -m pre-commit -v",
+		"To run only the failed checks (gofmt):",
+		"    PCG_SKIP=build,test pcg run -m pre-commit",
+		"In an emergency, 'git commit --no-verify' or 'git push --no-verify' bypasses",
+		"the hook.",
+		"",
+		"See https://example.com/pcg for our thresholds.",
+		"",
+	}
+	ut.AssertEqual(t, expected, strings.Split(out, "\n"))
+
+	out = onboardingText("pcg.yml", "x", []checks.Mode{checks.PrePush}, []string{"gofmt"}, []string{"gofmt"})
+	ut.AssertEqual(t, true, strings.Contains(out, "(gofmt):\n    pcg run -m pre-push -c pcg.yml\n"))
+}
+
+func TestPrintOnboarding(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(td); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	s := &checks.FileStorage{Dir: td}
+	a := &application{config: &checks.Config{OnboardingMessage: "Welcome"}, configName: "pre-commit-go.yml"}
+	b := &bytes.Buffer{}
+	a.printOnboarding(b, s, []checks.Mode{checks.PreCommit}, []string{"gofmt"}, []string{"gofmt"})
+	ut.AssertEqual(t, "", b.String())
+
+	// Only printed once from the hooks.
+	a.onboarding = true
+	a.printOnboarding(b, s, []checks.Mode{checks.PreCommit}, []string{"gofmt"}, []string{"gofmt"})
+	ut.AssertEqual(t, true, strings.HasSuffix(b.String(), "\nWelcome\n"))
+	b.Reset()
+	a.printOnboarding(b, s, []checks.Mode{checks.PreCommit}, []string{"gofmt"}, []string{"gofmt"})
+	ut.AssertEqual(t, "", b.String())
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+	"gopkg.in/yaml.v2"
+)
+
+// requiredEnvVar is the environment variable containing the comma separated
+// list of checks required in continuous-integration, in addition to
+// required_checks.
+const requiredEnvVar = "PCG_REQUIRED_CHECKS"
+
+// policyRefEnvVar is the environment variable naming the commit, e.g.
+// "origin/master", whose configuration file required_checks are enforced. The
+// CI sets it to the base branch so a change can't drop a required check by
+// editing the configuration file.
+const policyRefEnvVar = "PCG_POLICY_REF"
+
+// policyFile is the file in the artifacts directory recording the required
+// checks and the checks that were actually run.
+const policyFile = "policy.json"
+
+// requiredList is the checks required, mapped to where the requirement comes
+// from.
+type requiredList map[string]string
+
+// policyRecord is the content of policyFile.
+type policyRecord struct {
+	Modes []checks.Mode `json:"modes"`
+	// Config is the SHA-256 of the configuration used, serialized as YAML.
+	Config   string       `json:"config"`
+	Required requiredList `json:"required"`
+	Run      []string     `json:"run"`
+	Skipped  []string     `json:"skipped"`
+	Missing  []string     `json:"missing"`
+}
+
+// enforcesPolicy returns true if the required checks are enforced when running
+// modes, i.e. the continuous-integration mode is run directly or inherited.
+func enforcesPolicy(config *checks.Config, modes []checks.Mode) bool {
+	for _, mode := range config.ResolveModes(modes) {
+		if mode == checks.ContinuousIntegration {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredChecks returns the checks required by PCG_REQUIRED_CHECKS, by the
+// configuration file at PCG_POLICY_REF and by the current configuration.
+func (a *application) requiredChecks(repo scm.ReadOnlyRepo) (requiredList, error) {
+	out := parseRequiredEnv(os.Getenv(requiredEnvVar))
+	if ref := os.Getenv(policyRefEnvVar); ref != "" {
+		c := repo.Eval(ref)
+		if c == scm.Invalid {
+			return nil, fmt.Errorf("%s=%s is not a valid commit", policyRefEnvVar, ref)
+		}
+		source := fmt.Sprintf("%s at %s", a.configName, ref)
+		if content, err := repo.ContentAt(c, a.configName); err != nil {
+			log.Printf("no required checks from %s: %s", source, err)
+		} else {
+			config := parseConfig(content, source)
+			if config == nil {
+				return nil, fmt.Errorf("%s is invalid", source)
+			}
+			out.add(config.RequiredChecks, source)
+		}
+	}
+	out.add(a.config.RequiredChecks, a.configName)
+	return out, nil
+}
+
+// parseRequiredEnv parses the value of PCG_REQUIRED_CHECKS.
+func parseRequiredEnv(v string) requiredList {
+	out := requiredList{}
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out[name] = requiredEnvVar
+		}
+	}
+	return out
+}
+
+// names returns the required checks sorted.
+func (r requiredList) names() []string {
+	out := make([]string, 0, len(r))
+	for name := range r {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// enforce returns skip without the required checks and a notice for each
+// required check that was requested to be skipped.
+func (r requiredList) enforce(skip skipList, enabled []checks.Check) (skipList, []error) {
+	out := skipList{}
+	var notices []error
+	for _, c := range enabled {
+		name := c.GetName()
+		source, ok := skip[name]
+		if !ok {
+			source, ok = skip[skipAll]
+		}
+		if !ok {
+			continue
+		}
+		if by, ok := r[name]; ok {
+			notices = append(notices, fmt.Errorf("check %s can't be skipped as requested by %s; it is required by %s", name, source, by))
+			continue
+		}
+		out[name] = source
+	}
+	return out, notices
+}
+
+// Private stuff.
+
+// add adds the names not already required.
+func (r requiredList) add(names []string, source string) {
+	for _, name := range names {
+		if _, ok := r[name]; !ok {
+			r[name] = source
+		}
+	}
+}
+
+// writePolicy records the policy enforced during a run in the artifacts
+// directory.
+//
+// Failures are logged but otherwise ignored.
+func writePolicy(artifactsDir string, config *checks.Config, rec *policyRecord) {
+	if b, err := yaml.Marshal(config); err != nil {
+		log.Printf("failed to marshal the config: %s", err)
+	} else {
+		h := sha256.Sum256(b)
+		rec.Config = hex.EncodeToString(h[:])
+	}
+	if b, err := json.MarshalIndent(rec, "", "  "); err != nil {
+		log.Printf("failed to marshal the policy: %s", err)
+	} else if err = ioutil.WriteFile(filepath.Join(artifactsDir, policyFile), append(b, '\n'), 0644); err != nil {
+		log.Printf("failed to write %s: %s", policyFile, err)
+	}
+}
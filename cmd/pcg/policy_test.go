@@ -0,0 +1,48 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestParseRequired(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, requiredList{}, parseRequiredEnv(""))
+	r := parseRequiredEnv("gosec, coverage,")
+	ut.AssertEqual(t, requiredList{"coverage": "PCG_REQUIRED_CHECKS", "gosec": "PCG_REQUIRED_CHECKS"}, r)
+	r.add([]string{"coverage", "test"}, "pre-commit-go.yml")
+	ut.AssertEqual(t, requiredList{"coverage": "PCG_REQUIRED_CHECKS", "gosec": "PCG_REQUIRED_CHECKS", "test": "pre-commit-go.yml"}, r)
+	ut.AssertEqual(t, []string{"coverage", "gosec", "test"}, r.names())
+}
+
+func TestRequiredListEnforce(t *testing.T) {
+	t.Parallel()
+	enabled := []checks.Check{&checks.Build{}, &checks.Coverage{}, &checks.Gofmt{}}
+	r := requiredList{"coverage": "PCG_REQUIRED_CHECKS"}
+	skip, notices := r.enforce(skipList{}, enabled)
+	ut.AssertEqual(t, skipList{}, skip)
+	ut.AssertEqual(t, []error(nil), notices)
+
+	skip, notices = r.enforce(skipList{"coverage": "PCG_SKIP", "gofmt": "PCG_SKIP"}, enabled)
+	ut.AssertEqual(t, skipList{"gofmt": "PCG_SKIP"}, skip)
+	ut.AssertEqual(t, []error{errors.New("check coverage can't be skipped as requested by PCG_SKIP; it is required by PCG_REQUIRED_CHECKS")}, notices)
+
+	skip, notices = r.enforce(skipList{"all": "commit message"}, enabled)
+	ut.AssertEqual(t, skipList{"build": "commit message", "gofmt": "commit message"}, skip)
+	ut.AssertEqual(t, 1, len(notices))
+}
+
+func TestEnforcesPolicy(t *testing.T) {
+	t.Parallel()
+	config := checks.New("0.1")
+	ut.AssertEqual(t, true, enforcesPolicy(config, []checks.Mode{checks.ContinuousIntegration}))
+	ut.AssertEqual(t, true, enforcesPolicy(config, []checks.Mode{checks.Nightly}))
+	ut.AssertEqual(t, false, enforcesPolicy(config, []checks.Mode{checks.PreCommit}))
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// progressRefresh is the delay between two updates of the progress display.
+const progressRefresh = 100 * time.Millisecond
+
+// spinner is the animation of the running checks.
+const spinner = `-\|/`
+
+// isTerminal returns true if f is an interactive terminal supporting the
+// escape sequences used by progress.
+func isTerminal(f *os.File) bool {
+	if runtime.GOOS == "windows" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progress displays the status of the checks as they run, updated in place.
+//
+// The completed checks are printed once and scroll up; the running checks
+// and a summary line are redrawn below them.
+type progress struct {
+	w     io.Writer
+	total int
+	width int
+	color bool
+	// statusWidth is the width of the translated status of the checks.
+	statusWidth int
+
+	lock     sync.Mutex
+	running  map[string]time.Time
+	finished []string // Completed lines not printed yet.
+	done     int
+	failed   int
+	drawn    int // Number of lines of the live area on screen.
+	frame    int
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// newProgress returns a progress for the checks and starts refreshing it. It
+// must be stopped with close.
+func newProgress(w io.Writer, enabledChecks []checks.Check, color bool) *progress {
+	p := &progress{
+		w:       w,
+		total:   len(enabledChecks),
+		color:   color,
+		running: map[string]time.Time{},
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, c := range enabledChecks {
+		if l := utf8.RuneCountInString(c.GetName()); l > p.width {
+			p.width = l
+		}
+	}
+	p.statusWidth = statusWidth()
+	go func() {
+		defer close(p.stopped)
+		t := time.NewTicker(progressRefresh)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				p.render(now, false)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// start records that the check name started.
+func (p *progress) start(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.running[name] = time.Now()
+}
+
+// end records the result of a check.
+func (p *progress) end(res *checks.Result) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.running, res.Name)
+	p.done++
+	status, color := msgStatusOK, colorGreen
+	if res.Err != nil {
+		status, color = msgStatusFail, colorRed
+		p.failed++
+	} else if len(res.Warnings) != 0 {
+		status, color = msgStatusWarn, colorYellow
+	}
+	status = pad(tr(status), p.statusWidth)
+	if p.color {
+		status = color + status + colorReset
+	}
+	p.finished = append(p.finished, fmt.Sprintf("%s %s %6.2fs", status, pad(res.Name, p.width), res.Duration.Seconds()))
+}
+
+// close stops the refresh and leaves only the completed checks on screen.
+func (p *progress) close() {
+	close(p.stop)
+	<-p.stopped
+	p.render(time.Now(), true)
+}
+
+// render redraws the live area. If final is true, the live area is erased.
+func (p *progress) render(now time.Time, final bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	out := ""
+	if p.drawn != 0 {
+		// Move to the beginning of the live area and erase it.
+		out += fmt.Sprintf("\x1b[%dA\r\x1b[J", p.drawn)
+	}
+	for _, l := range p.finished {
+		out += l + "\n"
+	}
+	p.finished = nil
+	p.drawn = 0
+	if !final {
+		names := make([]string, 0, len(p.running))
+		for name := range p.running {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c := spinner[p.frame%len(spinner)]
+		p.frame++
+		for _, name := range names {
+			out += fmt.Sprintf("%s %s %6.1fs\n", pad(string(c), p.statusWidth), pad(name, p.width), now.Sub(p.running[name]).Seconds())
+		}
+		out += fmt.Sprintf(tr(msgProgress)+"\n", p.done, p.total, len(names), p.failed)
+		p.drawn = len(names) + 1
+	}
+	_, _ = io.WriteString(p.w, out)
+}
+
+// statusWidth returns the width of the widest translated status of a check.
+func statusWidth() int {
+	w := 0
+	for _, m := range []string{msgStatusOK, msgStatusFail, msgStatusWarn} {
+		if l := utf8.RuneCountInString(tr(m)); l > w {
+			w = l
+		}
+	}
+	return w
+}
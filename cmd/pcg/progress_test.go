@@ -0,0 +1,41 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+	b := &bytes.Buffer{}
+	p := &progress{w: b, total: 3, width: 5, statusWidth: 4, running: map[string]time.Time{}}
+	p.start("build")
+	p.start("gofmt")
+	now := p.running["build"].Add(1500 * time.Millisecond)
+	p.running["gofmt"] = now.Add(-250 * time.Millisecond)
+	p.render(now, false)
+	ut.AssertEqual(t, "-    build    1.5s\n-    gofmt    0.2s\n[0/3] 2 running, 0 failed\n", b.String())
+
+	b.Reset()
+	p.end(&checks.Result{Name: "gofmt", Duration: 300 * time.Millisecond})
+	p.start("vet")
+	p.running["vet"] = now
+	p.render(now, false)
+	ut.AssertEqual(t, "\x1b[3A\r\x1b[J"+"ok   gofmt   0.30s\n"+"\\    build    1.5s\n\\    vet      0.0s\n[1/3] 2 running, 0 failed\n", b.String())
+
+	b.Reset()
+	p.end(&checks.Result{Name: "build", Duration: 2 * time.Second, Err: errors.New("failed")})
+	p.end(&checks.Result{Name: "vet", Duration: time.Second, Warnings: []error{errors.New("slow")}})
+	p.render(now, true)
+	ut.AssertEqual(t, "\x1b[3A\r\x1b[J"+"FAIL build   2.00s\nwarn vet     1.00s\n", b.String())
+	ut.AssertEqual(t, 0, p.drawn)
+}
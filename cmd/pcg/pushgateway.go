@@ -0,0 +1,184 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// pushgatewayReporter pushes the duration and result of each check and the
+// coverage to a Prometheus Pushgateway in addition to forwarding everything to
+// another reporter.
+//
+// Failing to push is logged but doesn't fail the run.
+type pushgatewayReporter struct {
+	inner  reporter
+	config *checks.Pushgateway
+	client *http.Client
+	now    func() time.Time
+	checks []checks.Result
+	values map[string]float64
+}
+
+func newPushgatewayReporter(inner reporter, config *checks.Pushgateway) *pushgatewayReporter {
+	return &pushgatewayReporter{
+		inner:  inner,
+		config: config,
+		client: &http.Client{Timeout: time.Minute},
+		now:    time.Now,
+	}
+}
+
+func (p *pushgatewayReporter) results(r []checks.Result) {
+	p.inner.results(r)
+	p.checks = r
+}
+
+func (p *pushgatewayReporter) failure(check string, err error) {
+	p.inner.failure(check, err)
+}
+
+func (p *pushgatewayReporter) warning(check string, err error) {
+	p.inner.warning(check, err)
+}
+
+func (p *pushgatewayReporter) metrics(m map[string]float64) {
+	p.inner.metrics(m)
+	p.values = m
+}
+
+func (p *pushgatewayReporter) logs(l map[string]string) {
+	p.inner.logs(l)
+}
+
+func (p *pushgatewayReporter) flush() error {
+	err := p.inner.flush()
+	if len(p.checks) != 0 {
+		if err2 := p.push(); err2 != nil {
+			log.Printf("%s", err2)
+		}
+	}
+	// The reporter is reused for the submodules.
+	p.checks = nil
+	p.values = nil
+	return err
+}
+
+// push replaces the metrics of the group at the Pushgateway.
+//
+// See https://github.com/prometheus/pushgateway#api
+func (p *pushgatewayReporter) push() error {
+	url := p.groupURL()
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(p.exposition()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push to the pushgateway: %s", err)
+	}
+	defer resp.Body.Close()
+	out, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push to the pushgateway: %s\n%s", resp.Status, out)
+	}
+	log.Printf("pushed the results of %d checks to %s", len(p.checks), url)
+	return nil
+}
+
+// groupURL returns the url of the group of the metrics: the job and the
+// configured labels.
+func (p *pushgatewayReporter) groupURL() string {
+	job := p.config.Job
+	if job == "" {
+		job = "pcg"
+	}
+	url := strings.TrimRight(p.config.URL, "/") + "/metrics" + pathLabel("job", job)
+	names := make([]string, 0, len(p.config.Labels))
+	for name := range p.config.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		url += pathLabel(name, os.ExpandEnv(p.config.Labels[name]))
+	}
+	return url
+}
+
+// exposition returns the metrics in the Prometheus text format.
+func (p *pushgatewayReporter) exposition() []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# HELP pcg_check_duration_seconds Duration of the check.\n# TYPE pcg_check_duration_seconds gauge\n")
+	for _, r := range p.checks {
+		fmt.Fprintf(b, "pcg_check_duration_seconds{check=%s} %s\n", labelValue(r.Name), formatValue(r.Duration.Seconds()))
+	}
+	fmt.Fprintf(b, "# HELP pcg_check_success 1 if the check passed, 0 if it failed.\n# TYPE pcg_check_success gauge\n")
+	for _, r := range p.checks {
+		v := 1.
+		if r.Err != nil {
+			v = 0
+		}
+		fmt.Fprintf(b, "pcg_check_success{check=%s} %s\n", labelValue(r.Name), formatValue(v))
+	}
+	fmt.Fprintf(b, "# HELP pcg_check_result Result of the check: pass, warning, fail, timeout, interrupted or canceled.\n# TYPE pcg_check_result gauge\n")
+	for i := range p.checks {
+		r := &p.checks[i]
+		fmt.Fprintf(b, "pcg_check_result{check=%s,result=%s} 1\n", labelValue(r.Name), labelValue(resultName(r)))
+	}
+	var coverage []string
+	for _, name := range checks.SortedMetricNames(p.values) {
+		if strings.HasPrefix(name, "coverage.") {
+			coverage = append(coverage, name)
+		}
+	}
+	if len(coverage) != 0 {
+		fmt.Fprintf(b, "# HELP pcg_coverage_percent Coverage of the repository (scope=\"global\") or of a package directory.\n# TYPE pcg_coverage_percent gauge\n")
+		for _, name := range coverage {
+			fmt.Fprintf(b, "pcg_coverage_percent{scope=%s} %s\n", labelValue(name[len("coverage."):]), formatValue(p.values[name]))
+		}
+	}
+	fmt.Fprintf(b, "# HELP pcg_last_run_timestamp_seconds Time of the run.\n# TYPE pcg_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(b, "pcg_last_run_timestamp_seconds %d\n", p.now().Unix())
+	return b.Bytes()
+}
+
+// labelValue returns v quoted as a label value of the text format.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// formatValue returns v formatted as a sample value of the text format.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// pathLabel returns the label name=v as a part of the Pushgateway url. A value
+// that is empty or has characters that would need escaping is base64 encoded.
+func pathLabel(name, v string) string {
+	if v == "" {
+		return "/" + name + "@base64/="
+	}
+	if !rePlainValue.MatchString(v) {
+		return "/" + name + "@base64/" + base64.URLEncoding.EncodeToString([]byte(v))
+	}
+	return "/" + name + "/" + v
+}
+
+// rePlainValue matches a label value that can be used as is in a url.
+var rePlainValue = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
@@ -0,0 +1,79 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestPushgatewayReporter(t *testing.T) {
+	t.Parallel()
+	method := ""
+	path := ""
+	body := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		path = r.URL.Path
+		b, err := ioutil.ReadAll(r.Body)
+		ut.AssertEqual(t, nil, err)
+		body = string(b)
+	}))
+	defer ts.Close()
+
+	b := &bytes.Buffer{}
+	config := &checks.Pushgateway{URL: ts.URL + "/", Labels: map[string]string{"repo": "foo", "branch": "feature/x"}}
+	p := newPushgatewayReporter(&textReporter{w: b}, config)
+	p.now = func() time.Time { return time.Unix(1456790400, 0) }
+	// Nothing ran, nothing is pushed.
+	ut.AssertEqual(t, nil, p.flush())
+	ut.AssertEqual(t, "", path)
+
+	p.results([]checks.Result{
+		{Name: "build", Duration: 1500 * time.Millisecond},
+		{Name: "test", Duration: 3 * time.Second, Err: errors.New("failed"), TimedOut: true},
+	})
+	p.failure("test", errors.New("failed"))
+	p.metrics(map[string]float64{"coverage.global": 75.5, "coverage.foo": 80, "gocyclo.max": 12})
+	ut.AssertEqual(t, nil, p.flush())
+	ut.AssertEqual(t, "PUT", method)
+	ut.AssertEqual(t, "/metrics/job/pcg/branch@base64/ZmVhdHVyZS94/repo/foo", path)
+	expected := "# HELP pcg_check_duration_seconds Duration of the check.\n" +
+		"# TYPE pcg_check_duration_seconds gauge\n" +
+		"pcg_check_duration_seconds{check=\"build\"} 1.5\n" +
+		"pcg_check_duration_seconds{check=\"test\"} 3\n" +
+		"# HELP pcg_check_success 1 if the check passed, 0 if it failed.\n" +
+		"# TYPE pcg_check_success gauge\n" +
+		"pcg_check_success{check=\"build\"} 1\n" +
+		"pcg_check_success{check=\"test\"} 0\n" +
+		"# HELP pcg_check_result Result of the check: pass, warning, fail, timeout, interrupted or canceled.\n" +
+		"# TYPE pcg_check_result gauge\n" +
+		"pcg_check_result{check=\"build\",result=\"pass\"} 1\n" +
+		"pcg_check_result{check=\"test\",result=\"timeout\"} 1\n" +
+		"# HELP pcg_coverage_percent Coverage of the repository (scope=\"global\") or of a package directory.\n" +
+		"# TYPE pcg_coverage_percent gauge\n" +
+		"pcg_coverage_percent{scope=\"foo\"} 80\n" +
+		"pcg_coverage_percent{scope=\"global\"} 75.5\n" +
+		"# HELP pcg_last_run_timestamp_seconds Time of the run.\n" +
+		"# TYPE pcg_last_run_timestamp_seconds gauge\n" +
+		"pcg_last_run_timestamp_seconds 1456790400\n"
+	ut.AssertEqual(t, expected, body)
+	ut.AssertEqual(t, "--- FAIL: test (3.00s)\nfailed\n", b.String())
+}
+
+func TestPathLabel(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, "/job/pcg", pathLabel("job", "pcg"))
+	ut.AssertEqual(t, "/branch@base64/=", pathLabel("branch", ""))
+	ut.AssertEqual(t, "/branch@base64/YSBi", pathLabel("branch", "a b"))
+}
@@ -0,0 +1,10 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build race
+
+package main
+
+// raceEnabled is true when pcg is built with -race.
+const raceEnabled = true
@@ -0,0 +1,456 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// reporter formats the results of a check run.
+//
+// Calls to results, failure, warning and metrics are done serially.
+type reporter interface {
+	// results is called once with the results of the checks that ran, before
+	// failure and warning.
+	results(r []checks.Result)
+	// failure is called for each check that failed.
+	failure(check string, err error)
+	// warning is called for each non fatal issue. check is empty when the
+	// issue is not specific to a check.
+	warning(check string, err error)
+	// metrics is called once with the metrics published by the checks, if
+	// any.
+	metrics(m map[string]float64)
+	// logs is called once with the path of the log files saved by the checks
+	// in the artifacts directory, if any, see checks.Options.SaveLog.
+	logs(l map[string]string)
+	// flush is called once all checks completed. The reporter must then reset
+	// its state, since it is reused for each run of the invocation, e.g. for
+	// each submodule, or each patch with -patch.
+	flush() error
+}
+
+// knownReporters is the map of all supported -reporter values.
+var knownReporters = map[string]func(w io.Writer) reporter{
+	"text":       func(w io.Writer) reporter { return &textReporter{w: w} },
+	"json":       func(w io.Writer) reporter { return &jsonReporter{w: w} },
+	"reviewdog":  func(w io.Writer) reporter { return &reviewdogReporter{w: w} },
+	"checkstyle": func(w io.Writer) reporter { return &checkstyleReporter{w: w} },
+}
+
+// reporterNames returns the sorted names of knownReporters.
+func reporterNames() []string {
+	out := make([]string, 0, len(knownReporters))
+	for name := range knownReporters {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ANSI escape sequences used by textReporter when color is enabled.
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor returns true if the output to f should be colored: f is a
+// terminal and NO_COLOR is not set; see https://no-color.org.
+func useColor(f *os.File) bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(f)
+}
+
+// textReporter is the default human readable reporter.
+//
+// The failures and warnings are grouped per check, followed by a summary of
+// the checks with their duration.
+type textReporter struct {
+	w io.Writer
+	// color enables the ANSI colors.
+	color bool
+	// summary enables the summary table. It is disabled when the progress
+	// display already listed the checks or -summary prints a detailed one.
+	summary bool
+
+	checks   []checks.Result
+	order    []string // Checks with issues, in the order reported.
+	failures map[string][]error
+	warnings map[string][]error
+}
+
+func (t *textReporter) results(r []checks.Result) {
+	t.checks = r
+}
+
+func (t *textReporter) failure(check string, err error) {
+	t.add(check)
+	t.failures[check] = append(t.failures[check], err)
+}
+
+func (t *textReporter) warning(check string, err error) {
+	t.add(check)
+	t.warnings[check] = append(t.warnings[check], err)
+}
+
+func (t *textReporter) metrics(m map[string]float64) {
+	// They are logged in verbose mode.
+}
+
+func (t *textReporter) logs(l map[string]string) {
+	// Only the output of the failures is printed.
+}
+
+func (t *textReporter) flush() error {
+	// The reporter is reused for the submodules.
+	defer func() {
+		t.checks = nil
+		t.order = nil
+		t.failures = nil
+		t.warnings = nil
+	}()
+	// The issues of the checks are printed in the order of the checks, then the
+	// other ones, e.g. thresholds, in the order they were reported.
+	var order []string
+	seen := map[string]bool{}
+	for _, r := range t.checks {
+		if t.failures[r.Name] != nil || t.warnings[r.Name] != nil {
+			order = append(order, r.Name)
+			seen[r.Name] = true
+		}
+	}
+	for _, name := range t.order {
+		if !seen[name] {
+			order = append(order, name)
+		}
+	}
+	durations := map[string]float64{}
+	for _, r := range t.checks {
+		durations[r.Name] = r.Duration.Seconds()
+	}
+	for _, name := range order {
+		if name != "" {
+			header := t.colorize(colorYellow, fmt.Sprintf(tr(msgGroupWarn), name))
+			if t.failures[name] != nil {
+				header = t.colorize(colorRed, fmt.Sprintf(tr(msgGroupFail), name))
+			}
+			if d, ok := durations[name]; ok {
+				header += fmt.Sprintf(" (%1.2fs)", d)
+			}
+			fmt.Fprintf(t.w, "%s\n", header)
+		}
+		for _, err := range t.failures[name] {
+			fmt.Fprintf(t.w, "%s\n", err)
+		}
+		for _, err := range t.warnings[name] {
+			fmt.Fprintf(t.w, "%s %s\n", t.colorize(colorYellow, tr(msgWarning)), err)
+		}
+	}
+	if !t.summary || len(t.checks) == 0 {
+		return nil
+	}
+	// The widths of the columns depend on the translations.
+	nameW := utf8.RuneCountInString(tr(msgColumnCheck))
+	for _, r := range t.checks {
+		if l := utf8.RuneCountInString(r.Name); l > nameW {
+			nameW = l
+		}
+	}
+	statusW := statusWidth()
+	if l := utf8.RuneCountInString(tr(msgColumnResult)); l > statusW {
+		statusW = l
+	}
+	durationW := utf8.RuneCountInString(tr(msgColumnDuration))
+	if durationW < 8 {
+		durationW = 8
+	}
+	if len(order) != 0 {
+		fmt.Fprintf(t.w, "\n")
+	}
+	fmt.Fprintf(t.w, "%s  %s  %*s\n", pad(tr(msgColumnCheck), nameW), pad(tr(msgColumnResult), statusW), durationW, tr(msgColumnDuration))
+	for _, r := range t.checks {
+		status := t.colorize(colorGreen, pad(tr(msgStatusOK), statusW))
+		if t.failures[r.Name] != nil {
+			status = t.colorize(colorRed, pad(tr(msgStatusFail), statusW))
+		} else if t.warnings[r.Name] != nil {
+			status = t.colorize(colorYellow, pad(tr(msgStatusWarn), statusW))
+		}
+		fmt.Fprintf(t.w, "%s  %s  %*s\n", pad(r.Name, nameW), status, durationW, fmt.Sprintf("%1.2fs", r.Duration.Seconds()))
+	}
+	return nil
+}
+
+// add records that check has an issue.
+func (t *textReporter) add(check string) {
+	if t.failures == nil {
+		t.failures = map[string][]error{}
+		t.warnings = map[string][]error{}
+	}
+	if t.failures[check] == nil && t.warnings[check] == nil {
+		t.order = append(t.order, check)
+	}
+}
+
+// colorize returns s in color if enabled.
+func (t *textReporter) colorize(color, s string) string {
+	if !t.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// finding is a single issue found by a check, as parsed from its output.
+type finding struct {
+	check   string
+	file    string
+	line    int
+	column  int
+	message string
+}
+
+// reFinding matches the "file.go:line:col: message" lines used by most Go
+// tools. The column is optional.
+var reFinding = regexp.MustCompile(`^\s*([^\s:]+\.go):(\d+)(?::(\d+))?:?\s*(.*)$`)
+
+// parseFindings extracts the findings from a check error.
+//
+// A checks.Findings error is used as is. When no line can be parsed, a single
+// finding without location containing the whole error is returned.
+func parseFindings(check string, err error) []finding {
+	var out []finding
+	if fs, ok := err.(checks.Findings); ok {
+		// The check reported its findings as is, e.g. a custom check with the
+		// json protocol.
+		for _, f := range fs {
+			out = append(out, finding{check: check, file: f.Pos.Filename, line: f.Pos.Line, column: f.Pos.Column, message: f.Message})
+		}
+		return out
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		m := reFinding.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		f := finding{check: check, file: m[1], message: m[4]}
+		f.line, _ = strconv.Atoi(m[2])
+		f.column, _ = strconv.Atoi(m[3])
+		out = append(out, f)
+	}
+	if len(out) == 0 {
+		out = append(out, finding{check: check, message: err.Error()})
+	}
+	return out
+}
+
+// jsonReporter emits the findings, warnings and metrics of the run as a
+// single JSON object.
+type jsonReporter struct {
+	w        io.Writer
+	findings []finding
+	warnings []string
+	values   map[string]float64
+	files    map[string]string
+}
+
+func (j *jsonReporter) failure(check string, err error) {
+	j.findings = append(j.findings, parseFindings(check, err)...)
+}
+
+func (j *jsonReporter) results(r []checks.Result) {
+}
+
+func (j *jsonReporter) warning(check string, err error) {
+	j.warnings = append(j.warnings, err.Error())
+}
+
+func (j *jsonReporter) metrics(m map[string]float64) {
+	j.values = m
+}
+
+func (j *jsonReporter) logs(l map[string]string) {
+	j.files = l
+}
+
+func (j *jsonReporter) flush() error {
+	// The reporter is reused for the next run.
+	defer func() {
+		j.findings = nil
+		j.warnings = nil
+		j.values = nil
+		j.files = nil
+	}()
+	type jsonFinding struct {
+		Check   string `json:"check"`
+		File    string `json:"file,omitempty"`
+		Line    int    `json:"line,omitempty"`
+		Column  int    `json:"column,omitempty"`
+		Message string `json:"message"`
+	}
+	result := struct {
+		Findings []jsonFinding      `json:"findings"`
+		Warnings []string           `json:"warnings"`
+		Metrics  map[string]float64 `json:"metrics"`
+		Logs     map[string]string  `json:"logs,omitempty"`
+	}{[]jsonFinding{}, []string{}, map[string]float64{}, j.files}
+	for _, f := range j.findings {
+		result.Findings = append(result.Findings, jsonFinding{f.check, f.file, f.line, f.column, f.message})
+	}
+	result.Warnings = append(result.Warnings, j.warnings...)
+	for k, v := range j.values {
+		result.Metrics[k] = v
+	}
+	e := json.NewEncoder(j.w)
+	e.SetIndent("", "  ")
+	return e.Encode(result)
+}
+
+// reviewdogReporter emits Reviewdog Diagnostic Format (rdjson).
+//
+// See https://github.com/reviewdog/reviewdog/tree/master/proto/rdf
+type reviewdogReporter struct {
+	w        io.Writer
+	findings []finding
+}
+
+func (r *reviewdogReporter) failure(check string, err error) {
+	r.findings = append(r.findings, parseFindings(check, err)...)
+}
+
+func (r *reviewdogReporter) results(res []checks.Result) {
+}
+
+func (r *reviewdogReporter) warning(check string, err error) {
+}
+
+func (r *reviewdogReporter) metrics(m map[string]float64) {
+}
+
+func (r *reviewdogReporter) logs(l map[string]string) {
+}
+
+func (r *reviewdogReporter) flush() error {
+	// The reporter is reused for the next run.
+	defer func() {
+		r.findings = nil
+	}()
+	type position struct {
+		Line   int `json:"line,omitempty"`
+		Column int `json:"column,omitempty"`
+	}
+	type rng struct {
+		Start position `json:"start"`
+	}
+	type location struct {
+		Path  string `json:"path"`
+		Range *rng   `json:"range,omitempty"`
+	}
+	type source struct {
+		Name string `json:"name"`
+	}
+	type diagnostic struct {
+		Message  string   `json:"message"`
+		Location location `json:"location"`
+		Severity string   `json:"severity"`
+		Source   source   `json:"source"`
+	}
+	result := struct {
+		Source      source       `json:"source"`
+		Severity    string       `json:"severity"`
+		Diagnostics []diagnostic `json:"diagnostics"`
+	}{source{"pcg"}, "ERROR", []diagnostic{}}
+	for _, f := range r.findings {
+		d := diagnostic{
+			Message:  f.message,
+			Location: location{Path: f.file},
+			Severity: "ERROR",
+			Source:   source{f.check},
+		}
+		if f.line != 0 {
+			d.Location.Range = &rng{position{f.line, f.column}}
+		}
+		result.Diagnostics = append(result.Diagnostics, d)
+	}
+	e := json.NewEncoder(r.w)
+	e.SetIndent("", "  ")
+	return e.Encode(result)
+}
+
+// checkstyleReporter emits checkstyle XML, as supported by most code review
+// tools.
+type checkstyleReporter struct {
+	w        io.Writer
+	findings []finding
+}
+
+func (c *checkstyleReporter) failure(check string, err error) {
+	c.findings = append(c.findings, parseFindings(check, err)...)
+}
+
+func (c *checkstyleReporter) results(r []checks.Result) {
+}
+
+func (c *checkstyleReporter) warning(check string, err error) {
+}
+
+func (c *checkstyleReporter) metrics(m map[string]float64) {
+}
+
+func (c *checkstyleReporter) logs(l map[string]string) {
+}
+
+func (c *checkstyleReporter) flush() error {
+	// The reporter is reused for the next run.
+	defer func() {
+		c.findings = nil
+	}()
+	type csError struct {
+		Line     int    `xml:"line,attr,omitempty"`
+		Column   int    `xml:"column,attr,omitempty"`
+		Severity string `xml:"severity,attr"`
+		Message  string `xml:"message,attr"`
+		Source   string `xml:"source,attr"`
+	}
+	type csFile struct {
+		Name   string    `xml:"name,attr"`
+		Errors []csError `xml:"error"`
+	}
+	result := struct {
+		XMLName xml.Name  `xml:"checkstyle"`
+		Version string    `xml:"version,attr"`
+		Files   []*csFile `xml:"file"`
+	}{Version: "4.3"}
+	files := map[string]*csFile{}
+	for _, f := range c.findings {
+		file, ok := files[f.file]
+		if !ok {
+			file = &csFile{Name: f.file}
+			files[f.file] = file
+			result.Files = append(result.Files, file)
+		}
+		file.Errors = append(file.Errors, csError{f.line, f.column, "error", f.message, f.check})
+	}
+	if _, err := io.WriteString(c.w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(c.w)
+	e.Indent("", "  ")
+	if err := e.Encode(result); err != nil {
+		return err
+	}
+	_, err := io.WriteString(c.w, "\n")
+	return err
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/token"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestParseFindings(t *testing.T) {
+	err := errors.New("golint failed:\nfoo.go:3:1: exported func Foo should have comment\nbar/bar.go:12: bad")
+	expected := []finding{
+		{"golint", "foo.go", 3, 1, "exported func Foo should have comment"},
+		{"golint", "bar/bar.go", 12, 0, "bad"},
+	}
+	ut.AssertEqual(t, expected, parseFindings("golint", err))
+	ut.AssertEqual(t, []finding{{check: "test", message: "oops"}}, parseFindings("test", errors.New("oops")))
+	fs := checks.Findings{{Pos: token.Position{Filename: "README.md", Line: 2}, Message: "typo"}, {Message: "global"}}
+	expected = []finding{{"custom", "README.md", 2, 0, "typo"}, {check: "custom", message: "global"}}
+	ut.AssertEqual(t, expected, parseFindings("custom", fs))
+}
+
+func TestReporters(t *testing.T) {
+	for _, name := range reporterNames() {
+		b := &bytes.Buffer{}
+		r := knownReporters[name](b)
+		r.failure("golint", errors.New("foo.go:3:1: message"))
+		r.warning("test", errors.New("slow"))
+		r.metrics(map[string]float64{"gocyclo.max": 3})
+		ut.AssertEqual(t, nil, r.flush())
+		ut.AssertEqual(t, true, strings.Contains(b.String(), "message"))
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	b := &bytes.Buffer{}
+	r := knownReporters["json"](b)
+	r.failure("golint", errors.New("golint failed:\nfoo.go:3:1: bad"))
+	r.warning("test", errors.New("slow"))
+	r.metrics(map[string]float64{"gocyclo.max": 3})
+	r.logs(map[string]string{"test:./foo": "/artifacts/logs/test/foo.log"})
+	ut.AssertEqual(t, nil, r.flush())
+	expected := `{
+  "findings": [
+    {
+      "check": "golint",
+      "file": "foo.go",
+      "line": 3,
+      "column": 1,
+      "message": "bad"
+    }
+  ],
+  "warnings": [
+    "slow"
+  ],
+  "metrics": {
+    "gocyclo.max": 3
+  },
+  "logs": {
+    "test:./foo": "/artifacts/logs/test/foo.log"
+  }
+}
+`
+	ut.AssertEqual(t, expected, b.String())
+}
+
+func TestTextReporter(t *testing.T) {
+	b := &bytes.Buffer{}
+	r := &textReporter{w: b, summary: true}
+	r.results([]checks.Result{
+		{Name: "build", Duration: 1500 * time.Millisecond},
+		{Name: "golint", Duration: 250 * time.Millisecond},
+		{Name: "test", Duration: 3 * time.Second},
+	})
+	r.warning("", errors.New("skipped gofmt"))
+	r.failure("test", errors.New("test failed"))
+	r.warning("golint", errors.New("check golint: careful"))
+	r.warning("test", errors.New("check test: too slow"))
+	r.failure("thresholds", errors.New("thresholds failed"))
+	ut.AssertEqual(t, nil, r.flush())
+	expected := "--- WARN: golint (0.25s)\n" +
+		"warning: check golint: careful\n" +
+		"--- FAIL: test (3.00s)\n" +
+		"test failed\n" +
+		"warning: check test: too slow\n" +
+		"warning: skipped gofmt\n" +
+		"--- FAIL: thresholds\n" +
+		"thresholds failed\n" +
+		"\n" +
+		"check   result  duration\n" +
+		"build   ok         1.50s\n" +
+		"golint  warn       0.25s\n" +
+		"test    FAIL       3.00s\n"
+	ut.AssertEqual(t, expected, b.String())
+
+	// The state is reset for the next submodule.
+	b.Reset()
+	r.color = true
+	r.results([]checks.Result{{Name: "build", Duration: time.Second}})
+	ut.AssertEqual(t, nil, r.flush())
+	ut.AssertEqual(t, "check  result  duration\nbuild  \x1b[32mok    \x1b[0m     1.00s\n", b.String())
+}
+
+func TestReportersRunChecksTwice(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	change := fakeChange(t, tmpDir)
+	// textReporter prints the durations; it is covered by TestTextReporter.
+	for _, name := range []string{"checkstyle", "json", "reviewdog"} {
+		b := &bytes.Buffer{}
+		a := &application{config: failingConfig(), reporter: knownReporters[name](b)}
+		// Each run, e.g. each patch with -patch, prints its own document.
+		var outputs []string
+		for i := 0; i < 2; i++ {
+			b.Reset()
+			ut.AssertEqual(t, true, a.runChecks(context.Background(), change, []checks.Mode{checks.PreCommit}, nil, nil) != nil)
+			outputs = append(outputs, b.String())
+		}
+		ut.AssertEqual(t, 1, strings.Count(outputs[0], "bad"))
+		ut.AssertEqual(t, outputs[0], outputs[1])
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+	"gopkg.in/yaml.v2"
+)
+
+// checkpointDir is the bucket of the storage, e.g. the directory in .git,
+// where the checks that passed are recorded for -resume.
+const checkpointDir = "pcg-checkpoints"
+
+// checkpoint records the checks that passed on a tree, so a run interrupted by
+// a timeout or a crash can be resumed with -resume without running them again.
+//
+// The checkpoint is keyed by the fingerprint of the change, the modes and the
+// configuration; any modification starts over.
+type checkpoint struct {
+	storage checks.Storage
+	key     string
+
+	lock   sync.Mutex
+	passed map[string]bool
+}
+
+// openCheckpoint returns the checkpoint of change in modes in the storage s.
+func openCheckpoint(s checks.Storage, change scm.Change, modes []checks.Mode, config *checks.Config) (*checkpoint, error) {
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, _ = h.Write([]byte(fingerprint(change, modes)))
+	_, _ = h.Write(b)
+	c := &checkpoint{
+		storage: s,
+		key:     hex.EncodeToString(h.Sum(nil)),
+		passed:  map[string]bool{},
+	}
+	content, _, err := s.Get(checkpointDir, c.key)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(string(content), "\n") {
+		if name != "" {
+			c.passed[name] = true
+		}
+	}
+	return c, nil
+}
+
+// filter returns enabledChecks without the checks that already passed.
+func (c *checkpoint) filter(enabledChecks []checks.Check) ([]checks.Check, []string) {
+	var out []checks.Check
+	var done []string
+	for _, check := range enabledChecks {
+		if c.passed[check.GetName()] {
+			done = append(done, check.GetName())
+		} else {
+			out = append(out, check)
+		}
+	}
+	return out, done
+}
+
+// record records that the check name passed. It is safe to call concurrently.
+func (c *checkpoint) record(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.storage.Append(checkpointDir, c.key, []byte(name+"\n"))
+	if err == nil {
+		c.passed[name] = true
+	}
+	return err
+}
+
+// remove deletes the checkpoint once the run completed, since there is
+// nothing left to resume.
+func (c *checkpoint) remove() error {
+	return c.storage.Delete(checkpointDir, c.key)
+}
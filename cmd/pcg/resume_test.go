@@ -0,0 +1,46 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	c := &checkpoint{storage: &checks.FileStorage{Dir: tmpDir}, key: "key", passed: map[string]bool{}}
+	enabled := []checks.Check{&checks.Build{}, &checks.Coverage{}, &checks.Gofmt{}}
+	out, done := c.filter(enabled)
+	ut.AssertEqual(t, enabled, out)
+	ut.AssertEqual(t, []string(nil), done)
+
+	ut.AssertEqual(t, nil, c.record("build"))
+	ut.AssertEqual(t, nil, c.record("gofmt"))
+	content, err := ioutil.ReadFile(filepath.Join(tmpDir, checkpointDir, "key"))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "build\ngofmt\n", string(content))
+	out, done = c.filter(enabled)
+	ut.AssertEqual(t, []checks.Check{&checks.Coverage{}}, out)
+	ut.AssertEqual(t, []string{"build", "gofmt"}, done)
+
+	ut.AssertEqual(t, nil, c.remove())
+	_, err = os.Stat(filepath.Join(tmpDir, checkpointDir, "key"))
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+	ut.AssertEqual(t, nil, c.remove())
+}
@@ -0,0 +1,203 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// DiagnosticsArgs is the argument of PCG.Diagnostics.
+type DiagnosticsArgs struct {
+	// Version is the version of the diagnostics the client already has, 0
+	// initially. The call waits until newer diagnostics are available.
+	Version int `json:"version"`
+}
+
+// DiagnosticsReply is the reply of PCG.Diagnostics: the result of the last
+// run of the checks.
+type DiagnosticsReply struct {
+	// Version is incremented on each run of the checks.
+	Version int `json:"version"`
+	// Root is the root of the checkout; the file of each finding is relative
+	// to it.
+	Root     string       `json:"root"`
+	Findings []Diagnostic `json:"findings"`
+	Warnings []string     `json:"warnings"`
+	// Error is the error of the run, e.g. "checks failed in 1.2s", empty if
+	// the checks passed.
+	Error string `json:"error,omitempty"`
+}
+
+// Diagnostic is an issue found by a check. The position is omitted when the
+// check didn't report one.
+type Diagnostic struct {
+	Check   string `json:"check"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// cmdServe runs the checks of modes each time files are saved, like
+// cmdWatch, and serves their findings over JSON-RPC on conn, usually stdin and
+// stdout, so an editor plugin can show them inline. It returns when the
+// client closes the connection or ctx is done.
+//
+// The only method is PCG.Diagnostics; see DiagnosticsArgs.
+func (a *application) cmdServe(ctx context.Context, conn io.ReadWriteCloser, repo scm.ReadOnlyRepo, modes []checks.Mode, against string) error {
+	old, err := watchBase(repo, against)
+	if err != nil {
+		return err
+	}
+	a.lane = checks.Background
+	d := newDiagnosticsServer(repo.Root())
+	s := rpc.NewServer()
+	if err := s.RegisterName("PCG", d); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- a.watch(ctx, repo, func() {
+			c := &collectReporter{}
+			a.reporter = c
+			err := a.runWatched(ctx, repo, modes, old)
+			if ctx.Err() == nil {
+				d.publish(c, err)
+			}
+		})
+	}()
+	go func() {
+		<-ctx.Done()
+		// Unblock the clients waiting for a run and the server.
+		d.close()
+		_ = conn.Close()
+	}()
+	s.ServeCodec(jsonrpc.NewServerCodec(conn))
+	log.Printf("client disconnected")
+	cancel()
+	return <-done
+}
+
+// diagnosticsServer is the PCG service of cmdServe.
+type diagnosticsServer struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	last   DiagnosticsReply
+	closed bool
+}
+
+func newDiagnosticsServer(root string) *diagnosticsServer {
+	d := &diagnosticsServer{last: DiagnosticsReply{Root: root, Findings: []Diagnostic{}, Warnings: []string{}}}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// Diagnostics replies with the diagnostics of the last run of the checks once
+// it is newer than args.Version.
+func (d *diagnosticsServer) Diagnostics(args *DiagnosticsArgs, reply *DiagnosticsReply) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for !d.closed && d.last.Version <= args.Version {
+		d.cond.Wait()
+	}
+	if d.closed {
+		return errors.New("the server is stopping")
+	}
+	*reply = d.last
+	return nil
+}
+
+// publish makes the result of a run available to the clients.
+func (d *diagnosticsServer) publish(c *collectReporter, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	r := DiagnosticsReply{Version: d.last.Version + 1, Root: d.last.Root, Findings: []Diagnostic{}, Warnings: c.warnings}
+	if r.Warnings == nil {
+		r.Warnings = []string{}
+	}
+	for _, f := range c.findings {
+		r.Findings = append(r.Findings, Diagnostic{f.check, f.file, f.line, f.column, f.message})
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	d.last = r
+	d.cond.Broadcast()
+}
+
+// close wakes up the waiting clients with an error.
+func (d *diagnosticsServer) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	d.cond.Broadcast()
+}
+
+// collectReporter is a reporter keeping the findings and warnings of a run in
+// memory for diagnosticsServer.
+type collectReporter struct {
+	findings []finding
+	warnings []string
+}
+
+func (c *collectReporter) results(r []checks.Result) {
+}
+
+func (c *collectReporter) failure(check string, err error) {
+	c.findings = append(c.findings, parseFindings(check, err)...)
+}
+
+func (c *collectReporter) warning(check string, err error) {
+	c.warnings = append(c.warnings, err.Error())
+}
+
+func (c *collectReporter) metrics(m map[string]float64) {
+}
+
+func (c *collectReporter) logs(l map[string]string) {
+}
+
+func (c *collectReporter) flush() error {
+	return nil
+}
+
+// stdioConn is the connection to the client over stdin and stdout.
+//
+// The input is read through a pipe: closing os.Stdin doesn't unblock a read in
+// progress, so Close closes the pipe instead to stop the server when ctx is
+// done, even if the client keeps stdin open.
+type stdioConn struct {
+	in *io.PipeReader
+	io.Writer
+}
+
+func newStdioConn(in io.Reader, out io.Writer) *stdioConn {
+	r, w := io.Pipe()
+	go func() {
+		_, err := io.Copy(w, in)
+		// A nil error closes the pipe with io.EOF.
+		_ = w.CloseWithError(err)
+	}()
+	return &stdioConn{in: r, Writer: out}
+}
+
+func (s *stdioConn) Read(p []byte) (int, error) {
+	return s.in.Read(p)
+}
+
+func (s *stdioConn) Close() error {
+	return s.in.Close()
+}
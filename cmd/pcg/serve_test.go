@@ -0,0 +1,96 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+	"github.com/maruel/ut"
+)
+
+func TestDiagnosticsServer(t *testing.T) {
+	t.Parallel()
+	d := newDiagnosticsServer("/src")
+	replies := make(chan *DiagnosticsReply)
+	errs := make(chan error)
+	wait := func(version int) {
+		r := &DiagnosticsReply{}
+		if err := d.Diagnostics(&DiagnosticsArgs{Version: version}, r); err != nil {
+			errs <- err
+			return
+		}
+		replies <- r
+	}
+	go wait(0)
+
+	c := &collectReporter{}
+	c.failure("gofmt", errors.New("a.go:1:2: not formatted"))
+	c.warning("golint", errors.New("check golint is experimental"))
+	d.publish(c, errors.New("checks failed in 1.00s"))
+	expected := &DiagnosticsReply{
+		Version:  1,
+		Root:     "/src",
+		Findings: []Diagnostic{{"gofmt", "a.go", 1, 2, "not formatted"}},
+		Warnings: []string{"check golint is experimental"},
+		Error:    "checks failed in 1.00s",
+	}
+	ut.AssertEqual(t, expected, <-replies)
+
+	// A client already up to date waits for the next run.
+	go wait(1)
+	d.publish(&collectReporter{}, nil)
+	expected = &DiagnosticsReply{Version: 2, Root: "/src", Findings: []Diagnostic{}, Warnings: []string{}}
+	ut.AssertEqual(t, expected, <-replies)
+
+	go wait(2)
+	d.close()
+	ut.AssertEqual(t, errors.New("the server is stopping"), <-errs)
+}
+
+func TestServeCanceled(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	for _, args := range [][]string{
+		{"git", "init"},
+		{"git", "-c", "user.name=nobody", "-c", "user.email=nobody@localhost", "commit", "--allow-empty", "-m", "yo"},
+	} {
+		out, code, err := internal.Capture(context.Background(), tmpDir, nil, args...)
+		ut.AssertEqualf(t, 0, code, "%s", out)
+		ut.AssertEqual(t, nil, err)
+	}
+	repo, err := scm.GetRepo(tmpDir, "")
+	ut.AssertEqual(t, nil, err)
+	a := &application{config: &checks.Config{Modes: map[checks.Mode]checks.Settings{checks.PreCommit: {Options: checks.Options{MaxDuration: 5}}}}}
+
+	// The client keeps stdin open; the server must stop anyway, e.g. on Ctrl-C.
+	stdin, _ := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.cmdServe(ctx, newStdioConn(stdin, ioutil.Discard), repo, []checks.Mode{checks.PreCommit}, "HEAD")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		ut.AssertEqual(t, nil, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve didn't stop")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// cmdMergeCoverage merges the coverage profiles written by the shards of a
+// run with -shard and enforces the settings of the coverage check of modes on
+// the result, as the coverage check does on an unsharded run.
+//
+// The change is the whole repository like continuous-integration, or the files
+// modified since against.
+func (a *application) cmdMergeCoverage(w io.Writer, repo scm.ReadOnlyRepo, modes []checks.Mode, against string, profiles []string) error {
+	if len(profiles) == 0 {
+		return errors.New("merge-coverage requires the coverage profiles written by the shards as arguments")
+	}
+	c := coverageCheck(a.config, modes)
+	if c == nil {
+		return fmt.Errorf("coverage is not enabled in %s", modes)
+	}
+	old := scm.Initial
+	if against != "" {
+		if old = repo.Eval(against); old == scm.Invalid {
+			return fmt.Errorf("invalid commit %q", against)
+		}
+	}
+	change, err := repo.Between(scm.Current, old, a.ignorePatterns)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		return errors.New("no change")
+	}
+	profile, err := c.LoadProfiles(change, profiles...)
+	if err != nil {
+		return err
+	}
+	_, options := a.config.EnabledChecks(modes)
+	if err := c.Enforce(change, profile, options); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "coverage of %d shards: %1.1f%%\n", len(profiles), profile.CoveragePercent())
+	return err
+}
+
+// coverageCheck returns the coverage check enabled in modes, or nil.
+func coverageCheck(config *checks.Config, modes []checks.Mode) *checks.Coverage {
+	for _, e := range config.MergedChecks(modes) {
+		if c, ok := e.Check.(*checks.Coverage); ok {
+			return c
+		}
+	}
+	return nil
+}
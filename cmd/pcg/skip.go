@@ -0,0 +1,94 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// skipEnvVar is the environment variable containing the comma separated list
+// of checks to skip, e.g. "golint,coverage", or "all".
+const skipEnvVar = "PCG_SKIP"
+
+// skipAll is the name used in skipList to skip all the checks.
+const skipAll = "all"
+
+// reSkipTag matches the "[skip pcg]" and "[skip check:<name>]" tags in a
+// commit message.
+var reSkipTag = regexp.MustCompile(`\[skip (?:(pcg)|check:([a-z_]+))\]`)
+
+// skipList is the checks requested to be skipped, mapped to where the request
+// comes from.
+type skipList map[string]string
+
+// skipFor returns the checks to skip as requested by PCG_SKIP and, unless c
+// is Current, by the tags in the commit message of c.
+func skipFor(repo scm.ReadOnlyRepo, c scm.Commit) skipList {
+	out := parseSkipEnv(os.Getenv(skipEnvVar))
+	if c == scm.Current {
+		return out
+	}
+	msg, err := repo.Message(c)
+	if err != nil {
+		log.Printf("%s", err)
+		return out
+	}
+	for name, source := range parseSkipMessage(msg) {
+		if _, ok := out[name]; !ok {
+			out[name] = source
+		}
+	}
+	return out
+}
+
+// parseSkipEnv parses the value of PCG_SKIP.
+func parseSkipEnv(v string) skipList {
+	out := skipList{}
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out[name] = skipEnvVar
+		}
+	}
+	return out
+}
+
+// parseSkipMessage parses the skip tags in a commit message.
+func parseSkipMessage(msg string) skipList {
+	out := skipList{}
+	for _, m := range reSkipTag.FindAllStringSubmatch(msg, -1) {
+		if m[1] != "" {
+			out[skipAll] = "commit message"
+		} else {
+			out[m[2]] = "commit message"
+		}
+	}
+	return out
+}
+
+// filter returns the checks that are not skipped and a notice for each check
+// skipped.
+func (s skipList) filter(enabled []checks.Check) ([]checks.Check, []error) {
+	var out []checks.Check
+	var notices []error
+	for _, c := range enabled {
+		source, ok := s[c.GetName()]
+		if !ok {
+			source, ok = s[skipAll]
+		}
+		if !ok {
+			out = append(out, c)
+			continue
+		}
+		notices = append(notices, fmt.Errorf("check %s SKIPPED as requested by %s", c.GetName(), source))
+	}
+	return out, notices
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestParseSkip(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, skipList{}, parseSkipEnv(""))
+	ut.AssertEqual(t, skipList{"golint": "PCG_SKIP", "coverage": "PCG_SKIP"}, parseSkipEnv("golint, coverage,"))
+	ut.AssertEqual(t, skipList{}, parseSkipMessage("Fix foo\n\nskip pcg"))
+	msg := "Fix foo [skip check:coverage]\n\n[skip check:golint] [skip pcg]\n"
+	ut.AssertEqual(t, skipList{"all": "commit message", "coverage": "commit message", "golint": "commit message"}, parseSkipMessage(msg))
+}
+
+func TestSkipListFilter(t *testing.T) {
+	t.Parallel()
+	enabled := []checks.Check{&checks.Build{}, &checks.Coverage{}, &checks.Gofmt{}}
+	out, notices := skipList{}.filter(enabled)
+	ut.AssertEqual(t, enabled, out)
+	ut.AssertEqual(t, []error(nil), notices)
+
+	out, notices = skipList{"coverage": "PCG_SKIP", "golint": "PCG_SKIP"}.filter(enabled)
+	ut.AssertEqual(t, []checks.Check{&checks.Build{}, &checks.Gofmt{}}, out)
+	ut.AssertEqual(t, []error{errors.New("check coverage SKIPPED as requested by PCG_SKIP")}, notices)
+
+	out, notices = skipList{"all": "commit message"}.filter(enabled)
+	ut.AssertEqual(t, []checks.Check(nil), out)
+	ut.AssertEqual(t, 3, len(notices))
+}
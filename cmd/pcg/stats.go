@@ -0,0 +1,255 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// statsFile is the usage log in the user's configuration directory; see
+// userConfigPath.
+//
+// Usage is only recorded if the file exists, i.e. after 'pcg stats enable'.
+// It never leaves the machine; 'pcg stats export' prints an aggregate for the
+// user to share as they see fit.
+const statsFile = "pcg-stats.jsonl"
+
+// usageRecord is a line of statsFile, describing a pcg invocation.
+//
+// It is anonymous: it doesn't contain any path, file name, commit or error
+// message.
+type usageRecord struct {
+	Time     time.Time     `json:"time"`
+	Version  string        `json:"version"`
+	Command  string        `json:"command"`
+	Modes    []checks.Mode `json:"modes,omitempty"`
+	Duration float64       `json:"duration"`
+	Success  bool          `json:"success"`
+	Checks   []usageCheck  `json:"checks,omitempty"`
+}
+
+// usageCheck is the result of a check in a usageRecord.
+type usageCheck struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+	// Result is one of "pass", "warning", "fail", "timeout" or "interrupted".
+	Result string `json:"result"`
+}
+
+// usageExport is printed by 'pcg stats export'. The values are totals, so the
+// exports of multiple machines can be summed.
+type usageExport struct {
+	Version  string                      `json:"version"`
+	First    time.Time                   `json:"first"`
+	Last     time.Time                   `json:"last"`
+	Commands map[string]int              `json:"commands"`
+	Checks   map[string]*usageCheckTotal `json:"checks"`
+}
+
+// usageCheckTotal is the aggregate of the results of a check.
+type usageCheckTotal struct {
+	Runs     int            `json:"runs"`
+	Duration float64        `json:"duration"`
+	Results  map[string]int `json:"results"`
+}
+
+// newUsageRecord returns the record of the command if the usage statistics
+// are enabled, nil otherwise.
+func newUsageRecord(command string) *usageRecord {
+	p := userConfigPath(statsFile)
+	if p == "" {
+		return nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		return nil
+	}
+	return &usageRecord{Time: time.Now().UTC(), Version: version, Command: command}
+}
+
+// add records the results of the checks.
+func (u *usageRecord) add(modes []checks.Mode, report *checks.Report) {
+	u.Modes = modes
+	for i := range report.Results {
+		r := &report.Results[i]
+		u.Checks = append(u.Checks, usageCheck{Name: r.Name, Duration: r.Duration.Seconds(), Result: resultName(r)})
+	}
+}
+
+// resultName returns the outcome of a check: "pass", "warning", "fail",
+// "timeout", "interrupted" or "canceled".
+func resultName(r *checks.Result) string {
+	switch {
+	case r.Interrupted:
+		return "interrupted"
+	case r.Canceled:
+		return "canceled"
+	case r.TimedOut:
+		return "timeout"
+	case r.Err != nil:
+		return "fail"
+	case len(r.Warnings) != 0:
+		return "warning"
+	}
+	return "pass"
+}
+
+// save appends the record to statsFile.
+func (u *usageRecord) save(err error) {
+	u.Duration = time.Since(u.Time).Seconds()
+	u.Success = err == nil
+	b, err := json.Marshal(u)
+	if err != nil {
+		log.Printf("failed to record usage: %s", err)
+		return
+	}
+	// Don't create the file; it may have been deleted by 'pcg stats disable'.
+	f, err := os.OpenFile(userConfigPath(statsFile), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("failed to record usage: %s", err)
+		return
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		log.Printf("failed to record usage: %s", err)
+	}
+}
+
+// loadUsage returns the records in statsFile.
+func loadUsage(path string) ([]usageRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []usageRecord
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var r usageRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			// A line can be truncated by a concurrent write or a crash.
+			log.Printf("ignoring invalid usage record: %s", err)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out, s.Err()
+}
+
+// aggregateUsage returns the totals of records.
+func aggregateUsage(records []usageRecord) *usageExport {
+	out := &usageExport{Version: version, Commands: map[string]int{}, Checks: map[string]*usageCheckTotal{}}
+	for i, r := range records {
+		if i == 0 || r.Time.Before(out.First) {
+			out.First = r.Time
+		}
+		if r.Time.After(out.Last) {
+			out.Last = r.Time
+		}
+		out.Commands[r.Command]++
+		for _, c := range r.Checks {
+			t := out.Checks[c.Name]
+			if t == nil {
+				t = &usageCheckTotal{Results: map[string]int{}}
+				out.Checks[c.Name] = t
+			}
+			t.Runs++
+			t.Duration += c.Duration
+			t.Results[c.Result]++
+		}
+	}
+	return out
+}
+
+// cmdStats implements 'pcg stats': enable, disable, show or export.
+func (a *application) cmdStats(w io.Writer, action string) error {
+	p := userConfigPath(statsFile)
+	if p == "" {
+		return errors.New("failed to find the user's configuration directory")
+	}
+	switch action {
+	case "enable":
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, tr(msgStatsEnabled), p)
+		return err
+
+	case "disable":
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		_, err := io.WriteString(w, tr(msgStatsDisabled))
+		return err
+
+	case "", "show", "export":
+		records, err := loadUsage(p)
+		if os.IsNotExist(err) {
+			_, err = io.WriteString(w, tr(msgStatsOff))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		e := aggregateUsage(records)
+		if action == "export" {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(e)
+		}
+		return printUsage(w, e, len(records))
+
+	default:
+		return fmt.Errorf("unknown stats action %q; supported: enable, disable, show, export", action)
+	}
+}
+
+// printUsage prints the usage statistics in a human readable format.
+func printUsage(w io.Writer, e *usageExport, runs int) error {
+	if runs == 0 {
+		_, err := fmt.Fprintf(w, "No usage recorded yet.\n")
+		return err
+	}
+	fmt.Fprintf(w, "%d runs from %s to %s\n\n", runs, e.First.Format("2006-01-02"), e.Last.Format("2006-01-02"))
+	names := make([]string, 0, len(e.Checks))
+	max := len("check")
+	for name := range e.Checks {
+		names = append(names, name)
+		if len(name) > max {
+			max = len(name)
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "%-*s  runs  failures  average\n", max, "check")
+	for _, name := range names {
+		t := e.Checks[name]
+		failures := t.Results["fail"] + t.Results["timeout"]
+		if _, err := fmt.Fprintf(w, "%-*s  %4d  %8d  %6.2fs\n", max, name, t.Runs, failures, t.Duration/float64(t.Runs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	u := &usageRecord{Time: time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC), Version: "0.4.7", Command: "run-hook"}
+	u.add([]checks.Mode{checks.PrePush}, &checks.Report{Results: []checks.Result{
+		{Name: "build", Duration: time.Second},
+		{Name: "test", Duration: 3 * time.Second, Err: errors.New("failed")},
+		{Name: "gofmt", Duration: time.Second, TimedOut: true, Err: errors.New("timed out")},
+	}})
+	ut.AssertEqual(t, []usageCheck{{"build", 1, "pass"}, {"test", 3, "fail"}, {"gofmt", 1, "timeout"}}, u.Checks)
+
+	// Records are appended as JSON lines; an invalid line is ignored.
+	line, err := json.Marshal(u)
+	ut.AssertEqual(t, nil, err)
+	p := filepath.Join(tmpDir, statsFile)
+	content := string(line) + "\n{\"trunc\n" + `{"time":"2016-03-02T00:00:00Z","command":"run","checks":[{"name":"build","duration":3,"result":"pass"}]}` + "\n"
+	ut.AssertEqual(t, nil, ioutil.WriteFile(p, []byte(content), 0600))
+	records, err := loadUsage(p)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 2, len(records))
+
+	e := aggregateUsage(records)
+	ut.AssertEqual(t, time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC), e.First)
+	ut.AssertEqual(t, time.Date(2016, 3, 2, 0, 0, 0, 0, time.UTC), e.Last)
+	ut.AssertEqual(t, map[string]int{"run": 1, "run-hook": 1}, e.Commands)
+	ut.AssertEqual(t, &usageCheckTotal{Runs: 2, Duration: 4, Results: map[string]int{"pass": 2}}, e.Checks["build"])
+
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, printUsage(b, e, len(records)))
+	expected := "2 runs from 2016-03-01 to 2016-03-02\n\n" +
+		"check  runs  failures  average\n" +
+		"build     2         0    2.00s\n" +
+		"gofmt     1         1    1.00s\n" +
+		"test      1         1    3.00s\n"
+	ut.AssertEqual(t, expected, b.String())
+}
@@ -0,0 +1,234 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// stressIterations is the default number of iterations of 'pcg stress'.
+const stressIterations = 20
+
+// stressWorkers is the number of iterations run concurrently, so they also
+// race on the caches shared between changes.
+const stressWorkers = 4
+
+// stressMaxLatency is the maximum delay injected at each synchronization
+// point.
+const stressMaxLatency = time.Millisecond
+
+// stressPackages are the packages of the synthetic repository; each imports
+// the previous one so modifying the first one affects all of them.
+var stressPackages = []string{"./p0", "./p1", "./p2", "./p3", "./p4"}
+
+// cmdStress repeatedly computes the changes of a synthetic repository and
+// runs the native checks on them concurrently while injecting random latency
+// in the internals, failing on any inconsistent result.
+//
+// It is meant to be run with a pcg built with -race to also detect the data
+// races.
+func cmdStress(w io.Writer, args []string) (err error) {
+	iterations := stressIterations
+	if len(args) > 1 {
+		return fmt.Errorf("specify at most the number of iterations, e.g. 'pcg stress 100'")
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of iterations %q", args[0])
+		}
+		iterations = n
+	}
+	if !raceEnabled {
+		fmt.Fprintf(w, "pcg is not built with -race so data races are not detected; use:\n  go run -race github.com/maruel/pre-commit-go/cmd/pcg stress\n")
+	}
+	td, err := ioutil.TempDir("", "pcg-stress")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := internal.RemoveAll(td); err == nil {
+			err = err2
+		}
+	}()
+	root, err := createStressRepo(td)
+	if err != nil {
+		return err
+	}
+	checks.SetLatencyHook(func(string) {
+		time.Sleep(time.Duration(rand.Int63n(int64(stressMaxLatency))))
+	})
+	defer checks.SetLatencyHook(nil)
+
+	start := time.Now()
+	config := stressConfig()
+	work := make(chan int)
+	errs := make(chan error, iterations)
+	var wg sync.WaitGroup
+	for i := 0; i < stressWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := stressOnce(config, td, root, i); err != nil {
+					errs <- fmt.Errorf("iteration %d: %s", i, err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < iterations; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	var failures []string
+	for err2 := range errs {
+		failures = append(failures, err2.Error())
+	}
+	if len(failures) != 0 {
+		return fmt.Errorf("%d of %d iterations were inconsistent:\n%s", len(failures), iterations, strings.Join(failures, "\n"))
+	}
+	_, err = fmt.Fprintf(w, "%d iterations in %1.2fs without inconsistency.\n", iterations, time.Since(start).Seconds())
+	return err
+}
+
+// stressConfig returns the configuration of the native checks run by
+// stressOnce. It is shared by the iterations, like the checks of a real run
+// are shared by the submodules.
+func stressConfig() *checks.Config {
+	return &checks.Config{
+		MaxConcurrent: 2,
+		HermeticEnv:   "never",
+		Modes: map[checks.Mode]checks.Settings{
+			checks.PreCommit: {
+				Options: checks.Options{MaxDuration: 60},
+				Checks: checks.Checks{
+					"copyright":        {&checks.Copyright{Header: "// Copyright {{.Year}} The stress authors."}},
+					"forbiddenimports": {&checks.ForbiddenImports{Rules: []checks.ImportRule{{Import: "unsafe"}}}},
+					"gocyclo":          {&checks.Gocyclo{PerDirDefault: checks.GocycloSettings{MaxComplexity: 10}}},
+					"secrets":          {&checks.Secrets{}},
+				},
+			},
+		},
+	}
+}
+
+// stressOnce computes a change of the synthetic repository at root and runs
+// the checks on it while reading the content of the files concurrently.
+func stressOnce(config *checks.Config, gopath, root string, i int) error {
+	repo, err := scm.GetRepo(root, gopath)
+	if err != nil {
+		return err
+	}
+	// Alternate between the uncommitted change and the whole repository.
+	against, changed := scm.Head, stressPackages[:1]
+	if i%2 == 1 {
+		against, changed = scm.Initial, stressPackages
+	}
+	change, err := repo.Between(scm.Current, against, nil)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		return fmt.Errorf("no change against %s", against)
+	}
+	if p := change.Changed().Packages(); !reflect.DeepEqual(p, changed) {
+		return fmt.Errorf("unexpected modified packages %v", p)
+	}
+	if p := change.Indirect().Packages(); !reflect.DeepEqual(p, stressPackages) {
+		return fmt.Errorf("unexpected affected packages %v", p)
+	}
+	if p := change.Indirect().TestPackages(); !reflect.DeepEqual(p, stressPackages) {
+		return fmt.Errorf("unexpected test packages %v", p)
+	}
+
+	files := change.All().Files()
+	contentErr := make(chan error, 1)
+	go func() {
+		for _, f := range files {
+			if change.Content(f) == nil {
+				contentErr <- fmt.Errorf("failed to read %s", f)
+				return
+			}
+		}
+		contentErr <- nil
+	}()
+	report := checks.NewRunner(config, []checks.Mode{checks.PreCommit}).Run(context.Background(), change)
+	if err := <-contentErr; err != nil {
+		return err
+	}
+	for _, r := range report.Failed() {
+		return fmt.Errorf("check %s failed: %s", r.Name, r.Err)
+	}
+	if m := report.Metrics["gocyclo.max"]; m != 2 {
+		return fmt.Errorf("unexpected gocyclo.max %g", m)
+	}
+	return nil
+}
+
+// createStressRepo creates the synthetic repository in the GOPATH gopath and
+// returns its root. The first package is modified but not committed.
+func createStressRepo(gopath string) (string, error) {
+	root := filepath.Join(gopath, "src", "stress")
+	for i := range stressPackages {
+		name := "p" + strconv.Itoa(i)
+		src := "// Copyright 2016 The stress authors.\n\npackage " + name + "\n\n"
+		if i != 0 {
+			src += "import \"stress/p" + strconv.Itoa(i-1) + "\"\n\n"
+		}
+		src += "// F returns i, or 0 if it is negative.\nfunc F(i int) int {\n\tif i < 0 {\n\t\treturn 0\n\t}\n"
+		if i != 0 {
+			src += "\treturn p" + strconv.Itoa(i-1) + ".F(i)\n}\n"
+		} else {
+			src += "\treturn i\n}\n"
+		}
+		test := "// Copyright 2016 The stress authors.\n\npackage " + name + "\n\nimport \"testing\"\n\nfunc TestF(t *testing.T) {\n\tif F(1) != 1 {\n\t\tt.Fatal(\"F(1)\")\n\t}\n}\n"
+		d := filepath.Join(root, name)
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, name+".go"), []byte(src), 0600); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, name+"_test.go"), []byte(test), 0600); err != nil {
+			return "", err
+		}
+	}
+	for _, args := range [][]string{
+		{"git", "init"},
+		{"git", "add", "."},
+		{"git", "-c", "user.name=pcg", "-c", "user.email=pcg@localhost", "commit", "-m", "Initial commit"},
+	} {
+		out, code, err := internal.Capture(context.Background(), root, nil, args...)
+		if code != 0 || err != nil {
+			return "", fmt.Errorf("%s failed: %s\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(root, "p0", "p0.go"), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString("\n// G is uncommitted.\nfunc G() {\n}\n")
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return root, err
+}
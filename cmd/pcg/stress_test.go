@@ -0,0 +1,31 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestStress(t *testing.T) {
+	// Not parallel, the latency hook is global.
+	if testing.Short() {
+		t.Skip("creates a git repository")
+	}
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, cmdStress(b, []string{"4"}))
+	ut.AssertEqual(t, true, strings.Contains(b.String(), "4 iterations in "))
+}
+
+func TestStressArgs(t *testing.T) {
+	t.Parallel()
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, "invalid number of iterations \"0\"", cmdStress(b, []string{"0"}).Error())
+	ut.AssertEqual(t, "specify at most the number of iterations, e.g. 'pcg stress 100'", cmdStress(b, []string{"1", "2"}).Error())
+	ut.AssertEqual(t, "", b.String())
+}
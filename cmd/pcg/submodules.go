@@ -0,0 +1,64 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// runSubmodules runs the checks in each submodule modified by change, with
+// the configuration of the submodule. A submodule that is not checked out is
+// skipped.
+func (a *application) runSubmodules(ctx context.Context, change scm.Change, modes []checks.Mode, skip skipList, prereqReady *sync.WaitGroup) error {
+	var failed []string
+	for _, s := range change.Submodules() {
+		root := filepath.Join(change.Repo().Root(), filepath.FromSlash(s.Path))
+		repo, err := scm.GetRepo(root, change.Repo().GOPATH())
+		if err != nil || filepath.Clean(repo.Root()) != root {
+			// GetRepo found the parent repository.
+			log.Printf("submodule %s is not checked out; skipping", s.Path)
+			continue
+		}
+		if err = a.runSubmodule(ctx, repo, s, modes, skip, prereqReady); err != nil {
+			failed = append(failed, fmt.Sprintf("submodule %s: %s", s.Path, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("checks failed in submodules:\n%s", strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// runSubmodule runs the checks in the submodule checked out in repo.
+func (a *application) runSubmodule(ctx context.Context, repo scm.Repo, s scm.Submodule, modes []checks.Mode, skip skipList, prereqReady *sync.WaitGroup) error {
+	configPath, config := loadConfig(repo, a.configName)
+	log.Printf("submodule %s: config %s", s.Path, configPath)
+	config.ArtifactsDir = a.config.ArtifactsDir
+	config.MaxConcurrent = a.config.MaxConcurrent
+	sub := &application{
+		config:          config,
+		maxConcurrent:   a.maxConcurrent,
+		reporter:        a.reporter,
+		ignorePatterns:  loadIgnorePatterns(repo, config),
+		configName:      a.configName,
+		configCheckedIn: configPath == filepath.Join(repo.Root(), a.configName),
+		resume:          a.resume,
+		failFast:        a.failFast,
+	}
+	repo.SetRenameThreshold(config.RenameThreshold)
+	change, err := repo.Between(s.New, s.Old, sub.ignorePatterns)
+	if err != nil {
+		return err
+	}
+	return sub.runChecks(ctx, change, modes, skip, prereqReady)
+}
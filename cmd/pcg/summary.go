@@ -0,0 +1,90 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/maruel/pre-commit-go/checks"
+)
+
+// printSummary prints the checks sorted by duration, slowest first, with their
+// share of the total time spent in checks, the number of packages they
+// processed and their cache hits, as reported with checks.Options.Count.
+//
+// The checks run concurrently so the total is larger than the wall time; the
+// share tells where the budget of a mode goes.
+func printSummary(w io.Writer, results []checks.Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+	sorted := make([]checks.Result, len(results))
+	copy(sorted, results)
+	sort.Stable(resultsBySlowest(sorted))
+	var total time.Duration
+	for _, r := range sorted {
+		total += r.Duration
+	}
+
+	// The widths of the columns depend on the translations.
+	headers := []string{tr(msgColumnCheck), tr(msgColumnDuration), tr(msgColumnShare), tr(msgColumnPackages), tr(msgColumnCacheHits)}
+	rows := make([][]string, 0, len(sorted)+1)
+	for _, r := range sorted {
+		share := 0.
+		if total > 0 {
+			share = 100 * r.Duration.Seconds() / total.Seconds()
+		}
+		rows = append(rows, []string{
+			r.Name,
+			fmt.Sprintf("%1.2fs", r.Duration.Seconds()),
+			fmt.Sprintf("%1.0f%%", share),
+			counter(r.Counters, checks.CounterPackages),
+			counter(r.Counters, checks.CounterCacheHits),
+		})
+	}
+	rows = append(rows, []string{tr(msgTotal), fmt.Sprintf("%1.2fs", total.Seconds()), "", "", ""})
+	widths := make([]int, len(headers))
+	for _, row := range append([][]string{headers}, rows...) {
+		for i, c := range row {
+			if l := utf8.RuneCountInString(c); l > widths[i] {
+				widths[i] = l
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n")
+	for _, row := range append([][]string{headers}, rows...) {
+		line := pad(row[0], widths[0])
+		for i := 1; i < len(row); i++ {
+			line += "  " + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(row[i])) + row[i]
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// counter returns the value of the counter name formatted for printSummary,
+// or "-" if the check didn't report it.
+func counter(counters map[string]int, name string) string {
+	v, ok := counters[name]
+	if !ok {
+		return "-"
+	}
+	return strconv.Itoa(v)
+}
+
+// resultsBySlowest sorts the results by decreasing duration.
+type resultsBySlowest []checks.Result
+
+func (r resultsBySlowest) Len() int           { return len(r) }
+func (r resultsBySlowest) Less(i, j int) bool { return r[i].Duration > r[j].Duration }
+func (r resultsBySlowest) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
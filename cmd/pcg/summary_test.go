@@ -0,0 +1,37 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestPrintSummary(t *testing.T) {
+	t.Parallel()
+	b := &bytes.Buffer{}
+	ut.AssertEqual(t, nil, printSummary(b, nil))
+	ut.AssertEqual(t, "", b.String())
+
+	results := []checks.Result{
+		{Name: "gofmt", Duration: 500 * time.Millisecond},
+		{Name: "test", Duration: 3 * time.Second, Counters: map[string]int{checks.CounterPackages: 12, checks.CounterCacheHits: 9}},
+		{Name: "build", Duration: 1500 * time.Millisecond, Counters: map[string]int{checks.CounterPackages: 4}},
+	}
+	ut.AssertEqual(t, nil, printSummary(b, results))
+	expected := "\n" +
+		"check  duration  share  packages  cache hits\n" +
+		"test      3.00s    60%        12           9\n" +
+		"build     1.50s    30%         4           -\n" +
+		"gofmt     0.50s    10%         -           -\n" +
+		"total     5.00s\n"
+	ut.AssertEqual(t, expected, b.String())
+	// The results are not reordered.
+	ut.AssertEqual(t, "gofmt", results[0].Name)
+}
@@ -0,0 +1,113 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// watchQuiet is how long the files must stop changing before 'pcg watch' runs
+// the checks, so a save of multiple files triggers a single run.
+const watchQuiet = 100 * time.Millisecond
+
+// errPreempted is returned by runChecks when the run was in the background
+// lane and an interactive run started, e.g. a git hook; see checks.Lanes.
+var errPreempted = errors.New("preempted by an interactive run")
+
+// cmdWatch runs the checks of modes on the changes against the commit
+// against, or upstream, each time files are saved until ctx is done.
+//
+// The process stays alive between the runs so the repository, the
+// configuration and the imports of the unmodified files are only loaded once.
+// The runs are in the background lane, so a git hook doesn't wait for them.
+func (a *application) cmdWatch(ctx context.Context, w io.Writer, repo scm.ReadOnlyRepo, modes []checks.Mode, against string) error {
+	old, err := watchBase(repo, against)
+	if err != nil {
+		return err
+	}
+	a.lane = checks.Background
+	fmt.Fprintf(w, "watching %s for modifications; press Ctrl-C to stop\n", repo.Root())
+	return a.watch(ctx, repo, func() {
+		start := time.Now()
+		fmt.Fprintf(w, "\n%s running %s\n", start.Format("15:04:05"), modes)
+		err := a.runWatched(ctx, repo, modes, old)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s\n", err)
+		} else {
+			fmt.Fprintf(w, "ok in %1.2fs\n", time.Since(start).Seconds())
+		}
+	})
+}
+
+// watchBase returns the commit against, or upstream, to diff against.
+func watchBase(repo scm.ReadOnlyRepo, against string) (scm.Commit, error) {
+	if against == "" {
+		against = string(scm.Upstream)
+	}
+	old := repo.Eval(against)
+	if old == scm.Invalid {
+		return old, fmt.Errorf("invalid commit %q", against)
+	}
+	return old, nil
+}
+
+// watch calls run once, then each time files of the checkout were modified,
+// until ctx is done.
+//
+// run is called once the files stopped changing for watchQuiet. The files
+// saved while run is running trigger another run. The imports of the files are
+// cached meanwhile, see scm.CacheImports.
+func (a *application) watch(ctx context.Context, repo scm.ReadOnlyRepo, run func()) error {
+	scm.CacheImports(true)
+	defer scm.CacheImports(false)
+	w, err := internal.NewWatcher(repo.Root(), a.ignorePatterns.Match)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for {
+		run()
+		files, err := w.Next(ctx, watchQuiet)
+		if err != nil {
+			return err
+		}
+		if files == nil {
+			return nil
+		}
+		log.Printf("modified: %s", strings.Join(files, ", "))
+	}
+}
+
+// runWatched runs the checks of modes on the local changes against old.
+//
+// A run preempted by an interactive run is run again once the interactive run
+// completed, since it may have modified the checkout, e.g. a commit.
+func (a *application) runWatched(ctx context.Context, repo scm.ReadOnlyRepo, modes []checks.Mode, old scm.Commit) error {
+	for {
+		change, err := repo.Between(scm.Current, old, a.ignorePatterns)
+		if err != nil {
+			return err
+		}
+		err = a.runChecks(ctx, change, modes, skipFor(repo, scm.Current), &sync.WaitGroup{})
+		if err != errPreempted || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("%s; running again once it completed", err)
+	}
+}
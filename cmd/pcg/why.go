@@ -0,0 +1,104 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/pre-commit-go/scm"
+)
+
+// cmdWhy explains how each file is handled: the ignore pattern deciding
+// whether it is ignored, its package, the test packages it triggers through
+// the import graph and the checks of each mode that consider it.
+//
+// files are relative to the current directory.
+func (a *application) cmdWhy(w io.Writer, repo scm.ReadOnlyRepo, modes []checks.Mode, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("specify the files to explain, e.g. 'pcg why foo.go'")
+	}
+	rel, err := relToRoot(repo, files)
+	if err != nil {
+		return err
+	}
+	if len(modes) == 0 {
+		modes = checks.AllModes
+	}
+	for i, f := range rel {
+		if _, err := os.Stat(filepath.Join(repo.Root(), f)); err != nil {
+			return err
+		}
+		f = filepath.ToSlash(f)
+		change, err := scm.FromFiles(repo, []string{f}, a.ignorePatterns)
+		if err != nil {
+			return err
+		}
+		if i != 0 {
+			fmt.Fprintf(w, "\n")
+		}
+		a.why(w, change, f, modes)
+	}
+	return nil
+}
+
+// why prints the explanation for the file f; change is the change containing
+// only f, nil if f is ignored.
+func (a *application) why(w io.Writer, change scm.Change, f string, modes []checks.Mode) {
+	fmt.Fprintf(w, "%s:\n", f)
+	pattern, matched, ignored := a.ignorePatterns.Why(f)
+	switch {
+	case pattern == "":
+		fmt.Fprintf(w, "  ignored:       no; no ignore pattern matches it\n")
+	case ignored && matched != f:
+		fmt.Fprintf(w, "  ignored:       yes; its directory %s matches %q\n", matched, pattern)
+	case ignored:
+		fmt.Fprintf(w, "  ignored:       yes; it matches %q\n", pattern)
+	default:
+		fmt.Fprintf(w, "  ignored:       no; it is re-included by %q\n", pattern)
+	}
+	if change == nil {
+		fmt.Fprintf(w, "  No check considers it.\n")
+		return
+	}
+	pkg := "none; it is not a Go source file"
+	if p := change.Changed().Packages(); len(p) != 0 {
+		pkg = strings.Join(p, ", ")
+	}
+	fmt.Fprintf(w, "  package:       %s\n", pkg)
+	tests := "none"
+	if p := change.Indirect().TestPackages(); len(p) != 0 {
+		tests = strings.Join(p, ", ")
+	}
+	fmt.Fprintf(w, "  test packages: %s\n", tests)
+	for _, mode := range modes {
+		if _, ok := a.config.Modes[mode]; !ok {
+			continue
+		}
+		enabledChecks, _ := a.config.EnabledChecks([]checks.Mode{mode})
+		if len(enabledChecks) == 0 {
+			continue
+		}
+		max := 0
+		for _, c := range enabledChecks {
+			if l := len(c.GetName()); l > max {
+				max = l
+			}
+		}
+		fmt.Fprintf(w, "  %s:\n", mode)
+		for _, c := range enabledChecks {
+			s := checks.CheckScope(c)
+			considered := "no "
+			if checks.Considers(c, change, f) {
+				considered = "yes"
+			}
+			fmt.Fprintf(w, "    %-*s  %s  %s\n", max, c.GetName(), considered, s)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/checks"
+	"github.com/maruel/ut"
+)
+
+func TestWhyIgnored(t *testing.T) {
+	t.Parallel()
+	a := &application{config: &checks.Config{}, ignorePatterns: []string{"vendor/", "*.pb.go", "!keep.pb.go"}}
+	b := &bytes.Buffer{}
+	a.why(b, nil, "vendor/a/b.go", checks.AllModes)
+	ut.AssertEqual(t, "vendor/a/b.go:\n  ignored:       yes; its directory vendor matches \"vendor/\"\n  No check considers it.\n", b.String())
+
+	b.Reset()
+	a.why(b, nil, "a/b.pb.go", checks.AllModes)
+	ut.AssertEqual(t, "a/b.pb.go:\n  ignored:       yes; it matches \"*.pb.go\"\n  No check considers it.\n", b.String())
+
+	b.Reset()
+	a.why(b, nil, "a/keep.pb.go", checks.AllModes)
+	ut.AssertEqual(t, "a/keep.pb.go:\n  ignored:       no; it is re-included by \"!keep.pb.go\"\n  No check considers it.\n", b.String())
+}
@@ -17,16 +17,49 @@ import (
 	"go/parser"
 	"go/token"
 	"io"
+	"regexp"
+	"strings"
 )
 
+// NoCoverPragma is the comment excluding code from the coverage accounting:
+//   - in the comments before the package clause, the whole file;
+//   - in the doc comment of a function, the function;
+//   - elsewhere, the statements starting on its line or on the next one,
+//     e.g. at the end of the line of 'if err != nil {'.
+const NoCoverPragma = "//pcg:nocover"
+
 // FindFuncs returns all the functions defined by a Go source file.
+//
+// All the functions of a generated file, per the "// Code generated ... DO NOT
+// EDIT." convention, are marked NoCover. So are the ones excluded by
+// NoCoverPragma.
 func FindFuncs(fileName string, r io.Reader) ([]*FuncExtent, error) {
 	fset := token.NewFileSet()
-	parsed, err := parser.ParseFile(fset, fileName, r, 0)
+	parsed, err := parser.ParseFile(fset, fileName, r, parser.ParseComments)
 	if err != nil {
 		return nil, err
 	}
 	visitor := &funcVisitor{fset: fset, fileName: fileName}
+	for _, g := range parsed.Comments {
+		for _, c := range g.List {
+			if g.Pos() < parsed.Package && reGenerated.MatchString(c.Text) {
+				visitor.noCover = true
+			}
+			if !isNoCoverPragma(c.Text) {
+				continue
+			}
+			if g.Pos() < parsed.Package {
+				visitor.noCover = true
+			} else {
+				if visitor.excluded == nil {
+					visitor.excluded = map[int]bool{}
+				}
+				line := fset.Position(c.Pos()).Line
+				visitor.excluded[line] = true
+				visitor.excluded[line+1] = true
+			}
+		}
+	}
 	ast.Walk(visitor, parsed)
 	return visitor.funcs, nil
 }
@@ -39,45 +72,18 @@ type FuncExtent struct {
 	StartCol  int
 	EndLine   int
 	EndCol    int
-}
+	// NoCover is set when the function is excluded from the coverage
+	// accounting; see FindFuncs.
+	NoCover bool
 
-type funcVisitor struct {
-	fset     *token.FileSet
-	fileName string
-	funcs    []*FuncExtent
-}
-
-func (v *funcVisitor) Visit(node ast.Node) ast.Visitor {
-	switch n := node.(type) {
-	case *ast.FuncDecl:
-		start := v.fset.Position(n.Pos())
-		end := v.fset.Position(n.End())
-		name := n.Name.Name
-		if n.Recv != nil {
-			// A method.
-			t := n.Recv.List[0].Type
-			if s, ok := t.(*ast.StarExpr); ok {
-				// Pointer receiver.
-				t = s.X
-			}
-			if i, ok := t.(*ast.Ident); ok {
-				name = i.Name + "." + name
-			}
-		}
-		fe := &FuncExtent{
-			FileName:  v.fileName,
-			FuncName:  name,
-			StartLine: start.Line,
-			StartCol:  start.Column,
-			EndLine:   end.Line,
-			EndCol:    end.Column,
-		}
-		v.funcs = append(v.funcs, fe)
-	}
-	return v
+	// excluded is the lines whose statements are excluded from the coverage
+	// accounting by NoCoverPragma.
+	excluded map[int]bool
 }
 
 // Coverage returns number of lines covered and the slice of lines missing.
+//
+// The statements excluded by NoCoverPragma are ignored.
 func (f *FuncExtent) Coverage(profile *Profile) (int, []int) {
 	// We could avoid making this n^2 overall by doing a single scan and
 	// annotating the functions, but the sizes of the data structures is never
@@ -96,6 +102,9 @@ func (f *FuncExtent) Coverage(profile *Profile) (int, []int) {
 			// Before the beginning of the function
 			continue
 		}
+		if f.excluded[b.StartLine] {
+			continue
+		}
 		// TODO(maruel): Properly handle multiple statements per line. For now we
 		// ignore that.
 		if b.Count > 0 {
@@ -131,3 +140,64 @@ func (f *FuncExtent) Coverage(profile *Profile) (int, []int) {
 	}
 	return covered, missing
 }
+
+// Private stuff.
+
+// reGenerated matches the comment marking a generated file; see
+// https://golang.org/s/generatedcode.
+var reGenerated = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isNoCoverPragma returns true if the comment text is NoCoverPragma, possibly
+// followed by an explanation.
+func isNoCoverPragma(text string) bool {
+	return text == NoCoverPragma || strings.HasPrefix(text, NoCoverPragma+" ")
+}
+
+type funcVisitor struct {
+	fset     *token.FileSet
+	fileName string
+	funcs    []*FuncExtent
+	// noCover is set when the whole file is excluded.
+	noCover bool
+	// excluded is the lines excluded by NoCoverPragma.
+	excluded map[int]bool
+}
+
+func (v *funcVisitor) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.FuncDecl:
+		start := v.fset.Position(n.Pos())
+		end := v.fset.Position(n.End())
+		name := n.Name.Name
+		if n.Recv != nil {
+			// A method.
+			t := n.Recv.List[0].Type
+			if s, ok := t.(*ast.StarExpr); ok {
+				// Pointer receiver.
+				t = s.X
+			}
+			if i, ok := t.(*ast.Ident); ok {
+				name = i.Name + "." + name
+			}
+		}
+		fe := &FuncExtent{
+			FileName:  v.fileName,
+			FuncName:  name,
+			StartLine: start.Line,
+			StartCol:  start.Column,
+			EndLine:   end.Line,
+			EndCol:    end.Column,
+			NoCover:   v.noCover,
+			excluded:  v.excluded,
+		}
+		if n.Doc != nil {
+			for _, c := range n.Doc.List {
+				if isNoCoverPragma(c.Text) {
+					fe.NoCover = true
+				}
+			}
+		}
+		v.funcs = append(v.funcs, fe)
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestFindFuncs(t *testing.T) {
+	t.Parallel()
+	src := "package foo\n\nfunc Foo() int {\n\treturn 1\n}\n\ntype T struct{}\n\nfunc (t *T) Bar() {\n}\n"
+	funcs, err := FindFuncs("foo.go", strings.NewReader(src))
+	ut.AssertEqual(t, nil, err)
+	expected := []*FuncExtent{
+		{FileName: "foo.go", FuncName: "Foo", StartLine: 3, StartCol: 1, EndLine: 5, EndCol: 2},
+		{FileName: "foo.go", FuncName: "T.Bar", StartLine: 9, StartCol: 1, EndLine: 10, EndCol: 2},
+	}
+	ut.AssertEqual(t, expected, funcs)
+
+	_, err = FindFuncs("foo.go", strings.NewReader("package"))
+	ut.AssertEqual(t, true, err != nil)
+}
+
+func TestFuncExtentCoverage(t *testing.T) {
+	t.Parallel()
+	src := "package foo\n\nfunc Foo(a bool) int {\n\tif a {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"
+	funcs, err := FindFuncs("foo.go", strings.NewReader(src))
+	ut.AssertEqual(t, nil, err)
+	in := "mode: set\nexample.com/foo/foo.go:3.22,4.7 1 1\nexample.com/foo/foo.go:4.7,6.3 1 1\nexample.com/foo/foo.go:7.2,7.10 1 0\n"
+	profiles, err := ParseProfiles(nil, strings.NewReader(in))
+	ut.AssertEqual(t, nil, err)
+	covered, missing := funcs[0].Coverage(profiles[0])
+	ut.AssertEqual(t, 2, covered)
+	ut.AssertEqual(t, []int{7}, missing)
+}
+
+func TestFindFuncsNoCover(t *testing.T) {
+	t.Parallel()
+	src := "package foo\n\n//pcg:nocover debug only.\nfunc Foo() int {\n\treturn 1\n}\n\nfunc Bar(err error) int {\n\tif err != nil { //pcg:nocover\n\t\treturn 1\n\t}\n\t//pcg:nocover\n\tif err == nil {\n\t\treturn 2\n\t}\n\treturn 0\n}\n"
+	funcs, err := FindFuncs("foo.go", strings.NewReader(src))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 2, len(funcs))
+	ut.AssertEqual(t, true, funcs[0].NoCover)
+	ut.AssertEqual(t, false, funcs[1].NoCover)
+	in := "mode: set\nexample.com/foo/foo.go:8.28,9.16 1 1\nexample.com/foo/foo.go:9.16,11.3 1 0\nexample.com/foo/foo.go:13.2,13.16 1 1\nexample.com/foo/foo.go:13.16,15.3 1 0\nexample.com/foo/foo.go:16.2,16.10 1 0\n"
+	profiles, err := ParseProfiles(nil, strings.NewReader(in))
+	ut.AssertEqual(t, nil, err)
+	covered, missing := funcs[1].Coverage(profiles[0])
+	ut.AssertEqual(t, 1, covered)
+	ut.AssertEqual(t, []int{16}, missing)
+
+	for _, header := range []string{"// Code generated by protoc-gen-go. DO NOT EDIT.\n\n", "//pcg:nocover\n\n"} {
+		funcs, err = FindFuncs("foo.go", strings.NewReader(header+src))
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, true, funcs[0].NoCover)
+		ut.AssertEqual(t, true, funcs[1].NoCover)
+	}
+	// The marker must be before the package clause.
+	funcs, err = FindFuncs("foo.go", strings.NewReader(src+"\n// Code generated by hand. DO NOT EDIT.\n"))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, funcs[1].NoCover)
+}
@@ -2,15 +2,20 @@
 // Use of this source code is governed under the Apache License, Version 2.0
 // that can be found in the LICENSE file.
 
-// Modified to add support to ignore files and take a io.Reader instead of a
-// filename to skip disk I/O altogether.
+// Modified to add support to ignore files, merge concatenated profiles and
+// take a io.Reader instead of a filename to skip disk I/O altogether.
 
 // Copyright 2013 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
 // Package cover provides support for parsing coverage profiles
-// generated by "go test -coverprofile=cover.out".
+// generated by "go test -coverprofile=cover.out" and mapping them to the
+// functions of the source files.
+//
+// Unlike golang.org/x/tools/cover, both the profile and the sources are read
+// from an io.Reader so the caller can serve them from memory, e.g. from a git
+// tree, without disk I/O. Its API is stable.
 package cover
 
 import (
@@ -24,11 +29,25 @@ import (
 	"strings"
 )
 
+// Modes of a coverage profile, as specified with "go test -covermode".
+const (
+	// ModeSet records whether each statement ran; Count is 0 or 1.
+	ModeSet = "set"
+	// ModeCount records how many times each statement ran.
+	ModeCount = "count"
+	// ModeAtomic is like ModeCount but is safe in concurrent tests.
+	ModeAtomic = "atomic"
+)
+
 // Profile represents the profiling data for a specific file.
 type Profile struct {
+	// FileName is the file name in absolute package format, e.g.
+	// "encoding/base64/base64.go".
 	FileName string
-	Mode     string
-	Blocks   []ProfileBlock
+	// Mode is one of ModeSet, ModeCount or ModeAtomic.
+	Mode string
+	// Blocks are sorted by start position.
+	Blocks []ProfileBlock
 }
 
 // ProfileBlock represents a single block of profiling data.
@@ -38,20 +57,21 @@ type ProfileBlock struct {
 	NumStmt, Count      int
 }
 
-type byFileName []*Profile
-
-func (p byFileName) Len() int           { return len(p) }
-func (p byFileName) Less(i, j int) bool { return p[i].FileName < p[j].FileName }
-func (p byFileName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-
 // IsIgnored is an interface to ignore files.
-// MARUEL
 type IsIgnored interface {
+	// IsIgnored returns true if the file name, in absolute package format,
+	// must be skipped.
 	IsIgnored(p string) bool
 }
 
-// ParseProfiles parses profile data in the specified file and returns a
-// Profile for each source file described therein.
+// ParseProfiles parses profile data from r and returns a Profile for each
+// source file described therein, sorted by file name.
+//
+// ignore can be nil. Otherwise the files it ignores are skipped.
+//
+// r may be the concatenation of multiple profiles, e.g. one per package, as
+// long as they use the same mode. The blocks found multiple times are merged:
+// their count is summed in ModeCount and ModeAtomic and or'ed in ModeSet.
 func ParseProfiles(ignore IsIgnored, r io.Reader) ([]*Profile, error) {
 	files := make(map[string]*Profile)
 	// First line is "mode: foo", where foo is "set", "count", or "atomic".
@@ -62,23 +82,39 @@ func ParseProfiles(ignore IsIgnored, r io.Reader) ([]*Profile, error) {
 	mode := ""
 	for s.Scan() {
 		line := s.Text()
-		if mode == "" {
-			const p = "mode: "
-			if !strings.HasPrefix(line, p) || line == p {
+		if mode == "" || strings.HasPrefix(line, modePrefix) {
+			if !strings.HasPrefix(line, modePrefix) || line == modePrefix {
 				return nil, fmt.Errorf("bad mode line: %v", line)
 			}
-			mode = line[len(p):]
+			m := line[len(modePrefix):]
+			if m != ModeSet && m != ModeCount && m != ModeAtomic {
+				return nil, fmt.Errorf("unknown mode %q", m)
+			}
+			if mode != "" && m != mode {
+				return nil, fmt.Errorf("can't merge mode %q with mode %q", m, mode)
+			}
+			mode = m
+			continue
+		}
+		if line == "" {
 			continue
 		}
 		m := lineRe.FindStringSubmatch(line)
 		if m == nil {
-			return nil, fmt.Errorf("line %q doesn't match expected format: %v", m, lineRe)
+			return nil, fmt.Errorf("line %q doesn't match expected format: %v", line, lineRe)
+		}
+		var b ProfileBlock
+		for i, d := range []*int{&b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.NumStmt, &b.Count} {
+			v, err := strconv.Atoi(m[i+2])
+			if err != nil {
+				return nil, fmt.Errorf("line %q: %v", line, err)
+			}
+			*d = v
 		}
 		fn := m[1]
 		p, ok := files[fn]
-		// MARUEL: Ignore files.
 		if !ok {
-			if ignore.IsIgnored(fn) {
+			if ignore != nil && ignore.IsIgnored(fn) {
 				files[fn] = nil
 				continue
 			}
@@ -90,54 +126,24 @@ func ParseProfiles(ignore IsIgnored, r io.Reader) ([]*Profile, error) {
 		} else if p == nil {
 			continue
 		}
-		p.Blocks = append(p.Blocks, ProfileBlock{
-			StartLine: toInt(m[2]),
-			StartCol:  toInt(m[3]),
-			EndLine:   toInt(m[4]),
-			EndCol:    toInt(m[5]),
-			NumStmt:   toInt(m[6]),
-			Count:     toInt(m[7]),
-		})
+		p.Blocks = append(p.Blocks, b)
 	}
 	if err := s.Err(); err != nil {
 		return nil, err
 	}
-	// Trim off all nil items.
-	for k, p := range files {
-		if p == nil {
-			delete(files, k)
-		} else {
-			sort.Sort(blocksByStart(p.Blocks))
-		}
-	}
-	// Generate a sorted slice.
+	// Generate a sorted slice, trimming off all nil items.
 	profiles := make([]*Profile, 0, len(files))
-	for _, profile := range files {
-		profiles = append(profiles, profile)
+	for _, p := range files {
+		if p != nil {
+			sort.Stable(blocksByStart(p.Blocks))
+			p.Blocks = mergeBlocks(p.Mode, p.Blocks)
+			profiles = append(profiles, p)
+		}
 	}
 	sort.Sort(byFileName(profiles))
 	return profiles, nil
 }
 
-type blocksByStart []ProfileBlock
-
-func (b blocksByStart) Len() int      { return len(b) }
-func (b blocksByStart) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
-func (b blocksByStart) Less(i, j int) bool {
-	bi, bj := b[i], b[j]
-	return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
-}
-
-var lineRe = regexp.MustCompile(`^(.+):([0-9]+).([0-9]+),([0-9]+).([0-9]+) ([0-9]+) ([0-9]+)$`)
-
-func toInt(s string) int {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		panic(err)
-	}
-	return i
-}
-
 // Boundary represents the position in a source file of the beginning or end of a
 // block as reported by the coverage profile. In HTML mode, it will correspond to
 // the opening or closing of a <span> tag and will be used to colorize the source
@@ -206,3 +212,43 @@ func (b boundariesByPos) Less(i, j int) bool {
 	}
 	return b[i].Offset < b[j].Offset
 }
+
+// Private stuff.
+
+const modePrefix = "mode: "
+
+var lineRe = regexp.MustCompile(`^(.+):([0-9]+).([0-9]+),([0-9]+).([0-9]+) ([0-9]+) ([0-9]+)$`)
+
+type byFileName []*Profile
+
+func (p byFileName) Len() int           { return len(p) }
+func (p byFileName) Less(i, j int) bool { return p[i].FileName < p[j].FileName }
+func (p byFileName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type blocksByStart []ProfileBlock
+
+func (b blocksByStart) Len() int      { return len(b) }
+func (b blocksByStart) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b blocksByStart) Less(i, j int) bool {
+	bi, bj := b[i], b[j]
+	return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+}
+
+// mergeBlocks merges the consecutive identical blocks of sorted blocks.
+func mergeBlocks(mode string, blocks []ProfileBlock) []ProfileBlock {
+	out := blocks[:0]
+	for _, b := range blocks {
+		if l := len(out) - 1; l >= 0 && out[l].StartLine == b.StartLine && out[l].StartCol == b.StartCol && out[l].EndLine == b.EndLine && out[l].EndCol == b.EndCol {
+			if mode == ModeSet {
+				if b.Count != 0 {
+					out[l].Count = 1
+				}
+			} else {
+				out[l].Count += b.Count
+			}
+			continue
+		}
+		out = append(out, b)
+	}
+	return out
+}
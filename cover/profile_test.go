@@ -0,0 +1,86 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestParseProfiles(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		mode     string
+		expected int
+	}{
+		{ModeSet, 1},
+		{ModeCount, 3},
+		{ModeAtomic, 3},
+	}
+	for _, line := range data {
+		in := "mode: " + line.mode + "\n" +
+			"example.com/foo/b.go:3.12,5.2 1 0\n" +
+			"example.com/foo/a.go:7.2,7.10 1 1\n" +
+			"example.com/foo/a.go:3.12,5.2 2 1\n" +
+			"mode: " + line.mode + "\n" +
+			"example.com/foo/a.go:3.12,5.2 2 2\n"
+		profiles, err := ParseProfiles(nil, strings.NewReader(in))
+		ut.AssertEqual(t, nil, err)
+		expected := []*Profile{
+			{
+				FileName: "example.com/foo/a.go",
+				Mode:     line.mode,
+				Blocks: []ProfileBlock{
+					{StartLine: 3, StartCol: 12, EndLine: 5, EndCol: 2, NumStmt: 2, Count: line.expected},
+					{StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 10, NumStmt: 1, Count: 1},
+				},
+			},
+			{
+				FileName: "example.com/foo/b.go",
+				Mode:     line.mode,
+				Blocks:   []ProfileBlock{{StartLine: 3, StartCol: 12, EndLine: 5, EndCol: 2, NumStmt: 1}},
+			},
+		}
+		ut.AssertEqualIndex(t, len(expected), expected, profiles)
+	}
+}
+
+func TestParseProfilesIgnored(t *testing.T) {
+	t.Parallel()
+	in := "mode: count\nexample.com/foo/a.go:3.12,5.2 2 1\nexample.com/foo/a_gen.go:3.12,5.2 2 1\n"
+	profiles, err := ParseProfiles(ignoreSuffix("_gen.go"), strings.NewReader(in))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, 1, len(profiles))
+	ut.AssertEqual(t, "example.com/foo/a.go", profiles[0].FileName)
+}
+
+func TestParseProfilesErrors(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		in       string
+		expected error
+	}{
+		{"", nil},
+		{"foo\n", errors.New("bad mode line: foo")},
+		{"mode: foo\n", errors.New("unknown mode \"foo\"")},
+		{"mode: set\nmode: count\n", errors.New("can't merge mode \"count\" with mode \"set\"")},
+		{"mode: set\na.go:1.1 1 1\n", errors.New("line \"a.go:1.1 1 1\" doesn't match expected format: " + lineRe.String())},
+	}
+	for i, line := range data {
+		_, err := ParseProfiles(nil, strings.NewReader(line.in))
+		ut.AssertEqualIndex(t, i, line.expected, err)
+	}
+	_, err := ParseProfiles(nil, strings.NewReader("mode: set\na.go:1.1,2.1 1 99999999999999999999\n"))
+	ut.AssertEqual(t, true, err != nil)
+}
+
+type ignoreSuffix string
+
+func (i ignoreSuffix) IsIgnored(p string) bool {
+	return strings.HasSuffix(p, string(i))
+}
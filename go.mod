@@ -0,0 +1,16 @@
+module github.com/maruel/pre-commit-go
+
+go 1.16
+
+require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/maruel/panicparse v0.0.0-20170227222818-25bcac0d793c
+	github.com/maruel/ut v1.0.0
+	github.com/mattn/go-sqlite3 v1.14.18
+	gopkg.in/yaml.v2 v2.0.0-20170208141851-a3f3340b5840
+)
+
+require (
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
@@ -0,0 +1,127 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extract extracts the archive into the directory dst, which must exist.
+//
+// The supported formats are .tar, .tar.gz, .tgz and .zip. Symlinks and other
+// special files are skipped, as are entries that would be written outside of
+// dst.
+func Extract(archive, dst string) error {
+	name := strings.ToLower(archive)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(archive, dst)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return extractTar(archive, dst, true)
+	case strings.HasSuffix(name, ".tar"):
+		return extractTar(archive, dst, false)
+	}
+	return fmt.Errorf("unsupported archive %s; supported formats are .tar, .tar.gz, .tgz and .zip", archive)
+}
+
+// Private stuff.
+
+func extractTar(archive, dst string, gz bool) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if gz {
+		g, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", archive, err)
+		}
+		defer g.Close()
+		r = g
+	}
+	t := tar.NewReader(r)
+	for {
+		h, err := t.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", archive, err)
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = extractEntry(dst, h.Name, true, nil, 0)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractEntry(dst, h.Name, false, t, os.FileMode(h.Mode))
+		default:
+			log.Printf("skipping %s in %s", h.Name, archive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(archive, dst string) error {
+	z, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", archive, err)
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		mode := f.Mode()
+		if mode.IsDir() {
+			err = extractEntry(dst, f.Name, true, nil, 0)
+		} else if mode.IsRegular() {
+			var r io.ReadCloser
+			if r, err = f.Open(); err == nil {
+				err = extractEntry(dst, f.Name, false, r, mode)
+				if err2 := r.Close(); err == nil {
+					err = err2
+				}
+			}
+		} else {
+			log.Printf("skipping %s in %s", f.Name, archive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractEntry writes a file or creates a directory named name in dst.
+func extractEntry(dst, name string, isDir bool, r io.Reader, mode os.FileMode) error {
+	p := filepath.Join(dst, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dst, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("archive entry %q is outside of the destination", name)
+	}
+	if isDir {
+		return os.MkdirAll(p, 0700)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	// Only keep the executable bit.
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600|(mode&0100))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
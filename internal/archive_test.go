@@ -0,0 +1,77 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := RemoveAll(td); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	files := map[string]string{"foo-1.0/a.go": "package a\n", "foo-1.0/b/b.go": "package b\n"}
+
+	// tar.gz.
+	f, err := os.Create(filepath.Join(td, "src.tar.gz"))
+	ut.AssertEqual(t, nil, err)
+	g := gzip.NewWriter(f)
+	w := tar.NewWriter(g)
+	for name, content := range files {
+		ut.AssertEqual(t, nil, w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err = w.Write([]byte(content))
+		ut.AssertEqual(t, nil, err)
+	}
+	ut.AssertEqual(t, nil, w.WriteHeader(&tar.Header{Name: "foo-1.0/link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}))
+	ut.AssertEqual(t, nil, w.Close())
+	ut.AssertEqual(t, nil, g.Close())
+	ut.AssertEqual(t, nil, f.Close())
+
+	// zip, with an entry escaping the destination.
+	f, err = os.Create(filepath.Join(td, "src.zip"))
+	ut.AssertEqual(t, nil, err)
+	z := zip.NewWriter(f)
+	for _, name := range []string{"foo-1.0/a.go", "../evil.go"} {
+		e, err := z.Create(name)
+		ut.AssertEqual(t, nil, err)
+		_, err = e.Write([]byte("package a\n"))
+		ut.AssertEqual(t, nil, err)
+	}
+	ut.AssertEqual(t, nil, z.Close())
+	ut.AssertEqual(t, nil, f.Close())
+
+	dst := filepath.Join(td, "tar")
+	ut.AssertEqual(t, nil, os.Mkdir(dst, 0700))
+	ut.AssertEqual(t, nil, Extract(filepath.Join(td, "src.tar.gz"), dst))
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, content, string(b))
+	}
+	_, err = os.Lstat(filepath.Join(dst, "foo-1.0", "link"))
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+
+	dst = filepath.Join(td, "zip")
+	ut.AssertEqual(t, nil, os.Mkdir(dst, 0700))
+	ut.AssertEqual(t, errors.New("archive entry \"../evil.go\" is outside of the destination"), Extract(filepath.Join(td, "src.zip"), dst))
+	_, err = os.Stat(filepath.Join(td, "evil.go"))
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+
+	ut.AssertEqual(t, true, Extract(filepath.Join(td, "src.rar"), dst) != nil)
+}
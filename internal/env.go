@@ -0,0 +1,64 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultEnvAllowlist is the list of environment variables preserved by
+// ScrubEnv. An entry ending with "*" matches any variable with this prefix.
+//
+// It contains what is needed to run the go toolchain and common tools, and the
+// variables set by CI services that tools like goveralls use. Variables that
+// alter the behavior of the checks, like GOFLAGS, GIT_* or CDPATH, are
+// deliberately not listed.
+var DefaultEnvAllowlist = []string{
+	// OS.
+	"HOME", "LOGNAME", "PATH", "TMPDIR", "USER",
+	// Windows.
+	"APPDATA", "COMSPEC", "LOCALAPPDATA", "PATHEXT", "PROGRAMDATA", "SYSTEMROOT",
+	"TEMP", "TMP", "USERPROFILE", "WINDIR",
+	// Go toolchain location, caches and module download.
+	"GO111MODULE", "GOBIN", "GOCACHE", "GOMODCACHE", "GONOPROXY", "GONOSUMDB",
+	"GOPATH", "GOPRIVATE", "GOPROXY", "GOROOT", "GOSUMDB", "GOTOOLCHAIN",
+	// Network.
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy",
+	"no_proxy",
+	// CI services.
+	"CI", "CIRCLE*", "COVERALLS_*", "DRONE*", "TRAVIS*",
+}
+
+// ScrubEnv returns the environment overrides removing all the variables of the
+// current process that are not matched by allowlist, to be used with Capture.
+func ScrubEnv(allowlist []string) []string {
+	var out []string
+	for _, item := range os.Environ() {
+		k := strings.SplitN(item, "=", 2)[0]
+		if k != "" && !envAllowed(k, allowlist) {
+			// Capture removes variables set to an empty value.
+			out = append(out, k+"=")
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Private stuff.
+
+func envAllowed(k string, allowlist []string) bool {
+	for _, a := range allowlist {
+		if strings.HasSuffix(a, "*") {
+			if strings.HasPrefix(k, a[:len(a)-1]) {
+				return true
+			}
+		} else if k == a {
+			return true
+		}
+	}
+	return false
+}
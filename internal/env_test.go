@@ -0,0 +1,45 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestEnvAllowed(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		k        string
+		expected bool
+	}{
+		{"PATH", true},
+		{"TRAVIS_JOB_ID", true},
+		{"GOFLAGS", false},
+		{"GIT_DIR", false},
+		{"PATHX", false},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, envAllowed(line.k, DefaultEnvAllowlist))
+	}
+}
+
+func TestScrubEnv(t *testing.T) {
+	ut.AssertEqual(t, nil, os.Setenv("PCG_SCRUB_TEST", "1"))
+	defer os.Unsetenv("PCG_SCRUB_TEST")
+	found := false
+	for _, e := range ScrubEnv(DefaultEnvAllowlist) {
+		ut.AssertEqual(t, false, e == "PATH=")
+		if e == "PCG_SCRUB_TEST=" {
+			found = true
+		}
+	}
+	ut.AssertEqual(t, true, found)
+	for _, e := range ScrubEnv(append([]string{"PCG_SCRUB_*"}, DefaultEnvAllowlist...)) {
+		ut.AssertEqual(t, false, e == "PCG_SCRUB_TEST=")
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// GoEnv returns the environment variables to override when running a go
+// subprocess for the repository at root located in gopath.
+//
+// In module mode, GOPATH is not modified at all; only GOBIN is pinned and
+// prepended to PATH so pcg-managed tools are found.
+//
+// Otherwise gopath may contain multiple entries. The entries of gopath are put
+// first, followed by the entries of the inherited GOPATH that are not already
+// listed. GOBIN and GOMODCACHE are pinned to their inherited default location
+// when not explicitly set, so that tools and the module cache are not
+// relocated by the GOPATH override.
+func GoEnv(root, gopath string) []string {
+	inherited := build.Default.GOPATH
+	first := ""
+	if l := filepath.SplitList(inherited); len(l) != 0 {
+		first = l[0]
+	}
+	var env []string
+	if IsModuleMode(root) {
+		gobin := os.Getenv("GOBIN")
+		if gobin == "" && first != "" {
+			gobin = filepath.Join(first, "bin")
+			env = append(env, "GOBIN="+gobin)
+		}
+		if gobin != "" {
+			env = append(env, "PATH="+MergeGOPATH(gobin, os.Getenv("PATH")))
+		}
+		return env
+	}
+	env = append(env, "GOPATH="+MergeGOPATH(gopath, inherited))
+	if first == "" {
+		return env
+	}
+	if os.Getenv("GOBIN") == "" {
+		env = append(env, "GOBIN="+filepath.Join(first, "bin"))
+	}
+	if os.Getenv("GOMODCACHE") == "" {
+		env = append(env, "GOMODCACHE="+filepath.Join(first, "pkg", "mod"))
+	}
+	return env
+}
+
+// IsModuleMode returns true if the go toolchain will run in module mode for
+// the repository at root.
+//
+// It is the case when GO111MODULE is "on", or when it is not set and the
+// toolchain is go1.16 or later. Otherwise, unless it is "off", it is the case
+// when a go.mod file is present at root or in one of its parent directories.
+func IsModuleMode(root string) bool {
+	switch os.Getenv("GO111MODULE") {
+	case "on":
+		return true
+	case "off":
+		return false
+	case "":
+		if moduleModeByDefault(goVersion()) {
+			return true
+		}
+	}
+	for dir := root; ; {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// MergeGOPATH returns a list (GOPATH or PATH) value with all the entries of
+// primary followed by the entries of secondary that are not in primary. Empty
+// entries are skipped.
+func MergeGOPATH(primary, secondary string) string {
+	seen := map[string]bool{}
+	var out []string
+	for _, l := range []string{primary, secondary} {
+		for _, p := range filepath.SplitList(l) {
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, string(filepath.ListSeparator))
+}
+
+// GoEnvVars are the go environment variables that most commonly differ
+// between the user's shell and the hooks, causing build cache misses or
+// different build results.
+var GoEnvVars = []string{"GOFLAGS", "GOCACHE", "GOPATH", "GO111MODULE", "GOBIN", "GOMODCACHE"}
+
+// EffectiveGoEnv returns the values of GoEnvVars as reported by 'go env' when
+// run from wd with the env overrides, as used by Capture.
+func EffectiveGoEnv(wd string, env []string) (map[string]string, error) {
+	out, code, err := Capture(context.Background(), wd, env, append([]string{"go", "env"}, GoEnvVars...)...)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("go env failed:\n%s", out)
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	if len(lines) != len(GoEnvVars) {
+		return nil, fmt.Errorf("unexpected go env output:\n%s", out)
+	}
+	values := make(map[string]string, len(GoEnvVars))
+	for i, k := range GoEnvVars {
+		values[k] = strings.TrimSpace(lines[i])
+	}
+	return values, nil
+}
+
+// Private stuff.
+
+var (
+	goVersionOnce  sync.Once
+	goVersionValue string
+)
+
+// goVersion returns the version of the go toolchain in PATH as reported by
+// 'go env GOVERSION', e.g. "go1.16.3". It is "" for toolchains older than
+// go1.16, which don't know GOVERSION. It is only queried once.
+func goVersion() string {
+	goVersionOnce.Do(func() {
+		out, code, err := Capture(context.Background(), os.TempDir(), nil, "go", "env", "GOVERSION")
+		if err == nil && code == 0 {
+			goVersionValue = strings.TrimSpace(out)
+		}
+	})
+	return goVersionValue
+}
+
+// moduleModeByDefault returns true if the toolchain version runs in module
+// mode when GO111MODULE is not set, which is the case since go1.16.
+//
+// Unparsable versions, e.g. development builds, are assumed to be recent.
+func moduleModeByDefault(version string) bool {
+	if version == "" {
+		return false
+	}
+	if !strings.HasPrefix(version, "go1.") {
+		return true
+	}
+	v := strings.TrimPrefix(version, "go1.")
+	if i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' }); i != -1 {
+		v = v[:i]
+	}
+	minor, err := strconv.Atoi(v)
+	return err != nil || minor >= 16
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestMergeGOPATH(t *testing.T) {
+	t.Parallel()
+	j := func(l ...string) string {
+		return strings.Join(l, string(filepath.ListSeparator))
+	}
+	data := []struct {
+		primary   string
+		secondary string
+		expected  string
+	}{
+		{"", "", ""},
+		{"a", "", "a"},
+		{"", "a", "a"},
+		{"a", "b", j("a", "b")},
+		{j("a", "b"), j("b", "c"), j("a", "b", "c")},
+		{j("a", "", "b"), j("", "a"), j("a", "b")},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, MergeGOPATH(line.primary, line.secondary))
+	}
+}
+
+func TestGoEnv(t *testing.T) {
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, RemoveAll(td))
+	}()
+	old := os.Getenv("GO111MODULE")
+	defer os.Setenv("GO111MODULE", old)
+	ut.AssertEqual(t, nil, os.Setenv("GO111MODULE", "auto"))
+	sub := filepath.Join(td, "sub")
+	ut.AssertEqual(t, nil, os.Mkdir(sub, 0700))
+
+	env := GoEnv(td, "foo")
+	ut.AssertEqual(t, true, strings.HasPrefix(env[0], "GOPATH=foo"))
+
+	ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(td, "go.mod"), []byte("module foo\n"), 0600))
+	ut.AssertEqual(t, true, IsModuleMode(td))
+	// The go.mod of a parent directory counts.
+	ut.AssertEqual(t, true, IsModuleMode(sub))
+	for _, e := range GoEnv(td, "foo") {
+		ut.AssertEqual(t, false, strings.HasPrefix(e, "GOPATH="))
+	}
+	ut.AssertEqual(t, nil, os.Setenv("GO111MODULE", "off"))
+	ut.AssertEqual(t, false, IsModuleMode(td))
+
+	// Unset depends on the toolchain.
+	ut.AssertEqual(t, true, strings.HasPrefix(goVersion(), "go"))
+	ut.AssertEqual(t, nil, os.RemoveAll(filepath.Join(td, "go.mod")))
+	ut.AssertEqual(t, nil, os.Setenv("GO111MODULE", ""))
+	ut.AssertEqual(t, moduleModeByDefault(goVersion()), IsModuleMode(sub))
+}
+
+func TestModuleModeByDefault(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		version  string
+		expected bool
+	}{
+		{"", false},
+		{"go1.15.15", false},
+		{"go1.16", true},
+		{"go1.16.3", true},
+		{"go1.21rc2", true},
+		{"go1.27.1", true},
+		{"devel go1.28-abcdef", true},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, moduleModeByDefault(line.version))
+	}
+}
+
+func TestEffectiveGoEnv(t *testing.T) {
+	t.Parallel()
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	values, err := EffectiveGoEnv(wd, []string{"GOFLAGS=-mod=mod"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, len(GoEnvVars), len(values))
+	ut.AssertEqual(t, "-mod=mod", values["GOFLAGS"])
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync/atomic"
+)
+
+// SetLatencyHook sets the function called by Latency, nil to remove it.
+//
+// It is safe to call concurrently with Latency.
+func SetLatencyHook(f func(point string)) {
+	latencyHook.Store(latencyFunc{f})
+}
+
+// Latency calls the hook set with SetLatencyHook, if any, at the
+// synchronization point named point, e.g. to inject a random delay and shake
+// out the data races and the ordering assumptions in the concurrent code.
+func Latency(point string) {
+	if f, ok := latencyHook.Load().(latencyFunc); ok && f.f != nil {
+		f.f(point)
+	}
+}
+
+// Private stuff.
+
+// latencyFunc wraps the hook since atomic.Value can't store nil.
+type latencyFunc struct {
+	f func(point string)
+}
+
+var latencyHook atomic.Value
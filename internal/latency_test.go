@@ -0,0 +1,23 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestLatency(t *testing.T) {
+	// Not parallel, the hook is global.
+	Latency("before")
+	var points []string
+	SetLatencyHook(func(point string) { points = append(points, point) })
+	Latency("a")
+	Latency("b")
+	SetLatencyHook(nil)
+	Latency("after")
+	ut.AssertEqual(t, []string{"a", "b"}, points)
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// setProcessGroup makes the process the leader of a new process group, so
+// its children can be terminated along with it.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// forwardInterrupt forwards SIGINT to the process group of c until the
+// returned function is called.
+//
+// The process group isn't the terminal's foreground group so Ctrl-C doesn't
+// reach it otherwise, leaving orphaned processes when pcg is interrupted.
+func forwardInterrupt(c *exec.Cmd) func() {
+	groupsLock.Lock()
+	defer groupsLock.Unlock()
+	if len(groups) == 0 {
+		interrupts = make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		go forwardInterrupts(interrupts)
+	}
+	pid := c.Process.Pid
+	groups[pid] = true
+	return func() {
+		groupsLock.Lock()
+		defer groupsLock.Unlock()
+		delete(groups, pid)
+		if len(groups) == 0 {
+			signal.Stop(interrupts)
+			close(interrupts)
+		}
+	}
+}
+
+// terminateProcessTree sends SIGTERM to the process group.
+func terminateProcessTree(c *exec.Cmd) error {
+	return syscall.Kill(-c.Process.Pid, syscall.SIGTERM)
+}
+
+// killProcessTree sends SIGKILL to the process group.
+func killProcessTree(c *exec.Cmd) error {
+	return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
+
+// processInfo returns the parent process id and the command line of the
+// process pid.
+func processInfo(pid int) (int, string, error) {
+	out, err := exec.Command("ps", "-o", "ppid=", "-o", "args=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0, "", err
+	}
+	items := strings.SplitN(strings.TrimSpace(string(out)), " ", 2)
+	if len(items) != 2 {
+		return 0, "", errors.New("unexpected ps output")
+	}
+	ppid, err := strconv.Atoi(items[0])
+	return ppid, strings.TrimSpace(items[1]), err
+}
+
+// Private stuff.
+
+var (
+	groupsLock sync.Mutex
+	// groups is the process groups of the running processes.
+	groups     = map[int]bool{}
+	interrupts chan os.Signal
+)
+
+func forwardInterrupts(c <-chan os.Signal) {
+	for range c {
+		groupsLock.Lock()
+		for pid := range groups {
+			_ = syscall.Kill(-pid, syscall.SIGINT)
+		}
+		groupsLock.Unlock()
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build !windows
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/maruel/ut"
+)
+
+func TestCaptureForwardInterrupt(t *testing.T) {
+	// This test can't be parallel.
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	r, w := io.Pipe()
+	codes := make(chan int)
+	start := time.Now()
+	go func() {
+		_, code, _ := CaptureTee(context.Background(), wd, nil, w, "sh", "-c", "echo ready; sleep 30")
+		_ = w.Close()
+		codes <- code
+	}()
+	reader := bufio.NewReader(r)
+	line, err := reader.ReadString('\n')
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "ready\n", line)
+	go func() {
+		_, _ = io.Copy(ioutil.Discard, reader)
+	}()
+	for {
+		groupsLock.Lock()
+		n := len(groups)
+		groupsLock.Unlock()
+		if n != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Emulates Ctrl-C, which is sent to pcg's process group only.
+	ut.AssertEqual(t, nil, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	ut.AssertEqual(t, true, <-codes != 0)
+	ut.AssertEqual(t, true, time.Since(start) < 10*time.Second)
+	groupsLock.Lock()
+	defer groupsLock.Unlock()
+	ut.AssertEqual(t, 0, len(groups))
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op on Windows, the process tree is killed with
+// taskkill.
+func setProcessGroup(c *exec.Cmd) {
+}
+
+// forwardInterrupt is a no-op on Windows, the children are in the same console
+// and receive Ctrl-C directly.
+func forwardInterrupt(c *exec.Cmd) func() {
+	return func() {}
+}
+
+// terminateProcessTree kills the process and its children. Windows has no
+// graceful termination for console processes.
+func terminateProcessTree(c *exec.Cmd) error {
+	return killProcessTree(c)
+}
+
+// killProcessTree kills the process and its children.
+func killProcessTree(c *exec.Cmd) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(c.Process.Pid)).Run(); err != nil {
+		return c.Process.Kill()
+	}
+	return nil
+}
+
+// processInfo is not implemented on Windows.
+func processInfo(pid int) (int, string, error) {
+	return 0, "", errors.New("not implemented")
+}
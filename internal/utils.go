@@ -10,29 +10,98 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 	"syscall"
+	"time"
+	"unicode/utf16"
 )
 
+// GracePeriod is the delay between the termination request and the forced kill
+// of a process tree when the context of Capture is done.
+const GracePeriod = 5 * time.Second
+
 // Capture runs an executable from a directory returns the output, exit code
 // and error if appropriate. It sets the environment variables specified.
-func Capture(wd string, env []string, args ...string) (string, int, error) {
+//
+// When ctx is done, the process and all its children are asked to terminate
+// and are killed after GracePeriod. In this case, the returned error is
+// ctx.Err().
+func Capture(ctx context.Context, wd string, env []string, args ...string) (string, int, error) {
+	return CaptureTee(ctx, wd, env, nil, args...)
+}
+
+// CaptureTee is like Capture but also writes the output to w as it is
+// produced, if w is not nil.
+func CaptureTee(ctx context.Context, wd string, env []string, w io.Writer, args ...string) (string, int, error) {
+	return CaptureLimit(ctx, wd, env, w, 0, args...)
+}
+
+// CaptureLimit is like CaptureTee but keeps at most about limit bytes of the
+// output in memory. When the output is larger, its beginning and its end are
+// returned, separated by a line telling how many bytes were truncated; w still
+// receives the whole output. There is no limit if limit is 0 or negative.
+func CaptureLimit(ctx context.Context, wd string, env []string, w io.Writer, limit int, args ...string) (string, int, error) {
+	buf := &cappedBuffer{limit: limit}
+	out := io.Writer(buf)
+	if w != nil {
+		out = io.MultiWriter(buf, w)
+	}
+	exitCode, err := run(ctx, wd, env, nil, out, out, args)
+	return decodeOutput(buf.bytes()), exitCode, err
+}
+
+// CaptureIO is like Capture but writes stdin to the process and returns its
+// stdout and stderr separately, for tools exchanging structured data.
+func CaptureIO(ctx context.Context, wd string, env []string, stdin []byte, args ...string) (string, string, int, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exitCode, err := run(ctx, wd, env, bytes.NewReader(stdin), stdout, stderr, args)
+	return decodeOutput(stdout.Bytes()), decodeOutput(stderr.Bytes()), exitCode, err
+}
+
+// AncestorsCommandLine returns the command lines of up to max ancestors of
+// the current process, starting with its parent.
+//
+// Stops at the first ancestor that can't be inspected. Returns nothing on
+// Windows.
+func AncestorsCommandLine(max int) []string {
+	var out []string
+	for pid := os.Getppid(); pid > 1 && len(out) < max; {
+		ppid, cmdline, err := processInfo(pid)
+		if err != nil {
+			break
+		}
+		out = append(out, cmdline)
+		pid = ppid
+	}
+	return out
+}
+
+// Private details.
+
+// run runs the process for Capture and CaptureIO.
+func run(ctx context.Context, wd string, env []string, stdin io.Reader, stdout, stderr io.Writer, args []string) (int, error) {
 	exitCode := -1
 	//log.Printf("Capture(%s, %s, %s)", wd, env, args)
 	var c *exec.Cmd
 	switch len(args) {
 	case 0:
-		return "", -1, errors.New("no command specified")
+		return -1, errors.New("no command specified")
 	case 1:
 		c = exec.Command(args[0])
 	default:
 		c = exec.Command(args[0], args[1:]...)
 	}
 	if wd == "" {
-		return "", -1, errors.New("wd is required")
+		return -1, errors.New("wd is required")
 	}
 	c.Dir = wd
 	procEnv := map[string]string{}
@@ -55,7 +124,30 @@ func Capture(wd string, env []string, args ...string) (string, int, error) {
 	for k, v := range procEnv {
 		c.Env = append(c.Env, k+"="+v)
 	}
-	out, err := c.CombinedOutput()
+	c.Stdin = stdin
+	c.Stdout = stdout
+	c.Stderr = stderr
+	setProcessGroup(c)
+	err := c.Start()
+	if err == nil {
+		defer forwardInterrupt(c)()
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Wait()
+		}()
+		select {
+		case err = <-done:
+		case <-ctx.Done():
+			_ = terminateProcessTree(c)
+			select {
+			case <-done:
+			case <-time.After(GracePeriod):
+				_ = killProcessTree(c)
+				<-done
+			}
+			return -1, ctx.Err()
+		}
+	}
 	if c.ProcessState != nil {
 		if waitStatus, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok {
 			exitCode = waitStatus.ExitStatus()
@@ -64,6 +156,84 @@ func Capture(wd string, env []string, args ...string) (string, int, error) {
 			}
 		}
 	}
-	// TODO(maruel): Handle code page on Windows.
-	return string(out), exitCode, err
+	return exitCode, err
+}
+
+// cappedBuffer is an io.Writer keeping the first and the last limit/2 bytes
+// written to it, or everything if limit is 0 or negative.
+type cappedBuffer struct {
+	limit int
+	head  []byte
+	// tail grows up to twice its limit before being trimmed, so the bytes are
+	// copied only once in a while.
+	tail      []byte
+	truncated int
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.limit <= 0 {
+		c.head = append(c.head, p...)
+		return n, nil
+	}
+	if room := c.limit/2 - len(c.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+	c.tail = append(c.tail, p...)
+	if len(c.tail) > 2*c.tailLimit() {
+		c.trim()
+	}
+	return n, nil
+}
+
+// bytes returns the content kept.
+func (c *cappedBuffer) bytes() []byte {
+	c.trim()
+	if c.truncated == 0 {
+		return append(c.head, c.tail...)
+	}
+	out := append([]byte{}, c.head...)
+	out = append(out, fmt.Sprintf("\n... %d bytes truncated ...\n", c.truncated)...)
+	return append(out, c.tail...)
+}
+
+func (c *cappedBuffer) tailLimit() int {
+	return c.limit - c.limit/2
+}
+
+// trim drops the bytes of tail beyond its limit.
+func (c *cappedBuffer) trim() {
+	if c.limit <= 0 {
+		return
+	}
+	if extra := len(c.tail) - c.tailLimit(); extra > 0 {
+		c.truncated += extra
+		c.tail = append(c.tail[:0], c.tail[extra:]...)
+	}
+}
+
+// decodeOutput returns the output of a process as UTF-8. Some Windows tools
+// write UTF-16 with a byte order mark when their output is redirected; it is
+// decoded so the output is parsed identically on all platforms.
+func decodeOutput(b []byte) string {
+	switch {
+	case len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE:
+		return decodeUTF16(b[2:], binary.LittleEndian)
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		return decodeUTF16(b[2:], binary.BigEndian)
+	default:
+		return string(b)
+	}
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
 }
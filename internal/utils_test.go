@@ -5,11 +5,14 @@
 package internal
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"os"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/maruel/ut"
 )
@@ -18,7 +21,7 @@ func TestCaptureNormal(t *testing.T) {
 	t.Parallel()
 	wd, err := os.Getwd()
 	ut.AssertEqual(t, nil, err)
-	out, code, err := Capture(wd, []string{"FOO=BAR"}, "go", "version")
+	out, code, err := Capture(context.Background(), wd, []string{"FOO=BAR"}, "go", "version")
 	ut.AssertEqual(t, true, strings.Contains(out, runtime.Version()))
 	ut.AssertEqual(t, 0, code)
 	ut.AssertEqual(t, nil, err)
@@ -28,7 +31,7 @@ func TestCaptureEmpty(t *testing.T) {
 	t.Parallel()
 	wd, err := os.Getwd()
 	ut.AssertEqual(t, nil, err)
-	out, code, err := Capture(wd, nil)
+	out, code, err := Capture(context.Background(), wd, nil)
 	ut.AssertEqual(t, "", out)
 	ut.AssertEqual(t, -1, code)
 	ut.AssertEqual(t, errors.New("no command specified"), err)
@@ -38,16 +41,81 @@ func TestCaptureOne(t *testing.T) {
 	t.Parallel()
 	wd, err := os.Getwd()
 	ut.AssertEqual(t, nil, err)
-	_, code, err := Capture(wd, nil, "go")
+	_, code, err := Capture(context.Background(), wd, nil, "go")
 	ut.AssertEqual(t, 2, code)
 	ut.AssertEqual(t, nil, err)
 }
 
+func TestCaptureIO(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	stdout, stderr, code, err := CaptureIO(context.Background(), wd, nil, []byte("in\n"), "sh", "-c", "cat; echo err >&2; exit 3")
+	ut.AssertEqual(t, "in\n", stdout)
+	ut.AssertEqual(t, "err\n", stderr)
+	ut.AssertEqual(t, 3, code)
+	ut.AssertEqual(t, nil, err)
+}
+
+func TestCaptureTee(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	buf := &bytes.Buffer{}
+	out, code, err := CaptureTee(context.Background(), wd, nil, buf, "sh", "-c", "echo out; echo err >&2")
+	ut.AssertEqual(t, "out\nerr\n", out)
+	ut.AssertEqual(t, out, buf.String())
+	ut.AssertEqual(t, 0, code)
+	ut.AssertEqual(t, nil, err)
+}
+
+func TestCaptureLimit(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	buf := &bytes.Buffer{}
+	out, code, err := CaptureLimit(context.Background(), wd, nil, buf, 8, "sh", "-c", "echo 0123456789; echo abcdefghij")
+	ut.AssertEqual(t, "0123\n... 14 bytes truncated ...\nhij\n", out)
+	ut.AssertEqual(t, "0123456789\nabcdefghij\n", buf.String())
+	ut.AssertEqual(t, 0, code)
+	ut.AssertEqual(t, nil, err)
+
+	out, _, err = CaptureLimit(context.Background(), wd, nil, nil, 100, "sh", "-c", "echo short")
+	ut.AssertEqual(t, "short\n", out)
+	ut.AssertEqual(t, nil, err)
+}
+
+func TestCappedBuffer(t *testing.T) {
+	t.Parallel()
+	c := &cappedBuffer{limit: 4}
+	for i := 0; i < 100; i++ {
+		n, err := c.Write([]byte{byte('a' + i%26)})
+		ut.AssertEqual(t, 1, n)
+		ut.AssertEqual(t, nil, err)
+		// The memory used is bounded.
+		ut.AssertEqual(t, true, len(c.head)+len(c.tail) <= 6)
+	}
+	ut.AssertEqual(t, "ab\n... 96 bytes truncated ...\nuv", string(c.bytes()))
+
+	c = &cappedBuffer{}
+	_, _ = c.Write([]byte("unlimited"))
+	ut.AssertEqual(t, "unlimited", string(c.bytes()))
+}
+
 func TestCaptureMissing(t *testing.T) {
 	t.Parallel()
 	wd, err := os.Getwd()
 	ut.AssertEqual(t, nil, err)
-	out, code, err := Capture(wd, nil, "program_is_non_existent")
+	out, code, err := Capture(context.Background(), wd, nil, "program_is_non_existent")
 	ut.AssertEqual(t, "", out)
 	ut.AssertEqual(t, -1, code)
 	ut.AssertEqual(t, true, err != nil)
@@ -55,7 +123,52 @@ func TestCaptureMissing(t *testing.T) {
 
 func TestCaptureNoWd(t *testing.T) {
 	t.Parallel()
-	_, code, err := Capture("", nil, "go")
+	_, code, err := Capture(context.Background(), "", nil, "go")
 	ut.AssertEqual(t, -1, code)
 	ut.AssertEqual(t, errors.New("wd is required"), err)
 }
+
+func TestCaptureContextTimeout(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.SkipNow()
+	}
+	wd, err := os.Getwd()
+	ut.AssertEqual(t, nil, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The grand child holds the output pipe, it must be killed too.
+	_, code, err := Capture(ctx, wd, nil, "sh", "-c", "sleep 30 & sleep 30")
+	ut.AssertEqual(t, -1, code)
+	ut.AssertEqual(t, context.DeadlineExceeded, err)
+	ut.AssertEqual(t, true, time.Since(start) < 10*time.Second)
+}
+
+func TestAncestorsCommandLine(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		ut.AssertEqual(t, []string(nil), AncestorsCommandLine(2))
+		return
+	}
+	out := AncestorsCommandLine(1)
+	ut.AssertEqual(t, 1, len(out))
+	ut.AssertEqual(t, true, out[0] != "")
+	ut.AssertEqual(t, []string(nil), AncestorsCommandLine(0))
+}
+
+func TestDecodeOutput(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		in       []byte
+		expected string
+	}{
+		{nil, ""},
+		{[]byte("a\nb\n"), "a\nb\n"},
+		{[]byte{0xFF, 0xFE, 'a', 0, 0xE9, 0, '\n', 0}, "aé\n"},
+		{[]byte{0xFE, 0xFF, 0, 'a', 0, 0xE9, 0, '\n'}, "aé\n"},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, decodeOutput(line.in))
+	}
+}
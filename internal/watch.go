@@ -0,0 +1,151 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports the files modified in a tree. It uses the file system
+// notifications of the OS, e.g. inotify, so watching an idle tree costs
+// nothing.
+type Watcher struct {
+	root    string
+	skip    func(rel string) bool
+	watcher *fsnotify.Watcher
+}
+
+// NewWatcher watches the files in root. The scm directory .git and the
+// directories and files for which skip returns true are ignored. The caller
+// must call Close.
+func NewWatcher(root string, skip func(rel string) bool) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{root: root, skip: skip, watcher: fw}
+	if _, err = w.add(root); err != nil {
+		_ = fw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Next waits for files to be modified, then for the modifications to stop for
+// quiet, so a save of multiple files is returned at once. It returns the
+// relative paths with forward slashes of the files modified, sorted, or nil
+// once ctx is done.
+func (w *Watcher) Next(ctx context.Context, quiet time.Duration) ([]string, error) {
+	modified := map[string]bool{}
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case e, ok := <-w.watcher.Events:
+			if !ok {
+				return nil, errors.New("the watcher is closed")
+			}
+			if w.handle(e, modified) {
+				settled = time.After(quiet)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil, errors.New("the watcher is closed")
+			}
+			if err != fsnotify.ErrEventOverflow {
+				return nil, fmt.Errorf("failed to watch %s: %s", w.root, err)
+			}
+			log.Printf("too many modifications at once; some were missed")
+		case <-settled:
+			out := make([]string, 0, len(modified))
+			for f := range modified {
+				out = append(out, f)
+			}
+			sort.Strings(out)
+			return out, nil
+		}
+	}
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	return w.watcher.Close()
+}
+
+// Private stuff.
+
+// add watches the directory d and its subdirectories, and returns the files
+// found in them.
+func (w *Watcher) add(d string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(d, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Deleted while walking, e.g. an editor's temporary directory.
+				return nil
+			}
+			return err
+		}
+		rel := w.rel(p)
+		if info.IsDir() {
+			if rel != "" && (info.Name() == ".git" || w.skip(rel)) {
+				return filepath.SkipDir
+			}
+			return w.watcher.Add(p)
+		}
+		if !w.skip(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %s", d, err)
+	}
+	return files, nil
+}
+
+// handle adds the files modified by e to modified. Returns true if any was.
+func (w *Watcher) handle(e fsnotify.Event, modified map[string]bool) bool {
+	rel := w.rel(e.Name)
+	if rel == "" || rel == ".git" || strings.HasPrefix(rel, ".git/") || w.skip(rel) || e.Op == fsnotify.Chmod {
+		return false
+	}
+	if e.Op&fsnotify.Create != 0 {
+		if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+			// Watch the new directory. Files may have been written in it before
+			// it was watched.
+			files, err := w.add(e.Name)
+			if err != nil {
+				log.Printf("%s", err)
+			}
+			for _, f := range files {
+				modified[f] = true
+			}
+			return len(files) != 0
+		}
+	}
+	modified[rel] = true
+	return true
+}
+
+// rel returns the path p relative to the root with forward slashes, "" for
+// the root itself.
+func (w *Watcher) rel(p string) string {
+	if len(p) <= len(w.root) {
+		return ""
+	}
+	return filepath.ToSlash(p[len(w.root)+1:])
+}
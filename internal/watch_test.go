@@ -0,0 +1,66 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/ut"
+)
+
+func TestWatcher(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := RemoveAll(td); err != nil {
+			t.Fail()
+		}
+	}()
+	write := func(files ...string) {
+		for _, p := range files {
+			ut.AssertEqual(t, nil, os.MkdirAll(filepath.Dir(filepath.Join(td, p)), 0700))
+			ut.AssertEqual(t, nil, ioutil.WriteFile(filepath.Join(td, p), []byte("package a\n"), 0600))
+		}
+	}
+	write(".git/index", "a.go", "b/c.go", "vendor/d.go")
+	ignore := func(rel string) bool {
+		return rel == "vendor" || strings.HasSuffix(rel, ".pb.go")
+	}
+	w, err := NewWatcher(td, ignore)
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, w.Close())
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The ignored files and the scm directory don't matter.
+	write(".git/index", "vendor/d.go", "e.pb.go", "b/c.go", "a.go")
+	files, err := w.Next(ctx, 50*time.Millisecond)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"a.go", "b/c.go"}, files)
+
+	// The files of a new directory are reported and it is watched.
+	write("f/g/h.go")
+	files, err = w.Next(ctx, 50*time.Millisecond)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"f/g/h.go"}, files)
+	write("f/g/i.go")
+	files, err = w.Next(ctx, 50*time.Millisecond)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"f/g/i.go"}, files)
+
+	cancel()
+	files, err = w.Next(ctx, 50*time.Millisecond)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string(nil), files)
+}
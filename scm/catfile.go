@@ -0,0 +1,139 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/pre-commit-go/internal"
+)
+
+// catFileIdle is the delay after which an unused cat-file process is stopped.
+const catFileIdle = time.Second
+
+// catFile reads git objects through a single long lived
+// "git cat-file --batch" process instead of starting one process per read.
+//
+// The process is started on first use and stopped once idle for catFileIdle,
+// so no process is left behind. The zero value is ready to use.
+type catFile struct {
+	lock   sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	timer  *time.Timer
+}
+
+// read returns the content of the blob named rev, e.g. "HEAD:foo.go" or
+// ":foo.go" for the index, in the repository at root.
+//
+// Returns false if the object doesn't exist.
+func (c *catFile) read(root, rev string) ([]byte, bool, error) {
+	if strings.ContainsAny(rev, "\r\n") {
+		return nil, false, fmt.Errorf("invalid object name %q", rev)
+	}
+	internal.Latency("scm.catFile.read")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cmd == nil {
+		if err := c.start(root); err != nil {
+			return nil, false, err
+		}
+	}
+	c.timer.Stop()
+	defer c.timer.Reset(catFileIdle)
+	content, found, err := c.request(rev)
+	if err != nil {
+		// The pipe is in an unknown state.
+		c.stopLocked()
+	}
+	return content, found, err
+}
+
+// Private stuff.
+
+func (c *catFile) start(root string) error {
+	cmd := exec.Command("git", "cat-file", "--batch")
+	cmd.Dir = root
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	c.cmd = cmd
+	c.stdin = stdin
+	c.stdout = bufio.NewReader(stdout)
+	c.timer = time.AfterFunc(catFileIdle, c.stop)
+	return nil
+}
+
+// request sends one request. The lock must be held.
+func (c *catFile) request(rev string) ([]byte, bool, error) {
+	if _, err := io.WriteString(c.stdin, rev+"\n"); err != nil {
+		return nil, false, err
+	}
+	// The header is "<sha1> <type> <size>" or "<rev> missing".
+	header, err := c.stdout.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	header = strings.TrimSuffix(header, "\n")
+	if strings.HasSuffix(header, " missing") || strings.HasSuffix(header, " ambiguous") {
+		return nil, false, nil
+	}
+	items := strings.Split(header, " ")
+	if len(items) != 3 {
+		return nil, false, fmt.Errorf("unexpected cat-file header %q", header)
+	}
+	size, err := strconv.Atoi(items[2])
+	if err != nil {
+		return nil, false, fmt.Errorf("unexpected cat-file header %q", header)
+	}
+	// The content is followed by a LF.
+	buf := make([]byte, size+1)
+	if _, err = io.ReadFull(c.stdout, buf); err != nil {
+		return nil, false, err
+	}
+	if items[1] != "blob" {
+		return nil, false, fmt.Errorf("%s is a %s", rev, items[1])
+	}
+	return buf[:size], true, nil
+}
+
+// stop stops the process if it is running.
+func (c *catFile) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stopLocked()
+}
+
+func (c *catFile) stopLocked() {
+	if c.cmd == nil {
+		return
+	}
+	c.timer.Stop()
+	// Closing stdin makes git exit.
+	_ = c.stdin.Close()
+	if err := c.cmd.Wait(); err != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	c.cmd = nil
+	c.stdin = nil
+	c.stdout = nil
+	c.timer = nil
+}
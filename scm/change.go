@@ -5,6 +5,7 @@
 package scm
 
 import (
+	"bytes"
 	"go/scanner"
 	"go/token"
 	"io/ioutil"
@@ -15,6 +16,8 @@ import (
 	"sort"
 	"strings"
 	"sync"
+
+	"github.com/maruel/pre-commit-go/internal"
 )
 
 // Change represents a change to test against.
@@ -23,8 +26,9 @@ import (
 type Change interface {
 	// Repo references back to the repository.
 	Repo() ReadOnlyRepo
-	// Package returns the package name to reference Repo().Root(). Returns an
-	// empty string if the repository is located outside of $GOPATH.
+	// Package returns the package name to reference Repo().Root(): the module
+	// path declared in go.mod if present, otherwise the path relative to
+	// $GOPATH/src. Returns an empty string if neither is found.
 	Package() string
 	// Changed is the directly affected files and packages.
 	Changed() Set
@@ -37,13 +41,81 @@ type Change interface {
 	Indirect() Set
 	// All returns all the files in the repository.
 	All() Set
-	// Content returns the content of a file.
+	// Content returns the content of a file. name uses forward slashes on all
+	// platforms. CRLF line endings are converted to LF when the source control
+	// does so on commit, e.g. with git core.autocrlf, so the content matches
+	// what is committed.
 	Content(name string) []byte
 	// IsIgnored returns true if this path is ignored. This is mostly relevant
 	// when using tools that work at the package level instead of at the file
 	// level and generated files (like proto-gen-go generated files) should be
 	// ignored.
 	IsIgnored(p string) bool
+	// Renames returns the files in Changed() that were renamed or moved,
+	// sorted by new path. See Repo.SetRenameThreshold.
+	Renames() []Rename
+	// Status returns the status of a file in Changed(). Returns Unchanged for
+	// any other file.
+	Status(p string) FileStatus
+	// Deleted returns the files deleted by this change, sorted. They are not
+	// in Changed() since they don't exist anymore. The old path of a renamed
+	// file is not included.
+	Deleted() []string
+	// Submodules returns the git submodules modified by this change, sorted by
+	// path. A submodule is not a file so it is never in any Set.
+	Submodules() []Submodule
+	// Hunks returns the line ranges of the file p in Changed() that were added
+	// or modified by this change, sorted. The whole file is returned when it
+	// was added or when its previous content is not known. Returns nil for any
+	// other file.
+	Hunks(p string) []Hunk
+}
+
+// FileStatus is the status of a file in a Change.
+type FileStatus string
+
+const (
+	// Unchanged is a file that is not part of the change.
+	Unchanged FileStatus = ""
+	// Added is a new file.
+	Added FileStatus = "added"
+	// Modified is a file that existed before, or a file whose status is
+	// unknown, e.g. when the files are explicitly listed.
+	Modified FileStatus = "modified"
+	// Renamed is a file renamed or moved, and possibly modified. See
+	// Change.Renames().
+	Renamed FileStatus = "renamed"
+)
+
+// Rename is a file renamed or moved in a Change.
+type Rename struct {
+	// Old is the path before the change.
+	Old string
+	// New is the path after the change.
+	New string
+	// Similarity is the percentage of the content that is unchanged.
+	Similarity int
+}
+
+// Submodule is a submodule modified in a Change.
+type Submodule struct {
+	// Path is the path of the submodule relative to the repository root.
+	Path string
+	// Old is the commit of the submodule before the change, Initial if it was
+	// added.
+	Old Commit
+	// New is the commit of the submodule after the change, Current when
+	// looking at the checkout.
+	New Commit
+}
+
+// Hunk is a range of lines modified by a Change, in the new content of a file.
+type Hunk struct {
+	// Start is the first line, starting at 1.
+	Start int
+	// Count is the number of lines. It is 0 when lines were only deleted, in
+	// which case they were located before Start.
+	Count int
 }
 
 // Set is a subset of files/directories/packages relative to the change and the
@@ -51,6 +123,9 @@ type Change interface {
 //
 // Each list is guaranteed to be sorted according to sort.StringsAreStored().
 type Set interface {
+	// Files returns all the files, including the ones that are not Go source
+	// files, e.g. documentation.
+	Files() []string
 	// GoFiles returns all the source files, including tests.
 	GoFiles() []string
 	// Packages returns all the packages included in this set, using the relative
@@ -65,6 +140,13 @@ type Set interface {
 	TestPackages() []string
 }
 
+// GetImports returns the package name and all imports of a Go source file.
+//
+// It is much faster than go/parser.ParseFile() with mode ImportsOnly.
+func GetImports(content []byte) (string, []string) {
+	return getImports(content)
+}
+
 // Private details.
 
 const pathSeparator = string(os.PathSeparator)
@@ -77,6 +159,21 @@ type change struct {
 	indirect       set
 	all            set
 
+	// old is the commit the change is compared to, empty when not known.
+	old Commit
+	// fromIndex is the files to read from the index instead of the disk.
+	fromIndex  map[string]bool
+	added      map[string]bool
+	deleted    []string
+	renames    []Rename
+	submodules []Submodule
+	// skipped is the files not checked out in a sparse checkout. They are read
+	// from the index, which fetches them on demand in a partial clone.
+	skipped map[string]bool
+
+	eolOnce sync.Once
+	eol     bool
+
 	lock    sync.Mutex
 	content map[string][]byte
 }
@@ -84,9 +181,14 @@ type change struct {
 func newChange(r ReadOnlyRepo, files, allFiles, ignorePatterns IgnorePatterns) *change {
 	//log.Printf("Change{%s, %s}", files, allFiles)
 	root := r.Root()
-	// An error occurs when the repository is not inside GOPATH. Ignore this
-	// error here.
-	pkgName, _ := relToGOPATH(root, r.GOPATH())
+	// The module path wins over the location in GOPATH, since it is what the
+	// toolchain uses in module mode, e.g. in the coverage profiles. An error
+	// occurs when the repository is not inside GOPATH; ignore it.
+	pkgName := modulePath(root)
+	if pkgName == "" {
+		pkgName, _ = relToGOPATH(root, r.GOPATH())
+	}
+	pkgName = filepath.ToSlash(pkgName)
 	c := &change{
 		repo:           r,
 		packageName:    pkgName,
@@ -94,6 +196,10 @@ func newChange(r ReadOnlyRepo, files, allFiles, ignorePatterns IgnorePatterns) *
 		content:        map[string][]byte{},
 	}
 
+	// files and allFiles are already sorted.
+	c.direct.paths = []string(files)
+	c.all.paths = []string(allFiles)
+
 	// Map of <relative directory> : <relative package>
 	testDirs := map[string]string{}
 	sourceDirs := map[string]string{}
@@ -130,12 +236,12 @@ func newChange(r ReadOnlyRepo, files, allFiles, ignorePatterns IgnorePatterns) *
 		}
 		c.all.files = append(c.all.files, f)
 		dir := dirName(f)
-		allDirs[dir] = append(allDirs[dir], filepath.Base(f))
+		allDirs[dir] = append(allDirs[dir], path.Base(f))
 		if _, ok := allSourceDirs[dir]; !ok {
 			relPkgName := dirToPkg(dir)
 			allSourceDirs[dir] = true
 			c.all.packages = append(c.all.packages, relPkgName)
-			allPkgs[path.Join(pkgName, strings.Replace(dir, pathSeparator, "/", -1))] = dir
+			allPkgs[path.Join(pkgName, dir)] = dir
 		}
 		if strings.HasSuffix(f, "_test.go") {
 			if _, ok := allTestDirs[dir]; !ok {
@@ -182,6 +288,7 @@ func newChange(r ReadOnlyRepo, files, allFiles, ignorePatterns IgnorePatterns) *
 	}()
 	wg.Wait()
 
+	c.indirect.paths = c.direct.paths
 	c.indirect.files = c.direct.files
 	if len(c.direct.packages) == len(c.all.packages) && len(c.direct.testPackages) == len(c.all.testPackages) {
 		// Everything is affected. Skip processing files.
@@ -220,11 +327,11 @@ func newChange(r ReadOnlyRepo, files, allFiles, ignorePatterns IgnorePatterns) *
 						wg.Done()
 						parallel <- true
 					}()
-					content := c.Content(filepath.Join(baseDir, f))
-					if content == nil {
+					internal.Latency("scm.Change.imports")
+					localImports, ok := c.imports(path.Join(baseDir, f))
+					if !ok {
 						return
 					}
-					_, localImports := getImports(content)
 					for _, imp := range localImports {
 						if importedDir, ok := allPkgs[imp]; ok {
 							isTest := strings.HasSuffix(f, "_test.go")
@@ -323,12 +430,20 @@ func (c *change) All() Set {
 }
 
 func (c *change) Content(p string) []byte {
+	p = filepath.ToSlash(p)
 	c.lock.Lock()
 	content, ok := c.content[p]
 	c.lock.Unlock()
 	if !ok {
+		internal.Latency("scm.Change.Content")
 		var err error
-		content, err = ioutil.ReadFile(filepath.Join(c.repo.Root(), p))
+		if c.fromIndex[p] || c.skipped[p] {
+			content, err = c.repo.ContentAt(Index, p)
+		} else {
+			if content, err = ioutil.ReadFile(filepath.Join(c.repo.Root(), filepath.FromSlash(p))); err == nil && c.normalizesEOL() {
+				content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+			}
+		}
 		if err != nil {
 			log.Printf("failed to read %s: %s", p, err)
 		}
@@ -339,19 +454,95 @@ func (c *change) Content(p string) []byte {
 	return content
 }
 
+// normalizesEOL returns true when the repository converts CRLF to LF on
+// commit.
+func (c *change) normalizesEOL() bool {
+	c.eolOnce.Do(func() {
+		if n, ok := c.repo.(interface {
+			normalizesEOL() bool
+		}); ok {
+			c.eol = n.normalizesEOL()
+		}
+	})
+	return c.eol
+}
+
 func (c *change) IsIgnored(p string) bool {
 	return c.ignorePatterns.Match(p)
 }
 
+func (c *change) Renames() []Rename {
+	return c.renames
+}
+
+func (c *change) Status(p string) FileStatus {
+	i := sort.SearchStrings(c.direct.paths, p)
+	if i == len(c.direct.paths) || c.direct.paths[i] != p {
+		return Unchanged
+	}
+	if c.added[p] {
+		return Added
+	}
+	for _, r := range c.renames {
+		if r.New == p {
+			return Renamed
+		}
+	}
+	return Modified
+}
+
+func (c *change) Deleted() []string {
+	return c.deleted
+}
+
+func (c *change) Submodules() []Submodule {
+	return c.submodules
+}
+
+func (c *change) Hunks(p string) []Hunk {
+	s := c.Status(p)
+	if s == Unchanged {
+		return nil
+	}
+	return c.hunks(p, s)
+}
+
+// setAllAdded marks all the files in the change as added.
+func (c *change) setAllAdded() {
+	c.added = make(map[string]bool, len(c.direct.paths))
+	for _, p := range c.direct.paths {
+		c.added[p] = true
+	}
+}
+
+// renamesByNew sorts renames by new path.
+type renamesByNew []Rename
+
+func (r renamesByNew) Len() int           { return len(r) }
+func (r renamesByNew) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r renamesByNew) Less(i, j int) bool { return r[i].New < r[j].New }
+
+// submodulesByPath sorts submodules by path.
+type submodulesByPath []Submodule
+
+func (s submodulesByPath) Len() int           { return len(s) }
+func (s submodulesByPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s submodulesByPath) Less(i, j int) bool { return s[i].Path < s[j].Path }
+
 // set implements Set.
 //
 // Items must be sorted.
 type set struct {
+	paths        []string
 	files        []string
 	packages     []string
 	testPackages []string
 }
 
+func (s *set) Files() []string {
+	return s.paths
+}
+
 func (s *set) GoFiles() []string {
 	return s.files
 }
@@ -368,11 +559,11 @@ func dirToPkg(d string) string {
 	if d == "." {
 		return d
 	}
-	return "./" + strings.Replace(d, pathSeparator, "/", -1)
+	return "./" + d
 }
 
 func dirName(p string) string {
-	if d := filepath.Dir(p); d != "" {
+	if d := path.Dir(p); d != "" {
 		return d
 	}
 	return "."
@@ -104,9 +104,12 @@ func TestChangeIndirect(t *testing.T) {
 	ut.AssertEqual(t, r, c.Repo())
 	ut.AssertEqual(t, "", c.Package())
 	changed := c.Changed()
+	ut.AssertEqual(t, []string{"a/a.go"}, changed.Files())
 	ut.AssertEqual(t, []string{"a/a.go"}, changed.GoFiles())
 	ut.AssertEqual(t, []string{"./a"}, changed.Packages())
 	ut.AssertEqual(t, []string{"./a"}, changed.TestPackages())
+	ut.AssertEqual(t, Modified, c.Status("a/a.go"))
+	ut.AssertEqual(t, Unchanged, c.Status("b/b.go"))
 	indirect := c.Indirect()
 	ut.AssertEqual(t, []string{"a/a.go"}, indirect.GoFiles())
 	ut.AssertEqual(t, []string{"./a", "./b"}, indirect.Packages())
@@ -193,6 +196,24 @@ func TestChangeAll(t *testing.T) {
 	ut.AssertEqual(t, []string{".", "./bar"}, all.TestPackages())
 }
 
+func TestChangePackage(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, internal.RemoveAll(td))
+	}()
+	root := filepath.Join(td, "src", "example.com", "checkout")
+	write(t, root, "foo.go", "package foo\n")
+	r, err := GetDir(root, td)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "example.com/checkout", newChange(r, nil, nil, nil).Package())
+
+	// A module checked out inside GOPATH is referenced by its module path.
+	write(t, root, "go.mod", "module example.com/foo\n")
+	ut.AssertEqual(t, "example.com/foo", newChange(r, nil, nil, nil).Package())
+}
+
 func TestGetImports(t *testing.T) {
 	t.Parallel()
 	data := []struct {
@@ -312,11 +333,21 @@ func (d *dummyRepo) ScmDir() (string, error)   { d.t.FailNow(); return "", nil }
 func (d *dummyRepo) HookPath() (string, error) { d.t.FailNow(); return "", nil }
 func (d *dummyRepo) Ref(c Commit) string       { d.t.FailNow(); return "" }
 func (d *dummyRepo) Eval(refish string) Commit { d.t.FailNow(); return Invalid }
+func (d *dummyRepo) Before(when string) Commit { d.t.FailNow(); return Invalid }
 func (d *dummyRepo) Between(recent, old Commit, ignoredPaths IgnorePatterns) (Change, error) {
 	d.t.FailNow()
 	return nil, nil
 }
-func (d *dummyRepo) GOPATH() string { return d.root }
+func (d *dummyRepo) GOPATH() string  { return d.root }
+func (d *dummyRepo) IsMerging() bool { d.t.FailNow(); return false }
+func (d *dummyRepo) ContentAt(c Commit, p string) ([]byte, error) {
+	d.t.FailNow()
+	return nil, nil
+}
+func (d *dummyRepo) Message(c Commit) (string, error) {
+	d.t.FailNow()
+	return "", nil
+}
 
 // makeTree creates a temporary directory and creates the files in it.
 //
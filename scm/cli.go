@@ -0,0 +1,322 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/internal"
+)
+
+// CLI describes how to drive a source control tool from its command line, so
+// a source control without native support, e.g. Fossil or Bazaar, can be
+// used from the configuration. git always uses its native implementation.
+//
+// Each command is a list of arguments, the first one being the executable. It
+// is run from the root of the checkout, except Root. In the arguments, {rev}
+// is replaced by a revision as printed by Head or Eval and {path} by a file
+// path relative to the root. A command listing files prints one path per line,
+// relative to the root. Use a shell to filter the output of a command, e.g.
+// ["sh", "-c", "fossil changes | cut -c 12-"].
+type CLI struct {
+	// Name is the name of the source control, e.g. "fossil".
+	Name string `yaml:"name"`
+	// Root prints the root directory of the checkout when run from any
+	// directory in it and fails outside of a checkout. Required.
+	Root []string `yaml:"root"`
+	// Files lists all the versioned files. Required.
+	Files []string `yaml:"files"`
+	// Changed lists the files added or modified in the checkout since {rev}.
+	// Required.
+	Changed []string `yaml:"changed"`
+	// Head prints the revision checked out. Required.
+	Head []string `yaml:"head"`
+	// Content prints the content of {path} at {rev}. Required.
+	Content []string `yaml:"content"`
+	// Eval prints the revision of the branch, tag or revision {rev}. When not
+	// set, revisions are used as is.
+	Eval []string `yaml:"eval,omitempty"`
+	// Upstream prints the revision the checkout is based on in the remote
+	// repository.
+	Upstream []string `yaml:"upstream,omitempty"`
+	// Message prints the message of the revision {rev}.
+	Message []string `yaml:"message,omitempty"`
+	// Checkout checks out {rev}.
+	Checkout []string `yaml:"checkout,omitempty"`
+	// Stash saves and reverts the modifications in the checkout; the
+	// equivalent of "git stash". Restore restores them. When not set, the
+	// modifications are checked as is.
+	Stash   []string `yaml:"stash,omitempty"`
+	Restore []string `yaml:"restore,omitempty"`
+}
+
+// Validate returns an error if a required command is missing.
+func (c *CLI) Validate() error {
+	if c.Name == "" {
+		return errors.New("scm: name is required")
+	}
+	required := []struct {
+		name string
+		cmd  []string
+	}{
+		{"root", c.Root},
+		{"files", c.Files},
+		{"changed", c.Changed},
+		{"head", c.Head},
+		{"content", c.Content},
+	}
+	for _, r := range required {
+		if len(r.cmd) == 0 {
+			return fmt.Errorf("scm %s: %s is required", c.Name, r.name)
+		}
+	}
+	if (len(c.Stash) == 0) != (len(c.Restore) == 0) {
+		return fmt.Errorf("scm %s: stash and restore must be set together", c.Name)
+	}
+	return nil
+}
+
+// GetCLIRepo returns a Repo for the checkout containing wd, driven by the
+// commands of c.
+//
+// The change between two revisions is not supported; Between only supports
+// Current as the recent commit. Apply and the hooks are not supported.
+func GetCLIRepo(wd, gopath string, c *CLI) (Repo, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	root, err := captureAbs(wd, c.Root...)
+	if err != nil {
+		return nil, fmt.Errorf("not a %s checkout: %s", c.Name, err)
+	}
+	if gopath == "" {
+		gopath = build.Default.GOPATH
+	}
+	return &cliRepo{root: root, gopath: gopath, cli: c}, nil
+}
+
+// Private details.
+
+// cliRepo implements Repo with the commands of a CLI.
+type cliRepo struct {
+	root   string
+	gopath string
+	cli    *CLI
+}
+
+func (r *cliRepo) Root() string {
+	return r.root
+}
+
+func (r *cliRepo) ScmDir() (string, error) {
+	return "", fmt.Errorf("%s has no scm directory", r.cli.Name)
+}
+
+func (r *cliRepo) HookPath() (string, error) {
+	return "", fmt.Errorf("%s hooks are not supported", r.cli.Name)
+}
+
+func (r *cliRepo) Ref(c Commit) string {
+	return ""
+}
+
+func (r *cliRepo) Eval(refish string) Commit {
+	var cmd []string
+	switch Commit(refish) {
+	case Current, Initial:
+		return Commit(refish)
+	case Head:
+		cmd = r.cli.Head
+	case Upstream:
+		cmd = r.cli.Upstream
+	default:
+		if len(r.cli.Eval) == 0 {
+			return Commit(refish)
+		}
+		cmd = r.cli.Eval
+	}
+	if len(cmd) == 0 {
+		return Invalid
+	}
+	out, err := r.run(cmd, refish, "")
+	if err != nil || out == "" {
+		return Invalid
+	}
+	return Commit(out)
+}
+
+func (r *cliRepo) Before(when string) Commit {
+	return Invalid
+}
+
+func (r *cliRepo) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change, error) {
+	// There's no staging area; what is committed is the checkout.
+	if recent != Current && recent != Index {
+		return nil, fmt.Errorf("%s only supports changes in the checkout", r.cli.Name)
+	}
+	all, err := r.list(r.cli.Files, "", ignorePatterns)
+	if err != nil {
+		return nil, err
+	}
+	files := all
+	if old != Initial {
+		if old = r.resolve(old); old == Invalid {
+			return nil, errors.New("invalid old commit")
+		}
+		if files, err = r.list(r.cli.Changed, string(old), ignorePatterns); err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	c := newChange(r, files, all, ignorePatterns)
+	if old == Initial {
+		c.setAllAdded()
+	} else {
+		c.old = old
+	}
+	return c, nil
+}
+
+func (r *cliRepo) GOPATH() string {
+	return r.gopath
+}
+
+func (r *cliRepo) IsMerging() bool {
+	return false
+}
+
+func (r *cliRepo) ContentAt(c Commit, p string) ([]byte, error) {
+	switch c {
+	case Current, Index:
+		// There's no staging area; the checkout is what will be committed.
+		return ioutil.ReadFile(filepath.Join(r.root, p))
+	case Initial:
+		return nil, fmt.Errorf("failed to read %s at %s: not found", p, c)
+	}
+	if c = r.resolve(c); c == Invalid {
+		return nil, errors.New("invalid commit")
+	}
+	args := expand(r.cli.Content, string(c), p)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = r.root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %s", p, c, err)
+	}
+	return out, nil
+}
+
+func (r *cliRepo) Message(c Commit) (string, error) {
+	if len(r.cli.Message) == 0 {
+		return "", fmt.Errorf("%s commit messages are not supported", r.cli.Name)
+	}
+	if c = r.resolve(c); c == Invalid || c == Current || c == Initial {
+		return "", fmt.Errorf("%s has no commit message", c)
+	}
+	return r.run(r.cli.Message, string(c), "")
+}
+
+func (r *cliRepo) Stash() (bool, error) {
+	if len(r.cli.Stash) == 0 {
+		return false, nil
+	}
+	if files, err := r.list(r.cli.Changed, string(r.Eval(string(Head))), nil); err != nil || len(files) == 0 {
+		// No need to stash, the checkout is unmodified.
+		return false, err
+	}
+	if _, err := r.run(r.cli.Stash, "", ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *cliRepo) Dirty(ignorePatterns IgnorePatterns) ([]string, error) {
+	return r.list(r.cli.Changed, string(r.Eval(string(Head))), ignorePatterns)
+}
+
+func (r *cliRepo) Restore() error {
+	if len(r.cli.Restore) == 0 {
+		return fmt.Errorf("%s can't restore", r.cli.Name)
+	}
+	_, err := r.run(r.cli.Restore, "", "")
+	return err
+}
+
+func (r *cliRepo) Checkout(refish string) error {
+	if len(r.cli.Checkout) == 0 {
+		return fmt.Errorf("%s checkout is not supported", r.cli.Name)
+	}
+	_, err := r.run(r.cli.Checkout, refish, "")
+	return err
+}
+
+func (r *cliRepo) Apply(patch string) error {
+	return fmt.Errorf("%s can't apply patches", r.cli.Name)
+}
+
+func (r *cliRepo) Merge(c Commit) error {
+	return fmt.Errorf("%s can't merge", r.cli.Name)
+}
+
+func (r *cliRepo) SetRenameThreshold(percent int) {
+}
+
+// resolve returns the revision of Head and Upstream and c otherwise.
+func (r *cliRepo) resolve(c Commit) Commit {
+	if c == Head || c == Upstream {
+		return r.Eval(string(c))
+	}
+	return c
+}
+
+// run runs the command cmd in the root and returns its trimmed output.
+func (r *cliRepo) run(cmd []string, rev, p string) (string, error) {
+	out, code, err := internal.Capture(context.Background(), r.root, nil, expand(cmd, rev, p)...)
+	out = strings.TrimSpace(out)
+	if err != nil {
+		return "", err
+	}
+	if code != 0 {
+		return "", fmt.Errorf("%s failed:\n%s", strings.Join(cmd, " "), out)
+	}
+	return out, nil
+}
+
+// list runs cmd and returns the sorted files it printed, skipping any file
+// matching ignorePatterns.
+func (r *cliRepo) list(cmd []string, rev string, ignorePatterns IgnorePatterns) ([]string, error) {
+	out, err := r.run(cmd, rev, "")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, l := range strings.Split(out, "\n") {
+		l = filepath.ToSlash(strings.TrimSpace(l))
+		if l != "" && !ignorePatterns.Match(l) {
+			files = append(files, l)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// expand returns cmd with {rev} and {path} replaced.
+func expand(cmd []string, rev, p string) []string {
+	out := make([]string, len(cmd))
+	for i, a := range cmd {
+		out[i] = strings.Replace(strings.Replace(a, "{rev}", rev, -1), "{path}", p, -1)
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestGetCLIRepo(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	// A fake source control keeping its state in .fake/.
+	write(t, tmpDir, ".fake/files", "README\na.go\nb/b.go\n")
+	write(t, tmpDir, ".fake/changed-r1", "a.go\n")
+	write(t, tmpDir, ".fake/changed-r2", "a.go\nb/b.go\n")
+	write(t, tmpDir, ".fake/head", "r2\n")
+	write(t, tmpDir, ".fake/r1/a.go", "package a\n")
+	write(t, tmpDir, "README", "hi\n")
+	write(t, tmpDir, "a.go", "package a\n\n// A.\n")
+	write(t, tmpDir, "b/b.go", "package b\n")
+	cli := &CLI{
+		Name:    "fake",
+		Root:    []string{"sh", "-c", "test -d .fake && pwd -P"},
+		Files:   []string{"cat", ".fake/files"},
+		Changed: []string{"cat", ".fake/changed-{rev}"},
+		Head:    []string{"cat", ".fake/head"},
+		Content: []string{"cat", ".fake/{rev}/{path}"},
+	}
+
+	_, err = GetCLIRepo(tmpDir, "", &CLI{Name: "fake"})
+	ut.AssertEqual(t, errors.New("scm fake: root is required"), err)
+	_, err = GetCLIRepo(tmpDir+"/b", "", cli)
+	ut.AssertEqual(t, true, err != nil)
+	r, err := GetCLIRepo(tmpDir, tmpDir, cli)
+	ut.AssertEqual(t, nil, err)
+	root, err := filepath.EvalSymlinks(tmpDir)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, root, r.Root())
+	ut.AssertEqual(t, Commit("r2"), r.Eval(string(Head)))
+	ut.AssertEqual(t, Invalid, r.Eval(string(Upstream)))
+
+	c, err := r.Between(Current, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"README", "a.go", "b/b.go"}, c.Changed().Files())
+	ut.AssertEqual(t, Added, c.Status("a.go"))
+	c, err = r.Between(Current, "r1", IgnorePatterns{"b"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"a.go"}, c.Changed().Files())
+	ut.AssertEqual(t, []string{"README", "a.go"}, c.All().Files())
+	ut.AssertEqual(t, Modified, c.Status("a.go"))
+	_, err = r.Between(Head, "r1", nil)
+	ut.AssertEqual(t, true, err != nil)
+
+	content, err := r.ContentAt("r1", "a.go")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "package a\n", string(content))
+	content, err = r.ContentAt(Index, "a.go")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "package a\n\n// A.\n", string(content))
+	_, err = r.ContentAt("r1", "README")
+	ut.AssertEqual(t, true, err != nil)
+	dirty, err := r.Dirty(IgnorePatterns{"b"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"a.go"}, dirty)
+	stashed, err := r.Stash()
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, stashed)
+	_, err = r.Message("r1")
+	ut.AssertEqual(t, true, err != nil)
+}
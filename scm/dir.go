@@ -0,0 +1,123 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// GetDir returns a ReadOnlyRepo for a directory that is not under source
+// control, e.g. extracted sources.
+//
+// It has no commit; Between(Current, Initial, ...) returns all the files in
+// the directory and the other commits are invalid.
+func GetDir(root, gopath string) (ReadOnlyRepo, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	return &dir{root: root, gopath: gopath}, nil
+}
+
+// Private details.
+
+var errNoSCM = errors.New("not under source control")
+
+// dir implements ReadOnlyRepo for a directory.
+type dir struct {
+	root   string
+	gopath string
+}
+
+func (d *dir) Root() string {
+	return d.root
+}
+
+func (d *dir) ScmDir() (string, error) {
+	return "", errNoSCM
+}
+
+func (d *dir) HookPath() (string, error) {
+	return "", errNoSCM
+}
+
+func (d *dir) Ref(c Commit) string {
+	return ""
+}
+
+func (d *dir) Eval(refish string) Commit {
+	return Invalid
+}
+
+func (d *dir) Before(when string) Commit {
+	return Invalid
+}
+
+func (d *dir) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change, error) {
+	if recent != Current || old != Initial {
+		return nil, errors.New("only all the files can be listed without source control")
+	}
+	var files []string
+	err := filepath.Walk(d.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if ignored, _ := ignorePatterns.match(rel, true); ignored {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !ignorePatterns.Match(rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+	// Without history, all the files are new.
+	c := newChange(d, files, files, ignorePatterns)
+	c.setAllAdded()
+	return c, nil
+}
+
+func (d *dir) GOPATH() string {
+	return d.gopath
+}
+
+func (d *dir) IsMerging() bool {
+	return false
+}
+
+func (d *dir) ContentAt(c Commit, p string) ([]byte, error) {
+	if c != Current {
+		return nil, fmt.Errorf("%s is not available without source control", c)
+	}
+	return ioutil.ReadFile(filepath.Join(d.root, p))
+}
+
+func (d *dir) Message(c Commit) (string, error) {
+	return "", fmt.Errorf("%s has no commit message", c)
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestGetDir(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	write(t, tmpDir, "foo/a.go", "package foo\n")
+	write(t, tmpDir, "foo/a_test.go", "package foo\n")
+	write(t, tmpDir, "README", "hi\n")
+	write(t, tmpDir, ".git/config", "")
+	write(t, tmpDir, "testdata/x.go", "package x\n")
+
+	_, err = GetDir(tmpDir+"/README", tmpDir)
+	ut.AssertEqual(t, true, err != nil)
+	r, err := GetDir(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, Invalid, r.Eval(string(Head)))
+	_, err = r.ScmDir()
+	ut.AssertEqual(t, errNoSCM, err)
+	_, err = r.Between(Current, Head, nil)
+	ut.AssertEqual(t, true, err != nil)
+
+	c, err := r.Between(Current, Initial, IgnorePatterns{".*", "testdata/"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"README", "foo/a.go", "foo/a_test.go"}, c.Changed().Files())
+	ut.AssertEqual(t, []string{"./foo"}, c.Changed().TestPackages())
+	ut.AssertEqual(t, c.Changed().Files(), c.All().Files())
+	ut.AssertEqual(t, "package foo\n", string(c.Content("foo/a.go")))
+	ut.AssertEqual(t, Added, c.Status("foo/a.go"))
+	ut.AssertEqual(t, []string(nil), c.Deleted())
+	content, err := r.ContentAt(Current, "README")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "hi\n", string(content))
+	_, err = r.ContentAt(Index, "README")
+	ut.AssertEqual(t, true, err != nil)
+}
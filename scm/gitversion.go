@@ -0,0 +1,142 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/maruel/pre-commit-go/internal"
+)
+
+// GitVersion is a version of git, e.g. {2, 39, 5}.
+type GitVersion []int
+
+func (v GitVersion) String() string {
+	s := make([]string, len(v))
+	for i, n := range v {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ".")
+}
+
+// AtLeast returns true if v is min or later.
+func (v GitVersion) AtLeast(min GitVersion) bool {
+	for i, m := range min {
+		if i >= len(v) || v[i] < m {
+			return false
+		}
+		if v[i] > m {
+			return true
+		}
+	}
+	return true
+}
+
+// ParseGitVersion returns the version in the output of 'git version', e.g.
+// "git version 2.39.5.windows.1", or nil.
+func ParseGitVersion(out string) GitVersion {
+	f := strings.Fields(out)
+	if len(f) < 3 || f[0] != "git" || f[1] != "version" {
+		return nil
+	}
+	var v GitVersion
+	for _, p := range strings.Split(f[2], ".") {
+		i, err := strconv.Atoi(p)
+		if err != nil {
+			break
+		}
+		v = append(v, i)
+	}
+	return v
+}
+
+// GetGitVersion returns the version of the git in PATH, or nil if it can't be
+// determined. git is only run once per process.
+func GetGitVersion() GitVersion {
+	gitVersion.once.Do(func() {
+		// Any directory works; the git version doesn't depend on the repository.
+		out, code, err := internal.Capture(context.Background(), os.TempDir(), nil, "git", "version")
+		if err != nil || code != 0 {
+			log.Printf("git version failed: %s %s", err, out)
+			return
+		}
+		if gitVersion.v = ParseGitVersion(out); gitVersion.v == nil {
+			log.Printf("unrecognized git version %q", strings.TrimSpace(out))
+		}
+	})
+	return gitVersion.v
+}
+
+// GitFeature is a git feature used by pcg that older versions of git lack.
+type GitFeature struct {
+	// Name describes the feature and what pcg uses it for.
+	Name string
+	// Min is the oldest version of git supporting it.
+	Min GitVersion
+}
+
+// The git features that pcg uses, when they are not available in all the
+// versions of git.
+//
+// pcg falls back to a compatible variant for the features with a
+// replacement, and fails with a clear error for the others.
+var (
+	// GitStashPush is 'git stash push'; 'git stash save' is used before.
+	GitStashPush = &GitFeature{"git stash push", GitVersion{2, 13}}
+	// GitStashUntracked is 'git stash --include-untracked', to stash the
+	// modifications not in the index before running the checks.
+	GitStashUntracked = &GitFeature{"git stash --include-untracked, to stash the modifications not in the index", GitVersion{1, 7, 7}}
+	// GitWorktree is 'git worktree add --detach', to check a patch or a
+	// submodule in a temporary checkout.
+	GitWorktree = &GitFeature{"git worktree, to check a patch in a temporary checkout", GitVersion{2, 5}}
+	// GitWorktreeRemove is 'git worktree remove'; the worktree is deleted
+	// then pruned before.
+	GitWorktreeRemove = &GitFeature{"git worktree remove", GitVersion{2, 17}}
+	// GitHooksPath is the core.hooksPath setting, for 'pcg install -global'.
+	GitHooksPath = &GitFeature{"core.hooksPath, to install the hooks globally", GitVersion{2, 9}}
+	// GitPath is 'git rev-parse --git-path', to locate the hooks directory.
+	GitPath = &GitFeature{"git rev-parse --git-path, to locate the hooks", GitVersion{2, 5}}
+)
+
+// GitFeatures are all the git features that pcg uses, oldest first.
+var GitFeatures = []*GitFeature{GitStashUntracked, GitWorktree, GitPath, GitHooksPath, GitStashPush, GitWorktreeRemove}
+
+// Supported returns true if the git in PATH supports f. It returns true when
+// the version can't be determined, e.g. for an unusual build of git, so pcg
+// doesn't get in the way.
+func (f *GitFeature) Supported() bool {
+	return f.supportedBy(GetGitVersion())
+}
+
+// Require returns an error like "git 2.5 required for git worktree" if the
+// git in PATH doesn't support f.
+func (f *GitFeature) Require() error {
+	return f.requireOf(GetGitVersion())
+}
+
+// Private stuff.
+
+// gitVersion caches GetGitVersion.
+var gitVersion struct {
+	once sync.Once
+	v    GitVersion
+}
+
+func (f *GitFeature) supportedBy(v GitVersion) bool {
+	return v == nil || v.AtLeast(f.Min)
+}
+
+func (f *GitFeature) requireOf(v GitVersion) error {
+	if f.supportedBy(v) {
+		return nil
+	}
+	return fmt.Errorf("git %s required for %s; found git %s", f.Min, f.Name, v)
+}
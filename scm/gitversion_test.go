@@ -0,0 +1,46 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	t.Parallel()
+	ut.AssertEqual(t, GitVersion{2, 39, 5}, ParseGitVersion("git version 2.39.5\n"))
+	ut.AssertEqual(t, GitVersion{2, 39, 5}, ParseGitVersion("git version 2.39.5.windows.1"))
+	ut.AssertEqual(t, GitVersion{2, 39, 5}, ParseGitVersion("git version 2.39.5 (Apple Git-143)"))
+	ut.AssertEqual(t, GitVersion(nil), ParseGitVersion("hg version 1"))
+	ut.AssertEqual(t, "2.39.5", GitVersion{2, 39, 5}.String())
+}
+
+func TestGitVersionAtLeast(t *testing.T) {
+	t.Parallel()
+	min := GitVersion{2, 13}
+	ut.AssertEqual(t, true, GitVersion{2, 13}.AtLeast(min))
+	ut.AssertEqual(t, true, GitVersion{2, 13, 1}.AtLeast(min))
+	ut.AssertEqual(t, true, GitVersion{3}.AtLeast(min))
+	ut.AssertEqual(t, false, GitVersion{2}.AtLeast(min))
+	ut.AssertEqual(t, false, GitVersion{2, 7, 4}.AtLeast(min))
+	ut.AssertEqual(t, false, GitVersion{1, 20}.AtLeast(min))
+}
+
+func TestGitFeature(t *testing.T) {
+	t.Parallel()
+	f := &GitFeature{"git foo", GitVersion{2, 5}}
+	ut.AssertEqual(t, true, f.supportedBy(nil))
+	ut.AssertEqual(t, true, f.supportedBy(GitVersion{2, 5, 1}))
+	ut.AssertEqual(t, false, f.supportedBy(GitVersion{2, 4}))
+	ut.AssertEqual(t, nil, f.requireOf(GitVersion{2, 5}))
+	ut.AssertEqual(t, errors.New("git 2.5 required for git foo; found git 2.4.1"), f.requireOf(GitVersion{2, 4, 1}))
+	for i := 1; i < len(GitFeatures); i++ {
+		ut.AssertEqual(t, true, GitFeatures[i].Min.AtLeast(GitFeatures[i-1].Min))
+	}
+	ut.AssertEqual(t, true, GetGitVersion() != nil)
+}
@@ -0,0 +1,118 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"bytes"
+	"log"
+)
+
+// maxDiffCells is the maximum size of the table used to compute the longest
+// common subsequence of lines. Past this limit, the lines between the common
+// prefix and suffix are reported as a single hunk.
+const maxDiffCells = 4 * 1024 * 1024
+
+// hunks returns the hunks of p, comparing with its content at c.old.
+func (c *change) hunks(p string, status FileStatus) []Hunk {
+	lines := splitLines(c.Content(p))
+	whole := []Hunk{{Start: 1, Count: len(lines)}}
+	if status == Added || c.old == "" {
+		return whole
+	}
+	oldPath := p
+	for _, r := range c.renames {
+		if r.New == p {
+			oldPath = r.Old
+			break
+		}
+	}
+	prev, err := c.repo.ContentAt(c.old, oldPath)
+	if err != nil {
+		log.Printf("failed to read %s at %s: %s", oldPath, c.old, err)
+		return whole
+	}
+	return diffLines(splitLines(prev), lines)
+}
+
+// splitLines returns the lines of content, without the line terminator.
+func splitLines(content []byte) [][]byte {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := bytes.Split(content, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the hunks of b that differ from a.
+func diffLines(a, b [][]byte) []Hunk {
+	// Trim the common prefix and suffix, which is most of the file in general.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && bytes.Equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && bytes.Equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if len(a) == 0 || len(b) == 0 || (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []Hunk{{Start: prefix + 1, Count: len(b)}}
+	}
+
+	// lcs[i*w+j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	w := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*w)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if bytes.Equal(a[i], b[j]) {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else if lcs[(i+1)*w+j] >= lcs[i*w+j+1] {
+				lcs[i*w+j] = lcs[(i+1)*w+j]
+			} else {
+				lcs[i*w+j] = lcs[i*w+j+1]
+			}
+		}
+	}
+	var out []Hunk
+	var cur *Hunk
+	flush := func() {
+		if cur != nil {
+			out = append(out, *cur)
+			cur = nil
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && bytes.Equal(a[i], b[j]):
+			flush()
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+			// a[i] was deleted.
+			if cur == nil {
+				cur = &Hunk{Start: prefix + j + 1}
+			}
+			i++
+		default:
+			// b[j] was added.
+			if cur == nil {
+				cur = &Hunk{Start: prefix + j + 1}
+			}
+			cur.Count++
+			j++
+		}
+	}
+	flush()
+	return out
+}
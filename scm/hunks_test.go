@@ -0,0 +1,62 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		a, b     string
+		expected []Hunk
+	}{
+		{"", "", nil},
+		{"a\nb\n", "a\nb\n", nil},
+		{"", "a\nb\n", []Hunk{{1, 2}}},
+		{"a\nb\n", "", []Hunk{{1, 0}}},
+		{"a\nb\nc\n", "a\nB\nc\n", []Hunk{{2, 1}}},
+		{"a\nb\nc\n", "a\nc\n", []Hunk{{2, 0}}},
+		{"a\nc\n", "a\nb\nc\nd\n", []Hunk{{2, 1}, {4, 1}}},
+		{"a\nb\nc\nd\ne\n", "x\na\nc\nd\ny\ne\n", []Hunk{{1, 1}, {3, 0}, {5, 1}}},
+	}
+	for i, line := range data {
+		ut.AssertEqualIndex(t, i, line.expected, diffLines(splitLines([]byte(line.a)), splitLines([]byte(line.b))))
+	}
+}
+
+func TestChangeHunks(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n\nfunc a() {\n}\n")
+	write(t, tmpDir, "b.go", "package a\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n\n// a is a.\nfunc a() {\n}\n")
+	write(t, tmpDir, "c.go", "package a\n\nvar c int\n")
+	run(t, tmpDir, nil, "add", ".")
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	c, err := r.Between(Current, Head, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []Hunk{{3, 1}}, c.Hunks("a.go"))
+	ut.AssertEqual(t, []Hunk(nil), c.Hunks("b.go"))
+	ut.AssertEqual(t, []Hunk{{1, 3}}, c.Hunks("c.go"))
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the name of the file at the root of the repository containing
+// additional ignore patterns in gitignore syntax.
+const IgnoreFile = ".pcgignore"
+
+// IgnorePatterns is a list of glob that when matching, means the file should
+// be ignored.
+//
+// The patterns use the gitignore syntax:
+//   - A pattern without a slash matches any path component, e.g. "*.pb.go" or
+//     "_*".
+//   - A pattern with a slash is anchored at the repository root, e.g.
+//     "/vendor" or "third_party/*.go". "**" matches any number of directories.
+//   - A trailing slash only matches directories, e.g. "testdata/".
+//   - A leading "!" re-includes a path ignored by a previous pattern. Like git,
+//     a file can't be re-included if one of its parent directories is ignored.
+type IgnorePatterns []string
+
+// Match returns true when the file should be ignored.
+func (i *IgnorePatterns) Match(p string) bool {
+	p = filepath.ToSlash(p)
+	chunks := strings.Split(p, "/")
+	// Look at the parent directories first.
+	for j := 1; j < len(chunks); j++ {
+		if ignored, _ := i.match(strings.Join(chunks[:j], "/"), true); ignored {
+			return true
+		}
+	}
+	ignored, _ := i.match(p, false)
+	return ignored
+}
+
+// Why returns the pattern deciding whether p is ignored and the path it
+// matched, p or one of its parent directories. pattern starts with "!" when it
+// re-includes p. pattern is empty when no pattern matches p.
+func (i *IgnorePatterns) Why(p string) (pattern, matched string, ignored bool) {
+	p = filepath.ToSlash(p)
+	chunks := strings.Split(p, "/")
+	for j := 1; j < len(chunks); j++ {
+		d := strings.Join(chunks[:j], "/")
+		if ignored, pattern := i.match(d, true); ignored {
+			return pattern, d, true
+		}
+	}
+	ignored, pattern = i.match(p, false)
+	if pattern == "" {
+		return "", "", false
+	}
+	return pattern, p, ignored
+}
+
+func (i *IgnorePatterns) String() string {
+	return fmt.Sprintf("%s", *i)
+}
+
+// Set implements flag.Value.
+func (i *IgnorePatterns) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// ReadIgnoreFile reads the patterns from a file in gitignore syntax.
+//
+// Returns no pattern and no error if the file doesn't exist.
+func ReadIgnoreFile(pathname string) (IgnorePatterns, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return parseIgnore(f)
+}
+
+// Private details.
+
+func parseIgnore(r io.Reader) (IgnorePatterns, error) {
+	var out IgnorePatterns
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), " \t\r")
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		out = append(out, l)
+	}
+	return out, s.Err()
+}
+
+// match returns true if the path p is ignored, evaluating the patterns in
+// order, and the pattern that decided it. The last matching pattern wins.
+func (i *IgnorePatterns) match(p string, isDir bool) (bool, string) {
+	ignored := false
+	decided := ""
+	for _, raw := range *i {
+		pattern := raw
+		negate := false
+		if strings.HasPrefix(pattern, "!") {
+			negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+			pattern = pattern[1:]
+		}
+		if ignored != negate {
+			// It can't change the result.
+			continue
+		}
+		if matchIgnorePattern(pattern, p, isDir) {
+			log.Printf("%s: ignored=%t due to %q", p, !negate, pattern)
+			ignored = !negate
+			decided = raw
+		}
+	}
+	return ignored, decided
+}
+
+// matchIgnorePattern returns true if a single gitignore pattern matches p.
+func matchIgnorePattern(pattern, p string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if !strings.Contains(pattern, "/") {
+		// Matches any path component.
+		return globMatch(pattern, path.Base(p))
+	}
+	return matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), strings.Split(p, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches zero or more segments.
+func matchSegments(pattern, p []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for j := 0; j <= len(p); j++ {
+				if matchSegments(pattern[1:], p[j:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(p) == 0 || !globMatch(pattern[0], p[0]) {
+			return false
+		}
+		pattern = pattern[1:]
+		p = p[1:]
+	}
+	return len(p) == 0
+}
+
+func globMatch(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	if err != nil {
+		log.Printf("bad pattern %q", pattern)
+	}
+	return matched
+}
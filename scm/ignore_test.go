@@ -0,0 +1,88 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maruel/ut"
+)
+
+func TestIgnorePatternsMatch(t *testing.T) {
+	t.Parallel()
+	i := IgnorePatterns{
+		".*",
+		"*.pb.go",
+		"/vendor",
+		"testdata/",
+		"docs/**/*.go",
+		"gen/*",
+		"!gen/keep.go",
+		"third_party/",
+		"!third_party/keep.go",
+	}
+	data := []struct {
+		p        string
+		expected bool
+	}{
+		{"foo.go", false},
+		{".git/config", true},
+		{"a/.hidden/b.go", true},
+		{"a/b.pb.go", true},
+		{"vendor/x/x.go", true},
+		{"a/vendor/x.go", false},
+		{"testdata", false},
+		{"a/testdata/x.go", true},
+		{"docs/x.go", true},
+		{"docs/a/b/x.go", true},
+		{"docs/a/b/x.md", false},
+		{"gen/a.go", true},
+		{"gen/keep.go", false},
+		// Can't re-include a file when the parent directory is ignored.
+		{"third_party/keep.go", true},
+	}
+	for j, line := range data {
+		ut.AssertEqualIndex(t, j, line.expected, i.Match(line.p))
+	}
+}
+
+func TestIgnorePatternsWhy(t *testing.T) {
+	t.Parallel()
+	i := IgnorePatterns{"*.pb.go", "gen/*", "!gen/keep.go", "third_party/"}
+	data := []struct {
+		p       string
+		pattern string
+		matched string
+		ignored bool
+	}{
+		{"foo.go", "", "", false},
+		{"a/b.pb.go", "*.pb.go", "a/b.pb.go", true},
+		{"gen/a.go", "gen/*", "gen/a.go", true},
+		{"gen/keep.go", "!gen/keep.go", "gen/keep.go", false},
+		{"third_party/a/b.go", "third_party/", "third_party", true},
+	}
+	for j, line := range data {
+		pattern, matched, ignored := i.Why(line.p)
+		ut.AssertEqualIndex(t, j, line.pattern, pattern)
+		ut.AssertEqualIndex(t, j, line.matched, matched)
+		ut.AssertEqualIndex(t, j, line.ignored, ignored)
+	}
+}
+
+func TestParseIgnore(t *testing.T) {
+	t.Parallel()
+	i, err := parseIgnore(strings.NewReader("# comment\n\n*.pb.go  \r\n\\#foo\n!bar\n"))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, IgnorePatterns{"*.pb.go", "\\#foo", "!bar"}, i)
+	ut.AssertEqual(t, true, i.Match("#foo"))
+}
+
+func TestReadIgnoreFileMissing(t *testing.T) {
+	t.Parallel()
+	i, err := ReadIgnoreFile("does/not/exist/.pcgignore")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, IgnorePatterns(nil), i)
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CacheImports enables or disables caching the imports of the Go files read
+// from the checkout across the changes, keyed by the modification time and
+// the size of each file. It makes computing the indirectly affected packages
+// of successive changes cheaper in a long-lived process, e.g. 'pcg watch',
+// since only the files modified in between are parsed again. Disabling it
+// drops the cache.
+func CacheImports(enabled bool) {
+	importsCache.Lock()
+	defer importsCache.Unlock()
+	if enabled {
+		if importsCache.entries == nil {
+			importsCache.entries = map[string]importsEntry{}
+		}
+	} else {
+		importsCache.entries = nil
+	}
+}
+
+// Private stuff.
+
+// importsEntry is the local imports of a file at a modification time and
+// size.
+type importsEntry struct {
+	modTime time.Time
+	size    int64
+	imports []string
+}
+
+// importsCache is the cache enabled by CacheImports, keyed by absolute path.
+var importsCache struct {
+	sync.Mutex
+	entries map[string]importsEntry
+}
+
+// imports returns the local imports of the file p, or false if it can't be
+// read.
+func (c *change) imports(p string) ([]string, bool) {
+	importsCache.Lock()
+	enabled := importsCache.entries != nil
+	importsCache.Unlock()
+	if !enabled || c.fromIndex[p] || c.skipped[p] {
+		return c.parseImports(p)
+	}
+	abs := filepath.Join(c.repo.Root(), filepath.FromSlash(p))
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return c.parseImports(p)
+	}
+	importsCache.Lock()
+	e, ok := importsCache.entries[abs]
+	importsCache.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.imports, true
+	}
+	imports, ok := c.parseImports(p)
+	if ok {
+		importsCache.Lock()
+		if importsCache.entries != nil {
+			importsCache.entries[abs] = importsEntry{fi.ModTime(), fi.Size(), imports}
+		}
+		importsCache.Unlock()
+	}
+	return imports, ok
+}
+
+// parseImports reads and parses the local imports of the file p.
+func (c *change) parseImports(p string) ([]string, bool) {
+	content := c.Content(p)
+	if content == nil {
+		return nil, false
+	}
+	_, imports := getImports(content)
+	return imports, true
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestCacheImports(t *testing.T) {
+	// Not parallel since it modifies the global cache.
+	defer CacheImports(false)
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	write(t, tmpDir, "foo/a.go", "package foo\n")
+	write(t, tmpDir, "bar/b.go", "package bar\n\nimport \"example.com/foo\"\n")
+	r, err := GetDir(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	all := []string{"bar/b.go", "foo/a.go"}
+	c := newChange(r, []string{"foo/a.go"}, all, nil)
+
+	CacheImports(true)
+	imports, ok := c.imports("bar/b.go")
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, []string{"example.com/foo"}, imports)
+	abs := filepath.Join(tmpDir, "bar", "b.go")
+	ut.AssertEqual(t, []string{"example.com/foo"}, importsCache.entries[abs].imports)
+
+	// A modified file is parsed again by the next change.
+	write(t, tmpDir, "bar/b.go", "package bar\n\nimport \"example.com/other\"\n")
+	c = newChange(r, []string{"foo/a.go"}, all, nil)
+	imports, ok = c.imports("bar/b.go")
+	ut.AssertEqual(t, true, ok)
+	ut.AssertEqual(t, []string{"example.com/other"}, imports)
+
+	_, ok = c.imports("missing.go")
+	ut.AssertEqual(t, false, ok)
+
+	CacheImports(false)
+	ut.AssertEqual(t, map[string]importsEntry(nil), importsCache.entries)
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/maruel/pre-commit-go/internal"
+)
+
+// SplitPatches splits a patch series in mbox format, e.g. as generated by
+// "git format-patch --stdout" or saved from a mailing list, into one file per
+// patch in dir. Returns the files in the order of the series.
+func SplitPatches(mbox, dir string) ([]string, error) {
+	mbox, err := filepath.Abs(mbox)
+	if err != nil {
+		return nil, err
+	}
+	out, code, err := internal.Capture(context.Background(), dir, nil, "git", "mailsplit", "-o"+dir, mbox)
+	if code != 0 || err != nil {
+		return nil, fmt.Errorf("failed to split %s: %s", mbox, strings.TrimSpace(out))
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// mailsplit names the files 0001, 0002, etc.
+	var files []string
+	for _, e := range entries {
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s contains no patch", mbox)
+	}
+	return files, nil
+}
+
+// NewWorktree returns a Repo for a new temporary worktree of r with c checked
+// out, so the checkout of r is not touched. The HEAD of the worktree is
+// detached.
+//
+// cleanup must be called to delete the worktree.
+func NewWorktree(r ReadOnlyRepo, c Commit) (Repo, func() error, error) {
+	gc := toGitCommit(c)
+	if gc == gitInvalid || gc == gitCurrent || gc == gitIndex || gc == gitInitial {
+		return nil, nil, fmt.Errorf("can't create a worktree at %s", c)
+	}
+	if err := GitWorktree.Require(); err != nil {
+		return nil, nil, err
+	}
+	tmpDir, err := ioutil.TempDir("", "pcg-worktree")
+	if err != nil {
+		return nil, nil, err
+	}
+	wt := filepath.Join(tmpDir, filepath.Base(r.Root()))
+	cleanup := func() error {
+		args := []string{"git", "worktree", "remove", "--force", wt}
+		if !GitWorktreeRemove.Supported() {
+			// Delete the worktree first so prune forgets about it.
+			if err := internal.RemoveAll(wt); err != nil {
+				return err
+			}
+			args = []string{"git", "worktree", "prune"}
+		}
+		out, code, err := internal.Capture(context.Background(), r.Root(), nil, args...)
+		if err == nil && code != 0 {
+			err = errors.New(strings.TrimSpace(out))
+		}
+		if err2 := internal.RemoveAll(tmpDir); err == nil {
+			err = err2
+		}
+		return err
+	}
+	out, code, err := internal.Capture(context.Background(), r.Root(), nil, "git", "worktree", "add", "--detach", wt, string(gc))
+	if code != 0 || err != nil {
+		_ = internal.RemoveAll(tmpDir)
+		return nil, nil, fmt.Errorf("failed to create a worktree at %s: %s", c, strings.TrimSpace(out))
+	}
+	n, err := getRepo(wt, r.GOPATH())
+	if err != nil {
+		_ = cleanup()
+		return nil, nil, err
+	}
+	log.Printf("using worktree %s at %s", wt, c)
+	return n, cleanup, nil
+}
+
+// Private details.
+
+// patchEnv is the committer of the commits created by Apply, so it works
+// without a git identity configured, e.g. on a bot.
+var patchEnv = []string{"GIT_COMMITTER_NAME=pcg", "GIT_COMMITTER_EMAIL=pcg@localhost"}
+
+// mergeEnv is the author and committer of the commits created by Merge.
+var mergeEnv = append([]string{"GIT_AUTHOR_NAME=pcg", "GIT_AUTHOR_EMAIL=pcg@localhost"}, patchEnv...)
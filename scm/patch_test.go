@@ -0,0 +1,118 @@
+// Copyright 2016 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package scm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maruel/pre-commit-go/internal"
+	"github.com/maruel/ut"
+)
+
+func TestPatches(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	src := filepath.Join(tmpDir, "src")
+	split := filepath.Join(tmpDir, "split")
+	ut.AssertEqual(t, nil, os.Mkdir(src, 0700))
+	ut.AssertEqual(t, nil, os.Mkdir(split, 0700))
+	setup(t, src)
+	write(t, src, "a.go", "package a\n")
+	run(t, src, nil, "add", ".")
+	deterministicCommit(t, src)
+	base := run(t, src, nil, "rev-parse", "HEAD")
+	write(t, src, "b.go", "package a\n")
+	run(t, src, nil, "add", ".")
+	run(t, src, nil, "commit", "-q", "-m", "add b")
+	write(t, src, "a.go", "package a\n\n// A.\n")
+	run(t, src, nil, "commit", "-q", "-a", "-m", "doc a")
+	mbox := filepath.Join(tmpDir, "series.mbox")
+	write(t, tmpDir, "series.mbox", run(t, src, nil, "format-patch", "--stdout", base)+"\n")
+	run(t, src, nil, "reset", "-q", "--hard", base)
+
+	patches, err := SplitPatches(mbox, split)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{filepath.Join(split, "0001"), filepath.Join(split, "0002")}, patches)
+
+	r, err := getRepo(src, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	_, _, err = NewWorktree(r, Current)
+	ut.AssertEqual(t, true, err != nil)
+	wt, cleanup, err := NewWorktree(r, Commit(base))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, Commit(base), wt.Eval(string(Head)))
+	ut.AssertEqual(t, nil, wt.Apply(patches[0]))
+	c, err := wt.Between(wt.Eval(string(Head)), Commit(base), nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"b.go"}, c.Changed().Files())
+	// The second patch doesn't apply twice.
+	ut.AssertEqual(t, nil, wt.Apply(patches[1]))
+	ut.AssertEqual(t, true, wt.Apply(patches[1]) != nil)
+	ut.AssertEqual(t, "package a\n\n// A.\n", read(t, wt.Root(), "a.go"))
+	// The original checkout is untouched.
+	ut.AssertEqual(t, Commit(base), r.Eval(string(Head)))
+	ut.AssertEqual(t, "package a\n", read(t, src, "a.go"))
+	ut.AssertEqual(t, nil, cleanup())
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	base := run(t, tmpDir, nil, "rev-parse", "HEAD")
+	write(t, tmpDir, "b.go", "package a\n")
+	run(t, tmpDir, nil, "add", ".")
+	run(t, tmpDir, nil, "commit", "-q", "-m", "add b")
+	theirs := run(t, tmpDir, nil, "rev-parse", "HEAD")
+	run(t, tmpDir, nil, "checkout", "-q", "-b", "conflict", base)
+	write(t, tmpDir, "b.go", "package b\n")
+	run(t, tmpDir, nil, "add", ".")
+	run(t, tmpDir, nil, "commit", "-q", "-m", "add another b")
+	conflict := run(t, tmpDir, nil, "rev-parse", "HEAD")
+
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	wt, cleanup, err := NewWorktree(r, Commit(base))
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, wt.Merge(Current) != nil)
+	ut.AssertEqual(t, nil, wt.Merge(Commit(theirs)))
+	// A merge commit is always created.
+	merged := wt.Eval(string(Head))
+	ut.AssertEqual(t, Commit(theirs), wt.Eval(string(merged)+"^2"))
+	c, err := wt.Between(merged, Commit(base), nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"b.go"}, c.Changed().Files())
+	err = wt.Merge(Commit(conflict))
+	ut.AssertEqual(t, true, err != nil)
+	ut.AssertEqual(t, "failed to merge "+conflict+"; conflicts in:\nb.go", err.Error())
+	// The merge was aborted.
+	ut.AssertEqual(t, merged, wt.Eval(string(Head)))
+	ut.AssertEqual(t, "package a\n", read(t, wt.Root(), "b.go"))
+	ut.AssertEqual(t, nil, cleanup())
+}
@@ -6,13 +6,18 @@
 package scm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -30,6 +35,10 @@ const (
 	Head Commit = "<head>"
 	// Current is a meta-reference to the current tree as on the file system.
 	Current Commit = "<current>"
+	// Index is a meta-reference to the staging area, e.g. the content that
+	// will be committed. It is only supported by ContentAt and as the recent
+	// commit of Between.
+	Index Commit = "<index>"
 	// Upstream is the commit on the remote repository against with the current
 	// branch is based on.
 	Upstream Commit = "<upstream>"
@@ -57,6 +66,10 @@ type ReadOnlyRepo interface {
 	// Eval returns the commit hash by evaluating refish. Returns Invalid in case
 	// of failure.
 	Eval(refish string) Commit
+	// Before returns the newest commit reachable from Head that is older than
+	// when, e.g. "2.weeks" or "2016-01-02". Returns Initial if there is no such
+	// commit and Invalid in case of failure.
+	Before(when string) Commit
 	// Between returns a change with files touched between from and to in it.
 	// If recent is Current, it diffs against the current tree, independent of
 	// what is versioned.
@@ -67,7 +80,8 @@ type ReadOnlyRepo interface {
 	//
 	// Files with untracked change will be included if recent == Current. To
 	// exclude untracked changes to tracked files, use Stash() first or specify
-	// Head for recent.
+	// Head for recent. Index lists the same files as Current but the content of
+	// the files with unstaged modifications is read from the staging area.
 	//
 	// To get the list of all files in the tree and the index, use
 	// Between(Current, Initial, ...).
@@ -76,6 +90,16 @@ type ReadOnlyRepo interface {
 	Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change, error)
 	// GOPATH returns the GOPATH. Mostly used in tests.
 	GOPATH() string
+	// IsMerging returns true if a merge is in progress, e.g. the commit being
+	// created is a merge commit.
+	IsMerging() bool
+	// ContentAt returns the content of the file p, relative to the root, as
+	// committed in c. Current reads the file on disk and Index reads the file
+	// in the staging area.
+	ContentAt(c Commit, p string) ([]byte, error)
+	// Message returns the commit message of c. Current and Initial have no
+	// message.
+	Message(c Commit) (string, error)
 }
 
 // Repo represents a source control managed checkout.
@@ -84,12 +108,29 @@ type ReadOnlyRepo interface {
 // interface.
 type Repo interface {
 	ReadOnlyRepo
-	// Stash stashes the content that is not in the index.
+	// Stash stashes the content that is not in the index, including the
+	// untracked files. Returns false if there was nothing to stash.
 	Stash() (bool, error)
+	// Dirty returns the files that Stash would stash, sorted: the unstaged
+	// modifications and the untracked files. The files matching
+	// ignorePatterns are skipped.
+	Dirty(ignorePatterns IgnorePatterns) ([]string, error)
 	// Stash restores the stash generated from Stash.
 	Restore() error
 	// Checkout checks out a commit or a branch.
 	Checkout(refish string) error
+	// Apply commits the patch in mail format, e.g. generated by git
+	// format-patch, on top of the checkout. The checkout is left untouched on
+	// failure.
+	Apply(patch string) error
+	// Merge merges c into the checkout with a merge commit, even when a fast
+	// forward is possible. The checkout is left untouched on failure, e.g. on
+	// conflict.
+	Merge(c Commit) error
+	// SetRenameThreshold sets the minimum similarity in percent for a deleted
+	// file and an added file to be reported as a rename by Between. 0 uses the
+	// default of 50 and a negative value disables rename detection.
+	SetRenameThreshold(percent int)
 }
 
 // GetRepo returns a valid Repo if one is found.
@@ -97,34 +138,92 @@ func GetRepo(wd, gopath string) (Repo, error) {
 	return getRepo(wd, gopath)
 }
 
-// IgnorePatterns is a list of glob that when matching, means the file should
-// be ignored.
-type IgnorePatterns []string
-
-// Match returns true when the file should be ignored.
-func (i *IgnorePatterns) Match(p string) bool {
-	chunks := strings.Split(p, pathSeparator)
-	for _, ignorePattern := range *i {
-		for _, chunk := range chunks {
-			if matched, err := filepath.Match(ignorePattern, chunk); matched {
-				log.Printf("%s: ignored due to %q", p, ignorePattern)
-				return true
-			} else if err != nil {
-				log.Printf("bad pattern %q", ignorePattern)
-			}
+// FromFiles returns a change containing the files specified instead of
+// diffing commits.
+//
+// files must be relative to the repository root. Files matching
+// ignorePatterns are skipped. Returns nil and no error if there's no file left.
+func FromFiles(r ReadOnlyRepo, files []string, ignorePatterns IgnorePatterns) (Change, error) {
+	all, err := r.Between(Current, Initial, ignorePatterns)
+	if err != nil {
+		return nil, err
+	}
+	set := map[string]bool{}
+	var allFiles []string
+	if all != nil {
+		for _, f := range all.All().Files() {
+			set[f] = true
+		}
+		allFiles = append(allFiles, all.All().Files()...)
+	}
+	selected := map[string]bool{}
+	var changed []string
+	for _, f := range files {
+		f = path.Clean(filepath.ToSlash(f))
+		if filepath.IsAbs(f) || path.IsAbs(f) || f == ".." || strings.HasPrefix(f, "../") {
+			return nil, fmt.Errorf("%s is outside the repository", f)
+		}
+		if selected[f] || ignorePatterns.Match(f) {
+			continue
+		}
+		selected[f] = true
+		changed = append(changed, f)
+		if !set[f] {
+			// Untracked file.
+			set[f] = true
+			allFiles = append(allFiles, f)
 		}
 	}
-	return false
-}
-
-func (i *IgnorePatterns) String() string {
-	return fmt.Sprintf("%s", *i)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(changed)
+	sort.Strings(allFiles)
+	return newChange(r, changed, allFiles, ignorePatterns), nil
 }
 
-// Set implements flag.Value.
-func (i *IgnorePatterns) Set(value string) error {
-	*i = append(*i, value)
-	return nil
+// ImportAs returns a Repo for the checkout r that resolves as importPath in
+// GOPATH, e.g. when a contributor's fork is not checked out at the canonical
+// location.
+//
+// If r is a go module or is already located at importPath in its GOPATH, r is
+// returned as is. Otherwise a temporary GOPATH is created with a symlink
+// <tmp>/src/<importPath> to r.Root(). The returned Repo is rooted at the
+// symlink and its GOPATH is the temporary directory followed by r.GOPATH().
+// cleanup must be called to delete the temporary GOPATH.
+func ImportAs(r Repo, importPath string) (Repo, func() error, error) {
+	noop := func() error { return nil }
+	if importPath == "" || internal.IsModuleMode(r.Root()) {
+		return r, noop, nil
+	}
+	if rel, err := relToGOPATH(r.Root(), r.GOPATH()); err == nil && filepath.ToSlash(rel) == importPath {
+		return r, noop, nil
+	}
+	tmpDir, err := ioutil.TempDir("", "pcg-gopath")
+	if err != nil {
+		return nil, nil, err
+	}
+	link := filepath.Join(tmpDir, "src", filepath.FromSlash(importPath))
+	cleanup := func() error {
+		// Remove the symlink first to be sure to never recurse into the checkout.
+		_ = os.Remove(link)
+		return internal.RemoveAll(tmpDir)
+	}
+	if err = os.MkdirAll(filepath.Dir(link), 0700); err == nil {
+		err = os.Symlink(r.Root(), link)
+	}
+	if err != nil {
+		_ = cleanup()
+		return nil, nil, fmt.Errorf("failed to create temporary GOPATH: %s", err)
+	}
+	gopath := tmpDir + string(filepath.ListSeparator) + r.GOPATH()
+	n, err := getRepo(link, gopath)
+	if err != nil {
+		_ = cleanup()
+		return nil, nil, err
+	}
+	log.Printf("using temporary GOPATH %s for %s", tmpDir, importPath)
+	return n, cleanup, nil
 }
 
 // Private details.
@@ -145,7 +244,9 @@ func getRepo(wd, gopath string) (repo, error) {
 	root, err := captureAbs(wd, "git", "rev-parse", "--show-cdup")
 	if err == nil {
 		if gopath == "" {
-			gopath = os.Getenv("GOPATH")
+			// build.Default.GOPATH takes care of the default value when GOPATH is
+			// not set.
+			gopath = build.Default.GOPATH
 		}
 		return &git{root: root, gopath: gopath}, nil
 	}
@@ -159,6 +260,7 @@ const (
 	gitInitial  gitCommit = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
 	gitHead     gitCommit = "HEAD"
 	gitCurrent  gitCommit = "<current>"
+	gitIndex    gitCommit = "<index>"
 	gitUpstream gitCommit = "@{upstream}"
 	gitInvalid  gitCommit = "<invalid>"
 )
@@ -171,6 +273,8 @@ func toGitCommit(c Commit) gitCommit {
 		return gitHead
 	case Current:
 		return gitCurrent
+	case Index:
+		return gitIndex
 	case Upstream:
 		return gitUpstream
 	case Invalid, "":
@@ -184,8 +288,14 @@ type git struct {
 	root   string
 	gopath string
 
-	lock   sync.Mutex
-	gitDir string
+	lock            sync.Mutex
+	gitDir          string
+	renameThreshold int
+	// autocrlf is "true" or "input" when git converts CRLF to LF on commit, ""
+	// when not known yet.
+	autocrlf string
+	// cat reads the content at a commit or in the index.
+	cat catFile
 }
 
 // ReadOnlyRepo interface.
@@ -258,9 +368,29 @@ func (g *git) Eval(refish string) Commit {
 	return Invalid
 }
 
+func (g *git) Before(when string) Commit {
+	if when == "" {
+		return Invalid
+	}
+	out, code, _ := g.capture("rev-list", "-1", "--before="+when, string(gitHead))
+	if code != 0 {
+		log.Println(out)
+		return Invalid
+	}
+	if out == "" {
+		return Commit(gitInitial)
+	}
+	return Commit(out)
+}
+
 func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change, error) {
 	log.Printf("Between(%q, %q, %s)", recent, old, ignorePatterns)
 	grecent := toGitCommit(recent)
+	// The staging area is listed like the checkout but read from the index.
+	staging := grecent == gitIndex
+	if staging {
+		grecent = gitCurrent
+	}
 	if grecent == gitInvalid {
 		return nil, errors.New("invalid recent commit")
 	}
@@ -278,12 +408,26 @@ func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change
 		return nil, errors.New("invalid old commit")
 	}
 
-	// Gather list of all files concurrently.
+	// Gather the status and the list of all files concurrently.
+	statusCh := make(chan *diffStatus, 1)
+	go func() {
+		statusCh <- g.status(ignorePatterns, gold, grecent)
+	}()
+	gitlinksCh := make(chan map[string]Commit, 1)
+	go func() {
+		gitlinksCh <- g.gitlinks(grecent)
+	}()
+	skippedCh := make(chan map[string]bool, 1)
+	go func() {
+		skippedCh <- g.skipWorktree()
+	}()
 	allFilesCh := make(chan []string)
 	var allFiles []string
 
 	// Gather list of changed files.
 	var files []string
+	// Files whose content on disk differs from the index.
+	var unstaged []string
 	filesEqualsAllFiles := false
 	if grecent == gitCurrent {
 		// Current is special cased, as it has to look at the checked out files.
@@ -295,6 +439,9 @@ func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change
 			allFiles = <-allFilesCh
 			files = allFiles
 			filesEqualsAllFiles = true
+			if staging {
+				unstaged = g.unstaged()
+			}
 		} else {
 			// Gather list of unstaged file plus diff.
 			unstagedCh := make(chan []string)
@@ -312,7 +459,8 @@ func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change
 			for _, f := range g.captureList(ignorePatterns, "diff-tree", "--no-commit-id", "--name-only", "-z", "-r", "--diff-filter=ACMRT", "--no-renames", "--no-ext-diff", string(gold), string(gitHead)) {
 				filesSet[f] = struct{}{}
 			}
-			for _, f := range <-unstagedCh {
+			unstaged = <-unstagedCh
+			for _, f := range unstaged {
 				filesSet[f] = struct{}{}
 			}
 			for _, f := range <-stagedCh {
@@ -332,7 +480,56 @@ func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change
 		files = g.captureList(ignorePatterns, "diff-tree", "--no-commit-id", "--name-only", "-z", "-r", "--diff-filter=ACMRT", "--no-renames", "--no-ext-diff", string(gold), string(grecent))
 		allFiles = <-allFilesCh
 	}
-	if len(files) == 0 {
+
+	// Submodules are not files; they are reported separately.
+	var submodules []Submodule
+	if links := <-gitlinksCh; len(links) != 0 {
+		for _, f := range files {
+			c, ok := links[f]
+			if !ok {
+				continue
+			}
+			old := g.gitlinkAt(gold, f)
+			if grecent == gitCurrent {
+				if gold != gitHead {
+					c = Current
+				} else if c == old {
+					// Checking the staging area and the submodule checkout change is
+					// not staged.
+					continue
+				}
+			}
+			submodules = append(submodules, Submodule{Path: f, Old: old, New: c})
+		}
+		isLink := func(p string) bool {
+			_, ok := links[p]
+			return ok
+		}
+		files = removePaths(files, isLink)
+		if filesEqualsAllFiles {
+			allFiles = files
+		} else {
+			allFiles = removePaths(allFiles, isLink)
+		}
+	}
+	// In a sparse checkout, only the files on disk can be checked.
+	skipped := <-skippedCh
+	if len(skipped) != 0 {
+		isSkipped := func(p string) bool {
+			return skipped[p]
+		}
+		n := len(files)
+		files = removePaths(files, isSkipped)
+		if filesEqualsAllFiles {
+			allFiles = files
+		} else {
+			allFiles = removePaths(allFiles, isSkipped)
+		}
+		if n != len(files) {
+			log.Printf("sparse checkout: ignoring %d modified files not checked out", n-len(files))
+		}
+	}
+	if len(files) == 0 && len(submodules) == 0 {
 		return nil, nil
 	}
 
@@ -348,43 +545,101 @@ func (g *git) Between(recent, old Commit, ignorePatterns IgnorePatterns) (Change
 	sort.Strings(allFiles)
 	wg.Wait()
 
-	return newChange(g, files, allFiles, ignorePatterns), nil
+	c := newChange(g, files, allFiles, ignorePatterns)
+	sort.Sort(submodulesByPath(submodules))
+	c.submodules = submodules
+	c.skipped = skipped
+	if gold != gitInitial {
+		c.old = old
+	}
+	if st := <-statusCh; st != nil {
+		c.added = st.added
+		c.deleted = st.deleted
+		c.renames = st.renames
+	} else {
+		c.setAllAdded()
+	}
+	if staging {
+		// Read the content that will be committed, not the unstaged
+		// modifications, in case they were not stashed.
+		c.fromIndex = make(map[string]bool, len(unstaged))
+		for _, f := range unstaged {
+			c.fromIndex[f] = true
+		}
+	}
+	return c, nil
 }
 
 func (g *git) GOPATH() string {
 	return g.gopath
 }
 
+func (g *git) IsMerging() bool {
+	d, err := g.ScmDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(d, "MERGE_HEAD"))
+	return err == nil
+}
+
+func (g *git) ContentAt(c Commit, p string) ([]byte, error) {
+	gc := toGitCommit(c)
+	switch gc {
+	case gitInvalid:
+		return nil, errors.New("invalid commit")
+	case gitCurrent:
+		return ioutil.ReadFile(filepath.Join(g.root, p))
+	}
+	rev := string(gc)
+	if gc == gitIndex {
+		// ":path" is the content in the index.
+		rev = ""
+	}
+	rev += ":" + filepath.ToSlash(p)
+	content, found, err := g.cat.read(g.root, rev)
+	if err == nil {
+		if !found {
+			return nil, fmt.Errorf("failed to read %s at %s: not found", p, c)
+		}
+		return content, nil
+	}
+	// Fall back to a one-off process.
+	// Do not use g.capture() since the content must not be trimmed.
+	out, code, err := internal.Capture(context.Background(), g.root, nil, "git", "show", rev)
+	if code != 0 || err != nil {
+		return nil, fmt.Errorf("failed to read %s at %s: %s", p, c, strings.TrimSpace(out))
+	}
+	return []byte(out), nil
+}
+
+func (g *git) Message(c Commit) (string, error) {
+	gc := toGitCommit(c)
+	switch gc {
+	case gitInvalid, gitCurrent, gitInitial:
+		return "", fmt.Errorf("%s has no commit message", c)
+	}
+	out, code, err := g.capture("log", "-1", "--format=%B", string(gc))
+	if code != 0 || err != nil {
+		return "", fmt.Errorf("failed to read the commit message of %s: %s", c, out)
+	}
+	return out, nil
+}
+
 // Repo interface.
 
 func (g *git) Stash() (bool, error) {
-	// Ensure everything is either tracked or ignored. This is because git stash
-	// doesn't stash untracked files.
+	// Untracked files are stashed too, so the checks only see the staged
+	// content. Ignored files are left alone.
 	// The 2 checks are run in parallel with the first stashing command.
-	errUntrackedCh := make(chan error)
+	untrackedCh := make(chan []string)
 	go func() {
-		if untracked := g.untracked(); untracked == nil {
-			errUntrackedCh <- errors.New("failed to get list of untracked files")
-		} else if len(untracked) != 0 {
-			errUntrackedCh <- fmt.Errorf("can't stash if there are untracked files: %q", untracked)
-		} else {
-			errUntrackedCh <- nil
-		}
+		untrackedCh <- g.untracked()
 	}()
-
-	errUnstagedCh := make(chan error)
-	ignore := errors.New("ignore")
+	unstagedCh := make(chan []string)
 	go func() {
-		if unstaged := g.unstaged(); unstaged == nil {
-			errUnstagedCh <- errors.New("failed to get list of unstaged files")
-		} else if len(unstaged) == 0 {
-			// No need to stash, there's no unstaged files.
-			errUnstagedCh <- ignore
-		} else {
-			errUnstagedCh <- nil
-		}
+		unstagedCh <- g.unstaged()
 	}()
-
 	oldStashCh := make(chan string)
 	go func() {
 		o, _, _ := g.capture("rev-parse", "-q", "--verify", "refs/stash")
@@ -392,18 +647,34 @@ func (g *git) Stash() (bool, error) {
 	}()
 
 	// Error handling of concurrent processes.
-	if err := <-errUntrackedCh; err != nil {
-		return false, err
-	}
-	if err := <-errUnstagedCh; err == ignore {
-		// No need to stash, there's no unstaged files.
+	untracked := <-untrackedCh
+	unstaged := <-unstagedCh
+	oldStash := <-oldStashCh
+	if untracked == nil {
+		return false, errors.New("failed to get list of untracked files")
+	}
+	if unstaged == nil {
+		return false, errors.New("failed to get list of unstaged files")
+	}
+	// git stash doesn't save the checkout of submodules.
+	links := g.gitlinks(gitCurrent)
+	unstaged = removePaths(unstaged, func(p string) bool {
+		_, ok := links[p]
+		return ok
+	})
+	if len(untracked) == 0 && len(unstaged) == 0 {
+		// No need to stash, the tree matches the index.
 		return false, nil
-	} else if err != nil {
-		return false, err
 	}
-	oldStash := <-oldStashCh
 
-	if out, e, err := g.capture("stash", "save", "-q", "--keep-index"); e != 0 || err != nil {
+	if err := GitStashUntracked.Require(); err != nil {
+		return false, err
+	}
+	verb := "push"
+	if !GitStashPush.Supported() {
+		verb = "save"
+	}
+	if out, e, err := g.capture("stash", verb, "-q", "--keep-index", "--include-untracked"); e != 0 || err != nil {
 		if gitCommit(g.Eval(string(gitHead))) == gitInitial {
 			return false, errors.New("Can't stash until there's at least one commit")
 		}
@@ -416,6 +687,28 @@ func (g *git) Stash() (bool, error) {
 	return oldStash != newStash, err
 }
 
+func (g *git) Dirty(ignorePatterns IgnorePatterns) ([]string, error) {
+	untrackedCh := make(chan []string)
+	go func() {
+		untrackedCh <- g.captureList(ignorePatterns, "ls-files", "--others", "--exclude-standard", "-z")
+	}()
+	unstaged := g.captureList(ignorePatterns, "diff", "--name-only", "--no-color", "--no-ext-diff", "-z")
+	untracked := <-untrackedCh
+	if untracked == nil {
+		return nil, errors.New("failed to get list of untracked files")
+	}
+	if unstaged == nil {
+		return nil, errors.New("failed to get list of unstaged files")
+	}
+	links := g.gitlinks(gitCurrent)
+	out := removePaths(append(unstaged, untracked...), func(p string) bool {
+		_, ok := links[p]
+		return ok
+	})
+	sort.Strings(out)
+	return out, nil
+}
+
 func (g *git) Restore() error {
 	if out, e, err := g.capture("reset", "--hard", "-q"); e != 0 || err != nil {
 		return fmt.Errorf("git reset failed:\n%s", out)
@@ -440,10 +733,101 @@ func (g *git) Checkout(refish string) error {
 	return nil
 }
 
+func (g *git) Apply(patch string) error {
+	if out, code, err := g.captureEnv(patchEnv, "am", "--quiet", patch); code != 0 || err != nil {
+		_, _, _ = g.capture("am", "--abort")
+		return fmt.Errorf("failed to apply %s:\n%s", filepath.Base(patch), out)
+	}
+	return nil
+}
+
+func (g *git) Merge(c Commit) error {
+	gc := toGitCommit(c)
+	if gc == gitInvalid || gc == gitCurrent || gc == gitIndex || gc == gitInitial {
+		return fmt.Errorf("can't merge %s", c)
+	}
+	out, code, err := g.captureEnv(mergeEnv, "merge", "--no-ff", "--no-edit", "--quiet", string(gc))
+	if code == 0 && err == nil {
+		return nil
+	}
+	conflicts := g.captureList(nil, "diff", "--name-only", "--diff-filter=U", "-z")
+	_, _, _ = g.capture("merge", "--abort")
+	if len(conflicts) != 0 {
+		return fmt.Errorf("failed to merge %s; conflicts in:\n%s", c, strings.Join(conflicts, "\n"))
+	}
+	return fmt.Errorf("failed to merge %s:\n%s", c, out)
+}
+
 func (g *git) untracked() []string {
 	return g.captureList(nil, "ls-files", "--others", "--exclude-standard", "-z")
 }
 
+func (g *git) SetRenameThreshold(percent int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.renameThreshold = percent
+}
+
+// diffStatus is the status of the files between two commits.
+type diffStatus struct {
+	added   map[string]bool
+	deleted []string
+	renames []Rename
+}
+
+// status returns the files added, deleted and renamed between old and recent.
+// When recent is Current, the index is compared since untracked files are
+// excluded.
+//
+// Returns nil when old is Initial, as all the files are added.
+func (g *git) status(ignorePatterns IgnorePatterns, old, recent gitCommit) *diffStatus {
+	if old == gitInitial {
+		return nil
+	}
+	g.lock.Lock()
+	threshold := g.renameThreshold
+	g.lock.Unlock()
+	args := []string{"diff-tree", "--no-commit-id", "-r", string(old), string(recent)}
+	if recent == gitCurrent {
+		args = []string{"diff", "--cached", string(old)}
+	}
+	args = append(args, "--name-status", "-z", "--no-ext-diff")
+	if threshold < 0 {
+		args = append(args, "--no-renames")
+	} else if threshold == 0 {
+		args = append(args, "-M50%")
+	} else if threshold > 100 {
+		args = append(args, "-M100%")
+	} else {
+		args = append(args, fmt.Sprintf("-M%d%%", threshold))
+	}
+	// The output is a list of status and path, e.g. "A", "foo.go", except for
+	// renames which are "R<similarity>", old path and new path.
+	list := g.captureList(nil, args...)
+	out := &diffStatus{added: map[string]bool{}}
+	for i := 0; i+1 < len(list); i += 2 {
+		status, p := list[i], list[i+1]
+		switch {
+		case strings.HasPrefix(status, "R") && i+2 < len(list):
+			similarity, err := strconv.Atoi(status[1:])
+			if err != nil {
+				log.Printf("unexpected rename status %q", status)
+			} else if n := list[i+2]; !ignorePatterns.Match(n) {
+				out.renames = append(out.renames, Rename{Old: p, New: n, Similarity: similarity})
+			}
+			i++
+		case ignorePatterns.Match(p):
+		case status == "A":
+			out.added[p] = true
+		case status == "D":
+			out.deleted = append(out.deleted, p)
+		}
+	}
+	sort.Strings(out.deleted)
+	sort.Sort(renamesByNew(out.renames))
+	return out
+}
+
 func (g *git) unstaged() []string {
 	return g.captureList(nil, "diff", "--name-only", "--no-color", "--no-ext-diff", "-z")
 }
@@ -452,12 +836,101 @@ func (g *git) staged() []string {
 	return g.captureList(nil, "diff", "--name-only", "--no-color", "--no-ext-diff", "--cached", "--diff-filter=ACMRT", "-z")
 }
 
+// gitlinks returns the submodules at recent, with the commit they point to.
+// For Current, it is the commit in the index.
+//
+// It returns nil without running git when there's no .gitmodules file, which
+// is the common case.
+func (g *git) gitlinks(recent gitCommit) map[string]Commit {
+	if _, err := os.Stat(filepath.Join(g.root, ".gitmodules")); err != nil {
+		return nil
+	}
+	var list []string
+	if recent == gitCurrent {
+		// "160000 <sha1> 0\t<path>"
+		list = g.captureList(nil, "ls-files", "--stage", "-z")
+	} else {
+		// "160000 commit <sha1>\t<path>"
+		list = g.captureList(nil, "ls-tree", "-r", "-z", string(recent))
+	}
+	out := map[string]Commit{}
+	for _, l := range list {
+		i := strings.IndexByte(l, '\t')
+		if i == -1 || !strings.HasPrefix(l, "160000 ") {
+			continue
+		}
+		fields := strings.Fields(l[:i])
+		if recent == gitCurrent {
+			out[l[i+1:]] = Commit(fields[1])
+		} else {
+			out[l[i+1:]] = Commit(fields[2])
+		}
+	}
+	return out
+}
+
+// gitlinkAt returns the commit of the submodule at path p in commit c, or
+// Initial if it didn't exist.
+func (g *git) gitlinkAt(c gitCommit, p string) Commit {
+	if c == gitInitial {
+		return Initial
+	}
+	out, code, err := g.capture("ls-tree", "-z", string(c), "--", p)
+	if code != 0 || err != nil || !strings.HasPrefix(out, "160000 ") {
+		return Initial
+	}
+	return Commit(strings.Fields(out)[2])
+}
+
+// skipWorktree returns the files in the index that are not checked out in a
+// sparse checkout. It returns nil without listing the files when the checkout
+// is not sparse, which is the common case.
+func (g *git) skipWorktree() map[string]bool {
+	if out, _, _ := g.capture("config", "--bool", "core.sparseCheckout"); out != "true" {
+		return nil
+	}
+	out := map[string]bool{}
+	// "S <path>" is a skip-worktree entry.
+	for _, l := range g.captureList(nil, "ls-files", "-t", "-z") {
+		if strings.HasPrefix(l, "S ") {
+			out[l[2:]] = true
+		}
+	}
+	return out
+}
+
+// normalizesEOL returns true when git converts CRLF line endings to LF when
+// committing, per core.autocrlf.
+func (g *git) normalizesEOL() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.autocrlf == "" {
+		out, _, _ := g.capture("config", "core.autocrlf")
+		g.autocrlf = strings.ToLower(out)
+		if g.autocrlf == "" {
+			g.autocrlf = "false"
+		}
+	}
+	return g.autocrlf == "true" || g.autocrlf == "input"
+}
+
+// removePaths returns files without the ones for which remove returns true.
+func removePaths(files []string, remove func(p string) bool) []string {
+	out := make([]string, 0, len(files))
+	for _, f := range files {
+		if !remove(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
 func (g *git) capture(args ...string) (string, int, error) {
 	return g.captureEnv(nil, args...)
 }
 
 func (g *git) captureEnv(env []string, args ...string) (string, int, error) {
-	out, code, err := internal.Capture(g.root, env, append([]string{"git"}, args...)...)
+	out, code, err := internal.Capture(context.Background(), g.root, env, append([]string{"git"}, args...)...)
 	return strings.TrimRight(out, "\n\r"), code, err
 }
 
@@ -505,7 +978,7 @@ func getGitDir(wd string) (string, error) {
 
 // captureAbs returns an absolute path of whatever a git command returned.
 func captureAbs(wd string, args ...string) (string, error) {
-	out, code, _ := internal.Capture(wd, nil, args...)
+	out, code, _ := internal.Capture(context.Background(), wd, nil, args...)
 	if code != 0 {
 		return "", fmt.Errorf("failed to run \"%s\"", strings.Join(args, " "))
 	}
@@ -5,6 +5,7 @@
 package scm
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -37,6 +38,7 @@ func TestGetRepoGitSlowSuccess(t *testing.T) {
 	ut.AssertEqual(t, nil, err)
 	ut.AssertEqual(t, filepath.Join(tmpDir, ".git", "hooks"), p)
 	ut.AssertEqual(t, Commit(gitInitial), r.Eval(string(Head)))
+	ut.AssertEqual(t, false, r.IsMerging())
 	ut.AssertEqual(t, nil, err)
 	err = r.Checkout(string(Initial))
 	ut.AssertEqual(t, errors.New("checkout failed:\nfatal: Cannot switch branch to a non-commit '4b825dc642cb6eb9a060e54bf8d69288fbee4904'"), err)
@@ -66,13 +68,62 @@ func TestGetRepoGitSlowSuccess(t *testing.T) {
 	ut.AssertEqual(t, "package foo\n// hello\n", read(t, tmpDir, "src/foo/file1.go"))
 	commitInitial := assertHEAD(t, r, "f4edb8ac30289340040451b6f8c20d17614a9ae7")
 	ut.AssertEqual(t, "master", r.Ref(Head))
+	ut.AssertEqual(t, commitInitial, r.Before("2006-01-01"))
+	ut.AssertEqual(t, Commit(gitInitial), r.Before("2005-01-01"))
+	ut.AssertEqual(t, Invalid, r.Before(""))
+	content, err := r.ContentAt(commitInitial, "src/foo/file1.go")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "package foo\n", string(content))
+	_, err = r.ContentAt(commitInitial, "src/foo/missing.go")
+	ut.AssertEqual(t, true, err != nil)
+	message, err := r.Message(Head)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "yo", message)
+	_, err = r.Message(Current)
+	ut.AssertEqual(t, errors.New("<current> has no commit message"), err)
+	// The staging area is checked, not the unstaged modification.
+	staged, err := r.Between(Index, Head, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"src/foo/file1.go"}, staged.Changed().Files())
+	ut.AssertEqual(t, "package foo\n", string(staged.Content("src/foo/file1.go")))
+	content, err = r.ContentAt(Index, "src/foo/file1.go")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "package foo\n", string(content))
+	// The cat-file process is reused and restarted once stopped.
+	cat := &catFile{}
+	for i := 0; i < 2; i++ {
+		content, found, err := cat.read(tmpDir, string(commitInitial)+":src/foo/file1.go")
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, true, found)
+		ut.AssertEqual(t, "package foo\n", string(content))
+		_, found, err = cat.read(tmpDir, string(commitInitial)+":src/foo/missing.go")
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, false, found)
+		_, _, err = cat.read(tmpDir, string(commitInitial)+":src/foo")
+		ut.AssertEqual(t, true, err != nil)
+		cat.stop()
+	}
+
+	done, err = r.Stash()
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, done)
+	ut.AssertEqual(t, "package foo\n", read(t, tmpDir, "src/foo/file1.go"))
+	ut.AssertEqual(t, nil, r.Restore())
+	ut.AssertEqual(t, "package foo\n// hello\n", read(t, tmpDir, "src/foo/file1.go"))
 
+	// Untracked files are stashed too.
+	write(t, tmpDir, "src/foo/scratch.txt", "scratch\n")
+	check(t, r, []string{"src/foo/scratch.txt"}, []string{"src/foo/file1.go"})
 	done, err = r.Stash()
 	ut.AssertEqual(t, nil, err)
 	ut.AssertEqual(t, true, done)
+	check(t, r, []string{}, []string{})
 	ut.AssertEqual(t, "package foo\n", read(t, tmpDir, "src/foo/file1.go"))
 	ut.AssertEqual(t, nil, r.Restore())
+	check(t, r, []string{"src/foo/scratch.txt"}, []string{"src/foo/file1.go"})
+	ut.AssertEqual(t, "scratch\n", read(t, tmpDir, "src/foo/scratch.txt"))
 	ut.AssertEqual(t, "package foo\n// hello\n", read(t, tmpDir, "src/foo/file1.go"))
+	ut.AssertEqual(t, nil, os.Remove(filepath.Join(tmpDir, "src", "foo", "scratch.txt")))
 
 	msg := "checkout failed:\nerror: pathspec 'invalid' did not match any file(s) known to git."
 	ut.AssertEqual(t, errors.New(msg), r.Checkout("invalid"))
@@ -115,6 +166,25 @@ func TestGetRepoGitSlowSuccess(t *testing.T) {
 	ut.AssertEqual(t, errors.New("invalid old commit"), err)
 	ut.AssertEqual(t, nil, c)
 
+	c, err = FromFiles(r, []string{"src/foo/file1.go", "src/foo/../foo/file1.go"}, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"src/foo/file1.go"}, c.Changed().GoFiles())
+	ut.AssertEqual(t, []string{"src/foo/file1.go"}, c.All().GoFiles())
+
+	c, err = FromFiles(r, []string{"src/foo/file1.go"}, []string{"f*"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, nil, c)
+
+	c, err = FromFiles(r, []string{"../file1.go"}, nil)
+	ut.AssertEqual(t, errors.New("../file1.go is outside the repository"), err)
+	ut.AssertEqual(t, nil, c)
+	c, err = FromFiles(r, []string{"/file1.go"}, nil)
+	ut.AssertEqual(t, errors.New("/file1.go is outside the repository"), err)
+	ut.AssertEqual(t, nil, c)
+	c, err = FromFiles(r, []string{"./src//foo/file1.go"}, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"src/foo/file1.go"}, c.Changed().GoFiles())
+
 	// Add a file then remove it. Make sure the file doesn't show up.
 	check(t, r, []string{}, []string{})
 	write(t, tmpDir, "src/foo/deleted/deleted.go", "package deleted\n")
@@ -168,6 +238,312 @@ func TestGetRepoGitSlowSuccess(t *testing.T) {
 	ut.AssertEqual(t, nil, c)
 }
 
+func TestImportAs(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	if internal.IsModuleMode(tmpDir) {
+		t.Skipf("module mode")
+	}
+	setup(t, tmpDir)
+	write(t, tmpDir, "foo.go", "package foo\n")
+	run(t, tmpDir, nil, "add", "foo.go")
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+
+	n, cleanup, err := ImportAs(r, "example.com/fork")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, true, strings.HasSuffix(n.Root(), filepath.Join("src", "example.com", "fork")))
+	c, err := n.Between(Current, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, "example.com/fork", c.Package())
+	ut.AssertEqual(t, []string{"foo.go"}, c.Changed().GoFiles())
+
+	// Already at the right location.
+	n2, cleanup2, err := ImportAs(n, "example.com/fork")
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, n, n2)
+	ut.AssertEqual(t, nil, cleanup2())
+
+	ut.AssertEqual(t, nil, cleanup())
+	ut.AssertEqual(t, "package foo\n", read(t, tmpDir, "foo.go"))
+}
+
+func TestGetRepoGitStatus(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	content := "package foo\n\nfunc A() {\n}\n\nfunc B() {\n}\n\nfunc C() {\n}\n"
+	write(t, tmpDir, "foo/a.go", content)
+	write(t, tmpDir, "foo/b.go", "package foo\n")
+	write(t, tmpDir, "foo/c.go", "package foo\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	base := r.Eval(string(Head))
+	c, err := r.Between(base, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, Added, c.Status("foo/a.go"))
+
+	run(t, tmpDir, nil, "rm", "-q", "foo/a.go", "foo/c.go")
+	write(t, tmpDir, "bar/a.go", content+"\nfunc D() {\n}\n")
+	write(t, tmpDir, "foo/b.go", "package foo\n\n// B.\n")
+	write(t, tmpDir, "foo/d.go", "package foo\n\nfunc D() {\n}\n")
+	run(t, tmpDir, nil, "add", ".")
+	expected := []Rename{{Old: "foo/a.go", New: "bar/a.go", Similarity: 79}}
+	// Staged.
+	c, err = r.Between(Current, Head, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"bar/a.go", "foo/b.go", "foo/d.go"}, c.Changed().Files())
+	ut.AssertEqual(t, expected, c.Renames())
+	ut.AssertEqual(t, []string{"foo/c.go"}, c.Deleted())
+	// Committed.
+	run(t, tmpDir, nil, "commit", "-m", "move")
+	head := r.Eval(string(Head))
+	c, err = r.Between(head, base, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, expected, c.Renames())
+	ut.AssertEqual(t, []string{"foo/c.go"}, c.Deleted())
+	ut.AssertEqual(t, Renamed, c.Status("bar/a.go"))
+	ut.AssertEqual(t, Modified, c.Status("foo/b.go"))
+	ut.AssertEqual(t, Added, c.Status("foo/d.go"))
+	ut.AssertEqual(t, Unchanged, c.Status("foo/c.go"))
+	c, err = r.Between(head, base, IgnorePatterns{"bar", "foo"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, nil, c)
+
+	r.SetRenameThreshold(80)
+	c, err = r.Between(head, base, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []Rename(nil), c.Renames())
+	ut.AssertEqual(t, Added, c.Status("bar/a.go"))
+	ut.AssertEqual(t, []string{"foo/a.go", "foo/c.go"}, c.Deleted())
+	r.SetRenameThreshold(-1)
+	c, err = r.Between(Current, base, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []Rename(nil), c.Renames())
+}
+
+func TestGetRepoGitSubmodules(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	sub := filepath.Join(tmpDir, "third_party", "sub.go")
+	ut.AssertEqual(t, nil, os.MkdirAll(sub, 0700))
+	setup(t, sub)
+	write(t, sub, "a.go", "package sub\n")
+	run(t, sub, nil, "add", ".")
+	deterministicCommit(t, sub)
+	subOld := Commit(run(t, sub, nil, "rev-parse", "HEAD"))
+
+	write(t, tmpDir, ".gitmodules", "[submodule \"third_party/sub.go\"]\n\tpath = third_party/sub.go\n\turl = ./sub\n")
+	write(t, tmpDir, "foo.go", "package foo\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	base := r.Eval(string(Head))
+	c, err := r.Between(base, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{".gitmodules", "foo.go"}, c.Changed().Files())
+	ut.AssertEqual(t, []string{"foo.go"}, c.All().GoFiles())
+	ut.AssertEqual(t, []Submodule{{Path: "third_party/sub.go", Old: Initial, New: subOld}}, c.Submodules())
+
+	// Only the submodule checkout changed.
+	write(t, sub, "a.go", "package sub\n\n// A.\n")
+	run(t, sub, nil, "commit", "-q", "-a", "-m", "b")
+	subNew := Commit(run(t, sub, nil, "rev-parse", "HEAD"))
+	c, err = r.Between(Current, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"foo.go"}, c.All().GoFiles())
+	ut.AssertEqual(t, []Submodule{{Path: "third_party/sub.go", Old: Initial, New: Current}}, c.Submodules())
+	// Not staged, so not part of the commit.
+	c, err = r.Between(Current, Head, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, nil, c)
+	stashed, err := r.Stash()
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, false, stashed)
+
+	run(t, tmpDir, nil, "add", "third_party/sub.go")
+	c, err = r.Between(Current, Head, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{}, c.Changed().Files())
+	ut.AssertEqual(t, []Submodule{{Path: "third_party/sub.go", Old: subOld, New: subNew}}, c.Submodules())
+}
+
+func TestGetRepoGitSparse(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	src := filepath.Join(tmpDir, "src")
+	ut.AssertEqual(t, nil, os.Mkdir(src, 0700))
+	setup(t, src)
+	write(t, src, "a/a.go", "package a\n")
+	write(t, src, "b/b.go", "package b\n")
+	run(t, src, nil, "add", ".")
+	deterministicCommit(t, src)
+	run(t, src, nil, "config", "uploadpack.allowFilter", "true")
+	// A partial clone without any blob, only checking out a/.
+	run(t, tmpDir, nil, "clone", "-q", "--filter=blob:none", "--sparse", "file://"+filepath.ToSlash(src), "dst")
+	dst := filepath.Join(tmpDir, "dst")
+	run(t, dst, nil, "sparse-checkout", "set", "a")
+	_, err = os.Stat(filepath.Join(dst, "b"))
+	ut.AssertEqual(t, true, os.IsNotExist(err))
+
+	r, err := getRepo(dst, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	c, err := r.Between(Current, Initial, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"a/a.go"}, c.All().Files())
+	ut.AssertEqual(t, []string{"./a"}, c.All().Packages())
+	// The blob is fetched on demand.
+	ut.AssertEqual(t, "package b\n", string(c.Content("b/b.go")))
+}
+
+func TestGetRepoGitAutoCRLF(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	run(t, tmpDir, nil, "config", "core.autocrlf", "input")
+	write(t, tmpDir, "a/a.go", "package a\r\n")
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	c, err := FromFiles(r, []string{filepath.Join("a", "a.go")}, nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{"a/a.go"}, c.Changed().Files())
+	ut.AssertEqual(t, []string{"./a"}, c.Changed().Packages())
+	// The content matches what git commits.
+	ut.AssertEqual(t, "package a\n", string(c.Content("a/a.go")))
+}
+
+func TestGetRepoGitDirty(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n")
+	write(t, tmpDir, "b.go", "package a\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	dirty, err := r.Dirty(nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{}, dirty)
+
+	write(t, tmpDir, "a.go", "package a\n\nvar A int\n")
+	run(t, tmpDir, nil, "add", "a.go")
+	write(t, tmpDir, "b.go", "package a\n\nvar B int\n")
+	write(t, tmpDir, "c.go", "package a\n")
+	write(t, tmpDir, ".editor", "state\n")
+	dirty, err = r.Dirty(nil)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{".editor", "b.go", "c.go"}, dirty)
+	dirty, err = r.Dirty(IgnorePatterns{".*", "*.go"})
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, []string{}, dirty)
+}
+
+func TestGetRepoGitIndex(t *testing.T) {
+	t.Parallel()
+	if isDrone() {
+		t.Skipf("Give up on drone, it uses a weird go template which makes it not standard when using git init")
+	}
+	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		if err := internal.RemoveAll(tmpDir); err != nil {
+			t.Errorf("%s", err)
+		}
+	}()
+	setup(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	write(t, tmpDir, "a.go", "package a\n\nvar A int\n")
+	run(t, tmpDir, nil, "add", ".")
+	deterministicCommit(t, tmpDir)
+	r, err := getRepo(tmpDir, tmpDir)
+	ut.AssertEqual(t, nil, err)
+	parent := r.Eval("HEAD~1")
+
+	write(t, tmpDir, "a.go", "package a\n\nvar A int\n\nvar B int\n")
+	run(t, tmpDir, nil, "add", ".")
+	write(t, tmpDir, "a.go", "package a\n\nvar A int\n\nvar B int\n\nvar C int\n")
+	data := []struct {
+		recent   Commit
+		old      Commit
+		expected string
+	}{
+		{Index, Head, "package a\n\nvar A int\n\nvar B int\n"},
+		// git commit --amend.
+		{Index, parent, "package a\n\nvar A int\n\nvar B int\n"},
+		{Index, Initial, "package a\n\nvar A int\n\nvar B int\n"},
+		// The checkout is read as is.
+		{Current, Head, "package a\n\nvar A int\n\nvar B int\n\nvar C int\n"},
+		{Current, parent, "package a\n\nvar A int\n\nvar B int\n\nvar C int\n"},
+	}
+	for i, line := range data {
+		change, err := r.Between(line.recent, line.old, nil)
+		ut.AssertEqualIndex(t, i, nil, err)
+		ut.AssertEqualIndex(t, i, []string{"a.go"}, change.Changed().Files())
+		ut.AssertEqualIndex(t, i, line.expected, string(change.Content("a.go")))
+	}
+}
+
 func TestGetRepoNoRepo(t *testing.T) {
 	t.Parallel()
 	tmpDir, err := ioutil.TempDir("", "pre-commit-go")
@@ -207,6 +583,7 @@ func TestGetRepoGitSlowFailures(t *testing.T) {
 
 	ut.AssertEqual(t, Commit(gitInitial), r.Eval(string(Head)))
 	ut.AssertEqual(t, "", r.Ref(Head))
+	ut.AssertEqual(t, false, r.IsMerging())
 
 	done, err := r.Stash()
 	ut.AssertEqual(t, errors.New("failed to get list of untracked files"), err)
@@ -225,7 +602,7 @@ func TestGetRepoGitSlowFailures(t *testing.T) {
 // Private stuff.
 
 func setup(t *testing.T, tmpDir string) {
-	_, code, err := internal.Capture(tmpDir, nil, "git", "init")
+	_, code, err := internal.Capture(context.Background(), tmpDir, nil, "git", "init")
 	ut.AssertEqual(t, 0, code)
 	ut.AssertEqual(t, nil, err)
 	run(t, tmpDir, nil, "config", "user.email", "nobody@localhost")
@@ -5,30 +5,89 @@
 package scm
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
 // relToGOPATH returns the path relative to $GOPATH/src.
+//
+// The paths are first compared as is, since some projects (e.g. circleci.com)
+// like to checkout outside of $GOPATH then symlink back in. Otherwise the
+// symlinks are resolved, in case the checkout is reached via a symlink or
+// $GOPATH is one. The comparison ignores the case on the platforms with a
+// case-insensitive file system by default.
 func relToGOPATH(p, gopath string) (string, error) {
-	for _, gopath := range filepath.SplitList(gopath) {
-		if len(gopath) == 0 {
+	gopaths := filepath.SplitList(gopath)
+	if rel, ok := relToSrc(p, gopaths); ok {
+		return rel, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		for _, g := range gopaths {
+			if g == "" {
+				continue
+			}
+			if r, err := filepath.EvalSymlinks(g); err == nil && r != g {
+				gopaths = append(gopaths, r)
+			}
+		}
+		if rel, ok := relToSrc(resolved, gopaths); ok {
+			return rel, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find GOPATH relative directory for %s", p)
+}
+
+// modulePath returns the module path as declared in <root>/go.mod. Returns an
+// empty string if there is no go.mod or it doesn't declare a module.
+func modulePath(root string) string {
+	f, err := os.Open(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "module") {
+			return strings.Trim(strings.TrimSpace(line[len("module"):]), "\"`")
+		}
+	}
+	return ""
+}
+
+// caseInsensitive is true on the platforms where the file system is
+// case-insensitive by default.
+var caseInsensitive = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// relToSrc returns p relative to the first <gopath>/src containing it.
+func relToSrc(p string, gopaths []string) (string, bool) {
+	p = filepath.Clean(p)
+	for _, gopath := range gopaths {
+		if gopath == "" {
 			continue
 		}
 		srcRoot := filepath.Join(gopath, "src")
-		// TODO(maruel): Accept case-insensitivity on Windows/OSX, maybe call
-		// filepath.EvalSymlinks().
-		// Calling EvalSymlinks() is a bad idea, as some projects (e.g.
-		// circleci.com) like to checkout outside of $GOPATH then symlink back in.
-		if !strings.HasPrefix(p, srcRoot) {
+		if len(p) < len(srcRoot) || !pathEqual(p[:len(srcRoot)], srcRoot) {
 			continue
 		}
-		rel, err := filepath.Rel(srcRoot, p)
-		if err != nil {
-			return "", fmt.Errorf("failed to find relative path from %s to %s", srcRoot, p)
+		if len(p) == len(srcRoot) {
+			return ".", true
+		}
+		if p[len(srcRoot)] == filepath.Separator {
+			return p[len(srcRoot)+1:], true
 		}
-		return rel, err
 	}
-	return "", fmt.Errorf("failed to find GOPATH relative directory for %s", p)
+	return "", false
+}
+
+// pathEqual returns true if a and b are the same path on this platform.
+func pathEqual(a, b string) bool {
+	if caseInsensitive {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
 }
@@ -6,9 +6,14 @@ package scm
 
 import (
 	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/maruel/pre-commit-go/internal"
 	"github.com/maruel/ut"
 )
 
@@ -17,4 +22,52 @@ func TestRelToGOPATH(t *testing.T) {
 	p, err := relToGOPATH("foo", string(os.PathListSeparator))
 	ut.AssertEqual(t, "", p)
 	ut.AssertEqual(t, errors.New("failed to find GOPATH relative directory for foo"), err)
+
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, internal.RemoveAll(td))
+	}()
+	gopath := filepath.Join(td, "gopath")
+	pkg := filepath.Join(gopath, "src", "example.com", "foo")
+	ut.AssertEqual(t, nil, os.MkdirAll(pkg, 0700))
+	p, err = relToGOPATH(pkg, "other"+string(os.PathListSeparator)+gopath)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, filepath.Join("example.com", "foo"), p)
+	p, err = relToGOPATH(filepath.Join(gopath, "src"), gopath)
+	ut.AssertEqual(t, nil, err)
+	ut.AssertEqual(t, ".", p)
+	_, err = relToGOPATH(filepath.Join(gopath, "srcfoo"), gopath)
+	ut.AssertEqual(t, true, err != nil)
+	if caseInsensitive {
+		p, err = relToGOPATH(pkg, strings.ToUpper(gopath))
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, filepath.Join("example.com", "foo"), p)
+	}
+
+	if runtime.GOOS != "windows" {
+		// The checkout is reached via a symlink, and GOPATH is a symlink.
+		link := filepath.Join(td, "link")
+		ut.AssertEqual(t, nil, os.Symlink(pkg, link))
+		p, err = relToGOPATH(link, gopath)
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, filepath.Join("example.com", "foo"), p)
+		gopathLink := filepath.Join(td, "gopathlink")
+		ut.AssertEqual(t, nil, os.Symlink(gopath, gopathLink))
+		p, err = relToGOPATH(pkg, gopathLink)
+		ut.AssertEqual(t, nil, err)
+		ut.AssertEqual(t, filepath.Join("example.com", "foo"), p)
+	}
+}
+
+func TestModulePath(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "pre-commit-go")
+	ut.AssertEqual(t, nil, err)
+	defer func() {
+		ut.AssertEqual(t, nil, internal.RemoveAll(td))
+	}()
+	ut.AssertEqual(t, "", modulePath(td))
+	write(t, td, "go.mod", "// comment\nmodule \"example.com/foo\"\n\ngo 1.12\n")
+	ut.AssertEqual(t, "example.com/foo", modulePath(td))
 }